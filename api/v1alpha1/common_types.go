@@ -26,12 +26,21 @@ type NamespaceSelector struct {
 
 // OutputSpec defines the output configuration
 type OutputSpec struct {
-	Mode            string         `json:"mode"`
-	PVC             *PVCSpec       `json:"pvc,omitempty"`
-	Collector       *CollectorSpec `json:"collector,omitempty"`
-	RollingInterval *string        `json:"rollingInterval,omitempty"`
-	Compress        *string        `json:"compress,omitempty"`
-	RetentionDays   *int32         `json:"retentionDays,omitempty"`
+	Mode      string         `json:"mode"`
+	PVC       *PVCSpec       `json:"pvc,omitempty"`
+	Collector *CollectorSpec `json:"collector,omitempty"`
+	// RollingInterval makes tools that support it, such as tcpdump, upload
+	// the capture in numbered segments at this interval (e.g. "10m")
+	RollingInterval *string `json:"rollingInterval,omitempty"`
+	// Compress is how the collector compresses uploads it stores: gzip, zstd
+	// or none. Uploads that arrive compressed are stored as they are.
+	Compress      *string `json:"compress,omitempty"`
+	RetentionDays *int32  `json:"retentionDays,omitempty"`
+	// GroupBy is the pod label key artifacts are grouped under, as
+	// <key>-<value>. When unset they are grouped under every label the
+	// selector matched on, sorted by key.
+	// +optional
+	GroupBy *string `json:"groupBy,omitempty"`
 }
 
 // PVCSpec defines the PVC output configuration
@@ -42,7 +51,32 @@ type PVCSpec struct {
 
 // CollectorSpec defines the collector output configuration
 type CollectorSpec struct {
-	Endpoint string `json:"endpoint"`
+	// Endpoint is the collector https base URL. When empty the controller resolves
+	// the named collector, or the cluster default.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// Name selects a collector Service labeled codriverlabs.ai.toe.run/collector=<name>
+	// in the collector namespace. Ignored when Endpoint is set.
+	// +optional
+	Name *string `json:"name,omitempty"`
+	// CASecretRef references a Secret holding the CA that signed the collector's
+	// serving certificate, e.g. one issued by cert-manager. When unset the
+	// controller falls back to the collector CA ConfigMap.
+	// +optional
+	CASecretRef *CASecretReference `json:"caSecretRef,omitempty"`
+}
+
+// CASecretReference identifies a PEM-encoded CA bundle stored in a Secret
+type CASecretReference struct {
+	// Name of the Secret
+	Name string `json:"name"`
+	// Namespace of the Secret. Defaults to the collector namespace; only the
+	// collector namespace and the PowerTool's own namespace are allowed.
+	// +optional
+	Namespace *string `json:"namespace,omitempty"`
+	// Key within the Secret data, defaults to ca.crt
+	// +optional
+	Key *string `json:"key,omitempty"`
 }
 
 // BudgetSpec defines the budget configuration
@@ -0,0 +1,126 @@
+/*
+Copyright 2025.
+
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Notification sink types
+const (
+	// SinkTypeWebhook posts the event as a JSON document
+	SinkTypeWebhook = "webhook"
+	// SinkTypeCloudEvents posts the event as a structured-mode CloudEvent
+	SinkTypeCloudEvents = "cloudevents"
+)
+
+// Notification events of a PowerTool run
+const (
+	NotificationEventStarted        = "Started"
+	NotificationEventCompleted      = "Completed"
+	NotificationEventFailed         = "Failed"
+	NotificationEventArtifactStored = "ArtifactStored"
+)
+
+// SinkSpec describes where and how run notifications are delivered
+type SinkSpec struct {
+	// Type is the encoding of the notification: webhook or cloudevents
+	// +kubebuilder:validation:Enum=webhook;cloudevents
+	// +kubebuilder:default=webhook
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// URL receives the notifications with HTTP POST
+	// +required
+	URL string `json:"url"`
+
+	// Events selects the events sent: Started, Completed, Failed and
+	// ArtifactStored. If empty, all of them are sent.
+	// +optional
+	Events []string `json:"events,omitempty"`
+
+	// SigningSecretRef names a Secret key whose value signs each
+	// notification with HMAC-SHA256
+	// +optional
+	SigningSecretRef *SecretKeyReference `json:"signingSecretRef,omitempty"`
+
+	// MaxRetries bounds the redeliveries of a notification the sink did
+	// not accept. Defaults to 5.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	MaxRetries *int32 `json:"maxRetries,omitempty"`
+}
+
+// SecretKeyReference selects a key of a Secret
+type SecretKeyReference struct {
+	Name string `json:"name"`
+	// Namespace of the Secret. Sinks declared in a PowerTool always read
+	// the PowerTool's namespace.
+	Namespace *string `json:"namespace,omitempty"`
+	// Key in the Secret. Defaults to "secret".
+	Key *string `json:"key,omitempty"`
+}
+
+// NotificationSpec declares the sinks a PowerTool notifies of its run
+type NotificationSpec struct {
+	Sinks []NamedSinkSpec `json:"sinks,omitempty"`
+}
+
+// NamedSinkSpec is a sink declared in a PowerTool
+type NamedSinkSpec struct {
+	Name     string `json:"name"`
+	SinkSpec `json:",inline"`
+}
+
+// NotificationStatus records the notifications already sent for a run, so
+// they are not sent again
+type NotificationStatus struct {
+	// Events lists the run events sent: Started, and Completed or Failed
+	Events []string `json:"events,omitempty"`
+	// Artifacts is the ArtifactCount already announced with ArtifactStored
+	Artifacts int32 `json:"artifacts,omitempty"`
+}
+
+// NotificationSinkSpec defines the desired state of NotificationSink
+type NotificationSinkSpec struct {
+	SinkSpec `json:",inline"`
+
+	// NamespaceSelector limits the PowerTools notified to those in the
+	// selected namespaces. If unset, PowerTools in all namespaces are.
+	// +optional
+	NamespaceSelector *NamespaceSelector `json:"namespaceSelector,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// NotificationSink is the Schema for the notificationsinks API: a sink that
+// receives the run notifications of PowerTools across the cluster
+type NotificationSink struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty,omitzero"`
+
+	// spec defines the desired state of NotificationSink
+	// +required
+	Spec NotificationSinkSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// NotificationSinkList contains a list of NotificationSink
+type NotificationSinkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NotificationSink `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NotificationSink{}, &NotificationSinkList{})
+}
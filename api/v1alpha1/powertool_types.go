@@ -6,6 +6,8 @@ Copyright 2025.
 package v1alpha1
 
 import (
+	"fmt"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -36,6 +38,9 @@ type PowerToolSpec struct {
 	FailurePolicy           *FailurePolicySpec `json:"failurePolicy,omitempty"`
 	Schedule                *string            `json:"schedule,omitempty"`
 	TTLSecondsAfterFinished *int32             `json:"ttlSecondsAfterFinished,omitempty"`
+	// Notifications declares sinks notified of the run, in addition to the
+	// cluster's NotificationSinks
+	Notifications *NotificationSpec `json:"notifications,omitempty"`
 }
 
 // ToolSpec defines the tool configuration (renamed from ProfilerSpec)
@@ -56,12 +61,36 @@ type PowerToolStatus struct {
 	SelectedPods  *int32               `json:"selectedPods,omitempty"`
 	CompletedPods *int32               `json:"completedPods,omitempty"`
 	BytesWritten  *string              `json:"bytesWritten,omitempty"`
-	Artifacts     []string             `json:"artifacts,omitempty"`
+	Artifacts     []ArtifactReference  `json:"artifacts,omitempty"`
 	LastError     *string              `json:"lastError,omitempty"`
 	StartedAt     *metav1.Time         `json:"startedAt,omitempty"`
 	FinishedAt    *metav1.Time         `json:"finishedAt,omitempty"`
 	Conditions    []PowerToolCondition `json:"conditions,omitempty"`
 	ActivePods    map[string]string    `json:"activePods,omitempty"` // podName -> containerName
+	// CollectorEndpoint is the collector URL resolved for this run
+	CollectorEndpoint *string `json:"collectorEndpoint,omitempty"`
+	// ArtifactLinksExpireAt is when the signed download links in Artifacts
+	// stop working. The controller renews them while the PowerTool exists.
+	ArtifactLinksExpireAt *metav1.Time `json:"artifactLinksExpireAt,omitempty"`
+	// Artifacts are the newest MaxStatusArtifacts artifacts the collector
+	// stored for the run. ArtifactCount and ArtifactBytes count all of them,
+	// and BytesWritten is ArtifactBytes in readable form, such as "1.5 GiB".
+	ArtifactCount *int32 `json:"artifactCount,omitempty"`
+	ArtifactBytes *int64 `json:"artifactBytes,omitempty"`
+	// Notifications records the run notifications already sent
+	Notifications *NotificationStatus `json:"notifications,omitempty"`
+}
+
+// ArtifactReference describes an artifact stored by the collector
+type ArtifactReference struct {
+	// Path locates the artifact in the collector's storage and artifact API
+	Path    string `json:"path"`
+	PodName string `json:"podName,omitempty"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	// URL downloads the artifact without credentials until
+	// ArtifactLinksExpireAt. Set when the collector signs links.
+	URL string `json:"url,omitempty"`
 }
 
 // PowerToolCondition represents a condition of a PowerTool
@@ -93,6 +122,79 @@ type PowerTool struct {
 	Status PowerToolStatus `json:"status,omitempty,omitzero"`
 }
 
+// ToolContainerName returns the name of the ephemeral container injected into
+// each target pod for this PowerTool. It includes a UID prefix so a recreated
+// PowerTool with the same name never matches an older run's containers.
+func (p *PowerTool) ToolContainerName() string {
+	uid := string(p.UID)
+	if len(uid) > 8 {
+		uid = uid[:8]
+	}
+	return fmt.Sprintf("powertool-%s-%s", p.Name, uid)
+}
+
+// MaxStatusArtifacts bounds the artifact references kept in PowerTool status,
+// so runs with many pods or rolling segments stay well below object size limits
+const MaxStatusArtifacts = 200
+
+// RecordArtifact adds ref to the status and updates the totals, keeping the
+// newest MaxStatusArtifacts references. An artifact already listed is updated
+// in place, and an empty URL keeps the one it had. It reports whether the
+// artifact was new.
+func (s *PowerToolStatus) RecordArtifact(ref ArtifactReference) bool {
+	var count int32
+	var total int64
+	if s.ArtifactCount != nil {
+		count = *s.ArtifactCount
+	}
+	if s.ArtifactBytes != nil {
+		total = *s.ArtifactBytes
+	}
+
+	added := true
+	for i := range s.Artifacts {
+		existing := &s.Artifacts[i]
+		if existing.Path != ref.Path {
+			continue
+		}
+		total += ref.Size - existing.Size
+		if ref.URL == "" {
+			ref.URL = existing.URL
+		}
+		*existing = ref
+		added = false
+		break
+	}
+	if added {
+		s.Artifacts = append(s.Artifacts, ref)
+		if len(s.Artifacts) > MaxStatusArtifacts {
+			s.Artifacts = append([]ArtifactReference(nil), s.Artifacts[len(s.Artifacts)-MaxStatusArtifacts:]...)
+		}
+		count++
+		total += ref.Size
+	}
+
+	written := formatBytes(total)
+	s.ArtifactCount = &count
+	s.ArtifactBytes = &total
+	s.BytesWritten = &written
+	return added
+}
+
+// formatBytes renders a size in binary units with one decimal, like "1.5 GiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // +kubebuilder:object:root=true
 
 // PowerToolList contains a list of PowerTool
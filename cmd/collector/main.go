@@ -1,43 +1,68 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/pkg/collector/config"
 	"toe/pkg/collector/server"
 
+	"github.com/go-logr/stdr"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 func main() {
-	dateFormat := os.Getenv("DATE_FORMAT")
-	if dateFormat == "" {
-		log.Fatalf("DATE_FORMAT environment variable is required")
+	opts, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-
-	cfg := &server.Config{
-		Port:        8443,
-		StoragePath: "/data",
-		DateFormat:  dateFormat,
-		TLSCert:     os.Getenv("TLS_CERT_PATH"),
-		TLSKey:      os.Getenv("TLS_KEY_PATH"),
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	cfg := &opts.Server
+
+	// controller-runtime packages, such as the TLS certificate watcher, log
+	// through logr; send them to the standard logger like the rest
+	ctrllog.SetLogger(stdr.New(log.Default()))
 
 	// Create Kubernetes client
-	config, err := rest.InClusterConfig()
+	kubeConfig, err := restConfig(opts.Kubeconfig)
 	if err != nil {
 		log.Fatalf("Failed to create kubernetes config: %v", err)
 	}
 
-	k8sClient, err := kubernetes.NewForConfig(config)
+	k8sClient, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
 		log.Fatalf("Failed to create kubernetes client: %v", err)
 	}
 
-	srv, err := server.NewServer(cfg, k8sClient)
+	// Uploads are rare, so PowerTools and pods are read directly rather than
+	// through an informer cache
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		log.Fatalf("Failed to register core types: %v", err)
+	}
+	if err := toev1alpha1.AddToScheme(scheme); err != nil {
+		log.Fatalf("Failed to register PowerTool types: %v", err)
+	}
+	kubeClient, err := client.New(kubeConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		log.Fatalf("Failed to create PowerTool client: %v", err)
+	}
+
+	srv, err := server.NewServer(cfg, k8sClient, kubeClient)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
@@ -53,7 +78,19 @@ func main() {
 	}()
 
 	<-sigChan
-	if err := srv.Shutdown(); err != nil {
+	log.Printf("Shutting down, waiting up to %v for requests in flight", opts.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("Error shutting down server: %v", err)
 	}
 }
+
+// restConfig uses kubeconfig when set, for running outside the cluster, and
+// the in-cluster configuration otherwise
+func restConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
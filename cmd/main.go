@@ -26,7 +26,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	toerunv1alpha1 "toe/api/v1alpha1"
+	"toe/internal/config"
 	"toe/internal/controller"
+	"toe/internal/notify"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -51,6 +53,7 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var configFile, configMapName, configMapNamespace string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -69,6 +72,12 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&configFile, "config-file", "",
+		"Path to a controller configuration file. When set, it is used instead of the ConfigMap and reloaded on change.")
+	flag.StringVar(&configMapName, "config-map-name", "toe-tools-configuration",
+		"The name of the ConfigMap holding the controller configuration.")
+	flag.StringVar(&configMapNamespace, "config-map-namespace", "toe-system",
+		"The namespace of the ConfigMap holding the controller configuration.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -175,11 +184,51 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := controller.NewPowerToolReconciler(
+	// Controller configuration is loaded from a file when one is given,
+	// otherwise from the tools-configuration ConfigMap. Both are reloaded live.
+	configStore := config.NewStore(nil)
+	if configFile != "" {
+		cfg, err := config.LoadFile(configFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load controller configuration", "path", configFile)
+			os.Exit(1)
+		}
+		configStore.Set(cfg)
+		if err := mgr.Add(config.NewFileWatcher(configFile, configStore)); err != nil {
+			setupLog.Error(err, "unable to set up controller configuration watcher")
+			os.Exit(1)
+		}
+	} else if err := (&controller.ControllerConfigReconciler{
+		Client:    mgr.GetClient(),
+		Name:      configMapName,
+		Namespace: configMapNamespace,
+		Store:     configStore,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ControllerConfig")
+		os.Exit(1)
+	}
+
+	powerToolReconciler := controller.NewPowerToolReconciler(
 		mgr.GetClient(),
 		mgr.GetScheme(),
 		k8sClient,
-	).SetupWithManager(mgr); err != nil {
+		configStore,
+	)
+	// Artifact links are fetched from collectors with the controller's own
+	// ServiceAccount token, which only exists when running in a pod
+	if tokenFile := mgr.GetConfig().BearerTokenFile; tokenFile != "" {
+		powerToolReconciler.Linker = controller.NewTokenFileLinker(tokenFile)
+	} else {
+		setupLog.Info("no ServiceAccount token file, artifact links will not be published")
+	}
+	// Run notifications are delivered in the background, by the leader only
+	notifier := notify.NewDispatcher()
+	if err := mgr.Add(notifier); err != nil {
+		setupLog.Error(err, "unable to set up notification dispatcher")
+		os.Exit(1)
+	}
+	powerToolReconciler.Notifier = notifier
+	if err := powerToolReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PowerTool")
 		os.Exit(1)
 	}
@@ -0,0 +1,341 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigMap keys understood by the controller. They mirror the keys in
+// config/manager/tools-configuration-configmap.yaml.
+const (
+	KeyTokenExpiryMultiplier       = "token-expiry-multiplier"
+	KeyTokenBufferSeconds          = "token-buffer-seconds"
+	KeyMinTokenDuration            = "min-token-duration"
+	KeyActiveRunningInterval       = "active-running-interval"
+	KeySetupTeardownInterval       = "setup-teardown-interval"
+	KeyCompletedJobInterval        = "completed-job-interval"
+	KeyConflictRequeueInterval     = "conflict-requeue-interval"
+	KeyToolConfigNamespaces        = "tool-config-namespaces"
+	KeyCollectorNamespace          = "collector-namespace"
+	KeyCollectorServiceAccount     = "collector-service-account"
+	KeyCollectorAudience           = "collector-audience"
+	KeyCollectorCAConfigMap        = "collector-ca-configmap"
+	KeyCollectorCAKey              = "collector-ca-key"
+	KeyCollectorEndpoint           = "collector-endpoint"
+	KeyDefaultCollectorName        = "default-collector-name"
+	KeyArtifactLinkTTL             = "artifact-link-ttl"
+	KeyUploadGracePeriod           = "upload-grace-period"
+	KeyNotificationAllowedNetworks = "notification-allowed-networks"
+)
+
+// Default values used when a key is absent from the ConfigMap or file
+const (
+	DefaultTokenExpiryMultiplier   = 2.0
+	DefaultTokenBuffer             = 10 * time.Second
+	DefaultMinTokenDuration        = 10 * time.Minute
+	DefaultActiveRunningInterval   = 5 * time.Second
+	DefaultSetupTeardownInterval   = 15 * time.Second
+	DefaultCompletedJobInterval    = 5 * time.Minute
+	DefaultConflictRequeueInterval = time.Minute
+	DefaultCollectorNamespace      = "toe-system"
+	DefaultCollectorServiceAccount = "toe-collector"
+	DefaultCollectorAudience       = "toe-sdk-collector"
+	DefaultCollectorCAConfigMap    = "collector-ca"
+	DefaultCollectorCAKey          = "ca.crt"
+	DefaultCollectorName           = "default"
+	DefaultArtifactLinkTTL         = 24 * time.Hour
+	DefaultUploadGracePeriod       = 10 * time.Minute
+)
+
+// KubernetesMinTokenDuration is the shortest expiration the TokenRequest API accepts
+const KubernetesMinTokenDuration = 10 * time.Minute
+
+// DefaultToolConfigNamespaces are searched, in order, for PowerToolConfig objects
+var DefaultToolConfigNamespaces = []string{"toe-system", "default"}
+
+// ControllerConfig holds the tunables of the PowerTool controller
+type ControllerConfig struct {
+	// TokenExpiryMultiplier scales the tool duration when computing the collector token lifetime
+	TokenExpiryMultiplier float64
+	// TokenBuffer is added on top of the scaled tool duration
+	TokenBuffer time.Duration
+	// MinTokenDuration is the floor applied to the computed token lifetime
+	MinTokenDuration time.Duration
+
+	ActiveRunningInterval   time.Duration
+	SetupTeardownInterval   time.Duration
+	CompletedJobInterval    time.Duration
+	ConflictRequeueInterval time.Duration
+
+	// ToolConfigNamespaces are searched, in order, for <tool>-config PowerToolConfigs
+	ToolConfigNamespaces []string
+
+	// CollectorNamespace and CollectorServiceAccount identify the ServiceAccount
+	// collector tokens are minted for
+	CollectorNamespace      string
+	CollectorServiceAccount string
+	CollectorAudience       string
+
+	// CollectorCAConfigMap names the ConfigMap in CollectorNamespace holding the
+	// PEM bundle tools use to verify the collector, under CollectorCAKey
+	CollectorCAConfigMap string
+	CollectorCAKey       string
+
+	// CollectorEndpoint, when set, is used for collector-mode PowerTools that
+	// specify neither an endpoint nor a collector name
+	CollectorEndpoint string
+	// DefaultCollectorName selects the labeled collector Service used when
+	// CollectorEndpoint is empty
+	DefaultCollectorName string
+
+	// ArtifactLinkTTL is how long the signed artifact links published in
+	// PowerTool status stay valid. Zero disables publishing them.
+	ArtifactLinkTTL time.Duration
+	// UploadGracePeriod is how long after a PowerTool completes the collector
+	// still accepts its uploads, as set by the collector's upload-grace-period.
+	// Artifact links are fetched once more when it has passed.
+	UploadGracePeriod time.Duration
+
+	// NotificationAllowedNetworks are internal networks that sinks declared
+	// in PowerTools may still deliver to, such as an in-cluster receiver
+	NotificationAllowedNetworks []netip.Prefix
+}
+
+// Default returns the configuration used when no ConfigMap or file is provided
+func Default() *ControllerConfig {
+	return &ControllerConfig{
+		TokenExpiryMultiplier:   DefaultTokenExpiryMultiplier,
+		TokenBuffer:             DefaultTokenBuffer,
+		MinTokenDuration:        DefaultMinTokenDuration,
+		ActiveRunningInterval:   DefaultActiveRunningInterval,
+		SetupTeardownInterval:   DefaultSetupTeardownInterval,
+		CompletedJobInterval:    DefaultCompletedJobInterval,
+		ConflictRequeueInterval: DefaultConflictRequeueInterval,
+		ToolConfigNamespaces:    append([]string(nil), DefaultToolConfigNamespaces...),
+		CollectorNamespace:      DefaultCollectorNamespace,
+		CollectorServiceAccount: DefaultCollectorServiceAccount,
+		CollectorAudience:       DefaultCollectorAudience,
+		CollectorCAConfigMap:    DefaultCollectorCAConfigMap,
+		CollectorCAKey:          DefaultCollectorCAKey,
+		DefaultCollectorName:    DefaultCollectorName,
+		ArtifactLinkTTL:         DefaultArtifactLinkTTL,
+		UploadGracePeriod:       DefaultUploadGracePeriod,
+	}
+}
+
+// Validate checks that all values are usable by the controller
+func (c *ControllerConfig) Validate() error {
+	var errs []string
+
+	if c.TokenExpiryMultiplier < 1 {
+		errs = append(errs, fmt.Sprintf("%s must be >= 1, got %v", KeyTokenExpiryMultiplier, c.TokenExpiryMultiplier))
+	}
+	if c.TokenBuffer < 0 {
+		errs = append(errs, fmt.Sprintf("%s must not be negative, got %v", KeyTokenBufferSeconds, c.TokenBuffer))
+	}
+	if c.MinTokenDuration < KubernetesMinTokenDuration {
+		errs = append(errs, fmt.Sprintf("%s must be at least %v, got %v", KeyMinTokenDuration, KubernetesMinTokenDuration, c.MinTokenDuration))
+	}
+
+	intervals := map[string]time.Duration{
+		KeyActiveRunningInterval:   c.ActiveRunningInterval,
+		KeySetupTeardownInterval:   c.SetupTeardownInterval,
+		KeyCompletedJobInterval:    c.CompletedJobInterval,
+		KeyConflictRequeueInterval: c.ConflictRequeueInterval,
+	}
+	for _, key := range []string{KeyActiveRunningInterval, KeySetupTeardownInterval, KeyCompletedJobInterval, KeyConflictRequeueInterval} {
+		if intervals[key] <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be positive, got %v", key, intervals[key]))
+		}
+	}
+
+	if c.ArtifactLinkTTL < 0 {
+		errs = append(errs, fmt.Sprintf("%s must not be negative, got %v", KeyArtifactLinkTTL, c.ArtifactLinkTTL))
+	}
+	if c.UploadGracePeriod <= 0 {
+		errs = append(errs, fmt.Sprintf("%s must be positive, got %v", KeyUploadGracePeriod, c.UploadGracePeriod))
+	}
+
+	if len(c.ToolConfigNamespaces) == 0 {
+		errs = append(errs, fmt.Sprintf("%s must list at least one namespace", KeyToolConfigNamespaces))
+	}
+	if c.CollectorNamespace == "" {
+		errs = append(errs, fmt.Sprintf("%s is required", KeyCollectorNamespace))
+	}
+	if c.CollectorServiceAccount == "" {
+		errs = append(errs, fmt.Sprintf("%s is required", KeyCollectorServiceAccount))
+	}
+	if c.CollectorAudience == "" {
+		errs = append(errs, fmt.Sprintf("%s is required", KeyCollectorAudience))
+	}
+	if c.CollectorCAConfigMap == "" {
+		errs = append(errs, fmt.Sprintf("%s is required", KeyCollectorCAConfigMap))
+	}
+	if c.CollectorCAKey == "" {
+		errs = append(errs, fmt.Sprintf("%s is required", KeyCollectorCAKey))
+	}
+	if c.CollectorEndpoint != "" {
+		if u, err := url.Parse(c.CollectorEndpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("%s must be an https URL, got %q", KeyCollectorEndpoint, c.CollectorEndpoint))
+		}
+	}
+	if c.CollectorEndpoint == "" && c.DefaultCollectorName == "" {
+		errs = append(errs, fmt.Sprintf("one of %s or %s is required", KeyCollectorEndpoint, KeyDefaultCollectorName))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid controller configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// TokenDuration returns the collector token lifetime for a collection of the given length
+func (c *ControllerConfig) TokenDuration(collectionDuration time.Duration) time.Duration {
+	tokenDuration := time.Duration(float64(collectionDuration)*c.TokenExpiryMultiplier) + c.TokenBuffer
+	if tokenDuration < c.MinTokenDuration {
+		return c.MinTokenDuration
+	}
+	return tokenDuration
+}
+
+// Parse builds a configuration from ConfigMap-style key/value data.
+// Missing keys keep their defaults; unknown keys are ignored.
+func Parse(data map[string]string) (*ControllerConfig, error) {
+	cfg := Default()
+
+	if v, ok := data[KeyTokenExpiryMultiplier]; ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", KeyTokenExpiryMultiplier, v, err)
+		}
+		cfg.TokenExpiryMultiplier = f
+	}
+
+	if v, ok := data[KeyTokenBufferSeconds]; ok {
+		secs, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", KeyTokenBufferSeconds, v, err)
+		}
+		cfg.TokenBuffer = time.Duration(secs) * time.Second
+	}
+
+	durations := map[string]*time.Duration{
+		KeyMinTokenDuration:        &cfg.MinTokenDuration,
+		KeyActiveRunningInterval:   &cfg.ActiveRunningInterval,
+		KeySetupTeardownInterval:   &cfg.SetupTeardownInterval,
+		KeyCompletedJobInterval:    &cfg.CompletedJobInterval,
+		KeyConflictRequeueInterval: &cfg.ConflictRequeueInterval,
+		KeyArtifactLinkTTL:         &cfg.ArtifactLinkTTL,
+		KeyUploadGracePeriod:       &cfg.UploadGracePeriod,
+	}
+	for key, target := range durations {
+		v, ok := data[key]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", key, v, err)
+		}
+		*target = d
+	}
+
+	if v, ok := data[KeyToolConfigNamespaces]; ok {
+		cfg.ToolConfigNamespaces = splitList(v)
+	}
+
+	stringsByKey := map[string]*string{
+		KeyCollectorNamespace:      &cfg.CollectorNamespace,
+		KeyCollectorServiceAccount: &cfg.CollectorServiceAccount,
+		KeyCollectorAudience:       &cfg.CollectorAudience,
+		KeyCollectorCAConfigMap:    &cfg.CollectorCAConfigMap,
+		KeyCollectorCAKey:          &cfg.CollectorCAKey,
+		KeyCollectorEndpoint:       &cfg.CollectorEndpoint,
+		KeyDefaultCollectorName:    &cfg.DefaultCollectorName,
+	}
+	for key, target := range stringsByKey {
+		if v, ok := data[key]; ok {
+			*target = strings.TrimSpace(v)
+		}
+	}
+
+	if v, ok := data[KeyNotificationAllowedNetworks]; ok {
+		for _, item := range splitList(v) {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", KeyNotificationAllowedNetworks, item, err)
+			}
+			cfg.NotificationAllowedNetworks = append(cfg.NotificationAllowedNetworks, prefix.Masked())
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile reads a YAML file containing the same flat keys as the ConfigMap
+func LoadFile(path string) (*ControllerConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read controller config file: %w", err)
+	}
+
+	data := map[string]string{}
+	if err := yaml.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse controller config file %s: %w", path, err)
+	}
+
+	return Parse(data)
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Store holds the active configuration and allows it to be swapped at runtime
+type Store struct {
+	mu  sync.RWMutex
+	cfg *ControllerConfig
+}
+
+// NewStore returns a Store seeded with cfg, or the defaults if cfg is nil
+func NewStore(cfg *ControllerConfig) *Store {
+	if cfg == nil {
+		cfg = Default()
+	}
+	return &Store{cfg: cfg}
+}
+
+// Get returns the active configuration. A nil Store yields the defaults so
+// callers that were never wired to a Store keep working.
+func (s *Store) Get() *ControllerConfig {
+	if s == nil {
+		return Default()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// Set replaces the active configuration
+func (s *Store) Set(cfg *ControllerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefault_IsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default() is not valid: %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		check   func(t *testing.T, cfg *ControllerConfig)
+		wantErr string
+	}{
+		{
+			name: "empty data uses defaults",
+			data: map[string]string{},
+			check: func(t *testing.T, cfg *ControllerConfig) {
+				if cfg.TokenExpiryMultiplier != DefaultTokenExpiryMultiplier {
+					t.Errorf("TokenExpiryMultiplier = %v, want %v", cfg.TokenExpiryMultiplier, DefaultTokenExpiryMultiplier)
+				}
+				if cfg.CollectorServiceAccount != DefaultCollectorServiceAccount {
+					t.Errorf("CollectorServiceAccount = %v, want %v", cfg.CollectorServiceAccount, DefaultCollectorServiceAccount)
+				}
+			},
+		},
+		{
+			name: "all keys",
+			data: map[string]string{
+				KeyTokenExpiryMultiplier:       "1.5",
+				KeyTokenBufferSeconds:          "30",
+				KeyMinTokenDuration:            "15m",
+				KeyActiveRunningInterval:       "2s",
+				KeySetupTeardownInterval:       "20s",
+				KeyCompletedJobInterval:        "10m",
+				KeyConflictRequeueInterval:     "30s",
+				KeyToolConfigNamespaces:        " tools , toe-system ,",
+				KeyCollectorNamespace:          "observability",
+				KeyCollectorServiceAccount:     "collector",
+				KeyCollectorAudience:           "custom-audience",
+				KeyArtifactLinkTTL:             "0",
+				KeyUploadGracePeriod:           "30m",
+				KeyNotificationAllowedNetworks: "10.96.0.0/12, fd00::1/64",
+			},
+			check: func(t *testing.T, cfg *ControllerConfig) {
+				if cfg.TokenExpiryMultiplier != 1.5 {
+					t.Errorf("TokenExpiryMultiplier = %v, want 1.5", cfg.TokenExpiryMultiplier)
+				}
+				if cfg.TokenBuffer != 30*time.Second {
+					t.Errorf("TokenBuffer = %v, want 30s", cfg.TokenBuffer)
+				}
+				if cfg.MinTokenDuration != 15*time.Minute {
+					t.Errorf("MinTokenDuration = %v, want 15m", cfg.MinTokenDuration)
+				}
+				if cfg.ActiveRunningInterval != 2*time.Second {
+					t.Errorf("ActiveRunningInterval = %v, want 2s", cfg.ActiveRunningInterval)
+				}
+				if cfg.ConflictRequeueInterval != 30*time.Second {
+					t.Errorf("ConflictRequeueInterval = %v, want 30s", cfg.ConflictRequeueInterval)
+				}
+				if strings.Join(cfg.ToolConfigNamespaces, ",") != "tools,toe-system" {
+					t.Errorf("ToolConfigNamespaces = %v, want [tools toe-system]", cfg.ToolConfigNamespaces)
+				}
+				if cfg.CollectorNamespace != "observability" || cfg.CollectorServiceAccount != "collector" || cfg.CollectorAudience != "custom-audience" {
+					t.Errorf("collector identity = %s/%s (%s)", cfg.CollectorNamespace, cfg.CollectorServiceAccount, cfg.CollectorAudience)
+				}
+				if cfg.ArtifactLinkTTL != 0 {
+					t.Errorf("ArtifactLinkTTL = %v, want 0", cfg.ArtifactLinkTTL)
+				}
+				if cfg.UploadGracePeriod != 30*time.Minute {
+					t.Errorf("UploadGracePeriod = %v, want 30m", cfg.UploadGracePeriod)
+				}
+				if got := fmt.Sprint(cfg.NotificationAllowedNetworks); got != "[10.96.0.0/12 fd00::/64]" {
+					t.Errorf("NotificationAllowedNetworks = %s, want [10.96.0.0/12 fd00::/64]", got)
+				}
+			},
+		},
+		{
+			name:    "malformed multiplier",
+			data:    map[string]string{KeyTokenExpiryMultiplier: "two"},
+			wantErr: KeyTokenExpiryMultiplier,
+		},
+		{
+			name:    "multiplier below one",
+			data:    map[string]string{KeyTokenExpiryMultiplier: "0.5"},
+			wantErr: KeyTokenExpiryMultiplier,
+		},
+		{
+			name:    "malformed buffer",
+			data:    map[string]string{KeyTokenBufferSeconds: "10s"},
+			wantErr: KeyTokenBufferSeconds,
+		},
+		{
+			name:    "minimum token duration below kubernetes minimum",
+			data:    map[string]string{KeyMinTokenDuration: "5m"},
+			wantErr: KeyMinTokenDuration,
+		},
+		{
+			name:    "zero interval",
+			data:    map[string]string{KeyActiveRunningInterval: "0s"},
+			wantErr: KeyActiveRunningInterval,
+		},
+		{
+			name:    "negative artifact link TTL",
+			data:    map[string]string{KeyArtifactLinkTTL: "-1h"},
+			wantErr: KeyArtifactLinkTTL,
+		},
+		{
+			name:    "zero upload grace period",
+			data:    map[string]string{KeyUploadGracePeriod: "0"},
+			wantErr: KeyUploadGracePeriod,
+		},
+		{
+			name:    "malformed allowed network",
+			data:    map[string]string{KeyNotificationAllowedNetworks: "10.0.0.5"},
+			wantErr: KeyNotificationAllowedNetworks,
+		},
+		{
+			name:    "empty namespace list",
+			data:    map[string]string{KeyToolConfigNamespaces: " , "},
+			wantErr: KeyToolConfigNamespaces,
+		},
+		{
+			name:    "empty service account",
+			data:    map[string]string{KeyCollectorServiceAccount: ""},
+			wantErr: KeyCollectorServiceAccount,
+		},
+		{
+			name:    "collector endpoint without scheme",
+			data:    map[string]string{KeyCollectorEndpoint: "toe-collector:8443"},
+			wantErr: KeyCollectorEndpoint,
+		},
+		{
+			name:    "plain HTTP collector endpoint",
+			data:    map[string]string{KeyCollectorEndpoint: "http://toe-collector:8080"},
+			wantErr: KeyCollectorEndpoint,
+		},
+		{
+			name:    "no endpoint and no default collector",
+			data:    map[string]string{KeyDefaultCollectorName: ""},
+			wantErr: KeyDefaultCollectorName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse(tt.data)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("Parse() expected error containing %q, got nil", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() unexpected error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestTokenDuration(t *testing.T) {
+	cfg := Default()
+	cfg.TokenExpiryMultiplier = 2
+	cfg.TokenBuffer = 10 * time.Second
+
+	tests := []struct {
+		collection time.Duration
+		want       time.Duration
+	}{
+		{30 * time.Second, 10 * time.Minute},
+		{5 * time.Minute, 10*time.Minute + 10*time.Second},
+		{15 * time.Minute, 30*time.Minute + 10*time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.TokenDuration(tt.collection); got != tt.want {
+			t.Errorf("TokenDuration(%v) = %v, want %v", tt.collection, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "token-expiry-multiplier: \"3\"\ntool-config-namespaces: \"tools\"\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if cfg.TokenExpiryMultiplier != 3 {
+		t.Errorf("TokenExpiryMultiplier = %v, want 3", cfg.TokenExpiryMultiplier)
+	}
+	if len(cfg.ToolConfigNamespaces) != 1 || cfg.ToolConfigNamespaces[0] != "tools" {
+		t.Errorf("ToolConfigNamespaces = %v, want [tools]", cfg.ToolConfigNamespaces)
+	}
+
+	if _, err := LoadFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("LoadFile() expected error for missing file, got nil")
+	}
+}
+
+func TestStore(t *testing.T) {
+	var nilStore *Store
+	if nilStore.Get() == nil {
+		t.Fatal("nil Store should return defaults")
+	}
+
+	store := NewStore(nil)
+	if store.Get().CollectorAudience != DefaultCollectorAudience {
+		t.Errorf("NewStore(nil) did not seed defaults")
+	}
+
+	updated := Default()
+	updated.CollectorAudience = "other"
+	store.Set(updated)
+	if store.Get().CollectorAudience != "other" {
+		t.Errorf("Set() did not replace configuration")
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// FileWatcher reloads a configuration file into a Store whenever it changes.
+// It implements manager.Runnable so it can be added to the controller manager.
+type FileWatcher struct {
+	path  string
+	store *Store
+}
+
+// NewFileWatcher creates a watcher for path that publishes into store
+func NewFileWatcher(path string, store *Store) *FileWatcher {
+	return &FileWatcher{
+		path:  path,
+		store: store,
+	}
+}
+
+// Start watches the file until ctx is cancelled
+func (w *FileWatcher) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("controller-config").WithValues("path", w.path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config file watcher: %w", err)
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	// Watch the parent directory: ConfigMap volumes replace files by swapping
+	// a symlink, which never produces a write event on the file itself.
+	if err := watcher.Add(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("failed to watch config directory: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			w.reload(logger)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error(err, "config file watcher error")
+		}
+	}
+}
+
+func (w *FileWatcher) reload(logger logr.Logger) {
+	cfg, err := LoadFile(w.path)
+	if err != nil {
+		logger.Error(err, "ignoring invalid controller configuration, keeping previous values")
+		return
+	}
+	w.store.Set(cfg)
+	logger.Info("reloaded controller configuration")
+}
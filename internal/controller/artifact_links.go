@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+// collectorRequestTimeout bounds each request the controller makes to a
+// collector
+const collectorRequestTimeout = 30 * time.Second
+
+// ArtifactLinker fetches the artifacts a PowerTool uploaded to its collector,
+// each with a signed download link
+type ArtifactLinker interface {
+	ArtifactLinks(ctx context.Context, endpoint, caPEM string, powerTool *toev1alpha1.PowerTool, ttl time.Duration) ([]toev1alpha1.ArtifactReference, time.Time, error)
+}
+
+// CollectorLinker asks a collector's artifact listing for signed links. It
+// authenticates with the controller's own ServiceAccount token, so the
+// collector checks the controller's RBAC like any other reader's.
+type CollectorLinker struct {
+	// Token returns the bearer token sent to the collector
+	Token func() (string, error)
+}
+
+// NewTokenFileLinker reads its token from path on every request, picking up
+// the projected ServiceAccount token as the kubelet rotates it
+func NewTokenFileLinker(path string) *CollectorLinker {
+	return &CollectorLinker{Token: func() (string, error) {
+		token, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ServiceAccount token: %w", err)
+		}
+		return strings.TrimSpace(string(token)), nil
+	}}
+}
+
+// artifactList is the part of the collector's listing response the
+// controller reads
+type artifactList struct {
+	Artifacts []struct {
+		Path      string `json:"path"`
+		PodName   string `json:"podName"`
+		Size      int64  `json:"size"`
+		SHA256    string `json:"sha256"`
+		SignedURL string `json:"signedUrl"`
+	} `json:"artifacts"`
+	LinksExpireAt *time.Time `json:"linksExpireAt"`
+}
+
+func (l *CollectorLinker) ArtifactLinks(ctx context.Context, endpoint, caPEM string, powerTool *toev1alpha1.PowerTool, ttl time.Duration) ([]toev1alpha1.ArtifactReference, time.Time, error) {
+	token, err := l.Token()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(caPEM)) {
+		return nil, time.Time{}, fmt.Errorf("no certificates in the collector CA bundle")
+	}
+	client := &http.Client{
+		Timeout:   collectorRequestTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	}
+
+	base := strings.TrimSuffix(endpoint, "/")
+	query := url.Values{
+		"namespace": {powerTool.Namespace},
+		"powertool": {powerTool.Name},
+		"expiresIn": {ttl.String()},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/api/v1/artifacts?"+query.Encode(), nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to build artifact listing request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, time.Time{}, fmt.Errorf("collector refused to list artifacts: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var list artifactList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decode artifact listing: %w", err)
+	}
+	if list.LinksExpireAt == nil {
+		return nil, time.Time{}, fmt.Errorf("collector returned no link expiry")
+	}
+	refs := make([]toev1alpha1.ArtifactReference, 0, len(list.Artifacts))
+	for _, a := range list.Artifacts {
+		link := a.SignedURL
+		// Links are relative when the collector has no public URL
+		if strings.HasPrefix(link, "/") {
+			link = base + link
+		}
+		refs = append(refs, toev1alpha1.ArtifactReference{
+			Path:    a.Path,
+			PodName: a.PodName,
+			Size:    a.Size,
+			SHA256:  a.SHA256,
+			URL:     link,
+		})
+	}
+	return refs, *list.LinksExpireAt, nil
+}
+
+// artifactLinksDue reports whether the links in status should be fetched
+// again: when there are none, once more after uploads that raced the
+// completion can no longer arrive, and once half their lifetime has passed
+func artifactLinksDue(status *toev1alpha1.PowerToolStatus, ttl, grace time.Duration, now time.Time) bool {
+	if status.ArtifactLinksExpireAt == nil {
+		return true
+	}
+	expires := status.ArtifactLinksExpireAt.Time
+	if !now.Before(expires.Add(-ttl / 2)) {
+		return true
+	}
+	for _, c := range status.Conditions {
+		if c.Type == toev1alpha1.PowerToolConditionCompleted && c.Status == "True" {
+			uploadsClosed := c.LastTransitionTime.Add(grace)
+			issued := expires.Add(-ttl)
+			return issued.Before(uploadsClosed) && !now.Before(uploadsClosed)
+		}
+	}
+	return false
+}
+
+// fetchArtifactLinks returns the artifacts of a completed collector-mode
+// PowerTool with fresh signed links, when they are due, and records when the
+// links expire. An empty caPEM is resolved only once links are due. Failures
+// are logged and return nothing, leaving the previous links in place, since
+// they do not affect the run itself.
+func (r *PowerToolReconciler) fetchArtifactLinks(ctx context.Context, powerTool *toev1alpha1.PowerTool, caPEM string) []toev1alpha1.ArtifactReference {
+	cfg := r.Config.Get()
+	ttl := cfg.ArtifactLinkTTL
+	if r.Linker == nil || ttl <= 0 || powerTool.Status.CollectorEndpoint == nil {
+		return nil
+	}
+	if !artifactLinksDue(&powerTool.Status, ttl, cfg.UploadGracePeriod, time.Now()) {
+		return nil
+	}
+
+	if caPEM == "" {
+		var err error
+		if caPEM, err = r.resolveCollectorCA(ctx, powerTool); err != nil {
+			log.FromContext(ctx).Error(err, "failed to resolve collector CA for artifact links")
+			return nil
+		}
+	}
+
+	refs, expires, err := r.Linker.ArtifactLinks(ctx, *powerTool.Status.CollectorEndpoint, caPEM, powerTool, ttl)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to fetch artifact links", "collector", *powerTool.Status.CollectorEndpoint)
+		return nil
+	}
+	expiresAt := metav1.NewTime(expires)
+	powerTool.Status.ArtifactLinksExpireAt = &expiresAt
+	return refs
+}
+
+// keepArtifactStatus carries the artifact fields the collector maintains from
+// the stored status into the one the controller is about to write, so
+// uploads recorded since the PowerTool was read are not lost. A listing
+// fetched by this reconcile covers every stored artifact, oldest first, so it
+// replaces the references and totals, and only artifacts recorded after it
+// was taken are added back.
+func keepArtifactStatus(status, stored *toev1alpha1.PowerToolStatus, listing []toev1alpha1.ArtifactReference) {
+	if listing == nil {
+		status.Artifacts = append([]toev1alpha1.ArtifactReference(nil), stored.Artifacts...)
+		status.ArtifactCount = stored.ArtifactCount
+		status.ArtifactBytes = stored.ArtifactBytes
+		status.BytesWritten = stored.BytesWritten
+		return
+	}
+
+	status.Artifacts, status.ArtifactCount, status.ArtifactBytes = nil, nil, nil
+	listed := make(map[string]bool, len(listing))
+	for _, ref := range listing {
+		listed[ref.Path] = true
+		status.RecordArtifact(ref)
+	}
+	for _, ref := range stored.Artifacts {
+		if !listed[ref.Path] {
+			status.RecordArtifact(ref)
+		}
+	}
+}
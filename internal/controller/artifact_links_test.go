@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/internal/config"
+)
+
+func TestCollectorLinker(t *testing.T) {
+	expires := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	collector := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v1/artifacts" || q.Get("namespace") != "web" || q.Get("powertool") != "job" || q.Get("expiresIn") != "24h0m0s" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer controller-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"artifacts": [
+			{"path": "a", "podName": "web-1", "size": 10, "sha256": "aa", "url": "/api/v1/artifacts/a", "signedUrl": "/api/v1/artifacts/a?expires=1&signature=x"},
+			{"path": "b", "podName": "web-2", "size": 20, "sha256": "bb", "url": "/api/v1/artifacts/b", "signedUrl": "https://profiles.example.com/api/v1/artifacts/b?expires=1&signature=y"}
+		], "linksExpireAt": %q}`, expires.Format(time.RFC3339))
+	}))
+	defer collector.Close()
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: collector.Certificate().Raw}))
+	powerTool := &toev1alpha1.PowerTool{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "web"}}
+
+	linker := &CollectorLinker{Token: func() (string, error) { return "controller-token", nil }}
+	refs, gotExpires, err := linker.ArtifactLinks(context.Background(), collector.URL+"/", caPEM, powerTool, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("ArtifactLinks() error = %v", err)
+	}
+	want := []toev1alpha1.ArtifactReference{
+		{Path: "a", PodName: "web-1", Size: 10, SHA256: "aa", URL: collector.URL + "/api/v1/artifacts/a?expires=1&signature=x"},
+		{Path: "b", PodName: "web-2", Size: 20, SHA256: "bb", URL: "https://profiles.example.com/api/v1/artifacts/b?expires=1&signature=y"},
+	}
+	if len(refs) != 2 || refs[0] != want[0] || refs[1] != want[1] {
+		t.Errorf("refs = %+v, want %+v", refs, want)
+	}
+	if !gotExpires.Equal(expires) {
+		t.Errorf("expires = %v, want %v", gotExpires, expires)
+	}
+
+	linker.Token = func() (string, error) { return "other-token", nil }
+	if _, _, err := linker.ArtifactLinks(context.Background(), collector.URL, caPEM, powerTool, 24*time.Hour); err == nil {
+		t.Error("ArtifactLinks() with a rejected token error = nil, want error")
+	}
+}
+
+func TestArtifactLinksDue(t *testing.T) {
+	ttl := 24 * time.Hour
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(d))
+		return &t
+	}
+	tests := []struct {
+		name      string
+		expires   *metav1.Time
+		completed *metav1.Time
+		grace     time.Duration
+		want      bool
+	}{
+		{name: "never fetched", want: true},
+		{name: "fetched while uploads may still arrive", expires: at(ttl - 3*time.Minute), completed: at(-5 * time.Minute), want: false},
+		{name: "fetched before uploads closed", expires: at(ttl - 3*time.Minute), completed: at(-11 * time.Minute), want: true},
+		{name: "fetched before a longer grace ended", expires: at(ttl - 3*time.Minute), completed: at(-11 * time.Minute), grace: 30 * time.Minute, want: false},
+		{name: "fetched after uploads closed", expires: at(ttl - time.Minute), completed: at(-time.Hour), want: false},
+		{name: "half the lifetime gone", expires: at(ttl / 2), completed: at(-13 * time.Hour), want: true},
+		{name: "expired", expires: at(-time.Hour), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &toev1alpha1.PowerToolStatus{ArtifactLinksExpireAt: tt.expires}
+			if tt.completed != nil {
+				status.Conditions = []toev1alpha1.PowerToolCondition{{
+					Type:               toev1alpha1.PowerToolConditionCompleted,
+					Status:             "True",
+					LastTransitionTime: *tt.completed,
+				}}
+			}
+			grace := tt.grace
+			if grace == 0 {
+				grace = config.DefaultUploadGracePeriod
+			}
+			if got := artifactLinksDue(status, ttl, grace, now); got != tt.want {
+				t.Errorf("artifactLinksDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// stubLinker returns fixed references or an error
+type stubLinker struct {
+	refs  []toev1alpha1.ArtifactReference
+	err   error
+	calls int
+}
+
+func (s *stubLinker) ArtifactLinks(context.Context, string, string, *toev1alpha1.PowerTool, time.Duration) ([]toev1alpha1.ArtifactReference, time.Time, error) {
+	s.calls++
+	return s.refs, time.Now().Add(24 * time.Hour), s.err
+}
+
+func TestFetchArtifactLinks(t *testing.T) {
+	endpoint := "https://toe-collector.toe-system.svc:8443"
+	newPowerTool := func() *toev1alpha1.PowerTool {
+		return &toev1alpha1.PowerTool{
+			ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "web"},
+			Status:     toev1alpha1.PowerToolStatus{CollectorEndpoint: &endpoint},
+		}
+	}
+	refs := []toev1alpha1.ArtifactReference{{Path: "a", Size: 10, SHA256: "aa", URL: "https://link"}}
+
+	t.Run("fetches links", func(t *testing.T) {
+		r := &PowerToolReconciler{Config: config.NewStore(nil), Linker: &stubLinker{refs: refs}}
+		powerTool := newPowerTool()
+		if got := r.fetchArtifactLinks(context.Background(), powerTool, "ca"); len(got) != 1 || powerTool.Status.ArtifactLinksExpireAt == nil {
+			t.Errorf("fetchArtifactLinks() = %v with expiry %v, want the links and their expiry", got, powerTool.Status.ArtifactLinksExpireAt)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		r := &PowerToolReconciler{Config: config.NewStore(nil), Linker: &stubLinker{err: errors.New("collector down")}}
+		powerTool := newPowerTool()
+		if got := r.fetchArtifactLinks(context.Background(), powerTool, "ca"); got != nil || powerTool.Status.ArtifactLinksExpireAt != nil {
+			t.Errorf("fetchArtifactLinks() = %v with expiry %v, want nothing", got, powerTool.Status.ArtifactLinksExpireAt)
+		}
+	})
+
+	t.Run("disabled by configuration", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.ArtifactLinkTTL = 0
+		linker := &stubLinker{refs: refs}
+		r := &PowerToolReconciler{Config: config.NewStore(cfg), Linker: linker}
+		r.fetchArtifactLinks(context.Background(), newPowerTool(), "ca")
+		if linker.calls != 0 {
+			t.Errorf("linker called %d times, want none", linker.calls)
+		}
+	})
+}
+
+func TestKeepArtifactStatus(t *testing.T) {
+	// The controller read the PowerTool before the collector recorded b
+	var status, stored toev1alpha1.PowerToolStatus
+	status.RecordArtifact(toev1alpha1.ArtifactReference{Path: "a", Size: 1024, SHA256: "aa"})
+	stored.RecordArtifact(toev1alpha1.ArtifactReference{Path: "a", Size: 1024, SHA256: "aa"})
+	stored.RecordArtifact(toev1alpha1.ArtifactReference{Path: "b", Size: 2048, SHA256: "bb"})
+	phase := PhaseCompleted
+	status.Phase = &phase
+
+	keepArtifactStatus(&status, &stored, nil)
+	if len(status.Artifacts) != 2 || *status.ArtifactCount != 2 || *status.BytesWritten != "3.0 KiB" {
+		t.Errorf("without a listing: %d artifacts, count %d, %s; want the stored 2 totalling 3.0 KiB",
+			len(status.Artifacts), *status.ArtifactCount, *status.BytesWritten)
+	}
+	if *status.Phase != PhaseCompleted {
+		t.Errorf("Phase = %s, want the controller's own status kept", *status.Phase)
+	}
+
+	// The listing was taken before b was stored, and already had c
+	keepArtifactStatus(&status, &stored, []toev1alpha1.ArtifactReference{
+		{Path: "a", Size: 1024, SHA256: "aa", URL: "https://link/a"},
+		{Path: "c", Size: 1024, SHA256: "cc", URL: "https://link/c"},
+	})
+	if len(status.Artifacts) != 3 || status.Artifacts[0].URL != "https://link/a" || status.Artifacts[2].Path != "b" {
+		t.Errorf("Artifacts = %+v, want a and c with links, then b", status.Artifacts)
+	}
+	if *status.ArtifactCount != 3 || *status.ArtifactBytes != 4096 || *status.BytesWritten != "4.0 KiB" {
+		t.Errorf("totals = %d artifacts, %d bytes (%s), want 3 and 4096 (4.0 KiB)", *status.ArtifactCount, *status.ArtifactBytes, *status.BytesWritten)
+	}
+	if len(stored.Artifacts) != 2 {
+		t.Errorf("stored Artifacts modified: %+v", stored.Artifacts)
+	}
+}
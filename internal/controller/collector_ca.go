@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+// resolveCollectorCA returns the PEM bundle tool containers use to verify the
+// collector's serving certificate. A CASecretRef on the PowerTool takes
+// precedence over the controller-wide CA ConfigMap. It fails when no usable
+// CA is found so collector uploads are never sent unverified.
+func (r *PowerToolReconciler) resolveCollectorCA(ctx context.Context, powerTool *toev1alpha1.PowerTool) (string, error) {
+	cfg := r.Config.Get()
+	collector := powerTool.Spec.Output.Collector
+
+	if collector != nil && collector.CASecretRef != nil {
+		ref := collector.CASecretRef
+		namespace := cfg.CollectorNamespace
+		if ref.Namespace != nil && *ref.Namespace != "" {
+			namespace = *ref.Namespace
+		}
+		if namespace != cfg.CollectorNamespace && namespace != powerTool.Namespace {
+			return "", fmt.Errorf("collector CA secret must be in namespace %s or %s, got %s",
+				cfg.CollectorNamespace, powerTool.Namespace, namespace)
+		}
+		key := cfg.CollectorCAKey
+		if ref.Key != nil && *ref.Key != "" {
+			key = *ref.Key
+		}
+
+		// Read Secrets directly rather than through the manager cache so the
+		// controller doesn't have to list and watch every Secret in the cluster
+		secret, err := r.K8sClient.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get collector CA secret %s/%s: %w", namespace, ref.Name, err)
+		}
+		return validateCABundle(secret.Data[key], fmt.Sprintf("secret %s/%s key %s", namespace, ref.Name, key))
+	}
+
+	var cm corev1.ConfigMap
+	cmKey := client.ObjectKey{Name: cfg.CollectorCAConfigMap, Namespace: cfg.CollectorNamespace}
+	if err := r.Get(ctx, cmKey, &cm); err != nil {
+		return "", fmt.Errorf("no collector CA available: failed to get ConfigMap %s: %w", cmKey, err)
+	}
+	return validateCABundle([]byte(cm.Data[cfg.CollectorCAKey]), fmt.Sprintf("configmap %s key %s", cmKey, cfg.CollectorCAKey))
+}
+
+// validateCABundle checks that data holds only PEM-encoded X.509 certificates.
+// Rejecting anything else keeps a misdirected reference from copying private
+// keys or other Secret content into a tool container's environment.
+func validateCABundle(data []byte, source string) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("no collector CA available: %s is empty", source)
+	}
+
+	rest := data
+	certs := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return "", fmt.Errorf("%s contains a %s block, only certificates are allowed", source, block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return "", fmt.Errorf("%s contains an invalid certificate: %w", source, err)
+		}
+		certs++
+	}
+
+	if certs == 0 {
+		return "", fmt.Errorf("no collector CA available: %s contains no PEM certificates", source)
+	}
+	return string(data), nil
+}
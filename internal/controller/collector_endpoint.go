@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+// CollectorLabel marks Services that expose a collector. Its value is the
+// collector name PowerTools refer to through output.collector.name.
+const CollectorLabel = "codriverlabs.ai.toe.run/collector"
+
+// usesCollector reports whether the PowerTool uploads its output to a collector
+func usesCollector(powerTool *toev1alpha1.PowerTool) bool {
+	return powerTool.Spec.Output.Mode == OutputModeCollector || powerTool.Spec.Output.Collector != nil
+}
+
+// resolveCollectorEndpoint returns the collector URL for a PowerTool, in order:
+// the explicit endpoint, the named collector Service, the configured default
+// endpoint, and finally the default collector Service. Tools send a bearer
+// token and profile data to it, so anything but https is refused.
+func (r *PowerToolReconciler) resolveCollectorEndpoint(ctx context.Context, powerTool *toev1alpha1.PowerTool) (string, error) {
+	endpoint, err := r.findCollectorEndpoint(ctx, powerTool)
+	if err != nil {
+		return "", err
+	}
+	if u, err := url.Parse(endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("collector endpoint %q must be an https URL", endpoint)
+	}
+	return endpoint, nil
+}
+
+func (r *PowerToolReconciler) findCollectorEndpoint(ctx context.Context, powerTool *toev1alpha1.PowerTool) (string, error) {
+	cfg := r.Config.Get()
+	collector := powerTool.Spec.Output.Collector
+
+	if collector != nil && collector.Endpoint != "" {
+		return collector.Endpoint, nil
+	}
+
+	name := cfg.DefaultCollectorName
+	if collector != nil && collector.Name != nil && *collector.Name != "" {
+		name = *collector.Name
+	} else if cfg.CollectorEndpoint != "" {
+		return cfg.CollectorEndpoint, nil
+	}
+
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services,
+		client.InNamespace(cfg.CollectorNamespace),
+		client.MatchingLabels{CollectorLabel: name},
+	); err != nil {
+		return "", fmt.Errorf("failed to list collector services: %w", err)
+	}
+
+	switch len(services.Items) {
+	case 0:
+		return "", fmt.Errorf("no collector Service labeled %s=%s in namespace %s", CollectorLabel, name, cfg.CollectorNamespace)
+	case 1:
+		return serviceEndpoint(&services.Items[0])
+	default:
+		return "", fmt.Errorf("found %d collector Services labeled %s=%s in namespace %s, expected one",
+			len(services.Items), CollectorLabel, name, cfg.CollectorNamespace)
+	}
+}
+
+// serviceEndpoint builds the in-cluster https URL of a collector Service. A
+// port named "https" is preferred. A port named or declared (appProtocol) as
+// "http" is refused rather than used without TLS.
+func serviceEndpoint(svc *corev1.Service) (string, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return "", fmt.Errorf("collector Service %s/%s exposes no ports", svc.Namespace, svc.Name)
+	}
+
+	port := svc.Spec.Ports[0]
+	for _, p := range svc.Spec.Ports {
+		if p.Name == "https" {
+			port = p
+			break
+		}
+	}
+
+	if port.Name == "http" || (port.AppProtocol != nil && *port.AppProtocol == "http") {
+		return "", fmt.Errorf("collector Service %s/%s port %d serves plain HTTP, collector mode requires https",
+			svc.Namespace, svc.Name, port.Port)
+	}
+
+	return fmt.Sprintf("https://%s.%s.svc:%d", svc.Name, svc.Namespace, port.Port), nil
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/internal/config"
+)
+
+func collectorService(name, namespace, collectorName string, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{CollectorLabel: collectorName},
+		},
+		Spec: corev1.ServiceSpec{Ports: ports},
+	}
+}
+
+func TestResolveCollectorEndpoint(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+
+	defaultSvc := collectorService("toe-collector", "toe-system", "default",
+		corev1.ServicePort{Name: "https", Port: 8443})
+	teamSvc := collectorService("team-collector", "toe-system", "team-a",
+		corev1.ServicePort{Name: "metrics", Port: 9090},
+		corev1.ServicePort{Name: "https", Port: 443})
+	otherNSSvc := collectorService("stray-collector", "default", "stray",
+		corev1.ServicePort{Name: "https", Port: 8443})
+
+	tests := []struct {
+		name           string
+		collector      *toev1alpha1.CollectorSpec
+		configEndpoint string
+		services       []corev1.Service
+		want           string
+		wantErr        string
+	}{
+		{
+			name:      "explicit endpoint wins",
+			collector: &toev1alpha1.CollectorSpec{Endpoint: "https://custom:9443", Name: stringPtr("team-a")},
+			services:  []corev1.Service{*teamSvc},
+			want:      "https://custom:9443",
+		},
+		{
+			name:     "nil collector uses default service",
+			services: []corev1.Service{*defaultSvc},
+			want:     "https://toe-collector.toe-system.svc:8443",
+		},
+		{
+			name:      "named collector prefers https port",
+			collector: &toev1alpha1.CollectorSpec{Name: stringPtr("team-a")},
+			services:  []corev1.Service{*defaultSvc, *teamSvc},
+			want:      "https://team-collector.toe-system.svc:443",
+		},
+		{
+			name:           "configured endpoint used when no name is given",
+			configEndpoint: "https://collector.example.com",
+			services:       []corev1.Service{*defaultSvc},
+			want:           "https://collector.example.com",
+		},
+		{
+			name:           "named collector overrides configured endpoint",
+			collector:      &toev1alpha1.CollectorSpec{Name: stringPtr("team-a")},
+			configEndpoint: "https://collector.example.com",
+			services:       []corev1.Service{*teamSvc},
+			want:           "https://team-collector.toe-system.svc:443",
+		},
+		{
+			name:      "services outside the collector namespace are ignored",
+			collector: &toev1alpha1.CollectorSpec{Name: stringPtr("stray")},
+			services:  []corev1.Service{*otherNSSvc},
+			wantErr:   "no collector Service",
+		},
+		{
+			name:      "explicit http endpoint is refused",
+			collector: &toev1alpha1.CollectorSpec{Endpoint: "http://custom:8080"},
+			wantErr:   "must be an https URL",
+		},
+		{
+			name:           "configured http endpoint is refused",
+			configEndpoint: "http://collector.example.com",
+			wantErr:        "must be an https URL",
+		},
+		{
+			name:     "plain HTTP collector service is refused",
+			services: []corev1.Service{*collectorService("toe-collector", "toe-system", "default", corev1.ServicePort{Name: "http", Port: 8080})},
+			wantErr:  "plain HTTP",
+		},
+		{
+			name:    "no default collector",
+			wantErr: "no collector Service",
+		},
+		{
+			name: "ambiguous collector",
+			services: []corev1.Service{
+				*defaultSvc,
+				*collectorService("second", "toe-system", "default", corev1.ServicePort{Port: 8443}),
+			},
+			wantErr: "expected one",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			for i := range tt.services {
+				builder = builder.WithObjects(&tt.services[i])
+			}
+
+			cfg := config.Default()
+			cfg.CollectorEndpoint = tt.configEndpoint
+			r := &PowerToolReconciler{
+				Client: builder.Build(),
+				Scheme: scheme,
+				Config: config.NewStore(cfg),
+			}
+
+			powerTool := &toev1alpha1.PowerTool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pt", Namespace: "default"},
+				Spec: toev1alpha1.PowerToolSpec{
+					Output: toev1alpha1.OutputSpec{Mode: OutputModeCollector, Collector: tt.collector},
+				},
+			}
+
+			got, err := r.resolveCollectorEndpoint(context.Background(), powerTool)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveCollectorEndpoint() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveCollectorEndpoint() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveCollectorEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceEndpoint_Scheme(t *testing.T) {
+	httpProtocol := "http"
+
+	tests := []struct {
+		name    string
+		ports   []corev1.ServicePort
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "unnamed port defaults to https",
+			ports: []corev1.ServicePort{{Port: 8443}},
+			want:  "https://c.toe-system.svc:8443",
+		},
+		{
+			name:    "port named http is refused",
+			ports:   []corev1.ServicePort{{Name: "http", Port: 8080}},
+			wantErr: true,
+		},
+		{
+			name:    "appProtocol http is refused",
+			ports:   []corev1.ServicePort{{Name: "web", Port: 8080, AppProtocol: &httpProtocol}},
+			wantErr: true,
+		},
+		{
+			name:  "https port preferred over http",
+			ports: []corev1.ServicePort{{Name: "http", Port: 8080}, {Name: "https", Port: 8443}},
+			want:  "https://c.toe-system.svc:8443",
+		},
+		{
+			name:    "no ports",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := serviceEndpoint(collectorService("c", "toe-system", "default", tt.ports...))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("serviceEndpoint() expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("serviceEndpoint() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("serviceEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsesCollector(t *testing.T) {
+	if !usesCollector(&toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{Output: toev1alpha1.OutputSpec{Mode: OutputModeCollector}}}) {
+		t.Error("collector mode without a collector block should use the collector")
+	}
+	if usesCollector(&toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{Output: toev1alpha1.OutputSpec{Mode: "ephemeral"}}}) {
+		t.Error("ephemeral mode should not use the collector")
+	}
+}
@@ -6,14 +6,17 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"toe/internal/config"
 )
 
 func TestNewPowerToolReconciler(t *testing.T) {
 	scheme := runtime.NewScheme()
 	var mockClient client.Client
 	var mockK8sClient kubernetes.Interface
+	store := config.NewStore(nil)
 
-	r := NewPowerToolReconciler(mockClient, scheme, mockK8sClient)
+	r := NewPowerToolReconciler(mockClient, scheme, mockK8sClient, store)
 
 	if r == nil {
 		t.Fatal("expected non-nil reconciler")
@@ -30,4 +33,8 @@ func TestNewPowerToolReconciler(t *testing.T) {
 	if r.K8sClient != mockK8sClient {
 		t.Error("K8sClient not set correctly")
 	}
+
+	if r.Config != store {
+		t.Error("Config not set correctly")
+	}
 }
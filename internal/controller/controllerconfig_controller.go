@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"toe/internal/config"
+)
+
+// ControllerConfigReconciler keeps a config.Store in sync with the
+// tools-configuration ConfigMap so tunables can be changed without a restart
+type ControllerConfigReconciler struct {
+	client.Client
+	Name      string
+	Namespace string
+	Store     *config.Store
+}
+
+// Reconcile loads the ConfigMap into the Store. Invalid data is logged and the
+// previous configuration is kept.
+func (r *ControllerConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var cm corev1.ConfigMap
+	if err := r.Get(ctx, req.NamespacedName, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("Controller ConfigMap not found, using defaults")
+			r.Store.Set(config.Default())
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	cfg, err := config.Parse(cm.Data)
+	if err != nil {
+		logger.Error(err, "ignoring invalid controller configuration, keeping previous values")
+		return ctrl.Result{}, nil
+	}
+
+	r.Store.Set(cfg)
+	logger.Info("Loaded controller configuration",
+		"tokenExpiryMultiplier", cfg.TokenExpiryMultiplier,
+		"tokenBuffer", cfg.TokenBuffer,
+		"toolConfigNamespaces", cfg.ToolConfigNamespaces,
+		"collectorNamespace", cfg.CollectorNamespace)
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ControllerConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	isConfigMap := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == r.Name && obj.GetNamespace() == r.Namespace
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("controllerconfig").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(isConfigMap)).
+		Complete(r)
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/internal/config"
+)
+
+func TestControllerConfigReconciler(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+
+	key := types.NamespacedName{Name: "toe-tools-configuration", Namespace: "toe-system"}
+
+	tests := []struct {
+		name       string
+		data       map[string]string
+		missing    bool
+		wantBuffer time.Duration
+	}{
+		{
+			name:       "valid configmap is applied",
+			data:       map[string]string{config.KeyTokenBufferSeconds: "45"},
+			wantBuffer: 45 * time.Second,
+		},
+		{
+			name:       "invalid configmap keeps previous configuration",
+			data:       map[string]string{config.KeyTokenBufferSeconds: "abc"},
+			wantBuffer: 99 * time.Second,
+		},
+		{
+			name:       "missing configmap resets to defaults",
+			missing:    true,
+			wantBuffer: config.DefaultTokenBuffer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			if !tt.missing {
+				builder = builder.WithObjects(&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+					Data:       tt.data,
+				})
+			}
+
+			previous := config.Default()
+			previous.TokenBuffer = 99 * time.Second
+			store := config.NewStore(previous)
+
+			r := &ControllerConfigReconciler{
+				Client:    builder.Build(),
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Store:     store,
+			}
+
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() unexpected error = %v", err)
+			}
+
+			if got := store.Get().TokenBuffer; got != tt.wantBuffer {
+				t.Errorf("TokenBuffer = %v, want %v", got, tt.wantBuffer)
+			}
+		})
+	}
+}
+
+func TestPowerToolReconciler_UsesConfigStore(t *testing.T) {
+	cfg := config.Default()
+	cfg.ActiveRunningInterval = 42 * time.Second
+	cfg.TokenExpiryMultiplier = 1
+	cfg.TokenBuffer = time.Minute
+	cfg.MinTokenDuration = 20 * time.Minute
+
+	r := &PowerToolReconciler{Config: config.NewStore(cfg)}
+
+	running := "Running"
+	interval := r.getRequeueInterval(&toev1alpha1.PowerTool{
+		Status: toev1alpha1.PowerToolStatus{Phase: &running},
+	})
+	if interval != 42*time.Second {
+		t.Errorf("getRequeueInterval() = %v, want 42s", interval)
+	}
+
+	if got := r.getTokenDuration(context.Background(), 5*time.Minute); got != 20*time.Minute {
+		t.Errorf("getTokenDuration() = %v, want configured minimum 20m", got)
+	}
+	if got := r.getTokenDuration(context.Background(), 30*time.Minute); got != 31*time.Minute {
+		t.Errorf("getTokenDuration() = %v, want 31m", got)
+	}
+}
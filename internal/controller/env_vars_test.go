@@ -5,6 +5,7 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 
 	toev1alpha1 "toe/api/v1alpha1"
 )
@@ -164,7 +165,9 @@ func TestBuildPowerToolEnvVars(t *testing.T) {
 						Duration: "45s",
 					},
 					Output: toev1alpha1.OutputSpec{
-						Mode: "collector",
+						Mode:            "collector",
+						RetentionDays:   ptr.To[int32](14),
+						RollingInterval: ptr.To("10m"),
 					},
 				},
 			},
@@ -184,6 +187,52 @@ func TestBuildPowerToolEnvVars(t *testing.T) {
 				"TARGET_NAMESPACE":    "default",
 				"POD_MATCHING_LABELS": "tier-backend",
 				"OUTPUT_MODE":         "collector",
+				"RETENTION_DAYS":      "14",
+				"ROLLING_INTERVAL":    "10m",
+			},
+		},
+		{
+			name: "grouped by a chosen label",
+			powerTool: &toev1alpha1.PowerTool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "team-profile",
+					Namespace: "default",
+				},
+				Spec: toev1alpha1.PowerToolSpec{
+					Targets: toev1alpha1.TargetSpec{
+						LabelSelector: &metav1.LabelSelector{
+							MatchExpressions: []metav1.LabelSelectorRequirement{
+								{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"api", "web"}},
+							},
+						},
+					},
+					Tool: toev1alpha1.ToolSpec{
+						Name:     "aperf",
+						Duration: "30s",
+					},
+					Output: toev1alpha1.OutputSpec{
+						Mode:    "collector",
+						GroupBy: ptr.To("team"),
+					},
+				},
+			},
+			targetPod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "api-pod",
+					Namespace: "default",
+					Labels: map[string]string{
+						"app":  "api",
+						"team": "payments",
+					},
+				},
+			},
+			wantEnvs: map[string]string{
+				"PROFILER_TOOL":       "aperf",
+				"PROFILER_DURATION":   "30s",
+				"TARGET_POD_NAME":     "api-pod",
+				"TARGET_NAMESPACE":    "default",
+				"POD_MATCHING_LABELS": "team-payments",
+				"OUTPUT_MODE":         "collector",
 			},
 		},
 	}
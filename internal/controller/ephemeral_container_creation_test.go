@@ -171,7 +171,7 @@ func TestCreateEphemeralContainerForPod(t *testing.T) {
 				Build()
 
 			k8sClient := k8sfake.NewSimpleClientset(&tt.pod)
-			reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient)
+			reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient, nil)
 
 			err := reconciler.createEphemeralContainerForPod(
 				context.Background(),
@@ -179,6 +179,8 @@ func TestCreateEphemeralContainerForPod(t *testing.T) {
 				tt.toolConfig,
 				tt.pod,
 				tt.containerName,
+				"",
+				"",
 			)
 
 			if tt.expectError {
@@ -239,7 +241,7 @@ func TestCreateEphemeralContainer_WithToolArgs(t *testing.T) {
 		Build()
 
 	k8sClient := k8sfake.NewSimpleClientset(&pod)
-	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient)
+	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient, nil)
 
 	err := reconciler.createEphemeralContainerForPod(
 		context.Background(),
@@ -247,6 +249,8 @@ func TestCreateEphemeralContainer_WithToolArgs(t *testing.T) {
 		toolConfig,
 		pod,
 		"test-container-args",
+		"",
+		"",
 	)
 
 	assert.NoError(t, err)
@@ -292,7 +296,7 @@ func TestCreateEphemeralContainer_ErrorCases(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			fakeClient, k8sClient := tt.setupClient()
-			reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient)
+			reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient, nil)
 
 			powerTool := &toev1alpha1.PowerTool{
 				ObjectMeta: metav1.ObjectMeta{
@@ -329,6 +333,8 @@ func TestCreateEphemeralContainer_ErrorCases(t *testing.T) {
 				toolConfig,
 				pod,
 				"test-container",
+				"",
+				"",
 			)
 
 			if tt.expectError {
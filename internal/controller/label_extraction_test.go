@@ -54,24 +54,19 @@ func TestExtractMatchingLabels_EdgeCases(t *testing.T) {
 			expected:  "app-nginx",
 		},
 		{
-			name: "multiple matches - returns first",
+			name: "multiple matches - all, sorted by key",
 			selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{"app": "nginx", "env": "prod"},
 			},
 			podLabels: map[string]string{"app": "nginx", "env": "prod"},
-			expected:  "app-nginx", // or "env-prod" depending on map iteration
+			expected:  "app-nginx_env-prod",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := r.extractMatchingLabels(tt.selector, tt.podLabels)
-			// For multiple matches, just verify it's not "unknown"
-			if tt.name == "multiple matches - returns first" {
-				if got == "unknown" {
-					t.Errorf("expected a matching label, got %s", got)
-				}
-			} else if got != tt.expected {
+			if got != tt.expected {
 				t.Errorf("extractMatchingLabels() = %v, want %v", got, tt.expected)
 			}
 		})
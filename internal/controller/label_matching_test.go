@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"strings"
 	"testing"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	toev1alpha1 "toe/api/v1alpha1"
 )
 
 func TestExtractMatchingLabels(t *testing.T) {
@@ -28,7 +32,7 @@ func TestExtractMatchingLabels(t *testing.T) {
 			want: "app-nginx",
 		},
 		{
-			name: "multiple matching labels - returns first",
+			name: "multiple matching labels - all, sorted by key",
 			selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app": "nginx",
@@ -39,7 +43,90 @@ func TestExtractMatchingLabels(t *testing.T) {
 				"app": "nginx",
 				"env": "prod",
 			},
-			want: "app-nginx", // or "env-prod" - depends on map iteration
+			want: "app-nginx_env-prod",
+		},
+		{
+			name: "only matched labels of several",
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"tier": "web",
+					"app":  "nginx",
+					"env":  "prod",
+				},
+			},
+			podLabels: map[string]string{
+				"app":  "nginx",
+				"tier": "web",
+				"env":  "staging",
+			},
+			want: "app-nginx_tier-web",
+		},
+		{
+			name: "In expression",
+			selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod", "staging"}},
+				},
+			},
+			podLabels: map[string]string{
+				"env": "staging",
+			},
+			want: "env-staging",
+		},
+		{
+			name: "Exists expression",
+			selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app.kubernetes.io/name", Operator: metav1.LabelSelectorOpExists},
+				},
+			},
+			podLabels: map[string]string{
+				"app.kubernetes.io/name": "api",
+			},
+			want: "app.kubernetes.io_name-api",
+		},
+		{
+			name: "labels and expressions combined",
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"tier": "backend",
+				},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"api"}},
+					{Key: "canary", Operator: metav1.LabelSelectorOpDoesNotExist},
+					{Key: "env", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+				},
+			},
+			podLabels: map[string]string{
+				"tier": "backend",
+				"app":  "api",
+				"env":  "prod",
+			},
+			want: "app-api_tier-backend",
+		},
+		{
+			name: "only exclusions",
+			selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "env", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+				},
+			},
+			podLabels: map[string]string{
+				"env": "prod",
+			},
+			want: "unknown",
+		},
+		{
+			name: "prefixed label key",
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app.kubernetes.io/name": "nginx",
+				},
+			},
+			podLabels: map[string]string{
+				"app.kubernetes.io/name": "nginx",
+			},
+			want: "app.kubernetes.io_name-nginx",
 		},
 		{
 			name: "no matching labels",
@@ -145,16 +232,57 @@ func TestExtractMatchingLabels(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := r.extractMatchingLabels(tt.selector, tt.podLabels)
+			// Map iteration order must not show through
+			for range 10 {
+				if got := r.extractMatchingLabels(tt.selector, tt.podLabels); got != tt.want {
+					t.Fatalf("extractMatchingLabels() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
 
-			// For cases with multiple matching labels, we accept any of them
-			if tt.name == "multiple matching labels - returns first" {
-				if got != "app-nginx" && got != "env-prod" {
-					t.Errorf("extractMatchingLabels() = %v, want app-nginx or env-prod", got)
+func TestGroupingKey(t *testing.T) {
+	r := &PowerToolReconciler{}
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "nginx", "env": "prod"},
+	}
+	podLabels := map[string]string{"app": "nginx", "env": "prod", "team": "payments", "example.com/owner": "sre"}
+	long := strings.Repeat("x", 63)
+
+	tests := []struct {
+		name      string
+		groupBy   *string
+		selector  *metav1.LabelSelector
+		podLabels map[string]string
+		want      string
+	}{
+		{name: "matched labels", selector: selector, podLabels: podLabels, want: "app-nginx_env-prod"},
+		{name: "empty groupBy", groupBy: ptr.To(""), selector: selector, podLabels: podLabels, want: "app-nginx_env-prod"},
+		{name: "groupBy", groupBy: ptr.To("team"), selector: selector, podLabels: podLabels, want: "team-payments"},
+		{name: "prefixed groupBy", groupBy: ptr.To("example.com/owner"), selector: selector, podLabels: podLabels, want: "example.com_owner-sre"},
+		{name: "groupBy label missing", groupBy: ptr.To("cost-center"), selector: selector, podLabels: podLabels, want: "unknown"},
+		{
+			name: "too long for a path element",
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"a": long, "b": long, "c": long, "d": long},
+			},
+			podLabels: map[string]string{"a": long, "b": long, "c": long, "d": long},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{
+				Targets: toev1alpha1.TargetSpec{LabelSelector: tt.selector},
+				Output:  toev1alpha1.OutputSpec{GroupBy: tt.groupBy},
+			}}
+			got := r.groupingKey(job, tt.podLabels)
+			if tt.want == "" {
+				if len(got) != maxGroupKeyLength || !strings.HasPrefix(got, "a-xxx") {
+					t.Errorf("groupingKey() = %q (%d characters), want a %d character key", got, len(got), maxGroupKeyLength)
 				}
 				return
 			}
-
 			if got != tt.want {
 				t.Errorf("extractMatchingLabels() = %v, want %v", got, tt.want)
 			}
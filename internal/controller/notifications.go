@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/internal/notify"
+)
+
+// defaultSigningSecretKey is the Secret key holding a sink's signing secret
+// when the reference names none
+const defaultSigningSecretKey = "secret"
+
+// Notifier delivers run notifications to sinks without blocking the
+// reconcile
+type Notifier interface {
+	Notify(ctx context.Context, sinks []notify.Sink, event notify.Event)
+}
+
+// updateStatus writes the status of powerTool and then sends the
+// notifications it calls for
+func (r *PowerToolReconciler) updateStatus(ctx context.Context, powerTool *toev1alpha1.PowerTool, pods []corev1.Pod) error {
+	events := pendingNotifications(powerTool, pods)
+	if err := r.Status().Update(ctx, powerTool); err != nil {
+		return err
+	}
+	r.sendNotifications(ctx, powerTool, events)
+	return nil
+}
+
+// pendingNotifications returns the events the status of powerTool calls for
+// and records them as sent in the status, which must be written before they
+// are delivered. Events are thus sent at most once, even across controller
+// restarts. A status written before notifications were recorded is taken as
+// already announced, so upgrading the controller does not replay old runs.
+func pendingNotifications(powerTool *toev1alpha1.PowerTool, pods []corev1.Pod) []notify.Event {
+	status := &powerTool.Status
+	baseline := status.Notifications == nil
+	if baseline {
+		status.Notifications = &toev1alpha1.NotificationStatus{}
+	}
+	sent := status.Notifications
+	phase := ""
+	if status.Phase != nil {
+		phase = *status.Phase
+	}
+	var artifactCount int32
+	if status.ArtifactCount != nil {
+		artifactCount = *status.ArtifactCount
+	}
+
+	var events []notify.Event
+	if (phase == "Running" || phase == PhaseCompleted) && !slices.Contains(sent.Events, toev1alpha1.NotificationEventStarted) {
+		sent.Events = append(sent.Events, toev1alpha1.NotificationEventStarted)
+		event := newEvent(powerTool, toev1alpha1.NotificationEventStarted, "")
+		event.Pods = podOutcomes(powerTool, pods)
+		events = append(events, event)
+	}
+
+	// An artifact count lower than announced means the listing of a
+	// finished run replaced the totals; only growth is news
+	if artifactCount > sent.Artifacts {
+		event := newEvent(powerTool, toev1alpha1.NotificationEventArtifactStored, fmt.Sprintf("%d", artifactCount))
+		added := int(artifactCount - sent.Artifacts)
+		event.Artifacts = slices.Clone(status.Artifacts[max(len(status.Artifacts)-added, 0):])
+		events = append(events, event)
+	}
+	sent.Artifacts = artifactCount
+
+	if !slices.Contains(sent.Events, toev1alpha1.NotificationEventCompleted) &&
+		!slices.Contains(sent.Events, toev1alpha1.NotificationEventFailed) {
+		if event, ok := finishedEvent(powerTool, pods); ok {
+			sent.Events = append(sent.Events, event.Type)
+			events = append(events, event)
+		}
+	}
+
+	if baseline {
+		return nil
+	}
+	return events
+}
+
+// finishedEvent returns the Completed or Failed event of a run that ended.
+// A run fails when its configuration is rejected or a tool exits with an
+// error in any pod.
+func finishedEvent(powerTool *toev1alpha1.PowerTool, pods []corev1.Pod) (notify.Event, bool) {
+	for _, condition := range powerTool.Status.Conditions {
+		if condition.Type == toev1alpha1.PowerToolConditionFailed && condition.Status == "True" {
+			event := newEvent(powerTool, toev1alpha1.NotificationEventFailed, "")
+			event.Message = condition.Message
+			return event, true
+		}
+	}
+	if powerTool.Status.Phase == nil || *powerTool.Status.Phase != PhaseCompleted {
+		return notify.Event{}, false
+	}
+
+	outcomes := podOutcomes(powerTool, pods)
+	failed := 0
+	for _, o := range outcomes {
+		if o.Outcome == notify.OutcomeFailed {
+			failed++
+		}
+	}
+	event := newEvent(powerTool, toev1alpha1.NotificationEventCompleted, "")
+	event.Message = fmt.Sprintf("Tool completed on %d pods", len(outcomes))
+	if failed > 0 {
+		event = newEvent(powerTool, toev1alpha1.NotificationEventFailed, "")
+		event.Message = fmt.Sprintf("Tool failed on %d of %d pods", failed, len(outcomes))
+	}
+	event.Pods = outcomes
+	event.Artifacts = slices.Clone(powerTool.Status.Artifacts)
+	return event, true
+}
+
+// newEvent builds an event from the status of powerTool. Its ID is derived
+// from the PowerTool's UID and the event, plus discriminator when the event
+// can happen more than once.
+func newEvent(powerTool *toev1alpha1.PowerTool, eventType, discriminator string) notify.Event {
+	status := &powerTool.Status
+	id := fmt.Sprintf("%s-%s", powerTool.UID, eventType)
+	if discriminator != "" {
+		id += "-" + discriminator
+	}
+	event := notify.Event{
+		ID:   id,
+		Type: eventType,
+		Time: time.Now().UTC(),
+		PowerTool: notify.PowerToolRef{
+			Namespace: powerTool.Namespace,
+			Name:      powerTool.Name,
+			UID:       string(powerTool.UID),
+			Tool:      powerTool.Spec.Tool.Name,
+		},
+		StartedAt:             timeOf(status.StartedAt),
+		FinishedAt:            timeOf(status.FinishedAt),
+		ArtifactLinksExpireAt: timeOf(status.ArtifactLinksExpireAt),
+	}
+	if status.Phase != nil {
+		event.Phase = *status.Phase
+	}
+	if status.ArtifactCount != nil {
+		event.ArtifactCount = *status.ArtifactCount
+	}
+	if status.ArtifactBytes != nil {
+		event.ArtifactBytes = *status.ArtifactBytes
+	}
+	return event
+}
+
+func timeOf(t *metav1.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// podOutcomes reports the state of the tool container in each target pod
+func podOutcomes(powerTool *toev1alpha1.PowerTool, pods []corev1.Pod) []notify.PodOutcome {
+	containerName := powerTool.ToolContainerName()
+	outcomes := make([]notify.PodOutcome, 0, len(pods))
+	for _, pod := range pods {
+		outcome := notify.PodOutcome{Name: pod.Name, Node: pod.Spec.NodeName, Outcome: notify.OutcomeUnknown}
+		for _, ec := range pod.Spec.EphemeralContainers {
+			if ec.Name == containerName {
+				outcome.Outcome = notify.OutcomePending
+			}
+		}
+		for _, cs := range pod.Status.EphemeralContainerStatuses {
+			if cs.Name != containerName {
+				continue
+			}
+			switch {
+			case cs.State.Running != nil:
+				outcome.Outcome = notify.OutcomeRunning
+			case cs.State.Terminated != nil:
+				terminated := cs.State.Terminated
+				exitCode := terminated.ExitCode
+				outcome.ExitCode = &exitCode
+				outcome.Reason = terminated.Reason
+				outcome.Message = terminated.Message
+				outcome.Outcome = notify.OutcomeSucceeded
+				if exitCode != 0 {
+					outcome.Outcome = notify.OutcomeFailed
+				}
+			case cs.State.Waiting != nil:
+				outcome.Outcome = notify.OutcomePending
+				outcome.Reason = cs.State.Waiting.Reason
+				outcome.Message = cs.State.Waiting.Message
+			}
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
+
+// sendNotifications hands events to the notifier for every sink that
+// applies to powerTool
+func (r *PowerToolReconciler) sendNotifications(ctx context.Context, powerTool *toev1alpha1.PowerTool, events []notify.Event) {
+	if r.Notifier == nil || len(events) == 0 {
+		return
+	}
+	sinks := r.notificationSinks(ctx, powerTool)
+	if len(sinks) == 0 {
+		return
+	}
+	for _, event := range events {
+		r.Notifier.Notify(ctx, sinks, event)
+	}
+}
+
+// notificationSinks resolves the NotificationSinks selecting the namespace
+// of powerTool and the sinks it declares. A sink that cannot be resolved is
+// logged and skipped, so it does not hold back the others.
+func (r *PowerToolReconciler) notificationSinks(ctx context.Context, powerTool *toev1alpha1.PowerTool) []notify.Sink {
+	logger := log.FromContext(ctx)
+	var sinks []notify.Sink
+
+	var list toev1alpha1.NotificationSinkList
+	if err := r.List(ctx, &list); err != nil {
+		logger.Error(err, "failed to list NotificationSinks")
+	}
+	for i := range list.Items {
+		ns := &list.Items[i]
+		selected, err := namespaceSelected(ns.Spec.NamespaceSelector, powerTool.Namespace)
+		if err != nil {
+			logger.Error(err, "invalid NotificationSink namespace selector", "sink", ns.Name)
+			continue
+		}
+		if !selected {
+			continue
+		}
+		// Cluster sinks read their secret from the controller's namespace
+		// unless they name another
+		namespace := r.Config.Get().CollectorNamespace
+		if ref := ns.Spec.SigningSecretRef; ref != nil && ref.Namespace != nil && *ref.Namespace != "" {
+			namespace = *ref.Namespace
+		}
+		sink, err := r.resolveSink(ctx, "notificationsink/"+ns.Name, &ns.Spec.SinkSpec, namespace, false)
+		if err != nil {
+			logger.Error(err, "skipping NotificationSink", "sink", ns.Name)
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if powerTool.Spec.Notifications != nil {
+		for i := range powerTool.Spec.Notifications.Sinks {
+			spec := &powerTool.Spec.Notifications.Sinks[i]
+			// Sinks declared by PowerTool authors only read Secrets of the
+			// PowerTool's namespace and may not target internal addresses
+			sink, err := r.resolveSink(ctx, "powertool/"+spec.Name, &spec.SinkSpec, powerTool.Namespace, true)
+			if err != nil {
+				logger.Error(err, "skipping PowerTool notification sink", "sink", spec.Name)
+				continue
+			}
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
+
+// resolveSink checks spec and reads its signing secret from namespace
+func (r *PowerToolReconciler) resolveSink(ctx context.Context, name string, spec *toev1alpha1.SinkSpec, namespace string, restricted bool) (notify.Sink, error) {
+	sink := notify.Sink{
+		Name:       name,
+		Type:       spec.Type,
+		URL:        spec.URL,
+		Events:     slices.Clone(spec.Events),
+		MaxRetries: notify.DefaultMaxRetries,
+		Restricted: restricted,
+	}
+	if restricted {
+		sink.AllowedNetworks = r.Config.Get().NotificationAllowedNetworks
+	}
+	if spec.MaxRetries != nil {
+		sink.MaxRetries = int(*spec.MaxRetries)
+	}
+	if err := sink.Validate(); err != nil {
+		return notify.Sink{}, err
+	}
+
+	if ref := spec.SigningSecretRef; ref != nil {
+		key := defaultSigningSecretKey
+		if ref.Key != nil && *ref.Key != "" {
+			key = *ref.Key
+		}
+		// Read Secrets directly rather than through the manager cache so the
+		// controller doesn't have to list and watch every Secret in the cluster
+		secret, err := r.K8sClient.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return notify.Sink{}, fmt.Errorf("failed to get signing secret %s/%s: %w", namespace, ref.Name, err)
+		}
+		if len(secret.Data[key]) == 0 {
+			return notify.Sink{}, fmt.Errorf("signing secret %s/%s has no key %s", namespace, ref.Name, key)
+		}
+		sink.SigningKey = secret.Data[key]
+	}
+	return sink, nil
+}
+
+// namespaceSelected reports whether selector selects namespace. A nil or
+// empty selector selects every namespace.
+func namespaceSelected(selector *toev1alpha1.NamespaceSelector, namespace string) (bool, error) {
+	if selector == nil || (len(selector.MatchNames) == 0 && selector.MatchRegex == nil) {
+		return true, nil
+	}
+	if slices.Contains(selector.MatchNames, namespace) {
+		return true, nil
+	}
+	if selector.MatchRegex != nil {
+		re, err := regexp.Compile("^(?:" + *selector.MatchRegex + ")$")
+		if err != nil {
+			return false, fmt.Errorf("invalid matchRegex: %w", err)
+		}
+		return re.MatchString(namespace), nil
+	}
+	return false, nil
+}
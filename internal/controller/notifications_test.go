@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/internal/config"
+	"toe/internal/notify"
+)
+
+// recordingNotifier keeps the notifications it is asked to send
+type recordingNotifier struct {
+	mu     sync.Mutex
+	sinks  [][]notify.Sink
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, sinks []notify.Sink, event notify.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sinks = append(n.sinks, sinks)
+	n.events = append(n.events, event)
+}
+
+func eventTypes(events []notify.Event) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func toolPod(name, containerName string, state corev1.ContainerState) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			EphemeralContainers: []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: containerName},
+			}},
+		},
+		Status: corev1.PodStatus{
+			EphemeralContainerStatuses: []corev1.ContainerStatus{{Name: containerName, State: state}},
+		},
+	}
+}
+
+func TestPendingNotifications(t *testing.T) {
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default", UID: "0123456789abcdef"},
+		Spec:       toev1alpha1.PowerToolSpec{Tool: toev1alpha1.ToolSpec{Name: "aperf"}},
+		Status:     toev1alpha1.PowerToolStatus{Notifications: &toev1alpha1.NotificationStatus{}},
+	}
+	container := powerTool.ToolContainerName()
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	succeeded := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"}}
+	failed := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2, Reason: "Error"}}
+	phase := func(p string) { powerTool.Status.Phase = &p }
+
+	phase("Pending")
+	if events := pendingNotifications(powerTool, nil); len(events) != 0 {
+		t.Errorf("pending run: events = %v, want none", eventTypes(events))
+	}
+
+	phase("Running")
+	pods := []corev1.Pod{toolPod("web-1", container, running), toolPod("web-2", container, running)}
+	events := pendingNotifications(powerTool, pods)
+	if got := eventTypes(events); len(got) != 1 || got[0] != toev1alpha1.NotificationEventStarted {
+		t.Fatalf("running run: events = %v, want [Started]", got)
+	}
+	if len(events[0].Pods) != 2 || events[0].Pods[0].Outcome != notify.OutcomeRunning || events[0].Pods[0].Node != "node-1" {
+		t.Errorf("Started pods = %+v", events[0].Pods)
+	}
+	if events := pendingNotifications(powerTool, pods); len(events) != 0 {
+		t.Errorf("second reconcile: events = %v, want none", eventTypes(events))
+	}
+
+	powerTool.Status.RecordArtifact(toev1alpha1.ArtifactReference{Path: "a", PodName: "web-1"})
+	powerTool.Status.RecordArtifact(toev1alpha1.ArtifactReference{Path: "b", PodName: "web-2"})
+	events = pendingNotifications(powerTool, pods)
+	if got := eventTypes(events); len(got) != 1 || got[0] != toev1alpha1.NotificationEventArtifactStored {
+		t.Fatalf("artifacts stored: events = %v, want [ArtifactStored]", got)
+	}
+	if len(events[0].Artifacts) != 2 || events[0].ID != "0123456789abcdef-ArtifactStored-2" {
+		t.Errorf("ArtifactStored = %+v", events[0])
+	}
+	powerTool.Status.RecordArtifact(toev1alpha1.ArtifactReference{Path: "c", PodName: "web-1"})
+	events = pendingNotifications(powerTool, pods)
+	if len(events) != 1 || len(events[0].Artifacts) != 1 || events[0].Artifacts[0].Path != "c" {
+		t.Errorf("third artifact: events = %+v, want one announcing c", events)
+	}
+
+	phase(PhaseCompleted)
+	pods = []corev1.Pod{toolPod("web-1", container, succeeded), toolPod("web-2", container, failed)}
+	events = pendingNotifications(powerTool, pods)
+	if got := eventTypes(events); len(got) != 1 || got[0] != toev1alpha1.NotificationEventFailed {
+		t.Fatalf("completed with a failed tool: events = %v, want [Failed]", got)
+	}
+	outcomes := events[0].Pods
+	if outcomes[0].Outcome != notify.OutcomeSucceeded || outcomes[1].Outcome != notify.OutcomeFailed ||
+		outcomes[1].ExitCode == nil || *outcomes[1].ExitCode != 2 || outcomes[1].Reason != "Error" {
+		t.Errorf("Failed pods = %+v", outcomes)
+	}
+	if len(events[0].Artifacts) != 3 {
+		t.Errorf("Failed artifacts = %d, want 3", len(events[0].Artifacts))
+	}
+	if events := pendingNotifications(powerTool, pods); len(events) != 0 {
+		t.Errorf("after the run ended: events = %v, want none", eventTypes(events))
+	}
+}
+
+func TestPendingNotifications_Completed(t *testing.T) {
+	phase := PhaseCompleted
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default", UID: "uid"},
+		Status:     toev1alpha1.PowerToolStatus{Phase: &phase, Notifications: &toev1alpha1.NotificationStatus{}},
+	}
+	succeeded := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}
+	events := pendingNotifications(powerTool, []corev1.Pod{toolPod("web-1", powerTool.ToolContainerName(), succeeded)})
+
+	// A run finishing between reconciles still announces its start
+	got := eventTypes(events)
+	if len(got) != 2 || got[0] != toev1alpha1.NotificationEventStarted || got[1] != toev1alpha1.NotificationEventCompleted {
+		t.Errorf("events = %v, want [Started Completed]", got)
+	}
+}
+
+func TestPendingNotifications_ConfigurationFailure(t *testing.T) {
+	phase := "Pending"
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default", UID: "uid"},
+		Status:     toev1alpha1.PowerToolStatus{Phase: &phase, Notifications: &toev1alpha1.NotificationStatus{}},
+	}
+	r := &PowerToolReconciler{}
+	r.setCondition(powerTool, toev1alpha1.PowerToolConditionFailed, "True", toev1alpha1.ReasonFailed, "Tool configuration error")
+
+	events := pendingNotifications(powerTool, nil)
+	if len(events) != 1 || events[0].Type != toev1alpha1.NotificationEventFailed || events[0].Message != "Tool configuration error" {
+		t.Errorf("events = %+v, want one Failed with the condition message", events)
+	}
+}
+
+func TestPendingNotifications_Baseline(t *testing.T) {
+	// A run the controller finished before notifications were recorded
+	phase := PhaseCompleted
+	count := int32(4)
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default", UID: "uid"},
+		Status:     toev1alpha1.PowerToolStatus{Phase: &phase, ArtifactCount: &count},
+	}
+	if events := pendingNotifications(powerTool, nil); len(events) != 0 {
+		t.Errorf("events = %v, want none", eventTypes(events))
+	}
+	sent := powerTool.Status.Notifications
+	if sent == nil || sent.Artifacts != 4 || len(sent.Events) != 2 {
+		t.Errorf("baseline = %+v, want the run announced", sent)
+	}
+}
+
+func TestNotificationSinks(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = toev1alpha1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	otherNS := "other"
+	tokenKey := "token"
+	sinks := []toev1alpha1.NotificationSink{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "all"},
+			Spec: toev1alpha1.NotificationSinkSpec{SinkSpec: toev1alpha1.SinkSpec{
+				Type:             toev1alpha1.SinkTypeCloudEvents,
+				URL:              "http://broker.knative-eventing/default",
+				SigningSecretRef: &toev1alpha1.SecretKeyReference{Name: "cluster-hook"},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+			Spec: toev1alpha1.NotificationSinkSpec{
+				SinkSpec:          toev1alpha1.SinkSpec{URL: "https://team-a.example.com/hook"},
+				NamespaceSelector: &toev1alpha1.NamespaceSelector{MatchNames: []string{"team-a"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "bad-url"},
+			Spec:       toev1alpha1.NotificationSinkSpec{SinkSpec: toev1alpha1.SinkSpec{URL: "ftp://example.com"}},
+		},
+	}
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for i := range sinks {
+		builder = builder.WithObjects(&sinks[i])
+	}
+	secret := func(namespace, name, key string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       map[string][]byte{key: []byte(namespace + "-key")},
+		}
+	}
+	cfg := config.Default()
+	cfg.NotificationAllowedNetworks = []netip.Prefix{netip.MustParsePrefix("10.96.0.0/12")}
+	r := &PowerToolReconciler{
+		Client: builder.Build(),
+		Scheme: scheme,
+		K8sClient: k8sfake.NewSimpleClientset(
+			secret(config.DefaultCollectorNamespace, "cluster-hook", defaultSigningSecretKey),
+			secret("default", "hook", "token"),
+			secret(otherNS, "hook", "token"),
+		),
+		Config: config.NewStore(cfg),
+	}
+
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "default"},
+		Spec: toev1alpha1.PowerToolSpec{Notifications: &toev1alpha1.NotificationSpec{Sinks: []toev1alpha1.NamedSinkSpec{{
+			Name: "mine",
+			SinkSpec: toev1alpha1.SinkSpec{
+				URL:    "https://hooks.example.com/toe",
+				Events: []string{toev1alpha1.NotificationEventFailed},
+				// The namespace is ignored: inline sinks read their own
+				SigningSecretRef: &toev1alpha1.SecretKeyReference{Name: "hook", Namespace: &otherNS, Key: &tokenKey},
+			},
+		}}}},
+	}
+
+	got := r.notificationSinks(context.Background(), powerTool)
+	if len(got) != 2 {
+		t.Fatalf("sinks = %+v, want the cluster-wide and the PowerTool's", got)
+	}
+	byName := map[string]notify.Sink{}
+	for _, s := range got {
+		byName[s.Name] = s
+	}
+	cluster, inline := byName["notificationsink/all"], byName["powertool/mine"]
+	if cluster.Restricted || string(cluster.SigningKey) != config.DefaultCollectorNamespace+"-key" ||
+		cluster.Type != toev1alpha1.SinkTypeCloudEvents || cluster.MaxRetries != notify.DefaultMaxRetries {
+		t.Errorf("cluster sink = %+v", cluster)
+	}
+	if !inline.Restricted || string(inline.SigningKey) != "default-key" || !inline.Wants(toev1alpha1.NotificationEventFailed) ||
+		inline.Wants(toev1alpha1.NotificationEventStarted) || len(inline.AllowedNetworks) != 1 {
+		t.Errorf("inline sink = %+v", inline)
+	}
+
+	// A missing secret skips only its sink
+	powerTool.Namespace = "team-a"
+	got = r.notificationSinks(context.Background(), powerTool)
+	if len(got) != 2 || got[0].Name == "powertool/mine" || got[1].Name == "powertool/mine" {
+		t.Errorf("team-a sinks = %+v, want both cluster sinks", got)
+	}
+}
+
+func TestNamespaceSelected(t *testing.T) {
+	regex := "team-.*"
+	bad := "("
+	tests := []struct {
+		selector  *toev1alpha1.NamespaceSelector
+		namespace string
+		want      bool
+		wantErr   bool
+	}{
+		{selector: nil, namespace: "default", want: true},
+		{selector: &toev1alpha1.NamespaceSelector{}, namespace: "default", want: true},
+		{selector: &toev1alpha1.NamespaceSelector{MatchNames: []string{"a", "b"}}, namespace: "b", want: true},
+		{selector: &toev1alpha1.NamespaceSelector{MatchNames: []string{"a"}}, namespace: "b"},
+		{selector: &toev1alpha1.NamespaceSelector{MatchRegex: &regex}, namespace: "team-x", want: true},
+		{selector: &toev1alpha1.NamespaceSelector{MatchRegex: &regex}, namespace: "my-team-x"},
+		{selector: &toev1alpha1.NamespaceSelector{MatchRegex: &bad}, namespace: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := namespaceSelected(tt.selector, tt.namespace)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("namespaceSelected(%+v, %q) = %v, %v; want %v", tt.selector, tt.namespace, got, err, tt.want)
+		}
+	}
+}
+
+func TestReconcile_NotifiesFailure(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = toev1alpha1.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-tool", Namespace: "default", UID: "uid"},
+		Spec: toev1alpha1.PowerToolSpec{
+			Targets: toev1alpha1.TargetSpec{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}}},
+			Tool:    toev1alpha1.ToolSpec{Name: "nonexistent-tool", Duration: "30s"},
+			Output:  toev1alpha1.OutputSpec{Mode: "ephemeral"},
+		},
+	}
+	sink := &toev1alpha1.NotificationSink{
+		ObjectMeta: metav1.ObjectMeta{Name: "hook"},
+		Spec:       toev1alpha1.NotificationSinkSpec{SinkSpec: toev1alpha1.SinkSpec{URL: "https://hooks.example.com/toe"}},
+	}
+	notifier := &recordingNotifier{}
+	r := &PowerToolReconciler{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(powerTool, sink).
+			WithStatusSubresource(powerTool).
+			Build(),
+		Scheme:   scheme,
+		Notifier: notifier,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "test-tool", Namespace: "default"}}
+
+	for range 2 {
+		if _, err := r.Reconcile(context.Background(), req); err == nil {
+			t.Fatal("expected error for nonexistent ToolConfig")
+		}
+	}
+	if got := eventTypes(notifier.events); len(got) != 1 || got[0] != toev1alpha1.NotificationEventFailed {
+		t.Fatalf("events = %v, want one Failed", got)
+	}
+	if len(notifier.sinks[0]) != 1 || notifier.sinks[0][0].Name != "notificationsink/hook" {
+		t.Errorf("sinks = %+v", notifier.sinks[0])
+	}
+
+	var stored toev1alpha1.PowerTool
+	if err := r.Get(context.Background(), req.NamespacedName, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Notifications == nil || len(stored.Status.Notifications.Events) != 1 {
+		t.Errorf("status notifications = %+v, want Failed recorded", stored.Status.Notifications)
+	}
+}
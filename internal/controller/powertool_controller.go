@@ -2,7 +2,12 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,20 +22,23 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	toev1alpha1 "toe/api/v1alpha1"
+	"toe/internal/config"
+	"toe/internal/notify"
 	"toe/pkg/collector/auth"
 )
 
-// Reconciliation timing constants
+// Default reconciliation timing, overridable through the controller configuration
 const (
-	ActiveRunningInterval   = 5 * time.Second
-	SetupTeardownInterval   = 15 * time.Second
-	CompletedJobInterval    = 5 * time.Minute
+	ActiveRunningInterval   = config.DefaultActiveRunningInterval
+	SetupTeardownInterval   = config.DefaultSetupTeardownInterval
+	CompletedJobInterval    = config.DefaultCompletedJobInterval
 	EphemeralStatusInterval = 3 * time.Second
 )
 
 // Output mode constants
 const (
-	OutputModePVC = "pvc"
+	OutputModePVC       = "pvc"
+	OutputModeCollector = "collector"
 )
 
 // Phase constants
@@ -42,9 +50,12 @@ const (
 //+kubebuilder:rbac:groups=codriverlabs.ai.toe.run,resources=powertools/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=codriverlabs.ai.toe.run,resources=powertools/finalizers,verbs=update
 //+kubebuilder:rbac:groups=codriverlabs.ai.toe.run,resources=powertoolconfigs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=codriverlabs.ai.toe.run,resources=notificationsinks,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups="",resources=pods/ephemeralcontainers,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
 
@@ -52,21 +63,27 @@ type PowerToolReconciler struct {
 	client.Client
 	Scheme    *runtime.Scheme
 	K8sClient kubernetes.Interface
+	// Config holds the live controller configuration; nil means defaults
+	Config *config.Store
+	// Linker fetches the signed artifact links published in the status of
+	// completed collector-mode PowerTools. Nil disables them.
+	Linker ArtifactLinker
+	// Notifier delivers run notifications to sinks. Nil disables them.
+	Notifier Notifier
 }
 
-func NewPowerToolReconciler(c client.Client, scheme *runtime.Scheme, k8sClient kubernetes.Interface) *PowerToolReconciler {
+func NewPowerToolReconciler(c client.Client, scheme *runtime.Scheme, k8sClient kubernetes.Interface, cfg *config.Store) *PowerToolReconciler {
 	return &PowerToolReconciler{
 		Client:    c,
 		Scheme:    scheme,
 		K8sClient: k8sClient,
+		Config:    cfg,
 	}
 }
 
 func (r *PowerToolReconciler) getToolConfig(ctx context.Context, toolName string) (*toev1alpha1.PowerToolConfig, error) {
-	// Look for PowerToolConfig in the same namespace first, then toe-system
-	namespaces := []string{"toe-system", "default"}
-
-	for _, namespace := range namespaces {
+	// Look for PowerToolConfig in the configured namespaces, in order
+	for _, namespace := range r.Config.Get().ToolConfigNamespaces {
 		var toolConfig toev1alpha1.PowerToolConfig
 		configKey := client.ObjectKey{
 			Name:      toolName + "-config",
@@ -83,24 +100,17 @@ func (r *PowerToolReconciler) getToolConfig(ctx context.Context, toolName string
 
 func (r *PowerToolReconciler) getTokenDuration(ctx context.Context, collectionDuration time.Duration) time.Duration {
 	logger := log.FromContext(ctx)
+	cfg := r.Config.Get()
 
-	// Simple calculation: collection duration + 60 seconds buffer for overhead
-	buffer := 60 * time.Second
-	tokenDuration := collectionDuration + buffer
-
-	// Kubernetes minimum requirement: 10 minutes (600 seconds)
-	minDuration := 10 * time.Minute
-	if tokenDuration < minDuration {
-		logger.Info("Token duration below minimum, using 10 minutes",
-			"calculated", tokenDuration,
-			"minimum", minDuration,
-			"collectionDuration", collectionDuration)
-		tokenDuration = minDuration
-	}
+	// Scale the collection duration and add a buffer for upload overhead,
+	// never going below the configured (and Kubernetes) minimum
+	tokenDuration := cfg.TokenDuration(collectionDuration)
 
 	logger.Info("Token duration calculated",
 		"collectionDuration", collectionDuration,
-		"buffer", buffer,
+		"multiplier", cfg.TokenExpiryMultiplier,
+		"buffer", cfg.TokenBuffer,
+		"minimum", cfg.MinTokenDuration,
 		"finalTokenDuration", tokenDuration)
 
 	return tokenDuration
@@ -108,8 +118,9 @@ func (r *PowerToolReconciler) getTokenDuration(ctx context.Context, collectionDu
 
 // buildPowerToolEnvVars builds environment variables from PowerTool spec
 func (r *PowerToolReconciler) buildPowerToolEnvVars(job *toev1alpha1.PowerTool, targetPod corev1.Pod) []corev1.EnvVar {
-	// Extract matching labels from the PowerTool's label selector
-	matchingLabels := r.extractMatchingLabels(job.Spec.Targets.LabelSelector, targetPod.Labels)
+	// Artifacts are grouped under the labels that selected the pod, or the
+	// one output.groupBy names
+	matchingLabels := r.groupingKey(job, targetPod.Labels)
 
 	// Determine target container name
 	targetContainerName := "default"
@@ -155,28 +166,103 @@ func (r *PowerToolReconciler) buildPowerToolEnvVars(job *toev1alpha1.PowerTool,
 		})
 	}
 
+	// Tools that support it upload numbered segments at this interval
+	if job.Spec.Output.RollingInterval != nil && *job.Spec.Output.RollingInterval != "" {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  "ROLLING_INTERVAL",
+			Value: *job.Spec.Output.RollingInterval,
+		})
+	}
+
+	// Passed on to the collector so it can expire the uploaded artifacts
+	if job.Spec.Output.RetentionDays != nil {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  "RETENTION_DAYS",
+			Value: strconv.Itoa(int(*job.Spec.Output.RetentionDays)),
+		})
+	}
+
 	return envVars
 }
 
-// extractMatchingLabels extracts the labels that matched the selector
+// unknownGroup is the grouping key of pods no label identifies
+const unknownGroup = "unknown"
+
+// maxGroupKeyLength keeps grouping keys within the single path element the
+// collector stores them in
+const maxGroupKeyLength = 255
+
+// groupingKey is the label artifacts of targetPod are grouped under, passed
+// to tools as POD_MATCHING_LABELS. With output.groupBy it is that label of
+// the pod; otherwise it is every label the selector matched on.
+func (r *PowerToolReconciler) groupingKey(job *toev1alpha1.PowerTool, podLabels map[string]string) string {
+	if groupBy := job.Spec.Output.GroupBy; groupBy != nil && *groupBy != "" {
+		value, ok := podLabels[*groupBy]
+		if !ok {
+			return unknownGroup
+		}
+		return formatGroupKey([]string{labelComponent(*groupBy, value)})
+	}
+	return r.extractMatchingLabels(job.Spec.Targets.LabelSelector, podLabels)
+}
+
+// extractMatchingLabels extracts the labels that matched the selector, as
+// key-value components sorted by key and joined with "_". matchLabels and
+// the In and Exists expressions identify a label; NotIn and DoesNotExist
+// only exclude pods, so they are left out.
 func (r *PowerToolReconciler) extractMatchingLabels(selector *metav1.LabelSelector, podLabels map[string]string) string {
-	if selector == nil || selector.MatchLabels == nil {
-		return "unknown"
+	if selector == nil {
+		return unknownGroup
 	}
 
-	// Build a compact representation of matching labels: key-value
-	var labels []string
+	matched := map[string]string{}
 	for key, value := range selector.MatchLabels {
 		if podValue, exists := podLabels[key]; exists && podValue == value {
-			labels = append(labels, fmt.Sprintf("%s-%s", key, value))
+			matched[key] = value
+		}
+	}
+	for _, expr := range selector.MatchExpressions {
+		podValue, exists := podLabels[expr.Key]
+		if !exists {
+			continue
+		}
+		switch expr.Operator {
+		case metav1.LabelSelectorOpIn:
+			if slices.Contains(expr.Values, podValue) {
+				matched[expr.Key] = podValue
+			}
+		case metav1.LabelSelectorOpExists:
+			matched[expr.Key] = podValue
 		}
 	}
 
-	if len(labels) == 0 {
-		return "unknown"
+	if len(matched) == 0 {
+		return unknownGroup
 	}
+	components := make([]string, 0, len(matched))
+	for _, key := range slices.Sorted(maps.Keys(matched)) {
+		components = append(components, labelComponent(key, matched[key]))
+	}
+	return formatGroupKey(components)
+}
 
-	return labels[0] // Use first matching label for path organization
+// labelComponent renders a label as key-value. The prefix separator in keys
+// like app.kubernetes.io/name becomes "_" so the result stays a single path
+// component.
+func labelComponent(key, value string) string {
+	return fmt.Sprintf("%s-%s", strings.ReplaceAll(key, "/", "_"), value)
+}
+
+// formatGroupKey joins label components. A key too long for a path element
+// is cut short and ends in a digest of the whole, so it stays distinct.
+func formatGroupKey(components []string) string {
+	key := strings.Join(components, "_")
+	if len(key) <= maxGroupKeyLength {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	digest := hex.EncodeToString(sum[:])[:16]
+	return key[:maxGroupKeyLength-len(digest)-1] + "-" + digest
 }
 
 // findPVCVolumeName finds the volume name for a given PVC claim name in the pod
@@ -287,6 +373,7 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		powerTool.Status.Phase = &phase
 		now := metav1.Now()
 		powerTool.Status.StartedAt = &now
+		powerTool.Status.Notifications = &toev1alpha1.NotificationStatus{}
 		r.setCondition(&powerTool, toev1alpha1.PowerToolConditionReady, "False", toev1alpha1.ReasonTargetsSelected, "Initializing PowerTool")
 		if err := r.Status().Update(ctx, &powerTool); err != nil {
 			logger.Error(err, "unable to update PowerTool status")
@@ -299,7 +386,7 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	if err != nil {
 		logger.Error(err, "failed to get tool configuration")
 		r.setCondition(&powerTool, toev1alpha1.PowerToolConditionFailed, "True", toev1alpha1.ReasonFailed, fmt.Sprintf("Tool configuration error: %v", err))
-		if updateErr := r.Status().Update(ctx, &powerTool); updateErr != nil {
+		if updateErr := r.updateStatus(ctx, &powerTool, nil); updateErr != nil {
 			logger.Error(updateErr, "failed to update PowerTool status")
 		}
 		return ctrl.Result{}, err
@@ -309,19 +396,31 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	if err := r.validateNamespaceAccess(&powerTool, toolConfig); err != nil {
 		logger.Error(err, "namespace access denied")
 		r.setCondition(&powerTool, toev1alpha1.PowerToolConditionFailed, "True", toev1alpha1.ReasonFailed, fmt.Sprintf("Namespace access denied: %v", err))
-		if updateErr := r.Status().Update(ctx, &powerTool); updateErr != nil {
+		if updateErr := r.updateStatus(ctx, &powerTool, nil); updateErr != nil {
 			logger.Error(updateErr, "failed to update PowerTool status")
 		}
 		return ctrl.Result{}, err
 	}
 
+	// Collector mode needs a resolvable endpoint
+	var collectorEndpoint string
+	var artifactLinks []toev1alpha1.ArtifactReference
+	if usesCollector(&powerTool) {
+		collectorEndpoint, err = r.resolveCollectorEndpoint(ctx, &powerTool)
+		if err != nil {
+			r.failCollector(ctx, &powerTool, err)
+			return ctrl.Result{}, err
+		}
+		powerTool.Status.CollectorEndpoint = &collectorEndpoint
+	}
+
 	// Get target pods
 	var podList corev1.PodList
 	selector, err := metav1.LabelSelectorAsSelector(powerTool.Spec.Targets.LabelSelector)
 	if err != nil {
 		logger.Error(err, "unable to convert label selector")
 		r.setCondition(&powerTool, toev1alpha1.PowerToolConditionFailed, "True", toev1alpha1.ReasonFailed, fmt.Sprintf("Invalid label selector: %v", err))
-		if updateErr := r.Status().Update(ctx, &powerTool); updateErr != nil {
+		if updateErr := r.updateStatus(ctx, &powerTool, nil); updateErr != nil {
 			logger.Error(updateErr, "failed to update PowerTool status")
 		}
 		return ctrl.Result{}, err
@@ -343,11 +442,11 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		r.setCondition(&powerTool, toev1alpha1.PowerToolConditionConflicted, "True", toev1alpha1.ReasonConflictDetected, conflictMsg)
 		phase := "Conflicted"
 		powerTool.Status.Phase = &phase
-		if err := r.Status().Update(ctx, &powerTool); err != nil {
+		if err := r.updateStatus(ctx, &powerTool, podList.Items); err != nil {
 			logger.Error(err, "unable to update PowerTool status")
 			return ctrl.Result{}, err
 		}
-		return ctrl.Result{RequeueAfter: time.Minute}, nil
+		return ctrl.Result{RequeueAfter: r.Config.Get().ConflictRequeueInterval}, nil
 	}
 
 	// Initialize ActivePods map if needed
@@ -356,9 +455,9 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	}
 
 	// Process pods for profiling
+	containerName := powerTool.ToolContainerName()
+	var newPods []corev1.Pod
 	for _, pod := range podList.Items {
-		containerName := fmt.Sprintf("powertool-%s-%s", powerTool.Name, string(powerTool.UID)[:8])
-
 		// Check if we already have a container for this pod
 		if existingContainer, exists := powerTool.Status.ActivePods[pod.Name]; exists {
 			if r.isContainerRunning(pod, existingContainer) {
@@ -379,17 +478,34 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		for _, ec := range pod.Spec.EphemeralContainers {
 			if ec.Name == containerName {
 				containerExists = true
-				powerTool.Status.ActivePods[pod.Name] = containerName
+				// A container that already terminated belongs to the
+				// completed pods; tracking it again would flip the run
+				// back to Running
+				if r.isContainerRunning(pod, containerName) {
+					powerTool.Status.ActivePods[pod.Name] = containerName
+				}
 				break
 			}
 		}
 
-		if containerExists {
-			continue
+		if !containerExists {
+			newPods = append(newPods, pod)
+		}
+	}
+
+	// New tool containers need the CA so they never upload without verifying
+	// the collector. It is read once for all of them, and only when there are any.
+	var collectorCA string
+	if len(newPods) > 0 && usesCollector(&powerTool) {
+		collectorCA, err = r.resolveCollectorCA(ctx, &powerTool)
+		if err != nil {
+			r.failCollector(ctx, &powerTool, err)
+			return ctrl.Result{}, err
 		}
+	}
 
-		// Create new ephemeral container
-		if err := r.createEphemeralContainerForPod(ctx, &powerTool, toolConfig, pod, containerName); err != nil {
+	for _, pod := range newPods {
+		if err := r.createEphemeralContainerForPod(ctx, &powerTool, toolConfig, pod, containerName, collectorEndpoint, collectorCA); err != nil {
 			logger.Error(err, "failed to create ephemeral container", "pod", pod.Name)
 			continue
 		}
@@ -404,28 +520,42 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	if len(powerTool.Status.ActivePods) > 0 {
 		phase := "Running"
 		powerTool.Status.Phase = &phase
+		powerTool.Status.FinishedAt = nil
 		r.setCondition(&powerTool, toev1alpha1.PowerToolConditionRunning, "True", toev1alpha1.ReasonRunning, fmt.Sprintf("Running on %d pods", len(powerTool.Status.ActivePods)))
 	} else if selectedPods > 0 {
 		phase := PhaseCompleted
 		powerTool.Status.Phase = &phase
-		now := metav1.Now()
-		powerTool.Status.FinishedAt = &now
+		// The collector's upload grace is measured from when the run
+		// completed, so later reconciles must not move it
+		if powerTool.Status.FinishedAt == nil {
+			now := metav1.Now()
+			powerTool.Status.FinishedAt = &now
+		}
 		r.setCondition(&powerTool, toev1alpha1.PowerToolConditionCompleted, "True", toev1alpha1.ReasonCompleted, "All containers completed")
+		if usesCollector(&powerTool) {
+			artifactLinks = r.fetchArtifactLinks(ctx, &powerTool, collectorCA)
+		}
 	}
 
+	var notifications []notify.Event
 	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		// Get the latest version
 		latest := &toev1alpha1.PowerTool{}
 		if err := r.Get(ctx, req.NamespacedName, latest); err != nil {
 			return err
 		}
-		// Preserve our status changes
-		latest.Status = powerTool.Status
+		// Preserve our status changes, but not over the artifacts the
+		// collector has recorded since
+		status := powerTool.Status.DeepCopy()
+		keepArtifactStatus(status, &latest.Status, artifactLinks)
+		latest.Status = *status
+		notifications = pendingNotifications(latest, podList.Items)
 		return r.Status().Update(ctx, latest)
 	}); err != nil {
 		logger.Error(err, "unable to update PowerTool status")
 		return ctrl.Result{}, err
 	}
+	r.sendNotifications(ctx, &powerTool, notifications)
 
 	// Determine requeue interval
 	interval := r.getRequeueInterval(&powerTool)
@@ -433,17 +563,18 @@ func (r *PowerToolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 }
 
 func (r *PowerToolReconciler) getRequeueInterval(job *toev1alpha1.PowerTool) time.Duration {
+	cfg := r.Config.Get()
 	if job.Status.Phase == nil {
-		return SetupTeardownInterval
+		return cfg.SetupTeardownInterval
 	}
 
 	switch *job.Status.Phase {
 	case "Running":
-		return ActiveRunningInterval
+		return cfg.ActiveRunningInterval
 	case "Completed", "Failed":
-		return CompletedJobInterval
+		return cfg.CompletedJobInterval
 	default:
-		return SetupTeardownInterval
+		return cfg.SetupTeardownInterval
 	}
 }
 
@@ -542,8 +673,21 @@ func (r *PowerToolReconciler) isContainerRunning(pod corev1.Pod, containerName s
 	return false
 }
 
-// createEphemeralContainerForPod creates an ephemeral container for a specific pod
-func (r *PowerToolReconciler) createEphemeralContainerForPod(ctx context.Context, powerTool *toev1alpha1.PowerTool, toolConfig *toev1alpha1.PowerToolConfig, pod corev1.Pod, containerName string) error {
+// failCollector marks a collector-mode PowerTool failed when its collector
+// cannot be resolved
+func (r *PowerToolReconciler) failCollector(ctx context.Context, powerTool *toev1alpha1.PowerTool, err error) {
+	logger := log.FromContext(ctx)
+	logger.Error(err, "failed to resolve collector")
+	r.setCondition(powerTool, toev1alpha1.PowerToolConditionFailed, "True", toev1alpha1.ReasonFailed, fmt.Sprintf("Collector configuration error: %v", err))
+	if updateErr := r.updateStatus(ctx, powerTool, nil); updateErr != nil {
+		logger.Error(updateErr, "failed to update PowerTool status")
+	}
+}
+
+// createEphemeralContainerForPod creates an ephemeral container for a specific
+// pod. Collector-mode tools get the collector endpoint and CA resolved by the
+// caller.
+func (r *PowerToolReconciler) createEphemeralContainerForPod(ctx context.Context, powerTool *toev1alpha1.PowerTool, toolConfig *toev1alpha1.PowerToolConfig, pod corev1.Pod, containerName, collectorEndpoint, collectorCA string) error {
 	logger := log.FromContext(ctx)
 
 	// Get target container
@@ -556,7 +700,7 @@ func (r *PowerToolReconciler) createEphemeralContainerForPod(ctx context.Context
 	envVars := r.buildPowerToolEnvVars(powerTool, pod)
 
 	// Add collector configuration if specified
-	if powerTool.Spec.Output.Collector != nil {
+	if usesCollector(powerTool) {
 		collectionDuration, err := time.ParseDuration(powerTool.Spec.Tool.Duration)
 		if err != nil {
 			return fmt.Errorf("invalid duration: %w", err)
@@ -565,15 +709,17 @@ func (r *PowerToolReconciler) createEphemeralContainerForPod(ctx context.Context
 		tokenDuration := r.getTokenDuration(ctx, collectionDuration)
 
 		// Create a token manager for the collector
-		collectorTokenManager := auth.NewK8sTokenManager(r.K8sClient, "toe-system", "toe-sdk-collector")
+		cfg := r.Config.Get()
+		collectorTokenManager := auth.NewK8sTokenManager(r.K8sClient, cfg.CollectorNamespace, cfg.CollectorServiceAccount, cfg.CollectorAudience)
 		token, err := collectorTokenManager.GenerateToken(ctx, powerTool.Name, tokenDuration)
 		if err != nil {
 			return fmt.Errorf("failed to generate collection token: %w", err)
 		}
 
 		envVars = append(envVars,
-			corev1.EnvVar{Name: "COLLECTOR_ENDPOINT", Value: powerTool.Spec.Output.Collector.Endpoint},
+			corev1.EnvVar{Name: "COLLECTOR_ENDPOINT", Value: collectorEndpoint},
 			corev1.EnvVar{Name: "COLLECTOR_TOKEN", Value: token},
+			corev1.EnvVar{Name: "COLLECTOR_CA_CERT", Value: collectorCA},
 			corev1.EnvVar{Name: "POWERTOOL_JOB_ID", Value: powerTool.Name},
 		)
 	}
@@ -28,7 +28,7 @@ func TestReconcile_PowerToolNotFoundReturnsEmpty(t *testing.T) {
 		Build()
 
 	k8sClient := k8sfake.NewSimpleClientset()
-	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient)
+	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient, nil)
 
 	req := ctrl.Request{
 		NamespacedName: types.NamespacedName{
@@ -68,7 +68,7 @@ func TestReconcile_DeletionHandling(t *testing.T) {
 		Build()
 
 	k8sClient := k8sfake.NewSimpleClientset()
-	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient)
+	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sClient, nil)
 
 	req := ctrl.Request{
 		NamespacedName: types.NamespacedName{
@@ -82,3 +82,192 @@ func TestReconcile_DeletionHandling(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ctrl.Result{}, result)
 }
+
+func TestReconcile_CompletedKeepsFinishedAt(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, toev1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	running := "Running"
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-tool", Namespace: "default", UID: "12345678-abcd"},
+		Spec: toev1alpha1.PowerToolSpec{
+			Targets: toev1alpha1.TargetSpec{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+			},
+			Tool:   toev1alpha1.ToolSpec{Name: "perf", Duration: "30s"},
+			Output: toev1alpha1.OutputSpec{Mode: "ephemeral"},
+		},
+		Status: toev1alpha1.PowerToolStatus{Phase: &running},
+	}
+	containerName := powerTool.ToolContainerName()
+	powerTool.Status.ActivePods = map[string]string{"web-1": containerName}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec: corev1.PodSpec{
+			EphemeralContainers: []corev1.EphemeralContainer{{
+				EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: containerName},
+			}},
+		},
+		Status: corev1.PodStatus{
+			EphemeralContainerStatuses: []corev1.ContainerStatus{{
+				Name:  containerName,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			}},
+		},
+	}
+	toolConfig := &toev1alpha1.PowerToolConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "perf-config", Namespace: "toe-system"},
+		Spec:       toev1alpha1.PowerToolConfigSpec{Name: "perf", Image: "test-image:latest"},
+	}
+
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(powerTool, pod, toolConfig).
+		WithStatusSubresource(powerTool).
+		Build()
+	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sfake.NewSimpleClientset(), nil)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: powerTool.Name, Namespace: powerTool.Namespace}}
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	var completed toev1alpha1.PowerTool
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, &completed))
+	require.NotNil(t, completed.Status.Phase)
+	assert.Equal(t, PhaseCompleted, *completed.Status.Phase)
+	require.NotNil(t, completed.Status.FinishedAt)
+
+	// Later reconciles of the finished run must not move the completion
+	// time the collector's upload grace is measured from
+	finishedAt := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	completed.Status.FinishedAt = &finishedAt
+	require.NoError(t, fakeClient.Status().Update(ctx, &completed))
+
+	_, err = reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	var reconciled toev1alpha1.PowerTool
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, &reconciled))
+	require.NotNil(t, reconciled.Status.Phase)
+	assert.Equal(t, PhaseCompleted, *reconciled.Status.Phase)
+	assert.Empty(t, reconciled.Status.ActivePods)
+	require.NotNil(t, reconciled.Status.FinishedAt)
+	assert.True(t, finishedAt.Equal(reconciled.Status.FinishedAt), "FinishedAt moved from %v to %v", finishedAt, reconciled.Status.FinishedAt)
+}
+
+func TestReconcile_RefusesPlainHTTPCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, toev1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-tool", Namespace: "default", UID: "12345678-abcd"},
+		Spec: toev1alpha1.PowerToolSpec{
+			Targets: toev1alpha1.TargetSpec{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+			},
+			Tool: toev1alpha1.ToolSpec{Name: "perf", Duration: "30s"},
+			Output: toev1alpha1.OutputSpec{
+				Mode:      OutputModeCollector,
+				Collector: &toev1alpha1.CollectorSpec{Endpoint: "http://toe-collector.toe-system.svc:8080"},
+			},
+		},
+	}
+	toolConfig := &toev1alpha1.PowerToolConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "perf-config", Namespace: "toe-system"},
+		Spec:       toev1alpha1.PowerToolConfigSpec{Name: "perf", Image: "test-image:latest"},
+	}
+
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(powerTool, toolConfig).
+		WithStatusSubresource(powerTool).
+		Build()
+	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sfake.NewSimpleClientset(), nil)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: powerTool.Name, Namespace: powerTool.Namespace}}
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, req)
+	require.Error(t, err)
+
+	var refused toev1alpha1.PowerTool
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, &refused))
+	assert.Nil(t, refused.Status.CollectorEndpoint)
+	var failed *toev1alpha1.PowerToolCondition
+	for i := range refused.Status.Conditions {
+		if refused.Status.Conditions[i].Type == toev1alpha1.PowerToolConditionFailed {
+			failed = &refused.Status.Conditions[i]
+		}
+	}
+	require.NotNil(t, failed, "conditions = %+v", refused.Status.Conditions)
+	assert.Equal(t, "True", string(failed.Status))
+	assert.Contains(t, failed.Message, "must be an https URL")
+}
+
+func TestReconcile_CollectorCAOnlyForNewContainers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, toev1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	powerTool := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-tool", Namespace: "default", UID: "12345678-abcd"},
+		Spec: toev1alpha1.PowerToolSpec{
+			Targets: toev1alpha1.TargetSpec{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+			},
+			Tool: toev1alpha1.ToolSpec{Name: "perf", Duration: "30s"},
+			Output: toev1alpha1.OutputSpec{
+				Mode:      OutputModeCollector,
+				Collector: &toev1alpha1.CollectorSpec{Endpoint: "https://toe-collector.toe-system.svc:8443"},
+			},
+		},
+	}
+	toolConfig := &toev1alpha1.PowerToolConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "perf-config", Namespace: "toe-system"},
+		Spec:       toev1alpha1.PowerToolConfigSpec{Name: "perf", Image: "test-image:latest"},
+	}
+	// The tool already ran and exited in this pod
+	containerName := powerTool.ToolContainerName()
+	finished := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec: corev1.PodSpec{
+			EphemeralContainers: []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: containerName}}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			EphemeralContainerStatuses: []corev1.ContainerStatus{{
+				Name:  containerName,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			}},
+		},
+	}
+
+	// No CA ConfigMap exists, which only matters for new containers
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(powerTool, toolConfig, finished).
+		WithStatusSubresource(powerTool).
+		Build()
+	reconciler := NewPowerToolReconciler(fakeClient, scheme, k8sfake.NewSimpleClientset(), nil)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: powerTool.Name, Namespace: powerTool.Namespace}}
+	ctx := context.Background()
+
+	_, err := reconciler.Reconcile(ctx, req)
+	require.NoError(t, err)
+	var completed toev1alpha1.PowerTool
+	require.NoError(t, fakeClient.Get(ctx, req.NamespacedName, &completed))
+	require.NotNil(t, completed.Status.Phase)
+	assert.Equal(t, PhaseCompleted, *completed.Status.Phase)
+
+	// A new target pod needs a container, and so the CA
+	require.NoError(t, fakeClient.Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}))
+	_, err = reconciler.Reconcile(ctx, req)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no collector CA available")
+}
@@ -0,0 +1,333 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultMaxRetries bounds the redeliveries of a notification when the
+	// sink does not say otherwise
+	DefaultMaxRetries = 5
+	// DefaultQueueSize bounds the deliveries waiting for a worker; more
+	// are dropped
+	DefaultQueueSize = 1000
+	// DefaultWorkers is the number of deliveries made at once
+	DefaultWorkers = 4
+
+	requestTimeout = 10 * time.Second
+	initialBackoff = time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// Delivery results recorded in Deliveries
+const (
+	ResultDelivered = "delivered"
+	ResultRetried   = "retried"
+	ResultFailed    = "failed"
+	ResultDropped   = "dropped"
+)
+
+// Deliveries counts notification delivery attempts by event and result
+var Deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "toe_controller_notifications_total",
+	Help: "Notification delivery attempts, by event and result.",
+}, []string{"event", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(Deliveries)
+}
+
+// Sink is a resolved notification sink
+type Sink struct {
+	// Name identifies the sink in logs
+	Name string
+	// Type is webhook or cloudevents
+	Type string
+	URL  string
+	// Events selects the events sent; all of them when empty
+	Events []string
+	// SigningKey signs each notification when set
+	SigningKey []byte
+	MaxRetries int
+	// Restricted sinks may not be delivered to loopback, link-local,
+	// private, shared (100.64.0.0/10), unspecified or multicast addresses,
+	// other than those in AllowedNetworks. Sinks declared by PowerTool
+	// authors are, which keeps them away from cloud metadata and from
+	// Services and pods on cluster networks using private addresses.
+	Restricted bool
+	// AllowedNetworks are internal networks a restricted sink may still
+	// be delivered to
+	AllowedNetworks []netip.Prefix
+}
+
+// Wants reports whether the sink receives events of eventType
+func (s *Sink) Wants(eventType string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, eventType)
+}
+
+// Validate checks the sink's type and URL
+func (s *Sink) Validate() error {
+	if _, _, err := encode(s.Type, &Event{}); err != nil {
+		return err
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL %q must be an absolute http or https URL", s.URL)
+	}
+	return nil
+}
+
+// delivery is a notification on its way to one sink
+type delivery struct {
+	sink    Sink
+	event   *Event
+	attempt int
+}
+
+// Dispatcher delivers notifications from a bounded queue. It runs with the
+// manager, so only the leader sends notifications.
+type Dispatcher struct {
+	client     *http.Client
+	restricted *http.Client
+	queue      chan delivery
+	workers    int
+	// backoff is the wait before redelivery attempt n, counted from 1
+	backoff func(n int) time.Duration
+	now     func() time.Time
+
+	mu sync.Mutex
+	// ctx is the dispatcher's while it runs; redeliveries due after it
+	// ended are dropped
+	ctx context.Context
+}
+
+// NewDispatcher returns a dispatcher with DefaultWorkers workers and a
+// queue of DefaultQueueSize deliveries
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		client:     newHTTPClient(nil),
+		restricted: newHTTPClient(denyInternal),
+		queue:      make(chan delivery, DefaultQueueSize),
+		workers:    DefaultWorkers,
+		backoff:    exponentialBackoff,
+		now:        time.Now,
+	}
+}
+
+func newHTTPClient(control func(ctx context.Context, network, address string, c syscall.RawConn) error) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:        requestTimeout,
+		KeepAlive:      30 * time.Second,
+		ControlContext: control,
+	}).DialContext
+	// A pooled connection would skip the check of the next sink on its
+	// host, which may have other allowed networks
+	transport.DisableKeepAlives = control != nil
+	if control != nil {
+		// Through a proxy the check would see the proxy's address, not the sink's
+		transport.Proxy = nil
+	}
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+		// A redirect could lead a restricted sink anywhere, and a sink
+		// that moved should be reconfigured rather than followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which some
+// clusters use for pods or Services
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// allowedNetworksKey carries the AllowedNetworks of the sink being delivered
+// to from send to denyInternal
+type allowedNetworksKey struct{}
+
+// denyInternal refuses connections to loopback, link-local, private, shared,
+// unspecified and multicast addresses outside the sink's AllowedNetworks. It
+// runs on the address actually dialed, after DNS resolution.
+func denyInternal(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("notification sink address %q: %w", address, err)
+	}
+	addr := addrPort.Addr().Unmap()
+	if !isInternal(addr) {
+		return nil
+	}
+	allowed, _ := ctx.Value(allowedNetworksKey{}).([]netip.Prefix)
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("notification sink address %s is not allowed", addr)
+}
+
+func isInternal(addr netip.Addr) bool {
+	return addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsPrivate() || sharedAddressSpace.Contains(addr) ||
+		addr.IsUnspecified() || addr.IsMulticast() || addr.IsInterfaceLocalMulticast()
+}
+
+// exponentialBackoff doubles the wait from initialBackoff up to maxBackoff
+func exponentialBackoff(n int) time.Duration {
+	d := initialBackoff
+	for i := 1; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// Start runs the workers until ctx is done. Deliveries still queued or
+// waiting to be retried then are dropped.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	d.mu.Lock()
+	d.ctx = ctx
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for range d.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case dl := <-d.queue:
+					d.deliver(ctx, dl)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Notify queues event for each of sinks that wants it. It never blocks: when
+// the queue is full the notification is dropped and logged.
+func (d *Dispatcher) Notify(ctx context.Context, sinks []Sink, event Event) {
+	for _, sink := range sinks {
+		if sink.Wants(event.Type) {
+			d.enqueue(ctx, delivery{sink: sink, event: &event})
+		}
+	}
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, dl delivery) {
+	select {
+	case d.queue <- dl:
+	default:
+		Deliveries.WithLabelValues(dl.event.Type, ResultDropped).Inc()
+		log.FromContext(ctx).Info("Notification queue full, dropping notification",
+			"sink", dl.sink.Name, "event", dl.event.Type, "id", dl.event.ID)
+	}
+}
+
+// deliver makes one attempt and schedules the next if the sink may accept a
+// redelivery
+func (d *Dispatcher) deliver(ctx context.Context, dl delivery) {
+	logger := log.FromContext(ctx).WithValues("sink", dl.sink.Name, "event", dl.event.Type, "id", dl.event.ID)
+	err := d.send(ctx, &dl.sink, dl.event)
+	if err == nil {
+		Deliveries.WithLabelValues(dl.event.Type, ResultDelivered).Inc()
+		return
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || dl.attempt >= dl.sink.MaxRetries {
+		Deliveries.WithLabelValues(dl.event.Type, ResultFailed).Inc()
+		logger.Error(err, "failed to deliver notification", "attempts", dl.attempt+1)
+		return
+	}
+	Deliveries.WithLabelValues(dl.event.Type, ResultRetried).Inc()
+	dl.attempt++
+	wait := d.backoff(dl.attempt)
+	logger.Info("Notification not delivered, retrying", "error", err.Error(), "retryIn", wait)
+	time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		runCtx := d.ctx
+		d.mu.Unlock()
+		if runCtx == nil || runCtx.Err() != nil {
+			return
+		}
+		d.enqueue(runCtx, dl)
+	})
+}
+
+// permanentError is a failure redelivering will not fix, such as a client
+// error from the sink
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// send posts event to sink once
+func (d *Dispatcher) send(ctx context.Context, sink *Sink, event *Event) error {
+	body, contentType, err := encode(sink.Type, event)
+	if err != nil {
+		return &permanentError{err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "toe-controller")
+	req.Header.Set(HeaderEventID, event.ID)
+	req.Header.Set(HeaderEvent, event.Type)
+	timestamp := d.now().Unix()
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if len(sink.SigningKey) > 0 {
+		req.Header.Set(HeaderSignature, Sign(sink.SigningKey, timestamp, body))
+	}
+
+	client := d.client
+	if sink.Restricted {
+		client = d.restricted
+		req = req.WithContext(context.WithValue(ctx, allowedNetworksKey{}, sink.AllowedNetworks))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return fmt.Errorf("sink responded %s", resp.Status)
+	default:
+		return &permanentError{fmt.Errorf("sink responded %s", resp.Status)}
+	}
+}
@@ -0,0 +1,338 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+func testEvent() Event {
+	return Event{
+		ID:        "uid-1-Completed",
+		Type:      toev1alpha1.NotificationEventCompleted,
+		Time:      time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		PowerTool: PowerToolRef{Namespace: "default", Name: "job", UID: "uid-1", Tool: "aperf"},
+		Phase:     "Completed",
+		Pods:      []PodOutcome{{Name: "web-1", Outcome: OutcomeSucceeded}},
+		Artifacts: []toev1alpha1.ArtifactReference{{Path: "default/app-web/job/2025-01-02/web-1_perf.data", Size: 4, SHA256: "abc", URL: "https://collector/a"}},
+	}
+}
+
+// newTestDispatcher retries immediately and runs until the test ends
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	t.Helper()
+	d := NewDispatcher()
+	d.backoff = func(int) time.Duration { return time.Millisecond }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = d.Start(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// newTestSink answers with the statuses in order, then 204, and passes
+// every request on
+func newTestSink(t *testing.T, statuses ...int) (*httptest.Server, <-chan received, *atomic.Int32) {
+	t.Helper()
+	requests := make(chan received, 16)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n := int(calls.Add(1))
+		requests <- received{header: r.Header.Clone(), body: body}
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, &calls
+}
+
+func next(t *testing.T, requests <-chan received) received {
+	t.Helper()
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+		return received{}
+	}
+}
+
+func TestDispatcher_Webhook(t *testing.T) {
+	server, requests, _ := newTestSink(t)
+	d := newTestDispatcher(t)
+	key := []byte("shared-secret")
+
+	d.Notify(context.Background(), []Sink{{Name: "hook", Type: toev1alpha1.SinkTypeWebhook, URL: server.URL, SigningKey: key}}, testEvent())
+	r := next(t, requests)
+
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if r.header.Get(HeaderEventID) != "uid-1-Completed" || r.header.Get(HeaderEvent) != toev1alpha1.NotificationEventCompleted {
+		t.Errorf("event headers = %q, %q", r.header.Get(HeaderEventID), r.header.Get(HeaderEvent))
+	}
+	timestamp, err := strconv.ParseInt(r.header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp header: %v", err)
+	}
+	if !Verify(key, timestamp, r.body, r.header.Get(HeaderSignature)) {
+		t.Errorf("signature %q does not verify", r.header.Get(HeaderSignature))
+	}
+	if Verify([]byte("other"), timestamp, r.body, r.header.Get(HeaderSignature)) {
+		t.Error("signature verifies with another key")
+	}
+
+	var event Event
+	if err := json.Unmarshal(r.body, &event); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if event.PowerTool.Name != "job" || len(event.Pods) != 1 || len(event.Artifacts) != 1 || event.Artifacts[0].URL == "" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestDispatcher_CloudEvents(t *testing.T) {
+	server, requests, _ := newTestSink(t)
+	d := newTestDispatcher(t)
+
+	d.Notify(context.Background(), []Sink{{Name: "ce", Type: toev1alpha1.SinkTypeCloudEvents, URL: server.URL}}, testEvent())
+	r := next(t, requests)
+
+	if got := r.header.Get("Content-Type"); got != "application/cloudevents+json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if r.header.Get(HeaderSignature) != "" {
+		t.Error("unsigned sink received a signature")
+	}
+	var ce struct {
+		SpecVersion string `json:"specversion"`
+		ID          string `json:"id"`
+		Source      string `json:"source"`
+		Type        string `json:"type"`
+		Subject     string `json:"subject"`
+		Data        Event  `json:"data"`
+	}
+	if err := json.Unmarshal(r.body, &ce); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if ce.SpecVersion != "1.0" || ce.ID != "uid-1-Completed" || ce.Type != "run.toe.powertool.completed" ||
+		ce.Source != "/apis/codriverlabs.ai.toe.run/v1alpha1/namespaces/default/powertools/job" || ce.Subject != "default/job" {
+		t.Errorf("CloudEvent attributes = %+v", ce)
+	}
+	if ce.Data.PowerTool.UID != "uid-1" {
+		t.Errorf("CloudEvent data = %+v", ce.Data)
+	}
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		maxRetries int
+		wantCalls  int32
+	}{
+		{name: "server errors then success", statuses: []int{500, 503}, maxRetries: 5, wantCalls: 3},
+		{name: "rate limited", statuses: []int{429}, maxRetries: 5, wantCalls: 2},
+		{name: "retries exhausted", statuses: []int{500, 500, 500, 500}, maxRetries: 2, wantCalls: 3},
+		{name: "client error is not retried", statuses: []int{400}, maxRetries: 5, wantCalls: 1},
+		{name: "redirect is not followed", statuses: []int{302}, maxRetries: 5, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _, calls := newTestSink(t, tt.statuses...)
+			d := newTestDispatcher(t)
+
+			d.Notify(context.Background(), []Sink{{Name: "hook", URL: server.URL, MaxRetries: tt.maxRetries}}, testEvent())
+			deadline := time.Now().Add(5 * time.Second)
+			for calls.Load() < tt.wantCalls && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			// Give an unwanted extra attempt the chance to show up
+			time.Sleep(50 * time.Millisecond)
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("sink called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDispatcher_EventFilter(t *testing.T) {
+	server, requests, calls := newTestSink(t)
+	d := newTestDispatcher(t)
+	sinks := []Sink{{Name: "failures", URL: server.URL, Events: []string{toev1alpha1.NotificationEventFailed}}}
+
+	d.Notify(context.Background(), sinks, testEvent())
+	failed := testEvent()
+	failed.Type = toev1alpha1.NotificationEventFailed
+	d.Notify(context.Background(), sinks, failed)
+
+	if r := next(t, requests); r.header.Get(HeaderEvent) != toev1alpha1.NotificationEventFailed {
+		t.Errorf("sink received %q", r.header.Get(HeaderEvent))
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls.Load() != 1 {
+		t.Errorf("sink called %d times, want 1", calls.Load())
+	}
+}
+
+func TestDispatcher_Restricted(t *testing.T) {
+	// httptest listens on loopback, which restricted sinks may not reach
+	server, _, calls := newTestSink(t)
+	d := NewDispatcher()
+	event := testEvent()
+
+	err := d.send(context.Background(), &Sink{Name: "inline", URL: server.URL, Restricted: true}, &event)
+	if err == nil {
+		t.Fatal("restricted sink reached a loopback address")
+	}
+	if calls.Load() != 0 {
+		t.Error("restricted sink request was received")
+	}
+	if err := d.send(context.Background(), &Sink{Name: "cluster", URL: server.URL}, &event); err != nil {
+		t.Errorf("unrestricted sink error = %v", err)
+	}
+}
+
+func TestDispatcher_RestrictedPrivate(t *testing.T) {
+	d := NewDispatcher()
+	event := testEvent()
+
+	for _, url := range []string{"http://10.0.0.5:8080/hook", "http://192.168.1.10/hook", "http://100.64.0.1/hook", "http://[fd00::1]/hook"} {
+		err := d.send(context.Background(), &Sink{Name: "inline", URL: url, Restricted: true}, &event)
+		if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+			t.Errorf("restricted sink %s error = %v, want the address refused", url, err)
+		}
+	}
+}
+
+func TestDispatcher_RestrictedAllowedNetworks(t *testing.T) {
+	server, _, calls := newTestSink(t)
+	d := NewDispatcher()
+	event := testEvent()
+
+	sink := &Sink{Name: "inline", URL: server.URL, Restricted: true, AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	if err := d.send(context.Background(), sink, &event); err == nil {
+		t.Fatal("restricted sink reached a loopback address outside its allowed networks")
+	}
+	sink.AllowedNetworks = append(sink.AllowedNetworks, netip.MustParsePrefix("127.0.0.0/8"))
+	if err := d.send(context.Background(), sink, &event); err != nil {
+		t.Errorf("restricted sink in an allowed network error = %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("sink called %d times, want 1", calls.Load())
+	}
+}
+
+func TestDispatcher_RestrictedIgnoresProxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(proxy.Close)
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("HTTPS_PROXY", proxy.URL)
+	t.Setenv("NO_PROXY", "")
+
+	d := NewDispatcher()
+	if d.restricted.Transport.(*http.Transport).Proxy != nil {
+		t.Fatal("restricted transport uses a proxy")
+	}
+
+	// The proxy is allowed, the sink behind it is not
+	event := testEvent()
+	sink := &Sink{Name: "inline", URL: "http://10.0.0.5:8080/hook", Restricted: true, AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}
+	err := d.send(context.Background(), sink, &event)
+	if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("restricted sink error = %v, want the address refused", err)
+	}
+	if proxied.Load() != 0 {
+		t.Error("restricted sink request went through the proxy")
+	}
+}
+
+func TestDenyInternal(t *testing.T) {
+	for address, allowed := range map[string]bool{
+		"203.0.113.7:80":       true,
+		"[2001:db8::1]:443":    true,
+		"10.0.0.5:443":         false,
+		"172.16.3.4:443":       false,
+		"192.168.1.10:80":      false,
+		"100.64.0.1:80":        false,
+		"100.127.255.254:80":   false,
+		"[fd12::1]:80":         false,
+		"127.0.0.1:8080":       false,
+		"[::1]:8080":           false,
+		"169.254.169.254:80":   false,
+		"[fe80::1]:80":         false,
+		"0.0.0.0:80":           false,
+		"[::ffff:127.0.0.1]:1": false,
+		"[::ffff:10.0.0.5]:1":  false,
+	} {
+		if err := denyInternal(context.Background(), "tcp", address, nil); (err == nil) != allowed {
+			t.Errorf("denyInternal(%s) = %v, want allowed %v", address, err, allowed)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), allowedNetworksKey{}, []netip.Prefix{netip.MustParsePrefix("10.96.0.0/12")})
+	if err := denyInternal(ctx, "tcp", "10.96.0.10:80", nil); err != nil {
+		t.Errorf("denyInternal() in an allowed network = %v", err)
+	}
+	if err := denyInternal(ctx, "tcp", "10.0.0.5:80", nil); err == nil {
+		t.Error("denyInternal() allowed an address outside the allowed networks")
+	}
+}
+
+func TestSink_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		sink    Sink
+		wantErr bool
+	}{
+		{sink: Sink{URL: "https://hooks.example.com/toe"}},
+		{sink: Sink{Type: toev1alpha1.SinkTypeCloudEvents, URL: "http://broker.knative-eventing/default"}},
+		{sink: Sink{Type: "kafka", URL: "https://hooks.example.com"}, wantErr: true},
+		{sink: Sink{URL: "file:///etc/passwd"}, wantErr: true},
+		{sink: Sink{URL: "/relative"}, wantErr: true},
+	} {
+		if err := tt.sink.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, wantErr %v", tt.sink, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for i, w := range want {
+		if got := exponentialBackoff(i + 1); got != w {
+			t.Errorf("exponentialBackoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := exponentialBackoff(20); got != maxBackoff {
+		t.Errorf("exponentialBackoff(20) = %v, want %v", got, maxBackoff)
+	}
+}
@@ -0,0 +1,144 @@
+// Package notify delivers PowerTool run notifications to HTTP sinks: generic
+// webhooks receiving a JSON document, and CloudEvents receivers. Deliveries
+// are queued and retried in the background, so reconciles never wait on a
+// sink.
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+// Headers set on every notification. The signature header is only set for
+// sinks with a signing secret.
+const (
+	HeaderEventID   = "X-TOE-Event-ID"
+	HeaderEvent     = "X-TOE-Event"
+	HeaderTimestamp = "X-TOE-Timestamp"
+	HeaderSignature = "X-TOE-Signature"
+)
+
+// Pod outcomes reported in PodOutcome
+const (
+	OutcomePending   = "Pending"
+	OutcomeRunning   = "Running"
+	OutcomeSucceeded = "Succeeded"
+	OutcomeFailed    = "Failed"
+	OutcomeUnknown   = "Unknown"
+)
+
+// CloudEventSpecVersion is the CloudEvents version of cloudevents sinks
+const CloudEventSpecVersion = "1.0"
+
+// cloudEventTypes are the CloudEvents types of the run events
+var cloudEventTypes = map[string]string{
+	toev1alpha1.NotificationEventStarted:        "run.toe.powertool.started",
+	toev1alpha1.NotificationEventCompleted:      "run.toe.powertool.completed",
+	toev1alpha1.NotificationEventFailed:         "run.toe.powertool.failed",
+	toev1alpha1.NotificationEventArtifactStored: "run.toe.powertool.artifact.stored",
+}
+
+// Event is a notification of a PowerTool run. Webhook sinks receive it as
+// is; CloudEvents sinks receive it as the data of the CloudEvent.
+type Event struct {
+	// ID is the same for every delivery of the event, so receivers can
+	// drop duplicates
+	ID        string       `json:"id"`
+	Type      string       `json:"type"`
+	Time      time.Time    `json:"time"`
+	PowerTool PowerToolRef `json:"powerTool"`
+	Phase     string       `json:"phase,omitempty"`
+	Message   string       `json:"message,omitempty"`
+	// StartedAt and FinishedAt are the run's, as in its status
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Pods is the outcome of the tool in each target pod
+	Pods []PodOutcome `json:"pods,omitempty"`
+	// Artifacts are the artifacts an ArtifactStored event announces, or
+	// those listed in the status when the run finished. Their URLs work
+	// until ArtifactLinksExpireAt.
+	Artifacts             []toev1alpha1.ArtifactReference `json:"artifacts,omitempty"`
+	ArtifactCount         int32                           `json:"artifactCount,omitempty"`
+	ArtifactBytes         int64                           `json:"artifactBytes,omitempty"`
+	ArtifactLinksExpireAt *time.Time                      `json:"artifactLinksExpireAt,omitempty"`
+}
+
+// PowerToolRef identifies the PowerTool of an event
+type PowerToolRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+	Tool      string `json:"tool"`
+}
+
+// PodOutcome is the state of the tool container in a target pod
+type PodOutcome struct {
+	Name     string `json:"name"`
+	Node     string `json:"node,omitempty"`
+	Outcome  string `json:"outcome"`
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// cloudEvent is the structured-mode JSON encoding of a CloudEvent
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            *Event    `json:"data"`
+}
+
+// encode returns the request body and content type sinks of sinkType
+// receive event in
+func encode(sinkType string, event *Event) ([]byte, string, error) {
+	switch sinkType {
+	case "", toev1alpha1.SinkTypeWebhook:
+		body, err := json.Marshal(event)
+		return body, "application/json", err
+	case toev1alpha1.SinkTypeCloudEvents:
+		body, err := json.Marshal(cloudEvent{
+			SpecVersion: CloudEventSpecVersion,
+			ID:          event.ID,
+			Source: fmt.Sprintf("/apis/%s/namespaces/%s/powertools/%s",
+				toev1alpha1.GroupVersion, event.PowerTool.Namespace, event.PowerTool.Name),
+			Type:            cloudEventTypes[event.Type],
+			Subject:         event.PowerTool.Namespace + "/" + event.PowerTool.Name,
+			Time:            event.Time,
+			DataContentType: "application/json",
+			Data:            event,
+		})
+		return body, "application/cloudevents+json", err
+	default:
+		return nil, "", fmt.Errorf("unknown sink type %q", sinkType)
+	}
+}
+
+// Sign returns the X-TOE-Signature value of a notification body sent at
+// timestamp, in Unix seconds: "sha256=" and the hex HMAC-SHA256 of the
+// timestamp, a dot and the body. Receivers recompute it with the shared
+// secret and reject stale timestamps to stop replays.
+func Sign(key []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body sent at
+// timestamp, comparing in constant time
+func Verify(key []byte, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(key, timestamp, body)), []byte(signature))
+}
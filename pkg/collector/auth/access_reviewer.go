@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+// ErrReadForbidden is returned when the caller may not read the PowerTool an
+// artifact belongs to
+var ErrReadForbidden = errors.New("read not authorized")
+
+// ReadRequest identifies the artifacts a caller wants to read. An empty
+// PowerToolName covers every PowerTool in the namespace.
+type ReadRequest struct {
+	Namespace     string
+	PowerToolName string
+}
+
+// SubjectAccessReviewer authorizes artifact reads with a SubjectAccessReview:
+// whoever may get a PowerTool may read its artifacts, and whoever may list
+// PowerTools in a namespace may list theirs.
+type SubjectAccessReviewer struct {
+	client kubernetes.Interface
+}
+
+func NewSubjectAccessReviewer(client kubernetes.Interface) *SubjectAccessReviewer {
+	return &SubjectAccessReviewer{client: client}
+}
+
+func (a *SubjectAccessReviewer) AuthorizeRead(ctx context.Context, user *authv1.UserInfo, req ReadRequest) error {
+	attrs := &authzv1.ResourceAttributes{
+		Namespace: req.Namespace,
+		Verb:      "list",
+		Group:     toev1alpha1.GroupVersion.Group,
+		Resource:  "powertools",
+	}
+	if req.PowerToolName != "" {
+		attrs.Verb = "get"
+		attrs.Name = req.PowerToolName
+	}
+
+	extra := make(map[string]authzv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+
+	sar := &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.UID,
+			Extra:              extra,
+		},
+	}
+
+	result, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, sar, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("subject access review failed: %w", err)
+	}
+	if !result.Status.Allowed {
+		return fmt.Errorf("%w: %s may not %s powertools in namespace %s",
+			ErrReadForbidden, user.Username, attrs.Verb, req.Namespace)
+	}
+	return nil
+}
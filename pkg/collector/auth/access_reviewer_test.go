@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSubjectAccessReviewer_AuthorizeRead(t *testing.T) {
+	user := &authv1.UserInfo{
+		Username: "alice",
+		UID:      "alice-uid",
+		Groups:   []string{"team-web"},
+		Extra:    map[string]authv1.ExtraValue{"scopes": {"read"}},
+	}
+
+	tests := []struct {
+		name     string
+		req      ReadRequest
+		allowed  bool
+		apiErr   error
+		wantVerb string
+		wantErr  error
+	}{
+		{
+			name:     "get a PowerTool's artifacts",
+			req:      ReadRequest{Namespace: "web", PowerToolName: "profile-job"},
+			allowed:  true,
+			wantVerb: "get",
+		},
+		{
+			name:     "list a namespace's artifacts",
+			req:      ReadRequest{Namespace: "web"},
+			allowed:  true,
+			wantVerb: "list",
+		},
+		{
+			name:     "denied",
+			req:      ReadRequest{Namespace: "db", PowerToolName: "profile-job"},
+			allowed:  false,
+			wantVerb: "get",
+			wantErr:  ErrReadForbidden,
+		},
+		{
+			name:     "api error",
+			req:      ReadRequest{Namespace: "web"},
+			apiErr:   errors.New("connection refused"),
+			wantVerb: "list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			var got *authzv1.SubjectAccessReview
+			client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				got = action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+				if tt.apiErr != nil {
+					return true, nil, tt.apiErr
+				}
+				got.Status.Allowed = tt.allowed
+				return true, got, nil
+			})
+
+			err := NewSubjectAccessReviewer(client).AuthorizeRead(context.Background(), user, tt.req)
+			switch {
+			case tt.apiErr != nil:
+				if err == nil || errors.Is(err, ErrReadForbidden) {
+					t.Errorf("AuthorizeRead() error = %v, want a non-forbidden error", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("AuthorizeRead() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("AuthorizeRead() unexpected error = %v", err)
+			}
+
+			attrs := got.Spec.ResourceAttributes
+			if attrs.Verb != tt.wantVerb || attrs.Namespace != tt.req.Namespace || attrs.Name != tt.req.PowerToolName ||
+				attrs.Group != "codriverlabs.ai.toe.run" || attrs.Resource != "powertools" {
+				t.Errorf("review attributes = %+v", attrs)
+			}
+			if got.Spec.User != "alice" || got.Spec.UID != "alice-uid" || len(got.Spec.Groups) != 1 || got.Spec.Extra["scopes"][0] != "read" {
+				t.Errorf("review subject = %+v", got.Spec)
+			}
+		})
+	}
+}
+
+func TestValidateToken_DefaultAudience(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var got *authv1.TokenReview
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		got = action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		got.Status.Authenticated = true
+		return true, got, nil
+	})
+
+	if _, err := NewK8sTokenValidator(client, "").ValidateToken(context.Background(), "token"); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if len(got.Spec.Audiences) != 0 {
+		t.Errorf("audiences = %v, want none so the API server's own apply", got.Spec.Audiences)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// Authenticator identifies the caller of a request. ok is false, with no
+// error, when the request carries none of the credentials the authenticator
+// understands, so another one may try. An error means credentials were
+// presented and rejected, or could not be checked.
+type Authenticator interface {
+	AuthenticateRequest(r *http.Request) (user *authv1.UserInfo, ok bool, err error)
+}
+
+// BearerTokenAuthenticator authenticates the bearer token in the
+// Authorization header with a TokenValidator
+type BearerTokenAuthenticator struct {
+	validator TokenValidator
+}
+
+// NewBearerTokenAuthenticator checks bearer tokens with validator
+func NewBearerTokenAuthenticator(validator TokenValidator) *BearerTokenAuthenticator {
+	return &BearerTokenAuthenticator{validator: validator}
+}
+
+func (a *BearerTokenAuthenticator) AuthenticateRequest(r *http.Request) (*authv1.UserInfo, bool, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, false, nil
+	}
+	user, err := a.validator.ValidateToken(r.Context(), token)
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// ChainAuthenticator tries authenticators in order. The first to find
+// credentials it understands decides; later ones are not consulted, so a
+// rejected certificate does not fall through to a token.
+type ChainAuthenticator []Authenticator
+
+// NewChainAuthenticator chains authenticators in the order given
+func NewChainAuthenticator(authenticators ...Authenticator) ChainAuthenticator {
+	return ChainAuthenticator(authenticators)
+}
+
+func (c ChainAuthenticator) AuthenticateRequest(r *http.Request) (*authv1.UserInfo, bool, error) {
+	for _, a := range c {
+		user, ok, err := a.AuthenticateRequest(r)
+		if err != nil || ok {
+			return user, ok, err
+		}
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// staticAuthenticator returns a fixed outcome and records whether it ran
+type staticAuthenticator struct {
+	user   *authv1.UserInfo
+	ok     bool
+	err    error
+	called bool
+}
+
+func (s *staticAuthenticator) AuthenticateRequest(r *http.Request) (*authv1.UserInfo, bool, error) {
+	s.called = true
+	return s.user, s.ok, s.err
+}
+
+func TestBearerTokenAuthenticator(t *testing.T) {
+	a := NewBearerTokenAuthenticator(&countingValidator{})
+	tests := []struct {
+		name    string
+		header  string
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "valid token", header: "Bearer good-token", wantOK: true},
+		{name: "rejected token", header: "Bearer bad-token", wantErr: true},
+		{name: "no header"},
+		{name: "other scheme", header: "Basic dXNlcjpwYXNz"},
+		{name: "empty token", header: "Bearer "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/profile", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			_, ok, err := a.AuthenticateRequest(req)
+			if ok != tt.wantOK || (err != nil) != tt.wantErr {
+				t.Errorf("AuthenticateRequest() = %v, %v, want ok %v, wantErr %v", ok, err, tt.wantOK, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChainAuthenticator(t *testing.T) {
+	user := &authv1.UserInfo{Username: "x509:ci-runner"}
+	tests := []struct {
+		name       string
+		first      *staticAuthenticator
+		wantOK     bool
+		wantErr    bool
+		wantUser   string
+		wantSecond bool
+	}{
+		{name: "first accepts", first: &staticAuthenticator{user: user, ok: true}, wantOK: true, wantUser: "x509:ci-runner"},
+		{name: "first rejects", first: &staticAuthenticator{err: errors.New("bad certificate")}, wantErr: true},
+		{name: "first finds nothing", first: &staticAuthenticator{}, wantSecond: true, wantOK: true, wantUser: "token-user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := &staticAuthenticator{user: &authv1.UserInfo{Username: "token-user"}, ok: true}
+			got, ok, err := NewChainAuthenticator(tt.first, second).AuthenticateRequest(httptest.NewRequest("GET", "/", nil))
+			if ok != tt.wantOK || (err != nil) != tt.wantErr {
+				t.Fatalf("AuthenticateRequest() = %v, %v, want ok %v, wantErr %v", ok, err, tt.wantOK, tt.wantErr)
+			}
+			if ok && got.Username != tt.wantUser {
+				t.Errorf("Username = %q, want %q", got.Username, tt.wantUser)
+			}
+			if second.called != tt.wantSecond {
+				t.Errorf("second authenticator called = %v, want %v", second.called, tt.wantSecond)
+			}
+		})
+	}
+
+	if _, ok, err := NewChainAuthenticator(&staticAuthenticator{}).AuthenticateRequest(httptest.NewRequest("GET", "/", nil)); ok || err != nil {
+		t.Errorf("AuthenticateRequest() with no credentials = %v, %v, want false, nil", ok, err)
+	}
+}
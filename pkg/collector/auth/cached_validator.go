@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/utils/lru"
+
+	"toe/pkg/collector/metrics"
+)
+
+// Defaults for TokenCacheConfig fields left zero
+const (
+	DefaultTokenCacheTTL         = time.Minute
+	DefaultTokenNegativeCacheTTL = 10 * time.Second
+	DefaultTokenCacheSize        = 4096
+)
+
+// Default client-side limit on TokenReviews, well below the API server's own
+// default of 50 queries per second per client
+const (
+	DefaultTokenReviewQPS   = 20
+	DefaultTokenReviewBurst = 40
+)
+
+// reviewTimeout bounds a review shared by concurrent requests for one token,
+// which must not be cut short when the request that started it goes away
+const reviewTimeout = 10 * time.Second
+
+// TokenValidator authenticates bearer tokens
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (*authv1.UserInfo, error)
+}
+
+// TokenCacheConfig tunes a CachedTokenValidator
+type TokenCacheConfig struct {
+	// TTL is how long an accepted token is trusted without another review.
+	// It never extends past the token's own expiry.
+	TTL time.Duration
+	// NegativeTTL is how long a rejected token stays rejected. Errors
+	// reaching the API server are never cached.
+	NegativeTTL time.Duration
+	// Size bounds the number of cached tokens; the least recently used are
+	// evicted first
+	Size int
+	// Limiter, when set, throttles the reviews made on cache misses. Share
+	// it between validators that talk to the same API server.
+	Limiter *rate.Limiter
+}
+
+// CachedTokenValidator remembers the outcome of each token's review so a
+// burst of uploads with one token costs a single TokenReview. Tokens are
+// cached by their SHA-256, never in the clear.
+type CachedTokenValidator struct {
+	inner    TokenValidator
+	endpoint string
+	cfg      TokenCacheConfig
+	cache    *lru.Cache
+	reviews  singleflight.Group
+	now      func() time.Time
+}
+
+type tokenCacheEntry struct {
+	user    *authv1.UserInfo
+	err     error
+	expires time.Time
+}
+
+// NewCachedTokenValidator caches the results of inner. endpoint labels the
+// cache metrics, like the endpoint label of metrics.AuthFailures.
+func NewCachedTokenValidator(inner TokenValidator, endpoint string, cfg TokenCacheConfig) *CachedTokenValidator {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTokenCacheTTL
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = DefaultTokenNegativeCacheTTL
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultTokenCacheSize
+	}
+	return &CachedTokenValidator{
+		inner:    inner,
+		endpoint: endpoint,
+		cfg:      cfg,
+		cache:    lru.New(cfg.Size),
+		now:      time.Now,
+	}
+}
+
+// ValidateToken returns the cached outcome for token, reviewing it only when
+// nothing current is cached. Concurrent requests with the same uncached token
+// share one review.
+func (v *CachedTokenValidator) ValidateToken(ctx context.Context, token string) (*authv1.UserInfo, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := string(sum[:])
+
+	if cached, ok := v.cache.Get(key); ok {
+		entry := cached.(tokenCacheEntry)
+		if v.now().Before(entry.expires) {
+			if entry.err != nil {
+				metrics.TokenCacheLookups.WithLabelValues(v.endpoint, metrics.TokenCacheNegativeHit).Inc()
+				return nil, entry.err
+			}
+			metrics.TokenCacheLookups.WithLabelValues(v.endpoint, metrics.TokenCacheHit).Inc()
+			return entry.user.DeepCopy(), nil
+		}
+		v.cache.Remove(key)
+	}
+	metrics.TokenCacheLookups.WithLabelValues(v.endpoint, metrics.TokenCacheMiss).Inc()
+
+	result := v.reviews.DoChan(key, func() (any, error) {
+		reviewCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reviewTimeout)
+		defer cancel()
+		return v.review(reviewCtx, key, token)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*authv1.UserInfo).DeepCopy(), nil
+	}
+}
+
+// review asks inner about token, once the rate limit allows, and caches a
+// definite answer
+func (v *CachedTokenValidator) review(ctx context.Context, key, token string) (*authv1.UserInfo, error) {
+	if v.cfg.Limiter != nil {
+		if err := v.cfg.Limiter.Wait(ctx); err != nil {
+			metrics.TokenReviewsThrottled.WithLabelValues(v.endpoint).Inc()
+			return nil, fmt.Errorf("token review rate limited: %w", err)
+		}
+	}
+
+	user, err := v.inner.ValidateToken(ctx, token)
+	now := v.now()
+	switch {
+	case err == nil:
+		expires := now.Add(v.cfg.TTL)
+		if exp, ok := tokenExpiry(token); ok && exp.Before(expires) {
+			expires = exp
+		}
+		v.cache.Add(key, tokenCacheEntry{user: user, expires: expires})
+	case errors.Is(err, ErrTokenRejected):
+		v.cache.Add(key, tokenCacheEntry{err: err, expires: now.Add(v.cfg.NegativeTTL)})
+	}
+	return user, err
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it. It is only
+// used to stop trusting a cached review early, never to extend trust.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// countingValidator accepts tokens starting with "good" and counts reviews
+type countingValidator struct {
+	calls   atomic.Int32
+	err     error
+	release chan struct{}
+}
+
+func (c *countingValidator) ValidateToken(ctx context.Context, token string) (*authv1.UserInfo, error) {
+	c.calls.Add(1)
+	if c.release != nil {
+		<-c.release
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if !strings.HasPrefix(token, "good") {
+		return nil, fmt.Errorf("%w: invalid bearer token", ErrTokenRejected)
+	}
+	return &authv1.UserInfo{Username: "system:serviceaccount:toe-system:toe-collector"}, nil
+}
+
+func jwtExpiringAt(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return "good." + payload + ".signature"
+}
+
+func TestCachedTokenValidator(t *testing.T) {
+	now := time.Date(2025, 10, 30, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		token     string
+		innerErr  error
+		advance   time.Duration
+		wantErr   bool
+		wantCalls int32
+	}{
+		{name: "accepted token is cached", token: "good-token", wantCalls: 1},
+		{name: "accepted token expires from cache", token: "good-token", advance: DefaultTokenCacheTTL, wantCalls: 2},
+		{name: "rejected token is cached", token: "bad-token", wantErr: true, wantCalls: 1},
+		{name: "rejected token expires from cache", token: "bad-token", advance: DefaultTokenNegativeCacheTTL, wantErr: true, wantCalls: 2},
+		{name: "API errors are not cached", token: "good-token", innerErr: errors.New("connection refused"), wantErr: true, wantCalls: 2},
+		{name: "cache stops at token expiry", token: jwtExpiringAt(now.Add(10 * time.Second)), advance: 10 * time.Second, wantCalls: 2},
+		{name: "token expiry after TTL", token: jwtExpiringAt(now.Add(time.Hour)), advance: DefaultTokenCacheTTL - time.Second, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &countingValidator{err: tt.innerErr}
+			v := NewCachedTokenValidator(inner, "upload", TokenCacheConfig{})
+			clock := now
+			v.now = func() time.Time { return clock }
+
+			for i := 0; i < 2; i++ {
+				user, err := v.ValidateToken(context.Background(), tt.token)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("ValidateToken() #%d error = %v, wantErr %v", i+1, err, tt.wantErr)
+				}
+				if !tt.wantErr && user == nil {
+					t.Fatalf("ValidateToken() #%d returned no user", i+1)
+				}
+				clock = clock.Add(tt.advance)
+			}
+			if got := inner.calls.Load(); got != tt.wantCalls {
+				t.Errorf("reviews = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCachedTokenValidator_NegativeResultKeepsError(t *testing.T) {
+	v := NewCachedTokenValidator(&countingValidator{}, "upload", TokenCacheConfig{})
+	for i := 0; i < 2; i++ {
+		if _, err := v.ValidateToken(context.Background(), "bad-token"); !errors.Is(err, ErrTokenRejected) {
+			t.Errorf("ValidateToken() #%d error = %v, want ErrTokenRejected", i+1, err)
+		}
+	}
+}
+
+func TestCachedTokenValidator_Eviction(t *testing.T) {
+	inner := &countingValidator{}
+	v := NewCachedTokenValidator(inner, "upload", TokenCacheConfig{Size: 1})
+
+	for _, token := range []string{"good-a", "good-b", "good-a"} {
+		if _, err := v.ValidateToken(context.Background(), token); err != nil {
+			t.Fatalf("ValidateToken(%s) error = %v", token, err)
+		}
+	}
+	if got := inner.calls.Load(); got != 3 {
+		t.Errorf("reviews = %d, want 3 with room for one token", got)
+	}
+}
+
+func TestCachedTokenValidator_ConcurrentMissesShareReview(t *testing.T) {
+	inner := &countingValidator{release: make(chan struct{})}
+	v := NewCachedTokenValidator(inner, "upload", TokenCacheConfig{})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.ValidateToken(context.Background(), "good-token")
+			errs <- err
+		}()
+	}
+	// Let the waiting requests pile up behind the first review
+	for inner.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("ValidateToken() error = %v", err)
+		}
+	}
+	if got := inner.calls.Load(); got != 1 {
+		t.Errorf("reviews = %d, want 1", got)
+	}
+}
+
+func TestCachedTokenValidator_RateLimit(t *testing.T) {
+	inner := &countingValidator{}
+	v := NewCachedTokenValidator(inner, "upload", TokenCacheConfig{
+		// One review now, the next in 100 seconds
+		Limiter: rate.NewLimiter(rate.Limit(0.01), 1),
+	})
+
+	if _, err := v.ValidateToken(context.Background(), "good-a"); err != nil {
+		t.Fatalf("ValidateToken() within limit error = %v", err)
+	}
+	// Cached tokens are not limited
+	if _, err := v.ValidateToken(context.Background(), "good-a"); err != nil {
+		t.Fatalf("ValidateToken() cached error = %v", err)
+	}
+	if _, err := v.ValidateToken(context.Background(), "good-b"); err == nil || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("ValidateToken() over limit error = %v, want rate limited", err)
+	}
+	if got := inner.calls.Load(); got != 1 {
+		t.Errorf("reviews = %d, want 1", got)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Unix(1761825600, 0)
+	tests := []struct {
+		name   string
+		token  string
+		want   time.Time
+		wantOK bool
+	}{
+		{"JWT", jwtExpiringAt(exp), exp, true},
+		{"opaque token", "abcdef", time.Time{}, false},
+		{"undecodable payload", "a.!!!.c", time.Time{}, false},
+		{"no exp claim", "a." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"x"}`)) + ".c", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tokenExpiry(tt.token)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("tokenExpiry() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
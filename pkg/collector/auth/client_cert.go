@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// ErrCertificateRejected is returned for client certificates that do not
+// verify against the CA or match no rule
+var ErrCertificateRejected = errors.New("client certificate not accepted")
+
+// Extra keys carrying the upload scope of a client certificate. Identities
+// without them, such as ServiceAccount tokens, are limited by PowerTool
+// authorization alone.
+const (
+	ExtraUploadNamespaces = "toe.run/upload-namespaces"
+	ExtraUploadPowerTools = "toe.run/upload-powertools"
+)
+
+// GroupClientCertificates is added to the groups of every certificate
+// identity
+const GroupClientCertificates = "toe:client-certificates"
+
+// certUsernamePrefix keeps certificate identities apart from Kubernetes
+// users of the same name
+const certUsernamePrefix = "x509:"
+
+// CertificateRule maps client certificates to what they may upload. Exactly
+// one of CommonName, DNSName, URI or Email selects the certificates it
+// applies to; each is a path.Match pattern. Namespaces and PowerTools are
+// patterns too. Empty PowerTools allows every PowerTool in the namespaces.
+type CertificateRule struct {
+	CommonName string   `json:"commonName,omitempty"`
+	DNSName    string   `json:"dnsName,omitempty"`
+	URI        string   `json:"uri,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	Namespaces []string `json:"namespaces"`
+	PowerTools []string `json:"powerTools,omitempty"`
+}
+
+// Validate checks the rule selects certificates and grants something
+func (r CertificateRule) Validate() error {
+	selectors := 0
+	for _, p := range []string{r.CommonName, r.DNSName, r.URI, r.Email} {
+		if p == "" {
+			continue
+		}
+		selectors++
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	if selectors != 1 {
+		return fmt.Errorf("exactly one of commonName, dnsName, uri or email is required")
+	}
+	if len(r.Namespaces) == 0 {
+		return fmt.Errorf("namespaces is required")
+	}
+	for _, p := range append(append([]string(nil), r.Namespaces...), r.PowerTools...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// match returns the certificate name the rule selects, if any
+func (r CertificateRule) match(cert *x509.Certificate) (string, bool) {
+	switch {
+	case r.CommonName != "":
+		return matchAny(r.CommonName, []string{cert.Subject.CommonName})
+	case r.DNSName != "":
+		return matchAny(r.DNSName, cert.DNSNames)
+	case r.URI != "":
+		uris := make([]string, len(cert.URIs))
+		for i, u := range cert.URIs {
+			uris[i] = u.String()
+		}
+		return matchAny(r.URI, uris)
+	default:
+		return matchAny(r.Email, cert.EmailAddresses)
+	}
+}
+
+func matchAny(pattern string, names []string) (string, bool) {
+	for _, name := range names {
+		if ok, _ := path.Match(pattern, name); ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ClientCertAuthenticator authenticates TLS client certificates issued by a
+// configured CA, mapping them to upload permissions through rules
+type ClientCertAuthenticator struct {
+	roots *x509.CertPool
+	rules []CertificateRule
+	now   func() time.Time
+}
+
+// NewClientCertAuthenticator verifies certificates against the PEM CA bundle
+// and maps them with rules, the first matching rule winning
+func NewClientCertAuthenticator(caPEM []byte, rules []CertificateRule) (*ClientCertAuthenticator, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no CA certificates found in client CA bundle")
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("at least one client certificate rule is required")
+	}
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid client certificate rule %d: %w", i, err)
+		}
+	}
+	return &ClientCertAuthenticator{
+		roots: roots,
+		rules: rules,
+		now:   time.Now,
+	}, nil
+}
+
+// ClientCAs returns the pool certificates are verified against, for the
+// server to advertise in its TLS handshake
+func (a *ClientCertAuthenticator) ClientCAs() *x509.CertPool {
+	return a.roots
+}
+
+func (a *ClientCertAuthenticator) AuthenticateRequest(r *http.Request) (*authv1.UserInfo, bool, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, false, nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: intermediates,
+		CurrentTime:   a.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrCertificateRejected, err)
+	}
+
+	for _, rule := range a.rules {
+		name, ok := rule.match(cert)
+		if !ok {
+			continue
+		}
+		user := &authv1.UserInfo{
+			Username: certUsernamePrefix + name,
+			Groups:   []string{GroupClientCertificates},
+			Extra: map[string]authv1.ExtraValue{
+				ExtraUploadNamespaces: rule.Namespaces,
+			},
+		}
+		if len(rule.PowerTools) > 0 {
+			user.Extra[ExtraUploadPowerTools] = rule.PowerTools
+		}
+		return user, true, nil
+	}
+	return nil, false, fmt.Errorf("%w: no rule matches subject %q", ErrCertificateRejected, cert.Subject.String())
+}
+
+// CheckUploadScope refuses uploads outside the namespaces and PowerTools an
+// identity was limited to, wrapping ErrUploadForbidden. Identities without a
+// scope are not limited here.
+func CheckUploadScope(user *authv1.UserInfo, namespace, powerTool string) error {
+	if user == nil {
+		return nil
+	}
+	if patterns, ok := user.Extra[ExtraUploadNamespaces]; ok {
+		if !matchAnyPattern(patterns, namespace) {
+			return fmt.Errorf("%w: %s may not upload to namespace %s", ErrUploadForbidden, user.Username, namespace)
+		}
+	}
+	if patterns, ok := user.Extra[ExtraUploadPowerTools]; ok {
+		if !matchAnyPattern(patterns, powerTool) {
+			return fmt.Errorf("%w: %s may not upload for PowerTool %s", ErrUploadForbidden, user.Username, powerTool)
+		}
+	}
+	return nil
+}
+
+func matchAnyPattern(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// testCA issues client certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "toe test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue signs a certificate built from template, defaulting it to a client
+// certificate valid for an hour
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	if template.ExtKeyUsage == nil {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/ci/sa/uploader")
+
+	a, err := NewClientCertAuthenticator(ca.pem, []CertificateRule{
+		{CommonName: "ci-*", Namespaces: []string{"ci"}},
+		{DNSName: "*.uploader.example.com", Namespaces: []string{"team-*"}, PowerTools: []string{"nightly-*"}},
+		{URI: "spiffe://cluster.local/ns/*/sa/uploader", Namespaces: []string{"*"}},
+	})
+	if err != nil {
+		t.Fatalf("NewClientCertAuthenticator() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		cert           *x509.Certificate
+		noCert         bool
+		wantOK         bool
+		wantErr        bool
+		wantUser       string
+		wantNamespaces []string
+		wantPowerTools []string
+	}{
+		{
+			name:           "common name rule",
+			cert:           ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner"}}),
+			wantOK:         true,
+			wantUser:       "x509:ci-runner",
+			wantNamespaces: []string{"ci"},
+		},
+		{
+			name:           "DNS name rule",
+			cert:           ca.issue(t, &x509.Certificate{DNSNames: []string{"eu.uploader.example.com"}}),
+			wantOK:         true,
+			wantUser:       "x509:eu.uploader.example.com",
+			wantNamespaces: []string{"team-*"},
+			wantPowerTools: []string{"nightly-*"},
+		},
+		{
+			name:           "URI rule",
+			cert:           ca.issue(t, &x509.Certificate{URIs: []*url.URL{spiffe}}),
+			wantOK:         true,
+			wantUser:       "x509:" + spiffe.String(),
+			wantNamespaces: []string{"*"},
+		},
+		{
+			name:   "no certificate",
+			noCert: true,
+		},
+		{
+			name:    "issued by another CA",
+			cert:    otherCA.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner"}}),
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			cert: ca.issue(t, &x509.Certificate{
+				Subject:   pkix.Name{CommonName: "ci-runner"},
+				NotBefore: time.Now().Add(-2 * time.Hour),
+				NotAfter:  time.Now().Add(-time.Hour),
+			}),
+			wantErr: true,
+		},
+		{
+			name: "server certificate",
+			cert: ca.issue(t, &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "ci-runner"},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			}),
+			wantErr: true,
+		},
+		{
+			name:    "no rule matches",
+			cert:    ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "laptop"}}),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/profile", nil)
+			if !tt.noCert {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+
+			user, ok, err := a.AuthenticateRequest(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthenticateRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrCertificateRejected) {
+				t.Errorf("AuthenticateRequest() error = %v, want ErrCertificateRejected", err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("AuthenticateRequest() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if user.Username != tt.wantUser {
+				t.Errorf("Username = %q, want %q", user.Username, tt.wantUser)
+			}
+			if got := user.Extra[ExtraUploadNamespaces]; !equalStrings(got, tt.wantNamespaces) {
+				t.Errorf("namespaces = %v, want %v", got, tt.wantNamespaces)
+			}
+			if got := user.Extra[ExtraUploadPowerTools]; !equalStrings(got, tt.wantPowerTools) {
+				t.Errorf("PowerTools = %v, want %v", got, tt.wantPowerTools)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNewClientCertAuthenticator_Errors(t *testing.T) {
+	ca := newTestCA(t)
+	tests := []struct {
+		name  string
+		caPEM []byte
+		rules []CertificateRule
+	}{
+		{"no CA", []byte("not a certificate"), []CertificateRule{{CommonName: "a", Namespaces: []string{"a"}}}},
+		{"no rules", ca.pem, nil},
+		{"no selector", ca.pem, []CertificateRule{{Namespaces: []string{"a"}}}},
+		{"two selectors", ca.pem, []CertificateRule{{CommonName: "a", DNSName: "a", Namespaces: []string{"a"}}}},
+		{"no namespaces", ca.pem, []CertificateRule{{CommonName: "a"}}},
+		{"bad pattern", ca.pem, []CertificateRule{{CommonName: "a", Namespaces: []string{"["}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClientCertAuthenticator(tt.caPEM, tt.rules); err == nil {
+				t.Error("NewClientCertAuthenticator() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestCheckUploadScope(t *testing.T) {
+	scoped := &authv1.UserInfo{
+		Username: "x509:ci-runner",
+		Extra: map[string]authv1.ExtraValue{
+			ExtraUploadNamespaces: {"team-*"},
+			ExtraUploadPowerTools: {"nightly-*"},
+		},
+	}
+	tests := []struct {
+		name      string
+		user      *authv1.UserInfo
+		namespace string
+		powerTool string
+		wantErr   bool
+	}{
+		{"within scope", scoped, "team-a", "nightly-cpu", false},
+		{"other namespace", scoped, "kube-system", "nightly-cpu", true},
+		{"other PowerTool", scoped, "team-a", "adhoc", true},
+		{"unscoped identity", &authv1.UserInfo{Username: "system:serviceaccount:toe-system:toe-collector"}, "kube-system", "adhoc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckUploadScope(tt.user, tt.namespace, tt.powerTool)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckUploadScope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrUploadForbidden) {
+				t.Errorf("CheckUploadScope() error = %v, want ErrUploadForbidden", err)
+			}
+		})
+	}
+}
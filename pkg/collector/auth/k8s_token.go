@@ -11,16 +11,20 @@ import (
 )
 
 type K8sTokenManager struct {
-	client    kubernetes.Interface
-	namespace string
-	audience  string
+	client         kubernetes.Interface
+	namespace      string
+	serviceAccount string
+	audience       string
 }
 
-func NewK8sTokenManager(client kubernetes.Interface, namespace, audience string) *K8sTokenManager {
+// NewK8sTokenManager creates a token manager that mints tokens for the given
+// collector ServiceAccount, scoped to audience
+func NewK8sTokenManager(client kubernetes.Interface, namespace, serviceAccount, audience string) *K8sTokenManager {
 	return &K8sTokenManager{
-		client:    client,
-		namespace: namespace,
-		audience:  audience,
+		client:         client,
+		namespace:      namespace,
+		serviceAccount: serviceAccount,
+		audience:       audience,
 	}
 }
 
@@ -35,10 +39,10 @@ func (tm *K8sTokenManager) GenerateToken(ctx context.Context, jobID string, dura
 		},
 	}
 
-	// Request token from collector's ServiceAccount in toe-system
-	result, err := tm.client.CoreV1().ServiceAccounts("toe-system").CreateToken(
+	// Request token from the collector's ServiceAccount
+	result, err := tm.client.CoreV1().ServiceAccounts(tm.namespace).CreateToken(
 		ctx,
-		"toe-collector", // Collector's ServiceAccount (with kustomize prefix)
+		tm.serviceAccount,
 		treq,
 		metav1.CreateOptions{},
 	)
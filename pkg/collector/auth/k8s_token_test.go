@@ -17,9 +17,10 @@ import (
 func TestNewK8sTokenManager(t *testing.T) {
 	client := fake.NewSimpleClientset()
 	namespace := "test-namespace"
+	serviceAccount := "test-collector"
 	audience := "test-audience"
 
-	manager := NewK8sTokenManager(client, namespace, audience)
+	manager := NewK8sTokenManager(client, namespace, serviceAccount, audience)
 
 	if manager == nil {
 		t.Fatal("NewK8sTokenManager returned nil")
@@ -29,6 +30,10 @@ func TestNewK8sTokenManager(t *testing.T) {
 		t.Errorf("expected namespace %v, got %v", namespace, manager.namespace)
 	}
 
+	if manager.serviceAccount != serviceAccount {
+		t.Errorf("expected serviceAccount %v, got %v", serviceAccount, manager.serviceAccount)
+	}
+
 	if manager.audience != audience {
 		t.Errorf("expected audience %v, got %v", audience, manager.audience)
 	}
@@ -57,7 +62,7 @@ func TestGenerateToken_Success(t *testing.T) {
 		return false, nil, nil
 	})
 
-	manager := NewK8sTokenManager(client, "toe-system", "toe-sdk-collector")
+	manager := NewK8sTokenManager(client, "toe-system", "toe-collector", "toe-sdk-collector")
 	token, err := manager.GenerateToken(context.Background(), "test-job", 10*time.Minute)
 
 	if err != nil {
@@ -96,7 +101,7 @@ func TestGenerateToken_Duration(t *testing.T) {
 		return false, nil, nil
 	})
 
-	manager := NewK8sTokenManager(client, "toe-system", "toe-sdk-collector")
+	manager := NewK8sTokenManager(client, "toe-system", "toe-collector", "toe-sdk-collector")
 	duration := 15 * time.Minute
 	_, err := manager.GenerateToken(context.Background(), "test-job", duration)
 
@@ -121,7 +126,7 @@ func TestGenerateToken_ServiceAccountNotFound(t *testing.T) {
 		return false, nil, nil
 	})
 
-	manager := NewK8sTokenManager(client, "toe-system", "toe-sdk-collector")
+	manager := NewK8sTokenManager(client, "toe-system", "toe-collector", "toe-sdk-collector")
 	token, err := manager.GenerateToken(context.Background(), "test-job", 10*time.Minute)
 
 	if err == nil {
@@ -150,7 +155,7 @@ func TestGenerateToken_K8sAPIError(t *testing.T) {
 		return false, nil, nil
 	})
 
-	manager := NewK8sTokenManager(client, "toe-system", "toe-sdk-collector")
+	manager := NewK8sTokenManager(client, "toe-system", "toe-collector", "toe-sdk-collector")
 	token, err := manager.GenerateToken(context.Background(), "test-job", 10*time.Minute)
 
 	if err == nil {
@@ -161,3 +166,31 @@ func TestGenerateToken_K8sAPIError(t *testing.T) {
 		t.Errorf("expected empty token, got %v", token)
 	}
 }
+
+func TestGenerateToken_UsesConfiguredServiceAccount(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	var gotNamespace, gotName string
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "token" {
+			gotNamespace = action.GetNamespace()
+			gotName = action.(k8stesting.CreateActionImpl).Name
+			return true, &authv1.TokenRequest{
+				Status: authv1.TokenRequestStatus{Token: "test-token"},
+			}, nil
+		}
+		return false, nil, nil
+	})
+
+	manager := NewK8sTokenManager(client, "observability", "custom-collector", "toe-sdk-collector")
+	if _, err := manager.GenerateToken(context.Background(), "test-job", 10*time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotNamespace != "observability" {
+		t.Errorf("expected token request in namespace 'observability', got %v", gotNamespace)
+	}
+	if gotName != "custom-collector" {
+		t.Errorf("expected token request for 'custom-collector', got %v", gotName)
+	}
+}
@@ -2,33 +2,60 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	authv1 "k8s.io/api/authentication/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
+// ErrTokenRejected is returned for tokens the API server does not
+// authenticate, as opposed to errors reaching it
+var ErrTokenRejected = errors.New("token not authenticated")
+
 type K8sTokenValidator struct {
 	client   kubernetes.Interface
 	audience string
+	// usernames, when not empty, are the only identities accepted
+	usernames map[string]bool
 }
 
-func NewK8sTokenValidator(client kubernetes.Interface, audience string) *K8sTokenValidator {
-	return &K8sTokenValidator{
+// NewK8sTokenValidator creates a validator accepting tokens for audience. An
+// empty audience accepts tokens meant for the API server itself, such as a
+// user's kubectl credentials. When serviceAccounts are given, only tokens of
+// those ServiceAccounts are accepted.
+func NewK8sTokenValidator(client kubernetes.Interface, audience string, serviceAccounts ...types.NamespacedName) *K8sTokenValidator {
+	v := &K8sTokenValidator{
 		client:   client,
 		audience: audience,
 	}
+	if len(serviceAccounts) > 0 {
+		v.usernames = make(map[string]bool, len(serviceAccounts))
+		for _, sa := range serviceAccounts {
+			v.usernames[serviceAccountUsername(sa)] = true
+		}
+	}
+	return v
+}
+
+// serviceAccountUsername is the username the API server authenticates a
+// ServiceAccount's tokens as
+func serviceAccountUsername(sa types.NamespacedName) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", sa.Namespace, sa.Name)
 }
 
 func (v *K8sTokenValidator) ValidateToken(ctx context.Context, token string) (*authv1.UserInfo, error) {
 	// Create TokenReview
 	tr := &authv1.TokenReview{
 		Spec: authv1.TokenReviewSpec{
-			Token:     token,
-			Audiences: []string{v.audience},
+			Token: token,
 		},
 	}
+	if v.audience != "" {
+		tr.Spec.Audiences = []string{v.audience}
+	}
 
 	// Submit TokenReview to API server
 	result, err := v.client.AuthenticationV1().TokenReviews().Create(ctx, tr, metav1.CreateOptions{})
@@ -37,9 +64,27 @@ func (v *K8sTokenValidator) ValidateToken(ctx context.Context, token string) (*a
 	}
 
 	if !result.Status.Authenticated {
-		return nil, fmt.Errorf("token not authenticated: %v", result.Status.Error)
+		return nil, fmt.Errorf("%w: %v", ErrTokenRejected, result.Status.Error)
+	}
+
+	if v.usernames != nil && !v.usernames[result.Status.User.Username] {
+		return nil, fmt.Errorf("%w: %s is not an accepted service account", ErrTokenRejected, result.Status.User.Username)
 	}
 
 	// Return user info for further processing
 	return &result.Status.User, nil
 }
+
+// Check verifies the TokenReview API is reachable by reviewing a token that
+// can never authenticate
+func (v *K8sTokenValidator) Check(ctx context.Context) error {
+	tr := &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{
+			Token: "readiness-probe",
+		},
+	}
+	if _, err := v.client.AuthenticationV1().TokenReviews().Create(ctx, tr, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("token review failed: %w", err)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	authv1 "k8s.io/api/authentication/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
 )
@@ -129,3 +130,56 @@ func TestValidateToken_WithAudience(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestValidateToken_ServiceAccounts(t *testing.T) {
+	allowed := types.NamespacedName{Namespace: "toe-system", Name: "toe-collector"}
+
+	tests := []struct {
+		name     string
+		username string
+		wantErr  bool
+	}{
+		{"accepted service account", "system:serviceaccount:toe-system:toe-collector", false},
+		{"other service account", "system:serviceaccount:default:default", true},
+		{"same name in other namespace", "system:serviceaccount:default:toe-collector", true},
+		{"user", "jane@example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				tr := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+				tr.Status = authv1.TokenReviewStatus{
+					Authenticated: true,
+					User:          authv1.UserInfo{Username: tt.username},
+				}
+				return true, tr, nil
+			})
+
+			validator := NewK8sTokenValidator(client, "toe-sdk-collector", allowed)
+			userInfo, err := validator.ValidateToken(context.Background(), "token")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && userInfo != nil {
+				t.Errorf("ValidateToken() userInfo = %v, want nil", userInfo)
+			}
+		})
+	}
+}
+
+func TestK8sTokenValidator_Check(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	validator := NewK8sTokenValidator(client, "toe-sdk-collector")
+
+	if err := validator.Check(context.Background()); err != nil {
+		t.Errorf("Check() with reachable API error = %v", err)
+	}
+
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("API server unavailable")
+	})
+	if err := validator.Check(context.Background()); err == nil {
+		t.Error("Check() with unreachable API expected error, got nil")
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+// ErrUploadForbidden is returned when an authenticated upload does not come
+// from a target of an active PowerTool
+var ErrUploadForbidden = errors.New("upload not authorized")
+
+// DefaultFinishedUploadGrace is how long after a PowerTool completes its tools
+// may still upload, covering the last upload racing the status update
+const DefaultFinishedUploadGrace = 10 * time.Minute
+
+const (
+	phaseRunning   = "Running"
+	phaseCompleted = "Completed"
+)
+
+// UploadRequest identifies the PowerTool and target pod an upload claims to
+// come from
+type UploadRequest struct {
+	Namespace     string
+	PowerToolName string
+	PodName       string
+}
+
+// UploadTarget is what an authorized upload belongs to
+type UploadTarget struct {
+	PowerTool *toev1alpha1.PowerTool
+	// Pod is the target pod the upload was made from
+	Pod *corev1.Pod
+}
+
+// PowerToolAuthorizer checks uploads against the PowerTool they reference.
+// Collector tokens are minted for a shared ServiceAccount, so the token alone
+// does not say which run an upload belongs to.
+type PowerToolAuthorizer struct {
+	client client.Reader
+	grace  time.Duration
+	now    func() time.Time
+}
+
+// NewPowerToolAuthorizer creates an authorizer that reads PowerTools and pods
+// through c. A zero grace uses DefaultFinishedUploadGrace.
+func NewPowerToolAuthorizer(c client.Reader, grace time.Duration) *PowerToolAuthorizer {
+	if grace <= 0 {
+		grace = DefaultFinishedUploadGrace
+	}
+	return &PowerToolAuthorizer{
+		client: c,
+		grace:  grace,
+		now:    time.Now,
+	}
+}
+
+// AuthorizeUpload allows the upload only if the PowerTool exists, is Running
+// or finished within the grace period, and the pod is one of its targets
+// carrying the tool container. It returns the PowerTool and pod so the
+// PowerTool's output settings can be applied to the upload and its source
+// recorded. Denials wrap ErrUploadForbidden; other errors
+// mean the check itself could not be made.
+func (a *PowerToolAuthorizer) AuthorizeUpload(ctx context.Context, req UploadRequest) (*UploadTarget, error) {
+	var powerTool toev1alpha1.PowerTool
+	key := types.NamespacedName{Namespace: req.Namespace, Name: req.PowerToolName}
+	if err := a.client.Get(ctx, key, &powerTool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: PowerTool %s not found", ErrUploadForbidden, key)
+		}
+		return nil, fmt.Errorf("failed to get PowerTool %s: %w", key, err)
+	}
+
+	if err := a.checkPhase(&powerTool); err != nil {
+		return nil, err
+	}
+
+	var pod corev1.Pod
+	podKey := types.NamespacedName{Namespace: req.Namespace, Name: req.PodName}
+	if err := a.client.Get(ctx, podKey, &pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: pod %s not found", ErrUploadForbidden, podKey)
+		}
+		return nil, fmt.Errorf("failed to get pod %s: %w", podKey, err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(powerTool.Spec.Targets.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("%w: PowerTool %s has an invalid label selector: %v", ErrUploadForbidden, key, err)
+	}
+	if !selector.Matches(labels.Set(pod.Labels)) {
+		return nil, fmt.Errorf("%w: pod %s is not a target of PowerTool %s", ErrUploadForbidden, podKey, key)
+	}
+
+	containerName := powerTool.ToolContainerName()
+	for _, ec := range pod.Spec.EphemeralContainers {
+		if ec.Name == containerName {
+			return &UploadTarget{PowerTool: &powerTool, Pod: &pod}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: pod %s has no container for PowerTool %s", ErrUploadForbidden, podKey, key)
+}
+
+func (a *PowerToolAuthorizer) checkPhase(powerTool *toev1alpha1.PowerTool) error {
+	phase := ""
+	if powerTool.Status.Phase != nil {
+		phase = *powerTool.Status.Phase
+	}
+
+	switch phase {
+	case phaseRunning:
+		return nil
+	case phaseCompleted:
+		finishedAt := powerTool.Status.FinishedAt
+		if finishedAt != nil && a.now().Sub(finishedAt.Time) <= a.grace {
+			return nil
+		}
+		return fmt.Errorf("%w: PowerTool %s/%s finished more than %s ago",
+			ErrUploadForbidden, powerTool.Namespace, powerTool.Name, a.grace)
+	default:
+		return fmt.Errorf("%w: PowerTool %s/%s is not running (phase %q)",
+			ErrUploadForbidden, powerTool.Namespace, powerTool.Name, phase)
+	}
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	toev1alpha1 "toe/api/v1alpha1"
+)
+
+func newAuthorizerScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = toev1alpha1.AddToScheme(scheme)
+	return scheme
+}
+
+func testPowerTool(phase string, finishedAt *time.Time) *toev1alpha1.PowerTool {
+	pt := &toev1alpha1.PowerTool{
+		ObjectMeta: metav1.ObjectMeta{Name: "profile-app", Namespace: "default", UID: "12345678-abcd"},
+		Spec: toev1alpha1.PowerToolSpec{
+			Targets: toev1alpha1.TargetSpec{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+		},
+	}
+	if phase != "" {
+		pt.Status.Phase = &phase
+	}
+	if finishedAt != nil {
+		t := metav1.NewTime(*finishedAt)
+		pt.Status.FinishedAt = &t
+	}
+	return pt
+}
+
+func testTargetPod(name string, podLabels map[string]string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: podLabels},
+	}
+	for _, c := range containers {
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: c},
+		})
+	}
+	return pod
+}
+
+func TestPowerToolAuthorizer_AuthorizeUpload(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-5 * time.Minute)
+	stale := now.Add(-time.Hour)
+
+	toolContainer := testPowerTool("", nil).ToolContainerName()
+	webLabels := map[string]string{"app": "web"}
+
+	tests := []struct {
+		name      string
+		powerTool *toev1alpha1.PowerTool
+		pod       *corev1.Pod
+		req       UploadRequest
+		wantErr   bool
+		forbidden bool
+	}{
+		{
+			name:      "running target pod",
+			powerTool: testPowerTool("Running", nil),
+			pod:       testTargetPod("web-1", webLabels, toolContainer),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-1"},
+		},
+		{
+			name:      "recently completed",
+			powerTool: testPowerTool("Completed", &recent),
+			pod:       testTargetPod("web-1", webLabels, toolContainer),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-1"},
+		},
+		{
+			name:      "completed too long ago",
+			powerTool: testPowerTool("Completed", &stale),
+			pod:       testTargetPod("web-1", webLabels, toolContainer),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-1"},
+			wantErr:   true,
+			forbidden: true,
+		},
+		{
+			name:      "pending",
+			powerTool: testPowerTool("Pending", nil),
+			pod:       testTargetPod("web-1", webLabels, toolContainer),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-1"},
+			wantErr:   true,
+			forbidden: true,
+		},
+		{
+			name:      "unknown PowerTool",
+			powerTool: testPowerTool("Running", nil),
+			pod:       testTargetPod("web-1", webLabels, toolContainer),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "other", PodName: "web-1"},
+			wantErr:   true,
+			forbidden: true,
+		},
+		{
+			name:      "PowerTool in another namespace",
+			powerTool: testPowerTool("Running", nil),
+			pod:       testTargetPod("web-1", webLabels, toolContainer),
+			req:       UploadRequest{Namespace: "kube-system", PowerToolName: "profile-app", PodName: "web-1"},
+			wantErr:   true,
+			forbidden: true,
+		},
+		{
+			name:      "unknown pod",
+			powerTool: testPowerTool("Running", nil),
+			pod:       testTargetPod("web-1", webLabels, toolContainer),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-2"},
+			wantErr:   true,
+			forbidden: true,
+		},
+		{
+			name:      "pod not selected",
+			powerTool: testPowerTool("Running", nil),
+			pod:       testTargetPod("db-1", map[string]string{"app": "db"}, toolContainer),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "db-1"},
+			wantErr:   true,
+			forbidden: true,
+		},
+		{
+			name:      "pod without the tool container",
+			powerTool: testPowerTool("Running", nil),
+			pod:       testTargetPod("web-1", webLabels, "powertool-profile-app-deadbeef"),
+			req:       UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-1"},
+			wantErr:   true,
+			forbidden: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().
+				WithScheme(newAuthorizerScheme()).
+				WithObjects(tt.powerTool, tt.pod).
+				Build()
+
+			a := NewPowerToolAuthorizer(c, 0)
+			a.now = func() time.Time { return now }
+
+			target, err := a.AuthorizeUpload(context.Background(), tt.req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("AuthorizeUpload() expected error, got nil")
+				}
+				if got := errors.Is(err, ErrUploadForbidden); got != tt.forbidden {
+					t.Errorf("errors.Is(err, ErrUploadForbidden) = %v, want %v (err: %v)", got, tt.forbidden, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("AuthorizeUpload() unexpected error = %v", err)
+			}
+			if target == nil || target.PowerTool.Name != tt.req.PowerToolName || target.Pod.Name != tt.req.PodName {
+				t.Errorf("AuthorizeUpload() target = %+v, want PowerTool %s and pod %s", target, tt.req.PowerToolName, tt.req.PodName)
+			}
+		})
+	}
+}
+
+func TestPowerToolAuthorizer_GraceExpires(t *testing.T) {
+	finished := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := fake.NewClientBuilder().
+		WithScheme(newAuthorizerScheme()).
+		WithObjects(
+			testPowerTool("Completed", &finished),
+			testTargetPod("web-1", map[string]string{"app": "web"}, testPowerTool("", nil).ToolContainerName()),
+		).
+		Build()
+	a := NewPowerToolAuthorizer(c, 0)
+	req := UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-1"}
+
+	// The completion time stays put, so uploads are refused once the
+	// grace has passed however often the PowerTool is reconciled
+	for _, tt := range []struct {
+		after     time.Duration
+		forbidden bool
+	}{
+		{after: time.Minute},
+		{after: DefaultFinishedUploadGrace},
+		{after: DefaultFinishedUploadGrace + time.Second, forbidden: true},
+		{after: 24 * time.Hour, forbidden: true},
+	} {
+		a.now = func() time.Time { return finished.Add(tt.after) }
+		_, err := a.AuthorizeUpload(context.Background(), req)
+		if got := errors.Is(err, ErrUploadForbidden); got != tt.forbidden {
+			t.Errorf("upload %v after completion: error = %v, want forbidden %v", tt.after, err, tt.forbidden)
+		}
+	}
+}
+
+func TestPowerToolAuthorizer_APIError(t *testing.T) {
+	c := fake.NewClientBuilder().
+		WithScheme(newAuthorizerScheme()).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				return errors.New("connection refused")
+			},
+		}).
+		Build()
+
+	_, err := NewPowerToolAuthorizer(c, 0).AuthorizeUpload(context.Background(),
+		UploadRequest{Namespace: "default", PowerToolName: "profile-app", PodName: "web-1"})
+	if err == nil {
+		t.Fatal("AuthorizeUpload() expected error, got nil")
+	}
+	if errors.Is(err, ErrUploadForbidden) {
+		t.Errorf("API failures must not be reported as forbidden: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLinkSigner(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	signer, err := NewLinkSigner(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("NewLinkSigner() error = %v", err)
+	}
+	signer.now = func() time.Time { return now }
+	other, _ := NewLinkSigner(bytes.Repeat([]byte("o"), 32))
+
+	const p = "web/app-web/2026/10/18/job/perf-web-1.data"
+	valid := signer.Sign(p, now.Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		path    string
+		query   url.Values
+		wantErr bool
+	}{
+		{name: "valid", path: p, query: valid},
+		{name: "other path", path: "web/app-web/2026/10/18/job/perf-web-2.data", query: valid, wantErr: true},
+		{name: "expired", path: p, query: signer.Sign(p, now.Add(-time.Second)), wantErr: true},
+		{name: "extended expiry", path: p, query: url.Values{
+			LinkExpiresParam:   {"9999999999"},
+			LinkSignatureParam: {valid.Get(LinkSignatureParam)},
+		}, wantErr: true},
+		{name: "other key", path: p, query: other.Sign(p, now.Add(time.Hour)), wantErr: true},
+		{name: "no signature", path: p, query: url.Values{LinkExpiresParam: {valid.Get(LinkExpiresParam)}}, wantErr: true},
+		{name: "no expiry", path: p, query: url.Values{LinkSignatureParam: {valid.Get(LinkSignatureParam)}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := signer.Verify(tt.path, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrLinkInvalid) {
+				t.Errorf("Verify() error = %v, want ErrLinkInvalid", err)
+			}
+		})
+	}
+}
+
+func TestNewLinkSigner_ShortKey(t *testing.T) {
+	if _, err := NewLinkSigner([]byte("s3cret")); err == nil {
+		t.Error("NewLinkSigner() error = nil, want error for a short key")
+	}
+}
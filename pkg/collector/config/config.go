@@ -0,0 +1,439 @@
+// Package config assembles the collector's configuration from command-line
+// flags, an optional YAML file and environment variables.
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"toe/pkg/collector/auth"
+	"toe/pkg/collector/server"
+	"toe/pkg/collector/storage"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// Defaults applied when a setting is given nowhere
+const (
+	DefaultListenAddress     = ":8443"
+	DefaultStoragePath       = "/data"
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultShutdownTimeout   = 30 * time.Second
+)
+
+// flagConfig names the flag holding the configuration file's path. The file
+// itself cannot set it.
+const flagConfig = "config"
+
+// Options is the collector's complete configuration
+type Options struct {
+	// Server is passed to server.NewServer
+	Server server.Config
+	// Kubeconfig, when set, is used instead of the in-cluster configuration,
+	// so the collector can run outside the cluster
+	Kubeconfig string
+	// ShutdownTimeout bounds how long in-flight requests may run after a
+	// termination signal before their connections are closed
+	ShutdownTimeout time.Duration
+
+	configFile          string
+	signingKeyFile      string
+	clientCertRulesFile string
+}
+
+// Load parses args, then fills every setting they leave out from the
+// configuration file named by --config and then from the environment. The
+// file holds a YAML map of flag names to values; environment variables are
+// those listed in each flag's usage. Secrets such as S3 credentials are read
+// from the environment only, so they never show up in a process listing.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Options, error) {
+	opts := &Options{}
+	fs := flag.NewFlagSet("collector", flag.ContinueOnError)
+	env := opts.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if opts.configFile != "" {
+		values, err := readFile(opts.configFile)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			if name == flagConfig || fs.Lookup(name) == nil {
+				return nil, fmt.Errorf("unknown setting %q in %s", name, opts.configFile)
+			}
+			if set[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %w", name, opts.configFile, err)
+			}
+			set[name] = true
+		}
+	}
+
+	for name, key := range env {
+		if set[name] {
+			continue
+		}
+		if value, ok := lookupEnv(key); ok && value != "" {
+			if err := fs.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+
+	s3 := &opts.Server.S3
+	for key, target := range map[string]*string{
+		"AWS_ACCESS_KEY_ID":     &s3.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": &s3.SecretAccessKey,
+		"AWS_SESSION_TOKEN":     &s3.SessionToken,
+	} {
+		*target, _ = lookupEnv(key)
+	}
+
+	if opts.signingKeyFile != "" {
+		key, err := os.ReadFile(opts.signingKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+		opts.Server.SigningKey = bytes.TrimSpace(key)
+	}
+
+	if opts.clientCertRulesFile != "" {
+		rules, err := readCertificateRules(opts.clientCertRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.Server.ClientCertRules = rules
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// bindFlags registers every setting on fs and returns the environment
+// variable each flag falls back to
+func (o *Options) bindFlags(fs *flag.FlagSet) map[string]string {
+	env := map[string]string{}
+	str := func(target *string, name, key, value, usage string) {
+		fs.StringVar(target, name, value, usage+" ($"+key+")")
+		env[name] = key
+	}
+	duration := func(target *time.Duration, name, key string, value time.Duration, usage string) {
+		fs.DurationVar(target, name, value, usage+" ($"+key+")")
+		env[name] = key
+	}
+	days := func(target *int32, name, key, usage string) {
+		fs.Var((*int32Value)(target), name, usage+" ($"+key+")")
+		env[name] = key
+	}
+	bytesFlag := func(target *int64, name, key, usage string) {
+		fs.Var((*bytesValue)(target), name, usage+" ($"+key+")")
+		env[name] = key
+	}
+
+	cfg := &o.Server
+	fs.StringVar(&o.configFile, flagConfig, "", "YAML file mapping flag names to values, used for flags not given on the command line")
+	str(&o.Kubeconfig, "kubeconfig", "KUBECONFIG", "", "Kubeconfig for running outside the cluster; the in-cluster configuration is used when empty")
+	duration(&o.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", DefaultShutdownTimeout, "How long in-flight requests may finish after a termination signal")
+
+	str(&cfg.Address, "listen-address", "LISTEN_ADDRESS", DefaultListenAddress, "Address to serve on")
+	str(&cfg.TLSCert, "tls-cert", "TLS_CERT_PATH", "", "TLS certificate; plain HTTP is served when it or the key is missing")
+	str(&cfg.TLSKey, "tls-key", "TLS_KEY_PATH", "", "TLS private key")
+	str(&o.signingKeyFile, "signing-key-file", "SIGNING_KEY_FILE", "", "File holding the key download links are signed with; signed links are disabled when empty")
+	str(&cfg.PublicURL, "public-url", "PUBLIC_URL", "", "Base URL signed download links are given out with; links are relative to the collector when empty")
+	duration(&cfg.MaxLinkTTL, "max-link-ttl", "MAX_LINK_TTL", 0, "Longest lifetime of a signed download link; 0 for the default")
+	duration(&cfg.ReadHeaderTimeout, "read-header-timeout", "READ_HEADER_TIMEOUT", DefaultReadHeaderTimeout, "Time allowed to read request headers")
+	duration(&cfg.ReadTimeout, "read-timeout", "READ_TIMEOUT", 0, "Time allowed to read a whole request, body included; 0 for no limit")
+	duration(&cfg.WriteTimeout, "write-timeout", "WRITE_TIMEOUT", 0, "Time allowed to write a response; 0 for no limit")
+	duration(&cfg.IdleTimeout, "idle-timeout", "IDLE_TIMEOUT", DefaultIdleTimeout, "How long idle keep-alive connections are kept open")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", 0, "Largest request header accepted; 0 uses the net/http default ($MAX_HEADER_BYTES)")
+	env["max-header-bytes"] = "MAX_HEADER_BYTES"
+
+	str(&cfg.Audience, "audience", "KUBERNETES_AUDIENCE", server.DefaultAudience, "Audience upload tokens must be issued for")
+	fs.Var((*serviceAccountsValue)(&cfg.ServiceAccounts), "service-accounts",
+		"Comma-separated namespace/name ServiceAccounts whose tokens may upload; any when empty ($COLLECTOR_SERVICE_ACCOUNTS)")
+	env["service-accounts"] = "COLLECTOR_SERVICE_ACCOUNTS"
+	fs.Var((*listValue)(&cfg.Authenticators), "authenticators",
+		"Comma-separated upload authenticators, tried in order: tokenreview and client-cert; tokenreview when empty ($AUTHENTICATORS)")
+	env["authenticators"] = "AUTHENTICATORS"
+	str(&cfg.ClientCAFile, "client-ca", "CLIENT_CA_FILE", "", "PEM bundle of the CAs client certificates must be issued by")
+	str(&o.clientCertRulesFile, "client-cert-rules", "CLIENT_CERT_RULES_FILE", "", "YAML file of the rules mapping client certificates to namespaces and PowerTools")
+	duration(&cfg.TokenCacheTTL, "token-cache-ttl", "TOKEN_CACHE_TTL", 0, "How long an accepted token is trusted before it is reviewed again; 0 for the default")
+	duration(&cfg.TokenNegativeCacheTTL, "token-negative-cache-ttl", "TOKEN_NEGATIVE_CACHE_TTL", 0, "How long a rejected token stays rejected; 0 for the default")
+	fs.IntVar(&cfg.TokenCacheSize, "token-cache-size", 0, "Most tokens remembered; 0 for the default ($TOKEN_CACHE_SIZE)")
+	env["token-cache-size"] = "TOKEN_CACHE_SIZE"
+	fs.Float64Var(&cfg.TokenReviewQPS, "tokenreview-qps", 0, "TokenReviews per second sent on cache misses; 0 for the default ($TOKENREVIEW_QPS)")
+	env["tokenreview-qps"] = "TOKENREVIEW_QPS"
+	fs.IntVar(&cfg.TokenReviewBurst, "tokenreview-burst", 0, "TokenReviews that may be sent at once; 0 for the default ($TOKENREVIEW_BURST)")
+	env["tokenreview-burst"] = "TOKENREVIEW_BURST"
+	duration(&cfg.UploadGracePeriod, "upload-grace-period", "UPLOAD_GRACE_PERIOD", 0, "How long after a PowerTool completes uploads are accepted; 0 for the default")
+	duration(&cfg.UploadSessionTTL, "upload-session-ttl", "UPLOAD_SESSION_TTL", 0, "How long a resumable upload may sit idle; 0 for the default")
+
+	str(&cfg.StoragePath, "storage-path", "STORAGE_PATH", DefaultStoragePath, "Directory for artifacts, staging and upload sessions")
+	str(&cfg.DateFormat, "date-format", "DATE_FORMAT", "", "Go time layout of the date directories, e.g. 2006/01/02 (required)")
+	str(&cfg.PathTemplate, "path-template", "PATH_TEMPLATE", "", "Go template of the directory artifacts are stored in; "+storage.DefaultPathTemplate+" when empty")
+	str(&cfg.StorageBackend, "storage-backend", "STORAGE_BACKEND", "", "Artifact storage: filesystem (default) or s3")
+	str(&cfg.S3.Endpoint, "s3-endpoint", "S3_ENDPOINT", "", "S3 service URL")
+	str(&cfg.S3.Region, "s3-region", "S3_REGION", "", "S3 region")
+	str(&cfg.S3.Bucket, "s3-bucket", "S3_BUCKET", "", "S3 bucket")
+	str(&cfg.S3.Prefix, "s3-prefix", "S3_PREFIX", "", "Prefix of every object key")
+	fs.Int64Var(&cfg.S3.PartSize, "s3-part-size", 0, "Multipart upload part size in bytes; 0 for the default ($S3_PART_SIZE)")
+	env["s3-part-size"] = "S3_PART_SIZE"
+	fs.BoolVar(&cfg.DisableArtifactIndex, "disable-artifact-index", false,
+		"List artifacts from their metadata sidecars instead of the index under the storage path; required when replicas share a bucket ($DISABLE_ARTIFACT_INDEX)")
+	env["disable-artifact-index"] = "DISABLE_ARTIFACT_INDEX"
+
+	str(&cfg.EncryptionKeyringFile, "encryption-keyring", "ENCRYPTION_KEYRING_FILE", "", "YAML keyring of master keys to encrypt artifacts at rest with; artifacts are stored unencrypted when empty")
+
+	bytesFlag(&cfg.MaxArtifactBytes, "max-artifact-size", "MAX_ARTIFACT_SIZE", "Largest upload accepted, e.g. 5Gi; 0 for no limit")
+	bytesFlag(&cfg.NamespaceQuotaBytes, "namespace-quota", "NAMESPACE_QUOTA", "Bytes each namespace may store through this replica, e.g. 50Gi; 0 for no limit")
+	bytesFlag(&cfg.PowerToolQuotaBytes, "powertool-quota", "POWERTOOL_QUOTA", "Bytes each PowerTool may store through this replica, e.g. 10Gi; 0 for no limit")
+
+	days(&cfg.DefaultRetentionDays, "default-retention-days", "DEFAULT_RETENTION_DAYS", "Days artifacts are kept when their PowerTool sets none; 0 keeps them forever")
+	days(&cfg.MaxRetentionDays, "max-retention-days", "MAX_RETENTION_DAYS", "Most days a PowerTool may keep artifacts; 0 for no limit")
+	duration(&cfg.RetentionSweepInterval, "retention-sweep-interval", "RETENTION_SWEEP_INTERVAL", 0, "How often expired artifacts are deleted; 0 for the default")
+
+	return env
+}
+
+// Validate checks the settings the server cannot run without
+func (o *Options) Validate() error {
+	var errs []string
+	if o.Server.DateFormat == "" {
+		errs = append(errs, "date-format is required")
+	} else if o.Server.PathTemplate != "" {
+		if _, err := storage.ParsePathTemplate(o.Server.PathTemplate, o.Server.DateFormat); err != nil {
+			errs = append(errs, fmt.Sprintf("path-template: %v", err))
+		}
+	}
+	if o.Server.Address == "" {
+		errs = append(errs, "listen-address is required")
+	}
+	if o.Server.StoragePath == "" {
+		errs = append(errs, "storage-path is required")
+	}
+	if (o.Server.TLSCert == "") != (o.Server.TLSKey == "") {
+		errs = append(errs, "tls-cert and tls-key must be set together")
+	}
+	for _, name := range o.Server.Authenticators {
+		switch name {
+		case server.AuthenticatorTokenReview:
+		case server.AuthenticatorClientCert:
+			if o.Server.TLSCert == "" {
+				errs = append(errs, "the client-cert authenticator requires tls-cert and tls-key")
+			}
+			if o.Server.ClientCAFile == "" {
+				errs = append(errs, "the client-cert authenticator requires client-ca")
+			}
+			if len(o.Server.ClientCertRules) == 0 {
+				errs = append(errs, "the client-cert authenticator requires client-cert-rules")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("unknown authenticator %q", name))
+		}
+	}
+	if n := len(o.Server.SigningKey); n > 0 && n < auth.MinSigningKeySize {
+		errs = append(errs, fmt.Sprintf("the signing key must be at least %d bytes, got %d", auth.MinSigningKeySize, n))
+	}
+	if o.Server.PublicURL != "" {
+		if u, err := url.Parse(o.Server.PublicURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("public-url must be an http(s) URL, got %q", o.Server.PublicURL))
+		}
+	}
+	if o.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Sprintf("shutdown-timeout must be positive, got %v", o.ShutdownTimeout))
+	}
+	durations := map[string]time.Duration{
+		"token-cache-ttl":          o.Server.TokenCacheTTL,
+		"token-negative-cache-ttl": o.Server.TokenNegativeCacheTTL,
+		"read-header-timeout":      o.Server.ReadHeaderTimeout,
+		"read-timeout":             o.Server.ReadTimeout,
+		"write-timeout":            o.Server.WriteTimeout,
+		"idle-timeout":             o.Server.IdleTimeout,
+		"upload-grace-period":      o.Server.UploadGracePeriod,
+		"upload-session-ttl":       o.Server.UploadSessionTTL,
+		"retention-sweep-interval": o.Server.RetentionSweepInterval,
+		"max-link-ttl":             o.Server.MaxLinkTTL,
+	}
+	for _, name := range []string{"token-cache-ttl", "token-negative-cache-ttl", "read-header-timeout", "read-timeout",
+		"write-timeout", "idle-timeout", "upload-grace-period", "upload-session-ttl", "retention-sweep-interval", "max-link-ttl"} {
+		if durations[name] < 0 {
+			errs = append(errs, fmt.Sprintf("%s must not be negative, got %v", name, durations[name]))
+		}
+	}
+	counts := map[string]float64{
+		"token-cache-size":  float64(o.Server.TokenCacheSize),
+		"tokenreview-qps":   o.Server.TokenReviewQPS,
+		"tokenreview-burst": float64(o.Server.TokenReviewBurst),
+	}
+	for _, name := range []string{"token-cache-size", "tokenreview-qps", "tokenreview-burst"} {
+		if counts[name] < 0 {
+			errs = append(errs, fmt.Sprintf("%s must not be negative, got %v", name, counts[name]))
+		}
+	}
+	if o.Server.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Sprintf("max-header-bytes must not be negative, got %d", o.Server.MaxHeaderBytes))
+	}
+	if o.Server.S3.PartSize < 0 {
+		errs = append(errs, fmt.Sprintf("s3-part-size must not be negative, got %d", o.Server.S3.PartSize))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid collector configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// readFile reads a YAML map of flag names to values. Lists are joined with
+// commas, as their flags expect.
+func readFile(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collector config file: %w", err)
+	}
+	data := map[string]any{}
+	if err := yaml.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse collector config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(data))
+	for name, v := range data {
+		switch v := v.(type) {
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("invalid %s in %s: nested settings are not supported", name, path)
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// readCertificateRules reads a YAML list of client certificate rules
+func readCertificateRules(path string) ([]auth.CertificateRule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate rules: %w", err)
+	}
+	var rules []auth.CertificateRule
+	if err := yaml.UnmarshalStrict(raw, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate rules %s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid client certificate rule %d in %s: %w", i, path, err)
+		}
+	}
+	return rules, nil
+}
+
+// int32Value is a flag holding a non-negative day count
+type int32Value int32
+
+func (v *int32Value) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *int32Value) Set(s string) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("must not be negative, got %d", n)
+	}
+	*v = int32Value(n)
+	return nil
+}
+
+// bytesValue is a flag holding a byte count, written as a plain number or a
+// Kubernetes quantity such as 10Gi
+type bytesValue int64
+
+func (v *bytesValue) String() string {
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+func (v *bytesValue) Set(s string) error {
+	q, err := resource.ParseQuantity(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	n, ok := q.AsInt64()
+	if !ok || n < 0 {
+		return fmt.Errorf("must be a non-negative number of bytes, got %s", s)
+	}
+	*v = bytesValue(n)
+	return nil
+}
+
+// listValue is a flag holding comma-separated values
+type listValue []string
+
+func (v *listValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *listValue) Set(s string) error {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*v = items
+	return nil
+}
+
+// serviceAccountsValue is a flag holding comma-separated namespace/name pairs
+type serviceAccountsValue []types.NamespacedName
+
+func (v *serviceAccountsValue) String() string {
+	names := make([]string, len(*v))
+	for i, sa := range *v {
+		names[i] = sa.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (v *serviceAccountsValue) Set(s string) error {
+	var accounts []types.NamespacedName
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		namespace, name, ok := strings.Cut(item, "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("service account %q must be namespace/name", item)
+		}
+		accounts = append(accounts, types.NamespacedName{Namespace: namespace, Name: name})
+	}
+	*v = accounts
+	return nil
+}
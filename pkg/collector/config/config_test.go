@@ -0,0 +1,303 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"toe/pkg/collector/server"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func envFrom(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "collector.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	opts, err := Load(nil, envFrom(map[string]string{"DATE_FORMAT": "2006/01/02"}))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if opts.Server.Address != DefaultListenAddress {
+		t.Errorf("Address = %q, want %q", opts.Server.Address, DefaultListenAddress)
+	}
+	if opts.Server.StoragePath != DefaultStoragePath {
+		t.Errorf("StoragePath = %q, want %q", opts.Server.StoragePath, DefaultStoragePath)
+	}
+	if opts.Server.Audience != server.DefaultAudience {
+		t.Errorf("Audience = %q, want %q", opts.Server.Audience, server.DefaultAudience)
+	}
+	if opts.Server.ReadHeaderTimeout != DefaultReadHeaderTimeout {
+		t.Errorf("ReadHeaderTimeout = %v, want %v", opts.Server.ReadHeaderTimeout, DefaultReadHeaderTimeout)
+	}
+	if opts.ShutdownTimeout != DefaultShutdownTimeout {
+		t.Errorf("ShutdownTimeout = %v, want %v", opts.ShutdownTimeout, DefaultShutdownTimeout)
+	}
+	if opts.Kubeconfig != "" {
+		t.Errorf("Kubeconfig = %q, want in-cluster", opts.Kubeconfig)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, `
+date-format: "2006-01-02"
+listen-address: ":9000"
+storage-path: /file
+shutdown-timeout: 1m
+default-retention-days: 7
+service-accounts:
+- toe-system/toe-collector
+- other/uploader
+`)
+	env := envFrom(map[string]string{
+		"DATE_FORMAT":            "2006/01/02",
+		"STORAGE_PATH":           "/env",
+		"KUBERNETES_AUDIENCE":    "env-audience",
+		"AWS_ACCESS_KEY_ID":      "AKID",
+		"DISABLE_ARTIFACT_INDEX": "true",
+	})
+	opts, err := Load([]string{"--config", file, "--storage-path", "/flag"}, env)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Flags beat the file, the file beats the environment, and the
+	// environment beats the defaults
+	if opts.Server.StoragePath != "/flag" {
+		t.Errorf("StoragePath = %q, want the flag's /flag", opts.Server.StoragePath)
+	}
+	if opts.Server.DateFormat != "2006-01-02" {
+		t.Errorf("DateFormat = %q, want the file's 2006-01-02", opts.Server.DateFormat)
+	}
+	if opts.Server.Address != ":9000" {
+		t.Errorf("Address = %q, want the file's :9000", opts.Server.Address)
+	}
+	if opts.Server.Audience != "env-audience" {
+		t.Errorf("Audience = %q, want the environment's env-audience", opts.Server.Audience)
+	}
+	if opts.ShutdownTimeout != time.Minute {
+		t.Errorf("ShutdownTimeout = %v, want 1m", opts.ShutdownTimeout)
+	}
+	if opts.Server.DefaultRetentionDays != 7 {
+		t.Errorf("DefaultRetentionDays = %d, want 7", opts.Server.DefaultRetentionDays)
+	}
+	want := []types.NamespacedName{{Namespace: "toe-system", Name: "toe-collector"}, {Namespace: "other", Name: "uploader"}}
+	if len(opts.Server.ServiceAccounts) != len(want) {
+		t.Fatalf("ServiceAccounts = %v, want %v", opts.Server.ServiceAccounts, want)
+	}
+	for i := range want {
+		if opts.Server.ServiceAccounts[i] != want[i] {
+			t.Errorf("ServiceAccounts[%d] = %v, want %v", i, opts.Server.ServiceAccounts[i], want[i])
+		}
+	}
+	if opts.Server.S3.AccessKeyID != "AKID" {
+		t.Errorf("S3.AccessKeyID = %q, want it from the environment", opts.Server.S3.AccessKeyID)
+	}
+	if !opts.Server.DisableArtifactIndex {
+		t.Error("DisableArtifactIndex = false, want it from the environment")
+	}
+}
+
+func TestLoad_ByteSizes(t *testing.T) {
+	opts, err := Load([]string{
+		"--date-format", "2006/01/02",
+		"--max-artifact-size", "5Gi",
+		"--namespace-quota", "1000000",
+		"--powertool-quota", "1G",
+	}, envFrom(nil))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if opts.Server.MaxArtifactBytes != 5<<30 {
+		t.Errorf("MaxArtifactBytes = %d, want %d", opts.Server.MaxArtifactBytes, int64(5<<30))
+	}
+	if opts.Server.NamespaceQuotaBytes != 1000000 {
+		t.Errorf("NamespaceQuotaBytes = %d, want 1000000", opts.Server.NamespaceQuotaBytes)
+	}
+	if opts.Server.PowerToolQuotaBytes != 1000000000 {
+		t.Errorf("PowerToolQuotaBytes = %d, want 1000000000", opts.Server.PowerToolQuotaBytes)
+	}
+}
+
+func TestLoad_SigningKeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "signing.key")
+	if err := os.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	opts, err := Load([]string{"--date-format", "2006/01/02", "--signing-key-file", keyFile}, envFrom(nil))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if string(opts.Server.SigningKey) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("SigningKey = %q, want the trimmed file content", opts.Server.SigningKey)
+	}
+}
+
+func TestLoad_ShortSigningKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "signing.key")
+	if err := os.WriteFile(keyFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	_, err := Load([]string{"--date-format", "2006/01/02", "--signing-key-file", keyFile}, envFrom(nil))
+	if err == nil || !strings.Contains(err.Error(), "signing key must be at least 32 bytes") {
+		t.Errorf("Load() error = %v, want a short signing key error", err)
+	}
+}
+
+func TestLoad_ClientCertificates(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(rulesFile, []byte(`
+- commonName: "ci-*"
+  namespaces: [ci]
+- dnsName: "*.uploader.example.com"
+  namespaces: ["team-*"]
+  powerTools: [nightly]
+`), 0o600); err != nil {
+		t.Fatalf("failed to write rules: %v", err)
+	}
+
+	opts, err := Load([]string{
+		"--date-format", "2006/01/02",
+		"--tls-cert", "/certs/tls.crt", "--tls-key", "/certs/tls.key",
+		"--client-ca", "/certs/ca.crt",
+		"--client-cert-rules", rulesFile,
+	}, envFrom(map[string]string{"AUTHENTICATORS": "client-cert, tokenreview"}))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := strings.Join(opts.Server.Authenticators, ","); got != "client-cert,tokenreview" {
+		t.Errorf("Authenticators = %q, want client-cert,tokenreview", got)
+	}
+	if len(opts.Server.ClientCertRules) != 2 {
+		t.Fatalf("ClientCertRules = %v, want 2 rules", opts.Server.ClientCertRules)
+	}
+	if rule := opts.Server.ClientCertRules[1]; rule.DNSName != "*.uploader.example.com" || rule.PowerTools[0] != "nightly" {
+		t.Errorf("ClientCertRules[1] = %+v", rule)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		wantErr string
+	}{
+		{
+			name:    "missing date format",
+			wantErr: "date-format is required",
+		},
+		{
+			name:    "path template without the PowerTool",
+			args:    []string{"--date-format", "2006", "--path-template", "{{.Namespace}}/{{.Date}}"},
+			wantErr: "path-template: invalid path template",
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"--date-format", "2006", "--port", "1"},
+			wantErr: "flag provided but not defined",
+		},
+		{
+			name:    "unknown file setting",
+			file:    "date-format: \"2006\"\nport: 8443\n",
+			wantErr: `unknown setting "port"`,
+		},
+		{
+			name:    "file cannot name another file",
+			file:    "config: other.yaml\n",
+			wantErr: `unknown setting "config"`,
+		},
+		{
+			name:    "invalid environment value",
+			env:     map[string]string{"DATE_FORMAT": "2006", "SHUTDOWN_TIMEOUT": "soon"},
+			wantErr: "invalid SHUTDOWN_TIMEOUT",
+		},
+		{
+			name:    "negative retention",
+			args:    []string{"--date-format", "2006", "--max-retention-days", "-1"},
+			wantErr: "must not be negative",
+		},
+		{
+			name:    "invalid byte size",
+			args:    []string{"--date-format", "2006", "--namespace-quota", "lots"},
+			wantErr: "invalid value",
+		},
+		{
+			name:    "negative byte size",
+			env:     map[string]string{"DATE_FORMAT": "2006", "MAX_ARTIFACT_SIZE": "-1Gi"},
+			wantErr: "invalid MAX_ARTIFACT_SIZE",
+		},
+		{
+			name:    "malformed service account",
+			args:    []string{"--date-format", "2006", "--service-accounts", "toe-collector"},
+			wantErr: "must be namespace/name",
+		},
+		{
+			name:    "negative TokenReview rate",
+			args:    []string{"--date-format", "2006", "--tokenreview-qps", "-5"},
+			wantErr: "tokenreview-qps must not be negative",
+		},
+		{
+			name:    "certificate without key",
+			args:    []string{"--date-format", "2006", "--tls-cert", "/certs/tls.crt"},
+			wantErr: "tls-cert and tls-key must be set together",
+		},
+		{
+			name:    "unknown authenticator",
+			args:    []string{"--date-format", "2006", "--authenticators", "tokenreview,oidc"},
+			wantErr: `unknown authenticator "oidc"`,
+		},
+		{
+			name:    "client certificates without a CA",
+			args:    []string{"--date-format", "2006", "--authenticators", "client-cert"},
+			wantErr: "the client-cert authenticator requires client-ca",
+		},
+		{
+			name:    "client certificates without TLS",
+			args:    []string{"--date-format", "2006", "--authenticators", "client-cert", "--client-ca", "/certs/ca.crt"},
+			wantErr: "the client-cert authenticator requires tls-cert and tls-key",
+		},
+		{
+			name:    "relative public URL",
+			args:    []string{"--date-format", "2006", "--public-url", "profiles.example.com"},
+			wantErr: "public-url must be an http(s) URL",
+		},
+		{
+			name:    "zero shutdown timeout",
+			args:    []string{"--date-format", "2006", "--shutdown-timeout", "0s"},
+			wantErr: "shutdown-timeout must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"--config", writeFile(t, tt.file)}, args...)
+			}
+			_, err := Load(args, envFrom(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"errors"
+	"log"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterDiskFree exposes the space left on the filesystem holding path.
+// The collector stages every upload locally, so this matters whatever the
+// storage backend.
+func RegisterDiskFree(path string) error {
+	err := Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "toe_collector_storage_free_bytes",
+		Help:        "Free space on the collector's storage volume, available to unprivileged users.",
+		ConstLabels: prometheus.Labels{"path": path},
+	}, func() float64 {
+		free, err := DiskFree(path)
+		if err != nil {
+			log.Printf("Failed to read free space of %s: %v", path, err)
+			return 0
+		}
+		return float64(free)
+	}))
+	if errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+		return nil
+	}
+	return err
+}
+
+// DiskFree returns the bytes available to unprivileged users on the
+// filesystem holding path
+func DiskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
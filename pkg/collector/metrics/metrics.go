@@ -0,0 +1,137 @@
+// Package metrics holds the collector's Prometheus metrics. They are
+// registered on Registry rather than the global default registry so the
+// collector exposes only its own series.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Registry holds every collector metric
+var Registry = prometheus.NewRegistry()
+
+// Upload outcomes recorded in UploadsTotal
+const (
+	OutcomeStored         = "stored"
+	OutcomeDeduplicated   = "deduplicated"
+	OutcomeInvalid        = "invalid"
+	OutcomeDigestMismatch = "digest_mismatch"
+	OutcomeConflict       = "conflict"
+	OutcomeForbidden      = "forbidden"
+	OutcomeTooLarge       = "too_large"
+	OutcomeQuotaExceeded  = "quota_exceeded"
+	OutcomeError          = "error"
+)
+
+// Authentication failure reasons recorded in AuthFailures
+const (
+	AuthMissingToken = "missing_token"
+	AuthInvalidToken = "invalid_token"
+	AuthForbidden    = "forbidden"
+	AuthError        = "error"
+)
+
+// Token cache lookup results recorded in TokenCacheLookups
+const (
+	TokenCacheHit         = "hit"
+	TokenCacheNegativeHit = "negative_hit"
+	TokenCacheMiss        = "miss"
+)
+
+var (
+	// BytesReceived counts upload body bytes read, including chunks of
+	// uploads that never complete
+	BytesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_received_bytes_total",
+		Help: "Upload bytes received, by namespace.",
+	}, []string{"namespace"})
+
+	// UploadsTotal counts finished uploads by namespace and outcome.
+	// Requests rejected before their metadata was validated are counted in
+	// AuthFailures or not at all, so namespace is always a real one.
+	UploadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_uploads_total",
+		Help: "Uploads by namespace and outcome.",
+	}, []string{"namespace", "outcome"})
+
+	// AuthFailures counts rejected credentials and permission checks, by
+	// endpoint (upload or read) and reason
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_auth_failures_total",
+		Help: "Authentication and authorization failures, by endpoint and reason.",
+	}, []string{"endpoint", "reason"})
+
+	// TokenCacheLookups counts bearer token lookups in the TokenReview cache,
+	// by endpoint and result. Misses lead to a TokenReview.
+	TokenCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_token_cache_lookups_total",
+		Help: "Token cache lookups, by endpoint and result.",
+	}, []string{"endpoint", "result"})
+
+	// TokenReviewsThrottled counts reviews given up while waiting for the
+	// TokenReview rate limit
+	TokenReviewsThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_tokenreviews_throttled_total",
+		Help: "TokenReviews abandoned while waiting for the client-side rate limit, by endpoint.",
+	}, []string{"endpoint"})
+
+	// StorageLatency times storage backend operations
+	StorageLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "toe_collector_storage_operation_duration_seconds",
+		Help:    "Latency of storage backend operations, by operation and result.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+	}, []string{"operation", "result"})
+
+	// ArtifactsDeleted counts artifacts removed from storage, by namespace
+	// and reason
+	ArtifactsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_artifacts_deleted_total",
+		Help: "Artifacts deleted from storage, by namespace and reason.",
+	}, []string{"namespace", "reason"})
+
+	// ArtifactBytesDeleted counts the bytes freed by deleting artifacts
+	ArtifactBytesDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_artifact_bytes_deleted_total",
+		Help: "Bytes freed by deleting artifacts, by namespace and reason.",
+	}, []string{"namespace", "reason"})
+
+	// StoredBytes is the bytes each namespace has stored, as counted against
+	// its quota. It is only tracked when quotas are enabled.
+	StoredBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "toe_collector_stored_bytes",
+		Help: "Bytes stored, by namespace, as counted against storage quotas.",
+	}, []string{"namespace"})
+
+	// TLSCertificateExpiry is when the serving certificate currently loaded
+	// expires, so alerts can catch a renewal that never reached the collector
+	TLSCertificateExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "toe_collector_tls_certificate_expiry_timestamp_seconds",
+		Help: "Expiry of the serving TLS certificate currently loaded, in seconds since the epoch.",
+	})
+
+	// StatusUpdateFailures counts stored artifacts that could not be
+	// recorded in their PowerTool's status
+	StatusUpdateFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "toe_collector_status_update_failures_total",
+		Help: "Stored artifacts that could not be recorded in PowerTool status, by namespace.",
+	}, []string{"namespace"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		BytesReceived,
+		UploadsTotal,
+		AuthFailures,
+		TokenCacheLookups,
+		TokenReviewsThrottled,
+		StorageLatency,
+		ArtifactsDeleted,
+		ArtifactBytesDeleted,
+		StoredBytes,
+		TLSCertificateExpiry,
+		StatusUpdateFailures,
+	)
+}
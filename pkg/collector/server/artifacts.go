@@ -0,0 +1,331 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"toe/pkg/collector/auth"
+	"toe/pkg/collector/metrics"
+	"toe/pkg/collector/storage"
+
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// artifactsPath is where the download URL of an artifact starts
+const artifactsPath = "/api/v1/artifacts/"
+
+// artifactListResponse is returned by the listing endpoint
+type artifactListResponse struct {
+	Artifacts []artifactEntry `json:"artifacts"`
+	// LinksExpireAt is when the signed URLs of the artifacts expire, if
+	// they were asked for
+	LinksExpireAt *time.Time `json:"linksExpireAt,omitempty"`
+	// Groups assembles the segments listed in Artifacts into their rolling
+	// captures
+	Groups []segmentGroupEntry `json:"groups,omitempty"`
+}
+
+type artifactEntry struct {
+	storage.Artifact
+	// URL downloads the artifact from this collector
+	URL string `json:"url"`
+	// SignedURL downloads the artifact without credentials until
+	// LinksExpireAt. Only set when the listing asked for links.
+	SignedURL string `json:"signedUrl,omitempty"`
+}
+
+type segmentGroupEntry struct {
+	Path     string `json:"path"`
+	Complete bool   `json:"complete"`
+	Missing  []int  `json:"missing,omitempty"`
+	Size     int64  `json:"size"`
+	// Segments are the download URLs of the segments, in index order
+	Segments []string `json:"segments"`
+}
+
+// handleListArtifacts lists the artifacts of a namespace, optionally narrowed
+// by PowerTool, label, creation time, the pod, node, container and tool they
+// came from, their digest, and a selector on the labels of their pod. With
+// expiresIn, each artifact also gets a signed link valid for that long.
+func (s *Server) handleListArtifacts(w http.ResponseWriter, r *http.Request) {
+	userInfo, ok := s.authenticateReader(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.ArtifactFilter{
+		Namespace:     query.Get("namespace"),
+		PowerToolName: query.Get("powertool"),
+		AppLabel:      query.Get("label"),
+		PodName:       query.Get("pod"),
+		NodeName:      query.Get("node"),
+		ContainerName: query.Get("container"),
+		Tool:          query.Get("tool"),
+		SHA256:        query.Get("sha256"),
+	}
+	if filter.Namespace == "" {
+		http.Error(w, "Missing namespace parameter", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if v := query.Get("selector"); v != "" {
+		if filter.Selector, err = labels.Parse(v); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid selector parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if filter.Since, err = parseTimeParam(query.Get("since"), false); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid since parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filter.Until, err = parseTimeParam(query.Get("until"), true); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid until parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var linkTTL time.Duration
+	if v := query.Get("expiresIn"); v != "" {
+		if s.links == nil {
+			http.Error(w, "Signed links are not enabled: no signing key is configured", http.StatusNotImplemented)
+			return
+		}
+		if linkTTL, err = s.linkTTL(v); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid expiresIn parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !s.authorizeRead(w, r, userInfo, auth.ReadRequest{Namespace: filter.Namespace, PowerToolName: filter.PowerToolName}) {
+		return
+	}
+
+	artifacts, err := s.storage.ListArtifacts(r.Context(), filter)
+	if err != nil {
+		writeReadError(w, err)
+		return
+	}
+
+	resp := artifactListResponse{Artifacts: make([]artifactEntry, 0, len(artifacts))}
+	var expires time.Time
+	if linkTTL > 0 {
+		expires = time.Now().Add(linkTTL).Truncate(time.Second)
+		resp.LinksExpireAt = &expires
+	}
+	for _, a := range artifacts {
+		entry := artifactEntry{Artifact: a, URL: artifactsPath + a.Path}
+		if linkTTL > 0 {
+			entry.SignedURL = s.signedURL(a.Path, expires)
+		}
+		resp.Artifacts = append(resp.Artifacts, entry)
+	}
+	_, groups := storage.GroupSegments(artifacts)
+	for _, g := range groups {
+		entry := segmentGroupEntry{Path: g.Path, Complete: g.Complete, Missing: g.Missing, Size: g.Size}
+		for _, segment := range g.Segments {
+			entry.Segments = append(entry.Segments, artifactsPath+segment.Path)
+		}
+		resp.Groups = append(resp.Groups, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to write artifact list: %v", err)
+	}
+}
+
+// handleGetArtifact downloads an artifact. Range, If-Range and HEAD requests
+// are handled by http.ServeContent. Compressed artifacts are sent with
+// Content-Encoding to clients that accept it, as stored with ?raw=true, and
+// decompressed on the fly otherwise. A signed link stands in for the token
+// and the RBAC check.
+func (s *Server) handleGetArtifact(w http.ResponseWriter, r *http.Request) {
+	signed := r.URL.Query().Has(auth.LinkSignatureParam)
+	var userInfo *authv1.UserInfo
+	if !signed {
+		var ok bool
+		if userInfo, ok = s.authenticateReader(w, r); !ok {
+			return
+		}
+	}
+
+	key := r.PathValue("key")
+	if err := s.storage.PathTemplate().ValidateKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Authorize before looking the artifact up, so existence does not leak
+	namespace, _, powerTool, _ := s.storage.PathTemplate().SplitKey(key)
+	if signed {
+		if !s.verifyLink(w, r, key) {
+			return
+		}
+	} else {
+		if !s.authorizeRead(w, r, userInfo, auth.ReadRequest{Namespace: namespace, PowerToolName: powerTool}) {
+			return
+		}
+	}
+
+	artifact, content, err := s.storage.OpenArtifact(r.Context(), key)
+	if err != nil {
+		writeReadError(w, err)
+		return
+	}
+	defer func() {
+		_ = content.Close()
+	}()
+	if !ownedBy(artifact, namespace, powerTool) {
+		writeReadError(w, fs.ErrNotExist)
+		return
+	}
+
+	name := path.Base(key)
+	contentType := "application/octet-stream"
+	if artifact.Encoding != "" {
+		w.Header().Set("Vary", "Accept-Encoding")
+		switch {
+		case r.URL.Query().Get("raw") == "true":
+			contentType = "application/" + artifact.Encoding
+		case acceptsEncoding(r, artifact.Encoding):
+			// The client decodes, so it should save the decoded name
+			name = strings.TrimSuffix(name, path.Ext(name))
+			w.Header().Set("Content-Encoding", artifact.Encoding)
+		default:
+			serveDecompressed(w, r, artifact, content, strings.TrimSuffix(name, path.Ext(name)))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("ETag", `"`+artifact.SHA256+`"`)
+	w.Header().Set(headerSHA256, artifact.SHA256)
+	http.ServeContent(w, r, "", artifact.CreatedAt, content)
+}
+
+// serveDecompressed streams a compressed artifact decoded. The decoded bytes
+// are not addressable, so range requests get the whole artifact.
+func serveDecompressed(w http.ResponseWriter, r *http.Request, artifact *storage.Artifact, content io.Reader, name string) {
+	decoded, err := storage.NewDecoder(content, artifact.Encoding)
+	if err != nil {
+		log.Printf("Failed to decompress artifact %s: %v", artifact.Path, err)
+		http.Error(w, "Failed to decompress artifact", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = decoded.Close()
+	}()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if artifact.UncompressedSize > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(artifact.UncompressedSize, 10))
+	}
+	if artifact.UncompressedSHA256 != "" {
+		w.Header().Set(headerSHA256, artifact.UncompressedSHA256)
+	}
+	w.Header().Set("Last-Modified", artifact.CreatedAt.UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, decoded); err != nil {
+		// Headers are gone, the client sees a truncated body
+		log.Printf("Failed to send decompressed artifact %s: %v", artifact.Path, err)
+	}
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows the
+// given encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), encoding) && strings.TrimSpace(coding) != "*" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateReader validates the bearer token of a read request
+func (s *Server) authenticateReader(w http.ResponseWriter, r *http.Request) (*authv1.UserInfo, bool) {
+	return authenticateWith(s.readAuth, endpointRead, w, r)
+}
+
+// authorizeRead checks the caller's own RBAC permissions on the PowerTools
+// whose artifacts it reads
+func (s *Server) authorizeRead(w http.ResponseWriter, r *http.Request, userInfo *authv1.UserInfo, req auth.ReadRequest) bool {
+	err := s.readAuthorizer.AuthorizeRead(r.Context(), userInfo, req)
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, auth.ErrReadForbidden) {
+		log.Printf("Rejected artifact read: %v", err)
+		metrics.AuthFailures.WithLabelValues(endpointRead, metrics.AuthForbidden).Inc()
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	metrics.AuthFailures.WithLabelValues(endpointRead, metrics.AuthError).Inc()
+	log.Printf("Failed to authorize artifact read by %s: %v", userInfo.Username, err)
+	http.Error(w, "Failed to authorize request", http.StatusInternalServerError)
+	return false
+}
+
+// ownedBy reports whether artifact belongs to the namespace and PowerTool
+// its key was authorized for. Under an older path template, the elements
+// read as such may name someone else.
+func ownedBy(artifact *storage.Artifact, namespace, powerTool string) bool {
+	return artifact.Namespace == namespace && artifact.PowerToolName == powerTool
+}
+
+func writeReadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+	case errors.Is(err, storage.ErrInvalidMetadata):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Failed to read artifacts: %v", err)
+		http.Error(w, "Failed to read artifacts", http.StatusInternalServerError)
+	}
+}
+
+// parseTimeParam accepts RFC 3339 timestamps and plain dates. A plain date
+// used as an upper bound covers the whole day.
+func parseTimeParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
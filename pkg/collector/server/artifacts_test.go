@@ -0,0 +1,417 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"toe/pkg/collector/auth"
+	"toe/pkg/collector/storage"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+type mockReadAuthorizer struct {
+	authorizeReadFunc func(context.Context, *authv1.UserInfo, auth.ReadRequest) error
+}
+
+func (m *mockReadAuthorizer) AuthorizeRead(ctx context.Context, user *authv1.UserInfo, req auth.ReadRequest) error {
+	if m.authorizeReadFunc != nil {
+		return m.authorizeReadFunc(ctx, user, req)
+	}
+	return nil
+}
+
+func newArtifactTestServer(t *testing.T, store StorageManager, authorizer ReadAuthorizer) http.Handler {
+	t.Helper()
+	srv := &Server{
+		storage:        store,
+		auth:           &mockAuth{},
+		authorizer:     &mockAuthorizer{},
+		readAuth:       &mockAuth{},
+		readAuthorizer: authorizer,
+	}
+	return srv.routes()
+}
+
+func readRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer user-token")
+	return req
+}
+
+func TestListArtifacts(t *testing.T) {
+	var gotFilter storage.ArtifactFilter
+	var gotRead auth.ReadRequest
+	store := &mockStorage{
+		listArtifactsFunc: func(filter storage.ArtifactFilter) ([]storage.Artifact, error) {
+			gotFilter = filter
+			return []storage.Artifact{{
+				ProfileMetadata: storage.ProfileMetadata{Namespace: "web", PowerToolName: "job", PodName: "web-1", Filename: "perf.data"},
+				Path:            "web/app-web/job/2025-01-02/web-1_perf.data",
+				Size:            42,
+				SHA256:          "abc",
+			}}, nil
+		},
+	}
+	handler := newArtifactTestServer(t, store, &mockReadAuthorizer{
+		authorizeReadFunc: func(ctx context.Context, user *authv1.UserInfo, req auth.ReadRequest) error {
+			gotRead = req
+			return nil
+		},
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts?namespace=web&powertool=job&label=app-web&since=2025-01-01&until=2025-01-02"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	wantSince := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	wantUntil := time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+	if gotFilter.Namespace != "web" || gotFilter.PowerToolName != "job" || gotFilter.AppLabel != "app-web" ||
+		!gotFilter.Since.Equal(wantSince) || !gotFilter.Until.Equal(wantUntil) {
+		t.Errorf("filter = %+v", gotFilter)
+	}
+	if gotRead.Namespace != "web" || gotRead.PowerToolName != "job" {
+		t.Errorf("authorized read = %+v", gotRead)
+	}
+
+	var resp struct {
+		Artifacts []map[string]any `json:"artifacts"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(resp.Artifacts) != 1 {
+		t.Fatalf("expected one artifact, got %v", resp.Artifacts)
+	}
+	a := resp.Artifacts[0]
+	if a["url"] != "/api/v1/artifacts/web/app-web/job/2025-01-02/web-1_perf.data" ||
+		a["podName"] != "web-1" || a["sha256"] != "abc" || a["size"] != float64(42) {
+		t.Errorf("artifact entry = %v", a)
+	}
+}
+
+func TestListArtifacts_Search(t *testing.T) {
+	var gotFilter storage.ArtifactFilter
+	store := &mockStorage{
+		listArtifactsFunc: func(filter storage.ArtifactFilter) ([]storage.Artifact, error) {
+			gotFilter = filter
+			return nil, nil
+		},
+	}
+	handler := newArtifactTestServer(t, store, &mockReadAuthorizer{})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, readRequest("GET",
+		"/api/v1/artifacts?namespace=web&pod=web-1&node=node-1&container=nginx&tool=aperf&sha256=abc&selector=app%3Dweb,tier!%3Ddb"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if gotFilter.PodName != "web-1" || gotFilter.NodeName != "node-1" || gotFilter.ContainerName != "nginx" ||
+		gotFilter.Tool != "aperf" || gotFilter.SHA256 != "abc" {
+		t.Errorf("filter = %+v", gotFilter)
+	}
+	if gotFilter.Selector == nil || gotFilter.Selector.String() != "app=web,tier!=db" {
+		t.Errorf("selector = %v, want app=web,tier!=db", gotFilter.Selector)
+	}
+}
+
+func TestListArtifacts_SegmentGroups(t *testing.T) {
+	segment := func(index int, final bool) storage.Artifact {
+		return storage.Artifact{
+			ProfileMetadata: storage.ProfileMetadata{Namespace: "web", Segment: &storage.Segment{Index: index, Final: final}},
+			Path:            fmt.Sprintf("web/app-web/job/2025-01-02/web-1_capture.pcap.segments/%06d.pcap", index),
+			Size:            10,
+		}
+	}
+	store := &mockStorage{
+		listArtifactsFunc: func(filter storage.ArtifactFilter) ([]storage.Artifact, error) {
+			return []storage.Artifact{segment(1, true), segment(0, false)}, nil
+		},
+	}
+	handler := newArtifactTestServer(t, store, &mockReadAuthorizer{})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts?namespace=web"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp artifactListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(resp.Artifacts) != 2 || len(resp.Groups) != 1 {
+		t.Fatalf("got %d artifacts and %d groups, want 2 and 1", len(resp.Artifacts), len(resp.Groups))
+	}
+	group := resp.Groups[0]
+	want := []string{
+		"/api/v1/artifacts/web/app-web/job/2025-01-02/web-1_capture.pcap.segments/000000.pcap",
+		"/api/v1/artifacts/web/app-web/job/2025-01-02/web-1_capture.pcap.segments/000001.pcap",
+	}
+	if group.Path != "web/app-web/job/2025-01-02/web-1_capture.pcap" || !group.Complete || group.Size != 20 ||
+		len(group.Segments) != 2 || group.Segments[0] != want[0] || group.Segments[1] != want[1] {
+		t.Errorf("group = %+v", group)
+	}
+}
+
+func TestListArtifacts_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		token      bool
+		forbidden  bool
+		wantStatus int
+	}{
+		{name: "no token", target: "/api/v1/artifacts?namespace=web", wantStatus: http.StatusUnauthorized},
+		{name: "no namespace", target: "/api/v1/artifacts", token: true, wantStatus: http.StatusBadRequest},
+		{name: "bad date", target: "/api/v1/artifacts?namespace=web&since=yesterday", token: true, wantStatus: http.StatusBadRequest},
+		{name: "bad selector", target: "/api/v1/artifacts?namespace=web&selector=app%20in%20web", token: true, wantStatus: http.StatusBadRequest},
+		{name: "forbidden", target: "/api/v1/artifacts?namespace=web", token: true, forbidden: true, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listed := false
+			store := &mockStorage{
+				listArtifactsFunc: func(storage.ArtifactFilter) ([]storage.Artifact, error) {
+					listed = true
+					return nil, nil
+				},
+			}
+			handler := newArtifactTestServer(t, store, &mockReadAuthorizer{
+				authorizeReadFunc: func(ctx context.Context, user *authv1.UserInfo, req auth.ReadRequest) error {
+					if tt.forbidden {
+						return auth.ErrReadForbidden
+					}
+					return nil
+				},
+			})
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.token {
+				req.Header.Set("Authorization", "Bearer user-token")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if listed {
+				t.Error("storage must not be listed for a rejected request")
+			}
+		})
+	}
+}
+
+func TestGetArtifact(t *testing.T) {
+	mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	saved, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("0123456789"), storage.ProfileMetadata{
+		Namespace: "web", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1", Filename: "perf.data",
+	})
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+
+	var gotRead auth.ReadRequest
+	handler := newArtifactTestServer(t, mgr, &mockReadAuthorizer{
+		authorizeReadFunc: func(ctx context.Context, user *authv1.UserInfo, req auth.ReadRequest) error {
+			gotRead = req
+			if req.Namespace != "web" {
+				return auth.ErrReadForbidden
+			}
+			return nil
+		},
+	})
+
+	t.Run("full download", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts/"+saved.Path))
+		if rr.Code != http.StatusOK || rr.Body.String() != "0123456789" {
+			t.Fatalf("got %d %q, want 200 with the artifact", rr.Code, rr.Body.String())
+		}
+		if gotRead.Namespace != "web" || gotRead.PowerToolName != "job" {
+			t.Errorf("authorized read = %+v", gotRead)
+		}
+		if rr.Header().Get("X-PowerTool-SHA256") != saved.SHA256 || rr.Header().Get("ETag") != `"`+saved.SHA256+`"` {
+			t.Errorf("digest headers = %v", rr.Header())
+		}
+		if rr.Header().Get("Content-Disposition") != `attachment; filename=web-1_perf.data` {
+			t.Errorf("Content-Disposition = %q", rr.Header().Get("Content-Disposition"))
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		req := readRequest("GET", "/api/v1/artifacts/"+saved.Path)
+		req.Header.Set("Range", "bytes=3-5")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusPartialContent || rr.Body.String() != "345" {
+			t.Errorf("got %d %q, want 206 with bytes 3-5", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("Content-Range") != "bytes 3-5/10" {
+			t.Errorf("Content-Range = %q", rr.Header().Get("Content-Range"))
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("HEAD", "/api/v1/artifacts/"+saved.Path))
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Length") != "10" || rr.Body.Len() != 0 {
+			t.Errorf("got %d, Content-Length %q, body %q", rr.Code, rr.Header().Get("Content-Length"), rr.Body.String())
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts/web/app-web/job/2025-01-02/missing.data"))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("other namespace is forbidden before lookup", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts/db/app-db/job/2025-01-02/missing.data"))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", rr.Code)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts/"+saved.Path+storage.MetadataSuffix))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("no token", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/artifacts/"+saved.Path, nil))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401, got %d", rr.Code)
+		}
+	})
+}
+
+func TestGetArtifact_Compressed(t *testing.T) {
+	mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	content := strings.Repeat("0123456789", 100)
+	saved, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString(content), storage.ProfileMetadata{
+		Namespace: "web", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1", Filename: "perf.data",
+		Compress: storage.EncodingGzip,
+	})
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	handler := newArtifactTestServer(t, mgr, &mockReadAuthorizer{})
+
+	t.Run("decompressed for clients without gzip", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts/"+saved.Path))
+		if rr.Code != http.StatusOK || rr.Body.String() != content {
+			t.Fatalf("got %d with %d bytes, want 200 with the decompressed artifact", rr.Code, rr.Body.Len())
+		}
+		if rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Content-Length") != "1000" {
+			t.Errorf("headers = %v", rr.Header())
+		}
+		if rr.Header().Get("Content-Disposition") != `attachment; filename=web-1_perf.data` {
+			t.Errorf("Content-Disposition = %q", rr.Header().Get("Content-Disposition"))
+		}
+	})
+
+	t.Run("encoded for clients with gzip", func(t *testing.T) {
+		req := readRequest("GET", "/api/v1/artifacts/"+saved.Path)
+		req.Header.Set("Accept-Encoding", "zstd;q=0, gzip")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("got %d with Content-Encoding %q, want gzip", rr.Code, rr.Header().Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatalf("gzip.NewReader() error = %v", err)
+		}
+		if got, _ := io.ReadAll(zr); string(got) != content {
+			t.Errorf("decoded body differs from the upload")
+		}
+	})
+
+	t.Run("refused gzip", func(t *testing.T) {
+		req := readRequest("GET", "/api/v1/artifacts/"+saved.Path)
+		req.Header.Set("Accept-Encoding", "gzip;q=0")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != content {
+			t.Errorf("got Content-Encoding %q, want the decompressed artifact", rr.Header().Get("Content-Encoding"))
+		}
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts/"+saved.Path+"?raw=true"))
+		if rr.Code != http.StatusOK || int64(rr.Body.Len()) != saved.Size || rr.Header().Get("Content-Type") != "application/gzip" {
+			t.Errorf("got %d, %d bytes, Content-Type %q, want the stored object", rr.Code, rr.Body.Len(), rr.Header().Get("Content-Type"))
+		}
+		if rr.Header().Get("Content-Disposition") != `attachment; filename=web-1_perf.data.gz` {
+			t.Errorf("Content-Disposition = %q", rr.Header().Get("Content-Disposition"))
+		}
+	})
+}
+
+func TestGetArtifact_Encrypted(t *testing.T) {
+	mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	keyring, err := storage.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	mgr.EnableEncryption(keyring)
+	content := strings.Repeat("0123456789", 20000)
+	saved, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString(content), storage.ProfileMetadata{
+		Namespace: "web", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1", Filename: "perf.data",
+	})
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	handler := newArtifactTestServer(t, mgr, &mockReadAuthorizer{})
+
+	t.Run("decrypted download", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts/"+saved.Path))
+		if rr.Code != http.StatusOK || rr.Body.String() != content {
+			t.Fatalf("got %d with %d bytes, want 200 with the decrypted artifact", rr.Code, rr.Body.Len())
+		}
+		if rr.Header().Get("Content-Length") != fmt.Sprint(len(content)) || rr.Header().Get("X-PowerTool-SHA256") != saved.SHA256 {
+			t.Errorf("headers = %v", rr.Header())
+		}
+	})
+
+	t.Run("range across an encryption segment", func(t *testing.T) {
+		req := readRequest("GET", "/api/v1/artifacts/"+saved.Path)
+		req.Header.Set("Range", "bytes=65530-65545")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusPartialContent || rr.Body.String() != content[65530:65546] {
+			t.Errorf("got %d %q, want 206 with bytes 65530-65545", rr.Code, rr.Body.String())
+		}
+	})
+}
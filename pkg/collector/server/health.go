@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readinessTimeout bounds each readiness check, so a hung dependency fails
+// the probe instead of stalling it
+const readinessTimeout = 5 * time.Second
+
+// readinessCheck is one dependency the collector needs to accept uploads
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// handleHealthz reports that the process is serving requests
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReadyz runs every readiness check and lists the result of each, in
+// the style of the Kubernetes API server's /readyz?verbose
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	ready := true
+	for _, c := range s.checks {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		err := c.check(ctx)
+		cancel()
+		if err != nil {
+			ready = false
+			log.Printf("Readiness check %s failed: %v", c.name, err)
+			fmt.Fprintf(&b, "[-]%s failed: %v\n", c.name, err)
+			continue
+		}
+		fmt.Fprintf(&b, "[+]%s ok\n", c.name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		b.WriteString("readyz check failed\n")
+	} else {
+		b.WriteString("readyz check passed\n")
+	}
+	_, _ = w.Write([]byte(b.String()))
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"toe/pkg/collector/metrics"
+	"toe/pkg/collector/storage"
+)
+
+func TestHealthz(t *testing.T) {
+	srv := &Server{}
+	rr := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tokenReviewErr := errors.New("connection refused")
+	srv := &Server{
+		checks: []readinessCheck{
+			{name: "storage", check: func(ctx context.Context) error { return nil }},
+			{name: "tokenreview", check: func(ctx context.Context) error { return tokenReviewErr }},
+		},
+	}
+	handler := srv.routes()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with an unreachable TokenReview API, got %d", rr.Code)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "[+]storage ok") || !strings.Contains(body, "[-]tokenreview failed: connection refused") {
+		t.Errorf("readyz body = %q", body)
+	}
+
+	tokenReviewErr = nil
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 once every check passes, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := &Server{
+		storage: &mockStorage{
+			saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+				_, err := io.Copy(io.Discard, r)
+				return err
+			},
+		},
+		auth:       &mockAuth{},
+		authorizer: &mockAuthorizer{},
+	}
+	handler := srv.routes()
+
+	stored := testutil.ToFloat64(metrics.UploadsTotal.WithLabelValues("metrics-test", metrics.OutcomeStored))
+	received := testutil.ToFloat64(metrics.BytesReceived.WithLabelValues("metrics-test"))
+	missingToken := testutil.ToFloat64(metrics.AuthFailures.WithLabelValues(endpointUpload, metrics.AuthMissingToken))
+
+	req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("profile"))
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-PowerTool-Job-ID", "test-job")
+	req.Header.Set("X-PowerTool-Namespace", "metrics-test")
+	req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/profile", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rr.Code)
+	}
+
+	if got := testutil.ToFloat64(metrics.UploadsTotal.WithLabelValues("metrics-test", metrics.OutcomeStored)); got != stored+1 {
+		t.Errorf("stored uploads = %v, want %v", got, stored+1)
+	}
+	if got := testutil.ToFloat64(metrics.BytesReceived.WithLabelValues("metrics-test")); got != received+7 {
+		t.Errorf("received bytes = %v, want %v", got, received+7)
+	}
+	if got := testutil.ToFloat64(metrics.AuthFailures.WithLabelValues(endpointUpload, metrics.AuthMissingToken)); got != missingToken+1 {
+		t.Errorf("missing token failures = %v, want %v", got, missingToken+1)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `toe_collector_uploads_total{namespace="metrics-test",outcome="stored"}`) {
+		t.Errorf("/metrics = %d, missing the upload counter", rr.Code)
+	}
+}
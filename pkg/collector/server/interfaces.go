@@ -3,7 +3,9 @@ package server
 import (
 	"context"
 	"io"
+	"net/http"
 
+	"toe/pkg/collector/auth"
 	"toe/pkg/collector/storage"
 
 	authv1 "k8s.io/api/authentication/v1"
@@ -11,10 +13,40 @@ import (
 
 // StorageManager defines the interface for profile storage operations
 type StorageManager interface {
-	SaveProfile(r io.Reader, metadata storage.ProfileMetadata) error
+	SaveProfile(ctx context.Context, r io.Reader, metadata storage.ProfileMetadata) (*storage.Artifact, error)
+	ListArtifacts(ctx context.Context, filter storage.ArtifactFilter) ([]storage.Artifact, error)
+	Artifact(ctx context.Context, key string) (*storage.Artifact, error)
+	OpenArtifact(ctx context.Context, key string) (*storage.Artifact, io.ReadSeekCloser, error)
+	// PathTemplate is the layout of artifact keys, which says who claims
+	// to own an artifact before it is looked up
+	PathTemplate() *storage.PathTemplate
 }
 
 // TokenValidator defines the interface for token validation operations
 type TokenValidator interface {
 	ValidateToken(ctx context.Context, token string) (*authv1.UserInfo, error)
 }
+
+// Authenticator defines the interface for identifying the caller of a
+// request from its token or client certificate; see auth.Authenticator
+type Authenticator interface {
+	AuthenticateRequest(r *http.Request) (*authv1.UserInfo, bool, error)
+}
+
+// UploadAuthorizer defines the interface for checking an upload against the
+// PowerTool it claims to belong to, returning that PowerTool and the pod
+type UploadAuthorizer interface {
+	AuthorizeUpload(ctx context.Context, req auth.UploadRequest) (*auth.UploadTarget, error)
+}
+
+// ReadAuthorizer defines the interface for checking whether a caller may read
+// the artifacts of a PowerTool or namespace
+type ReadAuthorizer interface {
+	AuthorizeRead(ctx context.Context, user *authv1.UserInfo, req auth.ReadRequest) error
+}
+
+// StatusRecorder defines the interface for recording stored artifacts in the
+// status of their PowerTool
+type StatusRecorder interface {
+	RecordArtifact(ctx context.Context, artifact *storage.Artifact) error
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"toe/pkg/collector/auth"
+	"toe/pkg/collector/metrics"
+)
+
+// Lifetimes of signed download links
+const (
+	// DefaultLinkTTL applies when a link request names no lifetime
+	DefaultLinkTTL = 24 * time.Hour
+	// DefaultMaxLinkTTL caps link lifetimes when Config.MaxLinkTTL is zero
+	DefaultMaxLinkTTL = 7 * 24 * time.Hour
+)
+
+// maxLinkRequestBytes bounds the body of a link request
+const maxLinkRequestBytes = 4 << 10
+
+// linkRequest asks for a signed link to one artifact
+type linkRequest struct {
+	Path string `json:"path"`
+	// ExpiresIn is a Go duration such as "1h". Empty uses DefaultLinkTTL.
+	ExpiresIn string `json:"expiresIn,omitempty"`
+}
+
+type linkResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// handleCreateLink mints a signed link that downloads one artifact without
+// credentials until it expires. The caller must be allowed to read the
+// artifact itself.
+func (s *Server) handleCreateLink(w http.ResponseWriter, r *http.Request) {
+	userInfo, ok := s.authenticateReader(w, r)
+	if !ok {
+		return
+	}
+	if s.links == nil {
+		http.Error(w, "Signed links are not enabled: no signing key is configured", http.StatusNotImplemented)
+		return
+	}
+
+	var req linkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLinkRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid link request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.storage.PathTemplate().ValidateKey(req.Path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := s.linkTTL(req.ExpiresIn)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid expiresIn: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	namespace, _, powerTool, _ := s.storage.PathTemplate().SplitKey(req.Path)
+	if !s.authorizeRead(w, r, userInfo, auth.ReadRequest{Namespace: namespace, PowerToolName: powerTool}) {
+		return
+	}
+	artifact, err := s.storage.Artifact(r.Context(), req.Path)
+	if err != nil {
+		writeReadError(w, err)
+		return
+	}
+	if !ownedBy(artifact, namespace, powerTool) {
+		writeReadError(w, fs.ErrNotExist)
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	log.Printf("Issued download link for %s to %s, expiring %s", req.Path, userInfo.Username, expires.UTC().Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(linkResponse{URL: s.signedURL(req.Path, expires), ExpiresAt: expires}); err != nil {
+		log.Printf("Failed to write link response: %v", err)
+	}
+}
+
+// linkTTL parses a requested link lifetime, bounded by the configured maximum
+func (s *Server) linkTTL(v string) (time.Duration, error) {
+	if v == "" {
+		return min(DefaultLinkTTL, s.maxLinkTTL()), nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	if ttl > s.maxLinkTTL() {
+		return 0, fmt.Errorf("must be at most %v", s.maxLinkTTL())
+	}
+	return ttl, nil
+}
+
+func (s *Server) maxLinkTTL() time.Duration {
+	if s.config != nil && s.config.MaxLinkTTL > 0 {
+		return s.config.MaxLinkTTL
+	}
+	return DefaultMaxLinkTTL
+}
+
+// signedURL returns the download URL of key signed until expires. It is
+// absolute when Config.PublicURL is set and relative to the collector
+// otherwise.
+func (s *Server) signedURL(key string, expires time.Time) string {
+	base := ""
+	if s.config != nil {
+		base = strings.TrimSuffix(s.config.PublicURL, "/")
+	}
+	return base + artifactsPath + key + "?" + s.links.Sign(key, expires).Encode()
+}
+
+// verifyLink checks the signature a download request carries instead of a
+// token, writing the error response on failure
+func (s *Server) verifyLink(w http.ResponseWriter, r *http.Request, key string) bool {
+	if s.links == nil {
+		metrics.AuthFailures.WithLabelValues(endpointRead, metrics.AuthInvalidToken).Inc()
+		http.Error(w, "Signed links are not enabled: no signing key is configured", http.StatusForbidden)
+		return false
+	}
+	if err := s.links.Verify(key, r.URL.Query()); err != nil {
+		log.Printf("Rejected download link for %s: %v", key, err)
+		metrics.AuthFailures.WithLabelValues(endpointRead, metrics.AuthInvalidToken).Inc()
+		http.Error(w, "Invalid or expired download link", http.StatusForbidden)
+		return false
+	}
+	return true
+}
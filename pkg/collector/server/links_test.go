@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"toe/pkg/collector/auth"
+	"toe/pkg/collector/storage"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+const testPublicURL = "https://profiles.example.com"
+
+// newLinkTestServer serves one stored artifact with signed links enabled
+func newLinkTestServer(t *testing.T, authorizer ReadAuthorizer) (http.Handler, *storage.Artifact) {
+	t.Helper()
+	mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	saved, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("profile data"), storage.ProfileMetadata{
+		Namespace: "web", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1", Filename: "perf.data",
+	})
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	links, err := auth.NewLinkSigner(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("NewLinkSigner() error = %v", err)
+	}
+	srv := &Server{
+		config:         &Config{PublicURL: testPublicURL + "/", MaxLinkTTL: 48 * time.Hour},
+		storage:        mgr,
+		readAuth:       &mockAuth{},
+		readAuthorizer: authorizer,
+		links:          links,
+	}
+	return srv.routes(), saved
+}
+
+// localTarget turns a link into a request target for the test server
+func localTarget(t *testing.T, link string) string {
+	t.Helper()
+	target, ok := strings.CutPrefix(link, testPublicURL)
+	if !ok {
+		t.Fatalf("link %q does not start with %s", link, testPublicURL)
+	}
+	return target
+}
+
+func createLink(handler http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer user-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCreateLink(t *testing.T) {
+	handler, saved := newLinkTestServer(t, &mockReadAuthorizer{})
+
+	rr := createLink(handler, fmt.Sprintf(`{"path": %q, "expiresIn": "1h"}`, saved.Path))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create link status = %d, body %q", rr.Code, rr.Body.String())
+	}
+	var resp linkResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if d := time.Until(resp.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("ExpiresAt = %v, want about an hour from now", resp.ExpiresAt)
+	}
+
+	// The link works without a token
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", localTarget(t, resp.URL), nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "profile data" {
+		t.Errorf("signed download = %d %q, want 200 with the artifact", rr.Code, rr.Body.String())
+	}
+
+	// but only for the artifact it was signed for
+	other := strings.Replace(localTarget(t, resp.URL), "web-1", "web-2", 1)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", other, nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("signed download of another artifact = %d, want 403", rr.Code)
+	}
+}
+
+func TestCreateLink_Errors(t *testing.T) {
+	forbidden := &mockReadAuthorizer{authorizeReadFunc: func(context.Context, *authv1.UserInfo, auth.ReadRequest) error {
+		return fmt.Errorf("%w: no access", auth.ErrReadForbidden)
+	}}
+	tests := []struct {
+		name       string
+		authorizer ReadAuthorizer
+		body       string
+		wantStatus int
+	}{
+		{"longer than the maximum", &mockReadAuthorizer{}, `{"path": "%s", "expiresIn": "72h"}`, http.StatusBadRequest},
+		{"negative lifetime", &mockReadAuthorizer{}, `{"path": "%s", "expiresIn": "-1h"}`, http.StatusBadRequest},
+		{"not JSON", &mockReadAuthorizer{}, `path=%s`, http.StatusBadRequest},
+		{"no read access", forbidden, `{"path": "%s"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, saved := newLinkTestServer(t, tt.authorizer)
+			if rr := createLink(handler, fmt.Sprintf(tt.body, saved.Path)); rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("invalid path", func(t *testing.T) {
+		handler, _ := newLinkTestServer(t, &mockReadAuthorizer{})
+		if rr := createLink(handler, `{"path": "../etc/passwd"}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rr.Code)
+		}
+	})
+}
+
+func TestGetArtifact_SignedLinkRejected(t *testing.T) {
+	handler, saved := newLinkTestServer(t, &mockReadAuthorizer{})
+	signer, _ := auth.NewLinkSigner(bytes.Repeat([]byte("k"), 32))
+	otherKey, _ := auth.NewLinkSigner(bytes.Repeat([]byte("o"), 32))
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"expired", signer.Sign(saved.Path, time.Now().Add(-time.Minute)).Encode()},
+		{"signed with another key", otherKey.Sign(saved.Path, time.Now().Add(time.Hour)).Encode()},
+		{"garbled signature", "expires=9999999999&signature=AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", artifactsPath+saved.Path+"?"+tt.query, nil))
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403", rr.Code)
+			}
+		})
+	}
+
+	t.Run("links disabled", func(t *testing.T) {
+		srv := &Server{storage: &mockStorage{}, readAuth: &mockAuth{}, readAuthorizer: &mockReadAuthorizer{}}
+		rr := httptest.NewRecorder()
+		srv.routes().ServeHTTP(rr, httptest.NewRequest("GET", artifactsPath+saved.Path+"?"+signer.Sign(saved.Path, time.Now().Add(time.Hour)).Encode(), nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403", rr.Code)
+		}
+	})
+}
+
+func TestListArtifacts_SignedLinks(t *testing.T) {
+	handler, saved := newLinkTestServer(t, &mockReadAuthorizer{})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts?namespace=web&powertool=job&expiresIn=2h"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list status = %d, body %q", rr.Code, rr.Body.String())
+	}
+	var resp artifactListResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Artifacts) != 1 || resp.LinksExpireAt == nil {
+		t.Fatalf("response = %+v, want one artifact and a link expiry", resp)
+	}
+	if d := time.Until(*resp.LinksExpireAt); d < 119*time.Minute || d > 2*time.Hour {
+		t.Errorf("LinksExpireAt = %v, want about two hours from now", resp.LinksExpireAt)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", localTarget(t, resp.Artifacts[0].SignedURL), nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "profile data" {
+		t.Errorf("signed download of %s = %d %q, want 200 with the artifact", saved.Path, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, readRequest("GET", "/api/v1/artifacts?namespace=web"))
+	if strings.Contains(rr.Body.String(), "signedUrl") {
+		t.Errorf("listing without expiresIn returned signed links: %s", rr.Body.String())
+	}
+}
+
+func TestGetArtifact_OlderLayout(t *testing.T) {
+	mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	saved, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("team a data"), storage.ProfileMetadata{
+		Namespace: "team-a", AppLabel: "app-x", PowerToolName: "job", PodName: "web-1", Filename: "perf.data",
+	})
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	// Under the new layout the old key reads as namespace app-x, PowerTool job
+	if err := mgr.SetPathTemplate("{{.PodName}}/{{.Namespace}}/{{.PowerToolName}}/{{.Date}}"); err != nil {
+		t.Fatalf("SetPathTemplate() error = %v", err)
+	}
+	links, err := auth.NewLinkSigner(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("NewLinkSigner() error = %v", err)
+	}
+	var authorized []auth.ReadRequest
+	srv := &Server{
+		storage:  mgr,
+		readAuth: &mockAuth{},
+		readAuthorizer: &mockReadAuthorizer{authorizeReadFunc: func(ctx context.Context, user *authv1.UserInfo, req auth.ReadRequest) error {
+			authorized = append(authorized, req)
+			if req.Namespace != "app-x" {
+				return auth.ErrReadForbidden
+			}
+			return nil
+		}},
+		links: links,
+	}
+	handler := srv.routes()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, readRequest("GET", artifactsPath+saved.Path))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("download of another namespace's artifact = %d %q, want 404", rr.Code, rr.Body.String())
+	}
+	if rr := createLink(handler, fmt.Sprintf(`{"path": %q}`, saved.Path)); rr.Code != http.StatusNotFound {
+		t.Errorf("link to another namespace's artifact = %d %q, want 404", rr.Code, rr.Body.String())
+	}
+	// A link signed for the key does not get around the check either
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", srv.signedURL(saved.Path, time.Now().Add(time.Hour)), nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("signed download of another namespace's artifact = %d, want 404", rr.Code)
+	}
+	if len(authorized) != 2 || authorized[0].Namespace != "app-x" || authorized[0].PowerToolName != "job" {
+		t.Errorf("authorized reads = %+v", authorized)
+	}
+}
@@ -2,65 +2,565 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"strings"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	toev1alpha1 "toe/api/v1alpha1"
 	"toe/pkg/collector/auth"
+	"toe/pkg/collector/metrics"
+	"toe/pkg/collector/status"
 	"toe/pkg/collector/storage"
+	"toe/pkg/collector/upload"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/time/rate"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultAudience is the audience collector tokens are minted for
+const DefaultAudience = "toe-sdk-collector"
+
+// Upload authenticators, see Config.Authenticators
+const (
+	// AuthenticatorTokenReview accepts Kubernetes bearer tokens, checked
+	// with a TokenReview
+	AuthenticatorTokenReview = "tokenreview"
+	// AuthenticatorClientCert accepts TLS client certificates issued by
+	// Config.ClientCAFile
+	AuthenticatorClientCert = "client-cert"
 )
 
 type Config struct {
-	Port        int
+	// Address is the host:port to listen on. When empty the server listens on
+	// Port on all interfaces.
+	Address string
+	Port    int
+	// StoragePath holds artifacts for the filesystem backend, and upload
+	// sessions and staged uploads for every backend
 	StoragePath string
 	DateFormat  string
-	TLSCert     string
-	TLSKey      string
-	SigningKey  []byte
+	// PathTemplate lays out the directories artifacts are stored in; see
+	// storage.ParsePathTemplate. Empty uses storage.DefaultPathTemplate.
+	PathTemplate string
+	TLSCert      string
+	TLSKey       string
+	// SigningKey signs expiring download links. Links are disabled when it
+	// is empty; otherwise it must hold at least auth.MinSigningKeySize bytes.
+	SigningKey []byte
+	// PublicURL is the base URL signed links are given out with, such as
+	// an Ingress in front of the collector. Empty gives links relative to
+	// the collector.
+	PublicURL string
+	// MaxLinkTTL caps the lifetime of signed links. Zero uses
+	// DefaultMaxLinkTTL.
+	MaxLinkTTL time.Duration
+	// StorageBackend is storage.BackendFilesystem (the default) or
+	// storage.BackendS3
+	StorageBackend string
+	// S3 configures the S3 backend
+	S3 storage.S3Config
+	// UploadGracePeriod is how long after a PowerTool completes uploads are
+	// still accepted. Zero uses auth.DefaultFinishedUploadGrace.
+	UploadGracePeriod time.Duration
+	// UploadSessionTTL is how long a resumable upload may sit idle before it
+	// is discarded. Zero uses upload.DefaultSessionTTL.
+	UploadSessionTTL time.Duration
+	// DefaultRetentionDays applies to artifacts whose PowerTool declares no
+	// retention. Zero keeps them forever.
+	DefaultRetentionDays int32
+	// MaxRetentionDays caps the retention a PowerTool may declare. Zero
+	// means no cap.
+	MaxRetentionDays int32
+	// RetentionSweepInterval is how often expired artifacts are deleted.
+	// Zero uses storage.DefaultSweepInterval.
+	RetentionSweepInterval time.Duration
+
+	// Audience is the audience upload tokens must be issued for. Empty uses
+	// DefaultAudience.
+	Audience string
+	// ServiceAccounts, when not empty, are the only ServiceAccounts whose
+	// tokens may upload
+	ServiceAccounts []types.NamespacedName
+
+	// Authenticators lists the ways uploaders may identify themselves, tried
+	// in order: AuthenticatorTokenReview and AuthenticatorClientCert. Empty
+	// uses AuthenticatorTokenReview alone.
+	Authenticators []string
+	// ClientCAFile is the PEM bundle client certificates must chain to, and
+	// ClientCertRules maps them to what they may upload. Both are required
+	// with AuthenticatorClientCert.
+	ClientCAFile    string
+	ClientCertRules []auth.CertificateRule
+
+	// TokenCacheTTL and TokenNegativeCacheTTL are how long accepted and
+	// rejected tokens are remembered, and TokenCacheSize how many. Zero uses
+	// the auth package defaults.
+	TokenCacheTTL         time.Duration
+	TokenNegativeCacheTTL time.Duration
+	TokenCacheSize        int
+	// TokenReviewQPS and TokenReviewBurst limit the TokenReviews sent on
+	// cache misses. Zero uses auth.DefaultTokenReviewQPS and
+	// auth.DefaultTokenReviewBurst.
+	TokenReviewQPS   float64
+	TokenReviewBurst int
+
+	// EncryptionKeyringFile, when set, names the keyring artifacts are
+	// encrypted at rest with; see storage.LoadKeyring
+	EncryptionKeyringFile string
+
+	// DisableArtifactIndex lists artifacts by reading their sidecars instead
+	// of from the index kept below StoragePath. Replicas sharing an S3 bucket
+	// need this, since each only indexes its own uploads.
+	DisableArtifactIndex bool
+
+	// MaxArtifactBytes caps the size of one upload. Zero means no limit.
+	MaxArtifactBytes int64
+	// NamespaceQuotaBytes and PowerToolQuotaBytes cap the bytes stored for
+	// each namespace and each PowerTool. Zero means no limit.
+	NamespaceQuotaBytes int64
+	PowerToolQuotaBytes int64
+
+	// Timeouts and limits of the HTTP server; see http.Server. Zero means no
+	// limit, except for MaxHeaderBytes which then uses http.DefaultMaxHeaderBytes.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+// address returns where the server listens
+func (c *Config) address() string {
+	if c.Address != "" {
+		return c.Address
+	}
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// headerRetentionDays carries the retention the tool was started with. The
+// PowerTool's own setting takes precedence when the collector can see it.
+const headerRetentionDays = "X-PowerTool-Retention-Days"
+
+// Segment headers mark an upload as one numbered piece of a rolling capture.
+// Every segment of a capture is sent with the same filename.
+const (
+	headerSegment      = "X-PowerTool-Segment"
+	headerSegmentFinal = "X-PowerTool-Segment-Final"
+)
+
+// headerSHA256 carries the hex SHA-256 of the upload, either as a header or as
+// an HTTP trailer when the client hashes while streaming
+const headerSHA256 = "X-PowerTool-SHA256"
+
+// artifactResponse describes the stored artifact after a successful upload.
+// SHA256 is the digest of the content as uploaded, even when the collector
+// compressed it.
+type artifactResponse struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	// Segment echoes the segment stored, for rolling captures
+	Segment *storage.Segment `json:"segment,omitempty"`
 }
 
 type Server struct {
-	config  *Config
-	storage StorageManager
-	auth    TokenValidator
-	server  *http.Server
+	config     *Config
+	storage    StorageManager
+	auth       Authenticator
+	authorizer UploadAuthorizer
+	uploads    *upload.SessionStore
+	retention  *storage.Sweeper
+	server     *http.Server
+
+	// readAuth validates the tokens of people and tools reading artifacts.
+	// Unlike upload tokens they are meant for the API server, so collector
+	// tokens cannot be used to read.
+	readAuth       Authenticator
+	readAuthorizer ReadAuthorizer
+	// links signs and verifies download links. Nil when no signing key is
+	// configured.
+	links *auth.LinkSigner
+
+	// status records stored artifacts in PowerTool status. Nil disables
+	// recording.
+	status StatusRecorder
+
+	// checks must all pass for /readyz to report ready
+	checks []readinessCheck
+
+	// maxArtifactBytes caps each upload, zero meaning no limit
+	maxArtifactBytes int64
+	// quotas is consulted before reading uploads so ones that cannot fit are
+	// refused early; storage enforces them when publishing. Nil when quotas
+	// are disabled.
+	quotas *storage.Quotas
+	// index is closed on shutdown so the next start can trust it. Nil when
+	// disabled.
+	index *storage.Index
+	// rebuildIndex, when set, rebuilds an index that could not be trusted
+	// once the server starts
+	rebuildIndex func(context.Context) (int, error)
+
+	// sweeperCtx scopes the background work started with the server: the
+	// sweepers and the TLS certificate watcher
+	stopSweeper context.CancelFunc
+	sweeperCtx  context.Context
+
+	// rewrap, when set, re-seals the data keys of artifacts encrypted under
+	// master keys other than the primary one, once the server starts
+	rewrap func(context.Context) (storage.RewrapResult, error)
 }
 
-func NewServer(cfg *Config, k8sClient kubernetes.Interface) (*Server, error) {
-	storageManager, err := storage.NewManager(cfg.StoragePath, cfg.DateFormat)
+// NewServer creates a collector server. k8sClient is used for TokenReviews and
+// c for looking up the PowerTools and pods uploads claim to come from and
+// recording their artifacts in PowerTool status.
+func NewServer(cfg *Config, k8sClient kubernetes.Interface, c client.Client) (*Server, error) {
+	backend, err := storage.NewBackend(storage.Config{
+		Backend: cfg.StorageBackend,
+		Path:    cfg.StoragePath,
+		S3:      cfg.S3,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage backend: %w", err)
+	}
+	backend = storage.NewInstrumentedBackend(backend)
+	storageManager, err := storage.NewManagerWithBackend(backend, filepath.Join(cfg.StoragePath, ".staging"), cfg.DateFormat)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage manager: %w", err)
 	}
+	if cfg.PathTemplate != "" {
+		if err := storageManager.SetPathTemplate(cfg.PathTemplate); err != nil {
+			return nil, err
+		}
+		log.Printf("Storing artifacts under %s", cfg.PathTemplate)
+	}
 
-	s := &Server{
-		config:  cfg,
-		storage: storageManager,
-		auth:    auth.NewK8sTokenValidator(k8sClient, "toe-sdk-collector"),
+	// Namespaces are DNS labels, so a dot-directory never collides with
+	// profile paths
+	sessions, err := upload.NewSessionStore(filepath.Join(cfg.StoragePath, ".uploads"), cfg.UploadSessionTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload session store: %w", err)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/profile", s.handleProfile)
+	if err := metrics.RegisterDiskFree(cfg.StoragePath); err != nil {
+		return nil, fmt.Errorf("failed to register disk metrics: %w", err)
+	}
+
+	var quotas *storage.Quotas
+	if cfg.NamespaceQuotaBytes > 0 || cfg.PowerToolQuotaBytes > 0 {
+		quotas, err = storageManager.EnableQuotas(context.Background(), storage.QuotaLimits{
+			Namespace: cfg.NamespaceQuotaBytes,
+			PowerTool: cfg.PowerToolQuotaBytes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count stored artifacts for quotas: %w", err)
+		}
+	}
+
+	var rewrap func(context.Context) (storage.RewrapResult, error)
+	if cfg.EncryptionKeyringFile != "" {
+		keyring, err := storage.LoadKeyring(cfg.EncryptionKeyringFile)
+		if err != nil {
+			return nil, err
+		}
+		storageManager.EnableEncryption(keyring)
+		log.Printf("Encrypting artifacts at rest with master key %s", keyring.Primary())
+		if len(keyring.KeyIDs()) > 1 {
+			rewrap = storageManager.RewrapDataKeys
+		}
+	}
+
+	var links *auth.LinkSigner
+	if len(cfg.SigningKey) > 0 {
+		if links, err = auth.NewLinkSigner(cfg.SigningKey); err != nil {
+			return nil, err
+		}
+	}
+
+	audience := cfg.Audience
+	if audience == "" {
+		audience = DefaultAudience
+	}
+	tokenValidator := auth.NewK8sTokenValidator(k8sClient, audience, cfg.ServiceAccounts...)
+	tokenCache := tokenCacheConfig(cfg)
+	uploadAuth, clientCAs, err := uploadAuthenticator(cfg, auth.NewCachedTokenValidator(tokenValidator, endpointUpload, tokenCache))
+	if err != nil {
+		return nil, err
+	}
+	var index *storage.Index
+	var rebuildIndex func(context.Context) (int, error)
+	if !cfg.DisableArtifactIndex {
+		// Opened last, so no later failure leaves it locked. Namespaces
+		// are DNS labels, so .index never collides with artifacts.
+		index, err = storageManager.EnableIndex(filepath.Join(cfg.StoragePath, ".index", "artifacts.db"))
+		if err != nil {
+			return nil, err
+		}
+		if !index.Ready() {
+			rebuildIndex = storageManager.RebuildIndex
+		}
+	}
+
+	s := &Server{
+		config:     cfg,
+		storage:    storageManager,
+		auth:       uploadAuth,
+		authorizer: auth.NewPowerToolAuthorizer(c, cfg.UploadGracePeriod),
+		uploads:    sessions,
+		retention: storage.NewSweeper(storageManager, storage.RetentionPolicy{
+			DefaultDays: cfg.DefaultRetentionDays,
+			MaxDays:     cfg.MaxRetentionDays,
+		}, cfg.RetentionSweepInterval),
+
+		readAuth:       auth.NewBearerTokenAuthenticator(auth.NewCachedTokenValidator(auth.NewK8sTokenValidator(k8sClient, ""), endpointRead, tokenCache)),
+		readAuthorizer: auth.NewSubjectAccessReviewer(k8sClient),
+		links:          links,
+
+		status: status.NewRecorder(c),
+
+		checks: []readinessCheck{
+			{name: "storage", check: storageManager.Check},
+			{name: "tokenreview", check: tokenValidator.Check},
+		},
+
+		maxArtifactBytes: cfg.MaxArtifactBytes,
+		quotas:           quotas,
+		index:            index,
+		rebuildIndex:     rebuildIndex,
+		rewrap:           rewrap,
+	}
+	s.sweeperCtx, s.stopSweeper = context.WithCancel(context.Background())
 
 	s.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.Port),
-		Handler: mux,
+		Addr:              cfg.address(),
+		Handler:           s.routes(),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	if clientCAs != nil {
+		// Certificates are verified by the authenticator, so clients
+		// without one can still fall back to a token
+		s.server.TLSConfig = &tls.Config{
+			ClientAuth: tls.RequestClientCert,
+			ClientCAs:  clientCAs,
+		}
 	}
 
 	return s, nil
 }
 
+// uploadAuthenticator chains the authenticators cfg enables, in order. The
+// pool client certificates are verified against is returned when they are
+// accepted.
+func uploadAuthenticator(cfg *Config, tokens auth.TokenValidator) (Authenticator, *x509.CertPool, error) {
+	names := cfg.Authenticators
+	if len(names) == 0 {
+		names = []string{AuthenticatorTokenReview}
+	}
+
+	var chain auth.ChainAuthenticator
+	var clientCAs *x509.CertPool
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			return nil, nil, fmt.Errorf("authenticator %q listed twice", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case AuthenticatorTokenReview:
+			chain = append(chain, auth.NewBearerTokenAuthenticator(tokens))
+		case AuthenticatorClientCert:
+			if cfg.TLSCert == "" || cfg.TLSKey == "" {
+				return nil, nil, fmt.Errorf("the %s authenticator requires TLS", name)
+			}
+			if cfg.ClientCAFile == "" {
+				return nil, nil, fmt.Errorf("the %s authenticator requires a client CA", name)
+			}
+			caPEM, err := os.ReadFile(cfg.ClientCAFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read client CA: %w", err)
+			}
+			certs, err := auth.NewClientCertAuthenticator(caPEM, cfg.ClientCertRules)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to create client certificate authenticator: %w", err)
+			}
+			chain = append(chain, certs)
+			clientCAs = certs.ClientCAs()
+		default:
+			return nil, nil, fmt.Errorf("unknown authenticator %q", name)
+		}
+	}
+	if len(chain) == 1 {
+		return chain[0], clientCAs, nil
+	}
+	return chain, clientCAs, nil
+}
+
+// tokenCacheConfig caches TokenReviews as cfg asks. Both validators share
+// one rate limit, since they review against the same API server.
+func tokenCacheConfig(cfg *Config) auth.TokenCacheConfig {
+	qps, burst := cfg.TokenReviewQPS, cfg.TokenReviewBurst
+	if qps <= 0 {
+		qps = auth.DefaultTokenReviewQPS
+	}
+	if burst <= 0 {
+		burst = auth.DefaultTokenReviewBurst
+	}
+	return auth.TokenCacheConfig{
+		TTL:         cfg.TokenCacheTTL,
+		NegativeTTL: cfg.TokenNegativeCacheTTL,
+		Size:        cfg.TokenCacheSize,
+		Limiter:     rate.NewLimiter(rate.Limit(qps), burst),
+	}
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/profile", s.handleProfile)
+
+	// Probes and metrics are unauthenticated, like the kubelet expects
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.Handle("GET /metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	// Resumable uploads for artifacts too large to send in one request
+	mux.HandleFunc("POST /api/v1/uploads", s.handleCreateUpload)
+	mux.HandleFunc("GET /api/v1/uploads/{id}", s.handleUploadStatus)
+	mux.HandleFunc("PUT /api/v1/uploads/{id}", s.handleUploadChunk)
+	mux.HandleFunc("DELETE /api/v1/uploads/{id}", s.handleAbortUpload)
+	mux.HandleFunc("POST /api/v1/uploads/{id}/complete", s.handleCompleteUpload)
+
+	// Reading stored artifacts
+	mux.HandleFunc("GET /api/v1/artifacts", s.handleListArtifacts)
+	mux.HandleFunc("GET /api/v1/artifacts/{key...}", s.handleGetArtifact)
+	mux.HandleFunc("POST /api/v1/links", s.handleCreateLink)
+	return mux
+}
+
 func (s *Server) Start() error {
-	log.Printf("Starting server on port %d", s.config.Port)
+	if s.uploads != nil {
+		go s.uploads.Start(s.sweeperCtx)
+	}
+	if s.retention != nil {
+		go s.retention.Start(s.sweeperCtx)
+	}
+	if s.rewrap != nil {
+		go s.rewrapDataKeys(s.sweeperCtx)
+	}
+	if s.rebuildIndex != nil {
+		go s.rebuildArtifactIndex(s.sweeperCtx)
+	}
+
+	log.Printf("Starting server on %s", s.server.Addr)
 	if s.config.TLSCert != "" && s.config.TLSKey != "" {
-		return s.server.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
+		watcher, err := s.watchCertificate()
+		if err != nil {
+			return err
+		}
+		tlsConfig := &tls.Config{}
+		if s.server.TLSConfig != nil {
+			tlsConfig = s.server.TLSConfig.Clone()
+		}
+		tlsConfig.GetCertificate = watcher.GetCertificate
+		s.server.TLSConfig = tlsConfig
+		// The certificate comes from GetCertificate, not from files
+		return s.server.ListenAndServeTLS("", "")
 	}
 	return s.server.ListenAndServe()
 }
 
-func (s *Server) Shutdown() error {
-	return s.server.Shutdown(context.Background())
+// rewrapDataKeys moves artifacts sealed under old master keys to the primary
+// key after a rotation. Old keys can be removed once it reports none left.
+func (s *Server) rewrapDataKeys(ctx context.Context) {
+	result, err := s.rewrap(ctx)
+	if err != nil {
+		log.Printf("Re-sealing data keys failed: re-sealed %d, %d artifacts still under old keys: %v",
+			result.Rewrapped, result.Remaining, err)
+		return
+	}
+	log.Printf("Re-sealed %d data keys with the primary master key, %d artifacts still under old keys",
+		result.Rewrapped, result.Remaining)
+}
+
+// rebuildArtifactIndex indexes what is in storage, after the index was lost
+// or the collector did not shut down cleanly. Listings read sidecars until it
+// is done, and the next start tries again if it fails.
+func (s *Server) rebuildArtifactIndex(ctx context.Context) {
+	start := time.Now()
+	n, err := s.rebuildIndex(ctx)
+	if err != nil {
+		log.Printf("Rebuilding the artifact index failed after %d artifacts: %v", n, err)
+		return
+	}
+	log.Printf("Rebuilt the artifact index from storage: %d artifacts in %s", n, time.Since(start).Round(time.Millisecond))
+}
+
+// watchCertificate loads the serving certificate and reloads it whenever
+// its files change, as when cert-manager renews the Secret they are mounted
+// from. New handshakes get the new certificate; open connections are kept.
+func (s *Server) watchCertificate() (*certwatcher.CertWatcher, error) {
+	watcher, err := certwatcher.New(s.config.TLSCert, s.config.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	watcher.RegisterCallback(func(cert tls.Certificate) {
+		leaf := cert.Leaf
+		if leaf == nil {
+			var parseErr error
+			if leaf, parseErr = x509.ParseCertificate(cert.Certificate[0]); parseErr != nil {
+				log.Printf("Loaded TLS certificate that cannot be parsed: %v", parseErr)
+				return
+			}
+		}
+		metrics.TLSCertificateExpiry.Set(float64(leaf.NotAfter.Unix()))
+		log.Printf("Serving TLS certificate %s, valid until %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	})
+	go func() {
+		if err := watcher.Start(s.sweeperCtx); err != nil {
+			log.Printf("TLS certificate watcher stopped: %v", err)
+		}
+	}()
+	return watcher, nil
+}
+
+// Shutdown stops accepting requests and waits for those in flight until ctx
+// is done. Connections still open then, such as stuck uploads, are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.stopSweeper != nil {
+		s.stopSweeper()
+	}
+	defer func() {
+		if err := s.index.Close(); err != nil {
+			log.Printf("Failed to close artifact index: %v", err)
+		}
+	}()
+	err := s.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		if closeErr := s.server.Close(); closeErr != nil {
+			return fmt.Errorf("failed to close connections: %w", closeErr)
+		}
+		return fmt.Errorf("requests still in flight at shutdown deadline were aborted: %w", err)
+	}
+	return err
 }
 
 func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
@@ -69,30 +569,109 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract token from Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
+	userInfo, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := storage.NormalizeSHA256(r.Header.Get(headerSHA256)); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid %s header", headerSHA256), http.StatusBadRequest)
+		return
+	}
+
+	metadata, ok := s.authorizedMetadata(w, r, userInfo)
+	if !ok {
+		return
+	}
+	if !s.admitUpload(w, metadata, 0, r.ContentLength) {
 		return
 	}
-	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if s.maxArtifactBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxArtifactBytes)
+	}
 
-	// Validate token
-	userInfo, err := s.auth.ValidateToken(r.Context(), token)
+	body := &requestBody{req: r, received: metrics.BytesReceived.WithLabelValues(metadata.Namespace)}
+	artifact, err := s.storage.SaveProfile(r.Context(), body, metadata)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		writeSaveError(w, metadata.Namespace, err)
 		return
 	}
 
+	s.recordArtifact(r.Context(), artifact)
+	writeArtifact(w, artifact)
+}
+
+// requestBody exposes the digest the client sent, from the header or, once
+// the body has been read, from the trailer
+type requestBody struct {
+	req      *http.Request
+	received prometheus.Counter
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.req.Body.Read(p)
+	b.received.Add(float64(n))
+	return n, err
+}
+
+func (b *requestBody) ExpectedSHA256() string {
+	if digest := b.req.Header.Get(headerSHA256); digest != "" {
+		return digest
+	}
+	return b.req.Trailer.Get(headerSHA256)
+}
+
+// digestReader pairs stored upload data with the digest it was declared with
+type digestReader struct {
+	io.Reader
+	sha256 string
+}
+
+func (d *digestReader) ExpectedSHA256() string {
+	return d.sha256
+}
+
+// authenticate identifies the uploader, writing a 401 when no credentials
+// are presented or they are rejected
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*authv1.UserInfo, bool) {
+	return authenticateWith(s.auth, endpointUpload, w, r)
+}
+
+// Endpoint labels of metrics.AuthFailures
+const (
+	endpointUpload = "upload"
+	endpointRead   = "read"
+)
+
+func authenticateWith(authenticator Authenticator, endpoint string, w http.ResponseWriter, r *http.Request) (*authv1.UserInfo, bool) {
+	userInfo, ok, err := authenticator.AuthenticateRequest(r)
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues(endpoint, metrics.AuthInvalidToken).Inc()
+		http.Error(w, fmt.Sprintf("Invalid credentials: %v", err), http.StatusUnauthorized)
+		return nil, false
+	}
+	if !ok {
+		metrics.AuthFailures.WithLabelValues(endpoint, metrics.AuthMissingToken).Inc()
+		http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
+		return nil, false
+	}
+	return userInfo, true
+}
+
+// authorizedMetadata reads the upload metadata headers, validates them and
+// checks them against the referenced PowerTool, writing the error response on
+// failure
+func (s *Server) authorizedMetadata(w http.ResponseWriter, r *http.Request, userInfo *authv1.UserInfo) (storage.ProfileMetadata, bool) {
 	// Extract metadata from headers
 	namespace := r.Header.Get("X-PowerTool-Namespace")
 	matchingLabels := r.Header.Get("X-PowerTool-Matching-Labels")
 	powerToolName := r.Header.Get("X-PowerTool-Job-ID")
 	filename := r.Header.Get("X-PowerTool-Filename")
+	podName := r.Header.Get("X-PowerTool-Pod-Name")
 
-	if namespace == "" || powerToolName == "" {
+	if namespace == "" || powerToolName == "" || podName == "" {
 		http.Error(w, "Missing required headers", http.StatusBadRequest)
-		return
+		return storage.ProfileMetadata{}, false
 	}
 
 	if matchingLabels == "" {
@@ -103,20 +682,258 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 		filename = fmt.Sprintf("%s.profile", powerToolName)
 	}
 
+	var retentionDays int32
+	if v := r.Header.Get(headerRetentionDays); v != "" {
+		days, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || days < 0 {
+			http.Error(w, fmt.Sprintf("Invalid %s header", headerRetentionDays), http.StatusBadRequest)
+			return storage.ProfileMetadata{}, false
+		}
+		retentionDays = int32(days)
+	}
+
+	segment, err := parseSegment(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return storage.ProfileMetadata{}, false
+	}
+
+	encoding, err := storage.ParseEncoding(r.Header.Get("Content-Encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return storage.ProfileMetadata{}, false
+	}
+
 	metadata := storage.ProfileMetadata{
 		Namespace:     namespace,
 		AppLabel:      matchingLabels,
 		PowerToolName: powerToolName,
+		PodName:       podName,
 		Filename:      filename,
+		RetentionDays: retentionDays,
+		Encoding:      encoding,
+		Segment:       segment,
+	}
+	if err := metadata.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return storage.ProfileMetadata{}, false
 	}
 
-	log.Printf("Authenticated request from %s for job %s, saving to %s/%s/%s",
-		userInfo.Username, powerToolName, namespace, matchingLabels, powerToolName)
+	target, ok := s.authorize(r.Context(), w, userInfo, metadata)
+	if !ok {
+		return storage.ProfileMetadata{}, false
+	}
+	metadata = withUploadTarget(metadata, target)
 
-	if err := s.storage.SaveProfile(r.Body, metadata); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to save profile: %v", err), http.StatusInternalServerError)
+	log.Printf("Authorized request from %s for job %s pod %s, saving to %s/%s/%s",
+		userInfo.Username, powerToolName, podName, namespace, matchingLabels, powerToolName)
+
+	return metadata, true
+}
+
+// authorize ties an upload to a live PowerTool and one of its target pods; the
+// token alone only proves the caller holds a collector token
+func (s *Server) authorize(ctx context.Context, w http.ResponseWriter, userInfo *authv1.UserInfo, metadata storage.ProfileMetadata) (*auth.UploadTarget, bool) {
+	var target *auth.UploadTarget
+	err := auth.CheckUploadScope(userInfo, metadata.Namespace, metadata.PowerToolName)
+	if err == nil {
+		target, err = s.authorizer.AuthorizeUpload(ctx, auth.UploadRequest{
+			Namespace:     metadata.Namespace,
+			PowerToolName: metadata.PowerToolName,
+			PodName:       metadata.PodName,
+		})
+	}
+	if err == nil {
+		return target, true
+	}
+
+	if errors.Is(err, auth.ErrUploadForbidden) {
+		log.Printf("Rejected upload from %s: %v", userInfo.Username, err)
+		metrics.AuthFailures.WithLabelValues(endpointUpload, metrics.AuthForbidden).Inc()
+		metrics.UploadsTotal.WithLabelValues(metadata.Namespace, metrics.OutcomeForbidden).Inc()
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	metrics.AuthFailures.WithLabelValues(endpointUpload, metrics.AuthError).Inc()
+	metrics.UploadsTotal.WithLabelValues(metadata.Namespace, metrics.OutcomeError).Inc()
+	log.Printf("Failed to authorize upload from %s: %v", userInfo.Username, err)
+	http.Error(w, "Failed to authorize upload", http.StatusInternalServerError)
+	return nil, false
+}
+
+// parseSegment reads the segment headers, returning nil for whole uploads
+func parseSegment(h http.Header) (*storage.Segment, error) {
+	index := h.Get(headerSegment)
+	final := h.Get(headerSegmentFinal)
+	if index == "" {
+		if final != "" {
+			return nil, fmt.Errorf("%s requires %s", headerSegmentFinal, headerSegment)
+		}
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 || n > storage.MaxSegmentIndex {
+		return nil, fmt.Errorf("invalid %s header", headerSegment)
+	}
+	segment := &storage.Segment{Index: n}
+	if final != "" {
+		if segment.Final, err = strconv.ParseBool(final); err != nil {
+			return nil, fmt.Errorf("invalid %s header", headerSegmentFinal)
+		}
+	}
+	return segment, nil
+}
+
+// withUploadTarget applies what authorizing an upload found out: the output
+// settings of its PowerTool and where it came from
+func withUploadTarget(metadata storage.ProfileMetadata, target *auth.UploadTarget) storage.ProfileMetadata {
+	if target == nil {
+		return metadata
+	}
+	return withSource(withOutputSettings(metadata, target.PowerTool), target)
+}
+
+// withSource records the node, labels and container of the target pod and the
+// tool that ran, as looked up rather than as the tool reports them. The
+// container is the one the controller pointed the tool at.
+func withSource(metadata storage.ProfileMetadata, target *auth.UploadTarget) storage.ProfileMetadata {
+	if powerTool := target.PowerTool; powerTool != nil {
+		metadata.Tool = powerTool.Spec.Tool.Name
+		if c := powerTool.Spec.Targets.Container; c != nil {
+			metadata.ContainerName = *c
+		}
+	}
+	if pod := target.Pod; pod != nil {
+		metadata.NodeName = pod.Spec.NodeName
+		metadata.Labels = pod.Labels
+		if metadata.ContainerName == "" && len(pod.Spec.Containers) > 0 {
+			metadata.ContainerName = pod.Spec.Containers[0].Name
+		}
+	}
+	return metadata
+}
+
+// withOutputSettings applies the PowerTool's output settings to an upload. The
+// retention it declares wins over whatever the tool passed along, and segments
+// are filed under the date the PowerTool started.
+func withOutputSettings(metadata storage.ProfileMetadata, powerTool *toev1alpha1.PowerTool) storage.ProfileMetadata {
+	if powerTool == nil {
+		return metadata
+	}
+	output := powerTool.Spec.Output
+	if output.RetentionDays != nil && *output.RetentionDays > 0 {
+		metadata.RetentionDays = *output.RetentionDays
+	}
+	if output.Compress != nil {
+		compress, err := storage.ParseEncoding(*output.Compress)
+		if err != nil {
+			// Losing the profile over a typo would be worse than storing it
+			// uncompressed
+			log.Printf("Ignoring compression setting of PowerTool %s/%s: %v", powerTool.Namespace, powerTool.Name, err)
+		}
+		metadata.Compress = compress
+	}
+	if metadata.Segment != nil {
+		segment := *metadata.Segment
+		segment.Started = powerTool.CreationTimestamp.Time
+		if powerTool.Status.StartedAt != nil {
+			segment.Started = powerTool.Status.StartedAt.Time
+		}
+		metadata.Segment = &segment
+	}
+	return metadata
+}
+
+// admitUpload refuses, before any of it is read, an upload whose declared
+// length would take it past the size limit or its namespace or PowerTool past
+// their quota. offset is what earlier chunks already brought. Uploads of
+// unknown length are admitted and limited while they are read.
+func (s *Server) admitUpload(w http.ResponseWriter, metadata storage.ProfileMetadata, offset, length int64) bool {
+	if length <= 0 {
+		return true
+	}
+	if s.maxArtifactBytes > 0 && offset+length > s.maxArtifactBytes {
+		metrics.UploadsTotal.WithLabelValues(metadata.Namespace, metrics.OutcomeTooLarge).Inc()
+		http.Error(w, fmt.Sprintf("Upload of %d bytes exceeds the maximum artifact size of %d bytes", offset+length, s.maxArtifactBytes),
+			http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if err := s.quotas.Check(metadata.Namespace, metadata.PowerToolName, offset+length); err != nil {
+		metrics.UploadsTotal.WithLabelValues(metadata.Namespace, metrics.OutcomeQuotaExceeded).Inc()
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// writeSaveError answers an upload to namespace that could not be stored
+func writeSaveError(w http.ResponseWriter, namespace string, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		metrics.UploadsTotal.WithLabelValues(namespace, metrics.OutcomeTooLarge).Inc()
+		http.Error(w, fmt.Sprintf("Upload exceeds the maximum artifact size of %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		metrics.UploadsTotal.WithLabelValues(namespace, metrics.OutcomeQuotaExceeded).Inc()
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, storage.ErrInvalidMetadata):
+		metrics.UploadsTotal.WithLabelValues(namespace, metrics.OutcomeInvalid).Inc()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, storage.ErrDigestMismatch):
+		metrics.UploadsTotal.WithLabelValues(namespace, metrics.OutcomeDigestMismatch).Inc()
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, storage.ErrSegmentConflict):
+		metrics.UploadsTotal.WithLabelValues(namespace, metrics.OutcomeConflict).Inc()
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
+	metrics.UploadsTotal.WithLabelValues(namespace, metrics.OutcomeError).Inc()
+	http.Error(w, fmt.Sprintf("Failed to save profile: %v", err), http.StatusInternalServerError)
+}
+
+// statusUpdateTimeout bounds recording an artifact in PowerTool status, which
+// holds up the upload response
+const statusUpdateTimeout = 10 * time.Second
 
+// recordArtifact adds a newly stored artifact to its PowerTool's status.
+// Failures are logged but do not fail the upload: the artifact is safely
+// stored, and the controller fills in the status from the artifact listing
+// once the run completes.
+func (s *Server) recordArtifact(ctx context.Context, artifact *storage.Artifact) {
+	if s.status == nil || artifact.Deduplicated {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusUpdateTimeout)
+	defer cancel()
+	if err := s.status.RecordArtifact(ctx, artifact); err != nil {
+		log.Printf("Failed to record artifact in PowerTool status: %v", err)
+		metrics.StatusUpdateFailures.WithLabelValues(artifact.Namespace).Inc()
+	}
+}
+
+func writeArtifact(w http.ResponseWriter, artifact *storage.Artifact) {
+	if artifact.Deduplicated {
+		log.Printf("Upload matches existing artifact %s, nothing written", artifact.Path)
+		metrics.UploadsTotal.WithLabelValues(artifact.Namespace, metrics.OutcomeDeduplicated).Inc()
+	} else {
+		log.Printf("Stored artifact %s (%d bytes, sha256 %s)", artifact.Path, artifact.Size, artifact.UploadSHA256())
+		metrics.UploadsTotal.WithLabelValues(artifact.Namespace, metrics.OutcomeStored).Inc()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(artifactResponse{
+		Path:         artifact.Path,
+		Size:         artifact.Size,
+		SHA256:       artifact.UploadSHA256(),
+		Deduplicated: artifact.Deduplicated,
+		Segment:      artifact.Segment,
+	}); err != nil {
+		log.Printf("Failed to write artifact response: %v", err)
+	}
 }
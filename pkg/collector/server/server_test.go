@@ -1,31 +1,80 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/pkg/collector/auth"
 	"toe/pkg/collector/storage"
 
 	authv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 // Mock implementations
 type mockStorage struct {
-	saveProfileFunc func(io.Reader, storage.ProfileMetadata) error
+	saveProfileFunc   func(io.Reader, storage.ProfileMetadata) error
+	listArtifactsFunc func(storage.ArtifactFilter) ([]storage.Artifact, error)
 }
 
-func (m *mockStorage) SaveProfile(r io.Reader, metadata storage.ProfileMetadata) error {
+func (m *mockStorage) SaveProfile(ctx context.Context, r io.Reader, metadata storage.ProfileMetadata) (*storage.Artifact, error) {
 	if m.saveProfileFunc != nil {
-		return m.saveProfileFunc(r, metadata)
+		if err := m.saveProfileFunc(r, metadata); err != nil {
+			return nil, err
+		}
+	}
+	return &storage.Artifact{ProfileMetadata: metadata}, nil
+}
+
+func (m *mockStorage) ListArtifacts(ctx context.Context, filter storage.ArtifactFilter) ([]storage.Artifact, error) {
+	if m.listArtifactsFunc != nil {
+		return m.listArtifactsFunc(filter)
 	}
-	return nil
+	return nil, nil
+}
+
+func (m *mockStorage) Artifact(ctx context.Context, key string) (*storage.Artifact, error) {
+	return nil, fs.ErrNotExist
+}
+
+func (m *mockStorage) OpenArtifact(ctx context.Context, key string) (*storage.Artifact, io.ReadSeekCloser, error) {
+	return nil, nil, fs.ErrNotExist
+}
+
+func (m *mockStorage) PathTemplate() *storage.PathTemplate {
+	layout, err := storage.ParsePathTemplate(storage.DefaultPathTemplate, "2006-01-02")
+	if err != nil {
+		panic(err)
+	}
+	return layout
 }
 
 type mockAuth struct {
@@ -39,6 +88,29 @@ func (m *mockAuth) ValidateToken(ctx context.Context, token string) (*authv1.Use
 	return &authv1.UserInfo{Username: "test-user"}, nil
 }
 
+func (m *mockAuth) AuthenticateRequest(r *http.Request) (*authv1.UserInfo, bool, error) {
+	return auth.NewBearerTokenAuthenticator(m).AuthenticateRequest(r)
+}
+
+type mockAuthorizer struct {
+	authorizeUploadFunc func(context.Context, auth.UploadRequest) error
+	powerTool           *toev1alpha1.PowerTool
+	pod                 *corev1.Pod
+}
+
+func (m *mockAuthorizer) AuthorizeUpload(ctx context.Context, req auth.UploadRequest) (*auth.UploadTarget, error) {
+	if m.authorizeUploadFunc != nil {
+		if err := m.authorizeUploadFunc(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	target := &auth.UploadTarget{PowerTool: &toev1alpha1.PowerTool{}, Pod: m.pod}
+	if m.powerTool != nil {
+		target.PowerTool = m.powerTool
+	}
+	return target, nil
+}
+
 func TestNewServer(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -67,12 +139,88 @@ func TestNewServer(t *testing.T) {
 			wantErr: true,
 			errMsg:  "dateFormat is required",
 		},
+		{
+			name: "s3 backend",
+			config: &Config{
+				Port:           8443,
+				StoragePath:    t.TempDir(),
+				DateFormat:     "2006/01/02",
+				StorageBackend: storage.BackendS3,
+				S3: storage.S3Config{
+					Endpoint:        "http://minio:9000",
+					Bucket:          "profiles",
+					AccessKeyID:     "key",
+					SecretAccessKey: "secret",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "s3 backend without bucket",
+			config: &Config{
+				Port:           8443,
+				StoragePath:    t.TempDir(),
+				DateFormat:     "2006/01/02",
+				StorageBackend: storage.BackendS3,
+				S3:             storage.S3Config{Endpoint: "http://minio:9000"},
+			},
+			wantErr: true,
+			errMsg:  "bucket",
+		},
+		{
+			name: "unknown backend",
+			config: &Config{
+				Port:           8443,
+				StoragePath:    t.TempDir(),
+				DateFormat:     "2006/01/02",
+				StorageBackend: "tape",
+			},
+			wantErr: true,
+			errMsg:  "unknown storage backend",
+		},
+		{
+			name: "unknown authenticator",
+			config: &Config{
+				Port:           8443,
+				StoragePath:    t.TempDir(),
+				DateFormat:     "2006/01/02",
+				Authenticators: []string{AuthenticatorTokenReview, "oidc"},
+			},
+			wantErr: true,
+			errMsg:  `unknown authenticator "oidc"`,
+		},
+		{
+			name: "client certificates without TLS",
+			config: &Config{
+				Port:           8443,
+				StoragePath:    t.TempDir(),
+				DateFormat:     "2006/01/02",
+				Authenticators: []string{AuthenticatorClientCert},
+				ClientCAFile:   "/path/to/ca",
+			},
+			wantErr: true,
+			errMsg:  "requires TLS",
+		},
+		{
+			name: "client certificates with unreadable CA",
+			config: &Config{
+				Port:           8443,
+				StoragePath:    t.TempDir(),
+				DateFormat:     "2006/01/02",
+				TLSCert:        "/path/to/cert",
+				TLSKey:         "/path/to/key",
+				Authenticators: []string{AuthenticatorClientCert, AuthenticatorTokenReview},
+				ClientCAFile:   "/nonexistent/ca.pem",
+			},
+			wantErr: true,
+			errMsg:  "failed to read client CA",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			k8sClient := fake.NewSimpleClientset()
-			srv, err := NewServer(tt.config, k8sClient)
+			srv, err := NewServer(tt.config, k8sClient, ctrlfake.NewClientBuilder().Build())
 
 			if tt.wantErr {
 				if err == nil {
@@ -108,7 +256,7 @@ func TestHandleProfile_MethodNotAllowed(t *testing.T) {
 		DateFormat:  "2006/01/02",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -136,7 +284,7 @@ func TestHandleProfile_MissingAuthHeader(t *testing.T) {
 		DateFormat:  "2006/01/02",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -159,7 +307,7 @@ func TestHandleProfile_InvalidAuthHeader(t *testing.T) {
 		DateFormat:  "2006/01/02",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -196,7 +344,7 @@ func TestHandleProfile_MissingRequiredHeaders(t *testing.T) {
 		DateFormat:  "2006/01/02",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -250,7 +398,7 @@ func TestHandleProfile_LargeFile(t *testing.T) {
 		DateFormat:  "2006/01/02",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -316,8 +464,9 @@ func TestHandleProfile_Success(t *testing.T) {
 	}
 
 	srv := &Server{
-		storage: mockStorage,
-		auth:    mockAuth,
+		storage:    mockStorage,
+		auth:       mockAuth,
+		authorizer: &mockAuthorizer{},
 	}
 
 	body := bytes.NewBufferString("test profile data")
@@ -325,6 +474,7 @@ func TestHandleProfile_Success(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer valid-token")
 	req.Header.Set("X-PowerTool-Job-ID", "test-job")
 	req.Header.Set("X-PowerTool-Namespace", "default")
+	req.Header.Set("X-PowerTool-Pod-Name", "nginx-1")
 	req.Header.Set("X-PowerTool-Matching-Labels", "app-nginx")
 	req.Header.Set("X-PowerTool-Filename", "output.txt")
 
@@ -353,8 +503,9 @@ func TestHandleProfile_DefaultValues(t *testing.T) {
 	mockAuth := &mockAuth{}
 
 	srv := &Server{
-		storage: mockStorage,
-		auth:    mockAuth,
+		storage:    mockStorage,
+		auth:       mockAuth,
+		authorizer: &mockAuthorizer{},
 	}
 
 	body := bytes.NewBufferString("test data")
@@ -362,6 +513,7 @@ func TestHandleProfile_DefaultValues(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer token")
 	req.Header.Set("X-PowerTool-Job-ID", "test-job")
 	req.Header.Set("X-PowerTool-Namespace", "default")
+	req.Header.Set("X-PowerTool-Pod-Name", "test-pod")
 	// Missing X-PowerTool-Matching-Labels and X-PowerTool-Filename
 
 	rr := httptest.NewRecorder()
@@ -383,8 +535,9 @@ func TestHandleProfile_StorageError(t *testing.T) {
 	mockAuth := &mockAuth{}
 
 	srv := &Server{
-		storage: mockStorage,
-		auth:    mockAuth,
+		storage:    mockStorage,
+		auth:       mockAuth,
+		authorizer: &mockAuthorizer{},
 	}
 
 	body := bytes.NewBufferString("test data")
@@ -392,6 +545,7 @@ func TestHandleProfile_StorageError(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer token")
 	req.Header.Set("X-PowerTool-Job-ID", "test-job")
 	req.Header.Set("X-PowerTool-Namespace", "default")
+	req.Header.Set("X-PowerTool-Pod-Name", "test-pod")
 	req.Header.Set("X-PowerTool-Matching-Labels", "app-test")
 
 	rr := httptest.NewRecorder()
@@ -403,6 +557,147 @@ func TestHandleProfile_StorageError(t *testing.T) {
 	}
 }
 
+func TestHandleProfile_Authorization(t *testing.T) {
+	tests := []struct {
+		name        string
+		authErr     error
+		wantStatus  int
+		wantStorage bool
+	}{
+		{name: "authorized", wantStatus: http.StatusOK, wantStorage: true},
+		{
+			name:       "forbidden",
+			authErr:    fmt.Errorf("%w: pod default/web-1 is not a target", auth.ErrUploadForbidden),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "lookup failure",
+			authErr:    errors.New("connection refused"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := false
+			var gotReq auth.UploadRequest
+			srv := &Server{
+				storage: &mockStorage{
+					saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+						saved = true
+						return nil
+					},
+				},
+				auth: &mockAuth{},
+				authorizer: &mockAuthorizer{
+					authorizeUploadFunc: func(ctx context.Context, req auth.UploadRequest) error {
+						gotReq = req
+						return tt.authErr
+					},
+				},
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", "test-job")
+			req.Header.Set("X-PowerTool-Namespace", "default")
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(srv.handleProfile).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if saved != tt.wantStorage {
+				t.Errorf("storage called = %v, want %v", saved, tt.wantStorage)
+			}
+			want := auth.UploadRequest{Namespace: "default", PowerToolName: "test-job", PodName: "web-1"}
+			if gotReq != want {
+				t.Errorf("authorizer got %+v, want %+v", gotReq, want)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_ScopedIdentity(t *testing.T) {
+	// A client certificate limited to team namespaces and nightly PowerTools
+	scoped := &mockAuth{
+		validateTokenFunc: func(ctx context.Context, token string) (*authv1.UserInfo, error) {
+			return &authv1.UserInfo{
+				Username: "x509:ci-runner",
+				Extra: map[string]authv1.ExtraValue{
+					auth.ExtraUploadNamespaces: {"team-*"},
+					auth.ExtraUploadPowerTools: {"nightly-*"},
+				},
+			}, nil
+		},
+	}
+
+	tests := []struct {
+		name       string
+		namespace  string
+		powerTool  string
+		wantStatus int
+	}{
+		{"within scope", "team-a", "nightly-cpu", http.StatusOK},
+		{"other namespace", "default", "nightly-cpu", http.StatusForbidden},
+		{"other PowerTool", "team-a", "adhoc", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorized := false
+			srv := &Server{
+				storage: &mockStorage{},
+				auth:    scoped,
+				authorizer: &mockAuthorizer{
+					authorizeUploadFunc: func(ctx context.Context, req auth.UploadRequest) error {
+						authorized = true
+						return nil
+					},
+				},
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", tt.powerTool)
+			req.Header.Set("X-PowerTool-Namespace", tt.namespace)
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(srv.handleProfile).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			// Uploads outside the scope are refused before the PowerTool is looked up
+			if authorized != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("PowerTool authorization ran = %v", authorized)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_MissingPodName(t *testing.T) {
+	srv := &Server{
+		storage:    &mockStorage{},
+		auth:       &mockAuth{},
+		authorizer: &mockAuthorizer{},
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-PowerTool-Job-ID", "test-job")
+	req.Header.Set("X-PowerTool-Namespace", "default")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.handleProfile).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
+
 func TestHandleProfile_TokenValidationError(t *testing.T) {
 	mockAuth := &mockAuth{
 		validateTokenFunc: func(ctx context.Context, token string) (*authv1.UserInfo, error) {
@@ -434,7 +729,7 @@ func TestStart_HTTP(t *testing.T) {
 		// No TLS cert/key - will use HTTP
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -449,7 +744,7 @@ func TestStart_HTTP(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	// Shutdown server
-	_ = srv.Shutdown()
+	_ = srv.Shutdown(context.Background())
 
 	// Check if Start returned (should return after shutdown)
 	select {
@@ -471,7 +766,7 @@ func TestStart_TLS(t *testing.T) {
 		TLSKey:      "/nonexistent/key.pem",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -483,6 +778,120 @@ func TestStart_TLS(t *testing.T) {
 	}
 }
 
+// writeServingCert writes a self-signed key pair with the given serial to
+// dir, returning the certificate and key paths
+func writeServingCert(t *testing.T, dir string, serial int64) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "toe-collector.toe-system.svc"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	return certPath, keyPath
+}
+
+func TestStart_TLSReload(t *testing.T) {
+	certDir := t.TempDir()
+	certPath, keyPath := writeServingCert(t, certDir, 1)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv, err := NewServer(&Config{
+		Address:     addr,
+		StoragePath: t.TempDir(),
+		DateFormat:  "2006/01/02",
+		TLSCert:     certPath,
+		TLSKey:      keyPath,
+	}, fake.NewSimpleClientset(), ctrlfake.NewClientBuilder().Build())
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	go func() { _ = srv.Start() }()
+	defer func() { _ = srv.Shutdown(context.Background()) }()
+
+	dial := func() (*tls.Conn, error) {
+		return tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	}
+	serial := func(conn *tls.Conn) int64 {
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	var before *tls.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if before, err = dial(); err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer before.Close()
+	if got := serial(before); got != 1 {
+		t.Fatalf("serving certificate serial = %d, want 1", got)
+	}
+
+	// Renew the certificate in place, as a Secret volume update does
+	writeServingCert(t, certDir, 2)
+
+	var got int64
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		conn, err := dial()
+		if err != nil {
+			continue
+		}
+		got = serial(conn)
+		conn.Close()
+		if got == 2 {
+			break
+		}
+	}
+	if got != 2 {
+		t.Fatalf("serving certificate serial after renewal = %d, want 2", got)
+	}
+
+	// The connection made before the renewal is still served
+	if _, err := before.Write([]byte("GET /healthz HTTP/1.1\r\nHost: collector\r\n\r\n")); err != nil {
+		t.Fatalf("failed to write on existing connection: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(before), nil)
+	if err != nil {
+		t.Fatalf("failed to read response on existing connection: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz on existing connection = %d, want 200", resp.StatusCode)
+	}
+}
+
 func TestShutdown(t *testing.T) {
 	config := &Config{
 		Port:        0,
@@ -490,7 +899,7 @@ func TestShutdown(t *testing.T) {
 		DateFormat:  "2006/01/02",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -503,13 +912,13 @@ func TestShutdown(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	// Shutdown should not panic
-	err = srv.Shutdown()
+	err = srv.Shutdown(context.Background())
 	if err != nil && err != http.ErrServerClosed {
 		t.Errorf("Shutdown() unexpected error = %v", err)
 	}
 
 	// Multiple shutdowns should not panic
-	err = srv.Shutdown()
+	err = srv.Shutdown(context.Background())
 	// Second shutdown may or may not return error, just verify no panic
 }
 
@@ -520,13 +929,661 @@ func TestShutdown_WithoutStart(t *testing.T) {
 		DateFormat:  "2006/01/02",
 	}
 	k8sClient := fake.NewSimpleClientset()
-	srv, err := NewServer(config, k8sClient)
+	srv, err := NewServer(config, k8sClient, ctrlfake.NewClientBuilder().Build())
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
 
 	// Shutdown without starting should not panic
-	err = srv.Shutdown()
+	err = srv.Shutdown(context.Background())
 	// May or may not return error, just verify no panic
 	_ = err
 }
+
+func TestShutdown_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	srv := &Server{
+		server: &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// A stuck upload
+				close(started)
+				<-release
+			}),
+		},
+	}
+	go func() {
+		_ = srv.server.Serve(ln)
+	}()
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown() error = %v, want deadline exceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() did not return after its deadline")
+	}
+}
+
+func TestConfigAddress(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{"port only", Config{Port: 8443}, ":8443"},
+		{"address wins", Config{Address: "127.0.0.1:9443", Port: 8443}, "127.0.0.1:9443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.address(); got != tt.want {
+				t.Errorf("address() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_InvalidMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"namespace traversal", "X-PowerTool-Namespace", "../../etc"},
+		{"job ID traversal", "X-PowerTool-Job-ID", "../job"},
+		{"absolute filename", "X-PowerTool-Filename", "/etc/passwd"},
+		{"label with separator", "X-PowerTool-Matching-Labels", "app/../../x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorized := false
+			srv := &Server{
+				storage: &mockStorage{
+					saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+						t.Error("storage must not be called for invalid metadata")
+						return nil
+					},
+				},
+				auth: &mockAuth{},
+				authorizer: &mockAuthorizer{
+					authorizeUploadFunc: func(ctx context.Context, req auth.UploadRequest) error {
+						authorized = true
+						return nil
+					},
+				},
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", "test-job")
+			req.Header.Set("X-PowerTool-Namespace", "default")
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+			req.Header.Set(tt.header, tt.value)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(srv.handleProfile).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", rr.Code)
+			}
+			if authorized {
+				t.Error("invalid metadata should be rejected before authorization")
+			}
+		})
+	}
+}
+
+func TestHandleProfile_Digest(t *testing.T) {
+	sum := sha256.Sum256([]byte("data"))
+	digest := hex.EncodeToString(sum[:])
+	other := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name       string
+		header     string
+		trailer    string
+		wantStatus int
+	}{
+		{name: "no digest", wantStatus: http.StatusOK},
+		{name: "matching header", header: digest, wantStatus: http.StatusOK},
+		{name: "matching trailer", trailer: digest, wantStatus: http.StatusOK},
+		{name: "mismatched header", header: other, wantStatus: http.StatusUnprocessableEntity},
+		{name: "mismatched trailer", trailer: other, wantStatus: http.StatusUnprocessableEntity},
+		{name: "malformed header", header: "not-a-digest", wantStatus: http.StatusBadRequest},
+		{name: "malformed trailer", trailer: "not-a-digest", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			srv := &Server{
+				storage:    mgr,
+				auth:       &mockAuth{},
+				authorizer: &mockAuthorizer{},
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", "test-job")
+			req.Header.Set("X-PowerTool-Namespace", "default")
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+			if tt.header != "" {
+				req.Header.Set("X-PowerTool-SHA256", tt.header)
+			}
+			if tt.trailer != "" {
+				req.Trailer = http.Header{"X-Powertool-Sha256": {tt.trailer}}
+			}
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(srv.handleProfile).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var resp artifactResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if resp.SHA256 != digest || resp.Size != 4 || !strings.HasSuffix(resp.Path, "/web-1_test-job.profile") {
+				t.Errorf("response = %+v", resp)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_RetentionDays(t *testing.T) {
+	declared := int32(7)
+	tests := []struct {
+		name       string
+		header     string
+		powerTool  *toev1alpha1.PowerTool
+		wantStatus int
+		wantDays   int32
+	}{
+		{
+			name:       "none declared",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "passed by the tool",
+			header:     "30",
+			wantStatus: http.StatusOK,
+			wantDays:   30,
+		},
+		{
+			name:   "PowerTool wins over the tool",
+			header: "30",
+			powerTool: &toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{
+				Output: toev1alpha1.OutputSpec{RetentionDays: &declared},
+			}},
+			wantStatus: http.StatusOK,
+			wantDays:   7,
+		},
+		{
+			name:       "invalid header",
+			header:     "-1",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got storage.ProfileMetadata
+			srv := &Server{
+				storage: &mockStorage{
+					saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+						got = metadata
+						return nil
+					},
+				},
+				auth:       &mockAuth{},
+				authorizer: &mockAuthorizer{powerTool: tt.powerTool},
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", "test-job")
+			req.Header.Set("X-PowerTool-Namespace", "default")
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+			if tt.header != "" {
+				req.Header.Set(headerRetentionDays, tt.header)
+			}
+
+			rr := httptest.NewRecorder()
+			srv.handleProfile(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if got.RetentionDays != tt.wantDays {
+				t.Errorf("stored RetentionDays = %d, want %d", got.RetentionDays, tt.wantDays)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_Source(t *testing.T) {
+	sidecar := "sidecar"
+	tests := []struct {
+		name          string
+		powerTool     *toev1alpha1.PowerTool
+		pod           *corev1.Pod
+		wantTool      string
+		wantContainer string
+		wantNode      string
+		wantLabels    map[string]string
+	}{
+		{
+			name: "PowerTool and pod",
+			powerTool: &toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{
+				Tool: toev1alpha1.ToolSpec{Name: "aperf"},
+			}},
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+				Spec: corev1.PodSpec{
+					NodeName:   "node-1",
+					Containers: []corev1.Container{{Name: "nginx"}, {Name: "sidecar"}},
+				},
+			},
+			wantTool:      "aperf",
+			wantContainer: "nginx",
+			wantNode:      "node-1",
+			wantLabels:    map[string]string{"app": "web"},
+		},
+		{
+			name: "targeted container",
+			powerTool: &toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{
+				Tool:    toev1alpha1.ToolSpec{Name: "aperf"},
+				Targets: toev1alpha1.TargetSpec{Container: &sidecar},
+			}},
+			pod: &corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "nginx"}, {Name: "sidecar"}},
+			}},
+			wantTool:      "aperf",
+			wantContainer: "sidecar",
+		},
+		{
+			name: "pod not known",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got storage.ProfileMetadata
+			srv := &Server{
+				storage: &mockStorage{
+					saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+						got = metadata
+						return nil
+					},
+				},
+				auth:       &mockAuth{},
+				authorizer: &mockAuthorizer{powerTool: tt.powerTool, pod: tt.pod},
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", "test-job")
+			req.Header.Set("X-PowerTool-Namespace", "default")
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+
+			rr := httptest.NewRecorder()
+			srv.handleProfile(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if got.Tool != tt.wantTool || got.ContainerName != tt.wantContainer || got.NodeName != tt.wantNode {
+				t.Errorf("stored tool %q, container %q, node %q; want %q, %q, %q",
+					got.Tool, got.ContainerName, got.NodeName, tt.wantTool, tt.wantContainer, tt.wantNode)
+			}
+			if len(got.Labels) != len(tt.wantLabels) || got.Labels["app"] != tt.wantLabels["app"] {
+				t.Errorf("stored labels %v, want %v", got.Labels, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_Encoding(t *testing.T) {
+	zstd := "zstd"
+	typo := "lz4"
+	tests := []struct {
+		name            string
+		contentEncoding string
+		compress        *string
+		wantStatus      int
+		wantEncoding    string
+		wantCompress    string
+	}{
+		{
+			name:       "plain upload",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "compressed by the collector",
+			compress:     &zstd,
+			wantStatus:   http.StatusOK,
+			wantCompress: storage.EncodingZstd,
+		},
+		{
+			name:            "already compressed",
+			contentEncoding: "gzip",
+			compress:        &zstd,
+			wantStatus:      http.StatusOK,
+			wantEncoding:    storage.EncodingGzip,
+			wantCompress:    storage.EncodingZstd,
+		},
+		{
+			name:       "unknown setting stores uncompressed",
+			compress:   &typo,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:            "unsupported upload encoding",
+			contentEncoding: "br",
+			wantStatus:      http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got storage.ProfileMetadata
+			srv := &Server{
+				storage: &mockStorage{
+					saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+						got = metadata
+						return nil
+					},
+				},
+				auth: &mockAuth{},
+				authorizer: &mockAuthorizer{powerTool: &toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{
+					Output: toev1alpha1.OutputSpec{Compress: tt.compress},
+				}}},
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", "test-job")
+			req.Header.Set("X-PowerTool-Namespace", "default")
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+
+			rr := httptest.NewRecorder()
+			srv.handleProfile(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if got.Encoding != tt.wantEncoding || got.Compress != tt.wantCompress {
+				t.Errorf("stored encoding %q, compress %q, want %q, %q", got.Encoding, got.Compress, tt.wantEncoding, tt.wantCompress)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_CompressedDigest(t *testing.T) {
+	mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	zstd := "zstd"
+	srv := &Server{
+		storage: mgr,
+		auth:    &mockAuth{},
+		authorizer: &mockAuthorizer{powerTool: &toev1alpha1.PowerTool{Spec: toev1alpha1.PowerToolSpec{
+			Output: toev1alpha1.OutputSpec{Compress: &zstd},
+		}}},
+	}
+	content := strings.Repeat("perf sample ", 100)
+	sum := sha256.Sum256([]byte(content))
+	digest := hex.EncodeToString(sum[:])
+
+	for range 2 {
+		req := httptest.NewRequest("POST", "/api/v1/profile", strings.NewReader(content))
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("X-PowerTool-Job-ID", "test-job")
+		req.Header.Set("X-PowerTool-Namespace", "default")
+		req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+		req.Header.Set(headerSHA256, digest)
+		rr := httptest.NewRecorder()
+		srv.handleProfile(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var resp artifactResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		// The client gets back the digest it sent, not the compressed object's
+		if resp.SHA256 != digest {
+			t.Errorf("response sha256 = %s, want the upload's %s", resp.SHA256, digest)
+		}
+	}
+}
+
+func TestParseSegment(t *testing.T) {
+	tests := []struct {
+		name    string
+		index   string
+		final   string
+		want    *storage.Segment
+		wantErr bool
+	}{
+		{name: "whole upload"},
+		{name: "segment", index: "3", want: &storage.Segment{Index: 3}},
+		{name: "final segment", index: "4", final: "true", want: &storage.Segment{Index: 4, Final: true}},
+		{name: "negative index", index: "-1", wantErr: true},
+		{name: "index too large", index: "1000000", wantErr: true},
+		{name: "invalid final", index: "1", final: "yes", wantErr: true},
+		{name: "final without index", final: "true", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.index != "" {
+				h.Set(headerSegment, tt.index)
+			}
+			if tt.final != "" {
+				h.Set(headerSegmentFinal, tt.final)
+			}
+			got, err := parseSegment(h)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseSegment() expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSegment() error = %v", err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("parseSegment() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleProfile_SegmentStartDate(t *testing.T) {
+	started := metav1.NewTime(time.Date(2025, 10, 30, 23, 59, 0, 0, time.UTC))
+	var got storage.ProfileMetadata
+	srv := &Server{
+		storage: &mockStorage{
+			saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+				got = metadata
+				return nil
+			},
+		},
+		auth: &mockAuth{},
+		authorizer: &mockAuthorizer{powerTool: &toev1alpha1.PowerTool{
+			Status: toev1alpha1.PowerToolStatus{StartedAt: &started},
+		}},
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/profile", bytes.NewBufferString("data"))
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-PowerTool-Job-ID", "test-job")
+	req.Header.Set("X-PowerTool-Namespace", "default")
+	req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+	req.Header.Set(headerSegment, "2")
+
+	rr := httptest.NewRecorder()
+	srv.handleProfile(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got.Segment == nil || got.Segment.Index != 2 || !got.Segment.Started.Equal(started.Time) {
+		t.Errorf("stored segment = %+v, want index 2 started at %v", got.Segment, started)
+	}
+}
+
+func TestHandleProfile_SizeLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		unknownLen bool
+		maxBytes   int64
+		quota      storage.QuotaLimits
+		stored     int64
+		wantStatus int
+	}{
+		{name: "within limits", body: "data", maxBytes: 10, quota: storage.QuotaLimits{Namespace: 10}, wantStatus: http.StatusOK},
+		{name: "declared length over maximum", body: strings.Repeat("x", 11), maxBytes: 10, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "streamed body over maximum", body: strings.Repeat("x", 11), unknownLen: true, maxBytes: 10, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "declared length over namespace quota", body: "data", quota: storage.QuotaLimits{Namespace: 10}, stored: 8, wantStatus: http.StatusTooManyRequests},
+		{name: "declared length over PowerTool quota", body: "data", quota: storage.QuotaLimits{PowerTool: 5}, stored: 2, wantStatus: http.StatusTooManyRequests},
+		{name: "streamed body over quota", body: "data", unknownLen: true, quota: storage.QuotaLimits{Namespace: 10}, stored: 8, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			quotas, err := mgr.EnableQuotas(context.Background(), tt.quota)
+			if err != nil {
+				t.Fatalf("EnableQuotas() error = %v", err)
+			}
+			if err := quotas.Reserve("default", "test-job", tt.stored); err != nil {
+				t.Fatalf("Reserve() error = %v", err)
+			}
+			srv := &Server{
+				storage:          mgr,
+				auth:             &mockAuth{},
+				authorizer:       &mockAuthorizer{},
+				maxArtifactBytes: tt.maxBytes,
+				quotas:           quotas,
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/profile", strings.NewReader(tt.body))
+			if tt.unknownLen {
+				req.ContentLength = -1
+			}
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("X-PowerTool-Job-ID", "test-job")
+			req.Header.Set("X-PowerTool-Namespace", "default")
+			req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(srv.handleProfile).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			artifacts, err := mgr.ListArtifacts(context.Background(), storage.ArtifactFilter{Namespace: "default"})
+			if err != nil {
+				t.Fatalf("ListArtifacts() error = %v", err)
+			}
+			if wantStored := tt.wantStatus == http.StatusOK; (len(artifacts) == 1) != wantStored {
+				t.Errorf("stored %d artifacts, want stored = %v", len(artifacts), wantStored)
+			}
+		})
+	}
+}
+
+// mockStatusRecorder records the artifacts it is given
+type mockStatusRecorder struct {
+	recorded []*storage.Artifact
+	err      error
+}
+
+func (m *mockStatusRecorder) RecordArtifact(ctx context.Context, artifact *storage.Artifact) error {
+	m.recorded = append(m.recorded, artifact)
+	return m.err
+}
+
+func TestHandleProfile_RecordsStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		recorder   *mockStatusRecorder
+		uploads    int
+		wantRecord int
+	}{
+		{name: "recorded", recorder: &mockStatusRecorder{}, uploads: 1, wantRecord: 1},
+		{name: "duplicate upload not recorded again", recorder: &mockStatusRecorder{}, uploads: 2, wantRecord: 1},
+		{name: "recording failure does not fail the upload", recorder: &mockStatusRecorder{err: errors.New("conflict")}, uploads: 1, wantRecord: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			srv := &Server{storage: mgr, auth: &mockAuth{}, authorizer: &mockAuthorizer{}, status: tt.recorder}
+
+			for range tt.uploads {
+				req := httptest.NewRequest("POST", "/api/v1/profile", strings.NewReader("data"))
+				req.Header.Set("Authorization", "Bearer token")
+				req.Header.Set("X-PowerTool-Job-ID", "test-job")
+				req.Header.Set("X-PowerTool-Namespace", "default")
+				req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+				rr := httptest.NewRecorder()
+				srv.handleProfile(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+				}
+			}
+
+			if len(tt.recorder.recorded) != tt.wantRecord {
+				t.Fatalf("recorded %d artifacts, want %d", len(tt.recorder.recorded), tt.wantRecord)
+			}
+			if got := tt.recorder.recorded[0]; got.PowerToolName != "test-job" || got.PodName != "web-1" || got.SHA256 == "" {
+				t.Errorf("recorded %+v, want the stored artifact", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"toe/pkg/collector/metrics"
+	"toe/pkg/collector/storage"
+	"toe/pkg/collector/upload"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// Resumable upload protocol headers
+const (
+	headerUploadOffset  = "Upload-Offset"
+	headerUploadLength  = "Upload-Length"
+	headerUploadExpires = "Upload-Expires"
+)
+
+// extraPodUID is the TokenReview extra set for tokens bound to a pod
+const extraPodUID = "authentication.kubernetes.io/pod-uid"
+
+// uploadSessionResponse is returned when a session is created or queried
+type uploadSessionResponse struct {
+	ID        string    `json:"id"`
+	Offset    int64     `json:"offset"`
+	Length    int64     `json:"length,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// handleCreateUpload starts a resumable upload. It takes the same metadata
+// headers as /api/v1/profile plus an optional Upload-Length.
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if s.uploads == nil {
+		http.Error(w, "Resumable uploads are not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	userInfo, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var length int64
+	if v := r.Header.Get(headerUploadLength); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Invalid %s header", headerUploadLength), http.StatusBadRequest)
+			return
+		}
+		length = n
+	}
+
+	digest, err := storage.NormalizeSHA256(r.Header.Get(headerSHA256))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid %s header", headerSHA256), http.StatusBadRequest)
+		return
+	}
+
+	metadata, ok := s.authorizedMetadata(w, r, userInfo)
+	if !ok {
+		return
+	}
+	if !s.admitUpload(w, metadata, 0, length) {
+		return
+	}
+
+	sess, err := s.uploads.Create(sessionOwner(userInfo), metadata, length, digest)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created upload session %s for job %s pod %s", sess.ID, metadata.PowerToolName, metadata.PodName)
+
+	w.Header().Set("Location", "/api/v1/uploads/"+sess.ID)
+	s.writeSession(w, http.StatusCreated, sess)
+}
+
+// handleUploadStatus reports how many bytes a session has received, so a
+// client can resume after a dropped connection
+func (s *Server) handleUploadStatus(w http.ResponseWriter, r *http.Request) {
+	_, sess, ok := s.ownedSession(w, r)
+	if !ok {
+		return
+	}
+	s.writeSession(w, http.StatusOK, sess)
+}
+
+// handleUploadChunk appends the body at the Upload-Offset the client claims.
+// A mismatch returns 409 with the actual offset.
+func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	userInfo, sess, ok := s.ownedSession(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.authorize(r.Context(), w, userInfo, sess.Metadata); !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, fmt.Sprintf("Missing or invalid %s header", headerUploadOffset), http.StatusBadRequest)
+		return
+	}
+
+	if !s.admitUpload(w, sess.Metadata, offset, r.ContentLength) {
+		return
+	}
+	if s.maxArtifactBytes > 0 {
+		// What is left of the limit after the chunks already received
+		r.Body = http.MaxBytesReader(w, r.Body, max(s.maxArtifactBytes-offset, 0))
+	}
+
+	body := &countingReader{r: r.Body, received: metrics.BytesReceived.WithLabelValues(sess.Metadata.Namespace)}
+	updated, err := s.uploads.Append(sess.ID, offset, body)
+	if err != nil {
+		var mismatch *upload.OffsetMismatchError
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			metrics.UploadsTotal.WithLabelValues(sess.Metadata.Namespace, metrics.OutcomeTooLarge).Inc()
+			http.Error(w, fmt.Sprintf("Upload exceeds the maximum artifact size of %d bytes", s.maxArtifactBytes), http.StatusRequestEntityTooLarge)
+		case errors.As(err, &mismatch):
+			w.Header().Set(headerUploadOffset, strconv.FormatInt(mismatch.Current, 10))
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, upload.ErrLengthExceeded):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, upload.ErrSessionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.writeSession(w, http.StatusOK, updated)
+}
+
+// handleCompleteUpload re-checks authorization and moves the assembled upload
+// into profile storage. The digest may be given here if it was not known when
+// the session was created.
+func (s *Server) handleCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	userInfo, sess, ok := s.ownedSession(w, r)
+	if !ok {
+		return
+	}
+
+	digest, err := storage.NormalizeSHA256(r.Header.Get(headerSHA256))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid %s header", headerSHA256), http.StatusBadRequest)
+		return
+	}
+	if digest == "" {
+		digest = sess.SHA256
+	}
+
+	// The PowerTool may have finished since the session was created
+	target, ok := s.authorize(r.Context(), w, userInfo, sess.Metadata)
+	if !ok {
+		return
+	}
+
+	var artifact *storage.Artifact
+	done, err := s.uploads.Finalize(sess.ID, func(data io.Reader, metadata storage.ProfileMetadata) error {
+		var err error
+		metadata = withUploadTarget(metadata, target)
+		artifact, err = s.storage.SaveProfile(r.Context(), &digestReader{Reader: data, sha256: digest}, metadata)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, upload.ErrIncomplete):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, upload.ErrSessionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			writeSaveError(w, sess.Metadata.Namespace, err)
+		}
+		return
+	}
+
+	log.Printf("Completed upload session %s for job %s pod %s, %d bytes",
+		done.ID, done.Metadata.PowerToolName, done.Metadata.PodName, done.Offset)
+	s.recordArtifact(r.Context(), artifact)
+	writeArtifact(w, artifact)
+}
+
+// handleAbortUpload discards a session and its data
+func (s *Server) handleAbortUpload(w http.ResponseWriter, r *http.Request) {
+	userInfo, sess, ok := s.ownedSession(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.authorize(r.Context(), w, userInfo, sess.Metadata); !ok {
+		return
+	}
+	if err := s.uploads.Abort(sess.ID); err != nil && !errors.Is(err, upload.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedSession authenticates the request and loads the session named in the
+// path. Every collector token belongs to the same ServiceAccount, so the
+// request must also name the namespace, PowerTool and pod the session was
+// created for. Sessions of other callers are reported as not found.
+func (s *Server) ownedSession(w http.ResponseWriter, r *http.Request) (*authv1.UserInfo, upload.Session, bool) {
+	if s.uploads == nil {
+		http.Error(w, "Resumable uploads are not enabled", http.StatusNotImplemented)
+		return nil, upload.Session{}, false
+	}
+
+	userInfo, ok := s.authenticate(w, r)
+	if !ok {
+		return nil, upload.Session{}, false
+	}
+
+	sess, err := s.uploads.Get(r.PathValue("id"))
+	if err != nil || !boundTo(sess, userInfo, r.Header) {
+		http.Error(w, upload.ErrSessionNotFound.Error(), http.StatusNotFound)
+		return nil, upload.Session{}, false
+	}
+	return userInfo, sess, true
+}
+
+// sessionOwner identifies the credential a session is created with. Tokens
+// bound to a pod carry its UID, which pins the session to that pod instance.
+func sessionOwner(userInfo *authv1.UserInfo) string {
+	if uid := userInfo.Extra[extraPodUID]; len(uid) > 0 && uid[0] != "" {
+		return userInfo.Username + "/" + uid[0]
+	}
+	return userInfo.Username
+}
+
+// boundTo reports whether a request may use the session: same credential and
+// the same upload target headers the session was authorized for
+func boundTo(sess upload.Session, userInfo *authv1.UserInfo, h http.Header) bool {
+	return sess.Owner == sessionOwner(userInfo) &&
+		h.Get("X-PowerTool-Namespace") == sess.Metadata.Namespace &&
+		h.Get("X-PowerTool-Job-ID") == sess.Metadata.PowerToolName &&
+		h.Get("X-PowerTool-Pod-Name") == sess.Metadata.PodName
+}
+
+func (s *Server) writeSession(w http.ResponseWriter, status int, sess upload.Session) {
+	expiresAt := sess.LastActivity.Add(s.uploads.TTL()).UTC()
+
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(sess.Offset, 10))
+	if sess.Length > 0 {
+		w.Header().Set(headerUploadLength, strconv.FormatInt(sess.Length, 10))
+	}
+	w.Header().Set(headerUploadExpires, expiresAt.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(uploadSessionResponse{
+		ID:        sess.ID,
+		Offset:    sess.Offset,
+		Length:    sess.Length,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		log.Printf("Failed to write upload session response: %v", err)
+	}
+}
+
+// countingReader adds the bytes of a chunk to the received bytes metric
+type countingReader struct {
+	r        io.Reader
+	received prometheus.Counter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.received.Add(float64(n))
+	return n, err
+}
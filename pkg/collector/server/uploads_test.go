@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"toe/pkg/collector/auth"
+	"toe/pkg/collector/storage"
+	"toe/pkg/collector/upload"
+
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+func newUploadTestServer(t *testing.T, store StorageManager) *Server {
+	t.Helper()
+	sessions, err := upload.NewSessionStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+	return &Server{
+		storage:    store,
+		auth:       &mockAuth{},
+		authorizer: &mockAuthorizer{},
+		uploads:    sessions,
+	}
+}
+
+func uploadRequest(method, path string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-PowerTool-Job-ID", "test-job")
+	req.Header.Set("X-PowerTool-Namespace", "default")
+	req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+	return req
+}
+
+func createUpload(t *testing.T, handler http.Handler, length int) string {
+	t.Helper()
+	req := uploadRequest("POST", "/api/v1/uploads", nil)
+	req.Header.Set("X-PowerTool-Filename", "perf.data")
+	if length > 0 {
+		req.Header.Set("Upload-Length", strconv.Itoa(length))
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create upload: expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp uploadSessionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("create upload: invalid response: %v", err)
+	}
+	if rr.Header().Get("Location") != "/api/v1/uploads/"+resp.ID {
+		t.Errorf("Location = %q, want /api/v1/uploads/%s", rr.Header().Get("Location"), resp.ID)
+	}
+	return resp.ID
+}
+
+func putChunk(handler http.Handler, id string, offset int, data string) *httptest.ResponseRecorder {
+	req := uploadRequest("PUT", "/api/v1/uploads/"+id, bytes.NewBufferString(data))
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestResumableUpload_EndToEnd(t *testing.T) {
+	var saved bytes.Buffer
+	var savedMeta storage.ProfileMetadata
+	srv := newUploadTestServer(t, &mockStorage{
+		saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+			savedMeta = metadata
+			_, err := io.Copy(&saved, r)
+			return err
+		},
+	})
+	handler := srv.routes()
+
+	id := createUpload(t, handler, 10)
+
+	if rr := putChunk(handler, id, 0, "01234"); rr.Code != http.StatusOK {
+		t.Fatalf("first chunk: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A retried chunk is refused with the offset to resume from
+	rr := putChunk(handler, id, 0, "01234")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("stale chunk: expected 409, got %d", rr.Code)
+	}
+	if rr.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("stale chunk: Upload-Offset = %q, want 5", rr.Header().Get("Upload-Offset"))
+	}
+
+	// Query the offset the way a client resumes after a dropped connection
+	statusReq := uploadRequest("HEAD", "/api/v1/uploads/"+id, nil)
+	statusRR := httptest.NewRecorder()
+	handler.ServeHTTP(statusRR, statusReq)
+	if statusRR.Code != http.StatusOK || statusRR.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("status: got %d with Upload-Offset %q, want 200 and 5", statusRR.Code, statusRR.Header().Get("Upload-Offset"))
+	}
+	if statusRR.Header().Get("Upload-Expires") == "" {
+		t.Error("status: missing Upload-Expires header")
+	}
+
+	// Finalizing early is refused
+	earlyRR := httptest.NewRecorder()
+	handler.ServeHTTP(earlyRR, uploadRequest("POST", "/api/v1/uploads/"+id+"/complete", nil))
+	if earlyRR.Code != http.StatusConflict {
+		t.Errorf("early complete: expected 409, got %d", earlyRR.Code)
+	}
+
+	if rr := putChunk(handler, id, 5, "56789"); rr.Code != http.StatusOK {
+		t.Fatalf("second chunk: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	completeRR := httptest.NewRecorder()
+	handler.ServeHTTP(completeRR, uploadRequest("POST", "/api/v1/uploads/"+id+"/complete", nil))
+	if completeRR.Code != http.StatusOK {
+		t.Fatalf("complete: expected 200, got %d: %s", completeRR.Code, completeRR.Body.String())
+	}
+
+	if saved.String() != "0123456789" {
+		t.Errorf("saved content = %q, want %q", saved.String(), "0123456789")
+	}
+	if savedMeta.PodName != "web-1" || savedMeta.Filename != "perf.data" {
+		t.Errorf("saved metadata = %+v", savedMeta)
+	}
+
+	// The session is gone once finalized
+	goneRR := httptest.NewRecorder()
+	handler.ServeHTTP(goneRR, uploadRequest("GET", "/api/v1/uploads/"+id, nil))
+	if goneRR.Code != http.StatusNotFound {
+		t.Errorf("finished session: expected 404, got %d", goneRR.Code)
+	}
+}
+
+func TestResumableUpload_OtherCallerCannotUseSession(t *testing.T) {
+	srv := newUploadTestServer(t, &mockStorage{})
+	handler := srv.routes()
+	id := createUpload(t, handler, 0)
+
+	srv.auth = &mockAuth{
+		validateTokenFunc: func(ctx context.Context, token string) (*authv1.UserInfo, error) {
+			return &authv1.UserInfo{Username: "someone-else"}, nil
+		},
+	}
+
+	if rr := putChunk(handler, id, 0, "data"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another caller's session, got %d", rr.Code)
+	}
+}
+
+func TestResumableUpload_SessionBoundToTarget(t *testing.T) {
+	srv := newUploadTestServer(t, &mockStorage{})
+	handler := srv.routes()
+	id := createUpload(t, handler, 0)
+
+	// Same ServiceAccount, but a token and headers for another PowerTool's pod
+	requests := []*http.Request{
+		uploadRequest("HEAD", "/api/v1/uploads/"+id, nil),
+		uploadRequest("PUT", "/api/v1/uploads/"+id, bytes.NewBufferString("data")),
+		uploadRequest("POST", "/api/v1/uploads/"+id+"/complete", nil),
+		uploadRequest("DELETE", "/api/v1/uploads/"+id, nil),
+	}
+	for _, req := range requests {
+		req.Header.Set("X-PowerTool-Job-ID", "other-job")
+		req.Header.Set("X-PowerTool-Pod-Name", "db-0")
+		req.Header.Set("Upload-Offset", "0")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s with another target: expected 404, got %d", req.Method, rr.Code)
+		}
+	}
+
+	// Same headers, but a token bound to another pod
+	srv.auth = &mockAuth{
+		validateTokenFunc: func(ctx context.Context, token string) (*authv1.UserInfo, error) {
+			return &authv1.UserInfo{
+				Username: "test-user",
+				Extra:    map[string]authv1.ExtraValue{extraPodUID: {"other-pod-uid"}},
+			}, nil
+		},
+	}
+	if rr := putChunk(handler, id, 0, "data"); rr.Code != http.StatusNotFound {
+		t.Errorf("token bound to another pod: expected 404, got %d", rr.Code)
+	}
+
+	srv.auth = &mockAuth{}
+	if rr := putChunk(handler, id, 0, "data"); rr.Code != http.StatusOK {
+		t.Errorf("original uploader: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestResumableUpload_ChunkRechecksAuthorization(t *testing.T) {
+	srv := newUploadTestServer(t, &mockStorage{})
+	handler := srv.routes()
+	id := createUpload(t, handler, 0)
+
+	srv.authorizer = &mockAuthorizer{
+		authorizeUploadFunc: func(ctx context.Context, req auth.UploadRequest) error {
+			return auth.ErrUploadForbidden
+		},
+	}
+
+	if rr := putChunk(handler, id, 0, "data"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", rr.Code)
+	}
+}
+
+func TestResumableUpload_CompleteRechecksAuthorization(t *testing.T) {
+	saved := false
+	srv := newUploadTestServer(t, &mockStorage{
+		saveProfileFunc: func(r io.Reader, metadata storage.ProfileMetadata) error {
+			saved = true
+			return nil
+		},
+	})
+	handler := srv.routes()
+	id := createUpload(t, handler, 0)
+	putChunk(handler, id, 0, "data")
+
+	srv.authorizer = &mockAuthorizer{
+		authorizeUploadFunc: func(ctx context.Context, req auth.UploadRequest) error {
+			return auth.ErrUploadForbidden
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, uploadRequest("POST", "/api/v1/uploads/"+id+"/complete", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", rr.Code)
+	}
+	if saved {
+		t.Error("storage must not be called when authorization fails")
+	}
+}
+
+func TestResumableUpload_Abort(t *testing.T) {
+	srv := newUploadTestServer(t, &mockStorage{})
+	handler := srv.routes()
+	id := createUpload(t, handler, 0)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, uploadRequest("DELETE", "/api/v1/uploads/"+id, nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("abort: expected 204, got %d", rr.Code)
+	}
+
+	if rr := putChunk(handler, id, 0, "data"); rr.Code != http.StatusNotFound {
+		t.Errorf("chunk after abort: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestResumableUpload_RequiresAuth(t *testing.T) {
+	srv := newUploadTestServer(t, &mockStorage{})
+	handler := srv.routes()
+
+	req := httptest.NewRequest("POST", "/api/v1/uploads", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestResumableUpload_InvalidOffset(t *testing.T) {
+	srv := newUploadTestServer(t, &mockStorage{})
+	handler := srv.routes()
+	id := createUpload(t, handler, 0)
+
+	req := uploadRequest("PUT", "/api/v1/uploads/"+id, bytes.NewBufferString("data"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("missing offset: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestResumableUpload_VerifiesDigest(t *testing.T) {
+	mgr, err := storage.NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	srv := newUploadTestServer(t, mgr)
+	handler := srv.routes()
+
+	req := uploadRequest("POST", "/api/v1/uploads", nil)
+	req.Header.Set("X-PowerTool-Job-ID", "test-job")
+	req.Header.Set("X-PowerTool-Namespace", "default")
+	req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+	req.Header.Set("X-PowerTool-SHA256", strings.Repeat("0", 64))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create upload: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created uploadSessionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("create upload: invalid response: %v", err)
+	}
+	putChunk(handler, created.ID, 0, "data")
+
+	// The digest declared at creation does not match what arrived
+	mismatchRR := httptest.NewRecorder()
+	handler.ServeHTTP(mismatchRR, uploadRequest("POST", "/api/v1/uploads/"+created.ID+"/complete", nil))
+	if mismatchRR.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("complete with wrong digest: expected 422, got %d", mismatchRR.Code)
+	}
+
+	// The session survives, and a corrected digest at completion is accepted
+	sum := sha256.Sum256([]byte("data"))
+	completeReq := uploadRequest("POST", "/api/v1/uploads/"+created.ID+"/complete", nil)
+	completeReq.Header.Set("X-PowerTool-SHA256", hex.EncodeToString(sum[:]))
+	completeRR := httptest.NewRecorder()
+	handler.ServeHTTP(completeRR, completeReq)
+	if completeRR.Code != http.StatusOK {
+		t.Fatalf("complete: expected 200, got %d: %s", completeRR.Code, completeRR.Body.String())
+	}
+	var resp artifactResponse
+	if err := json.NewDecoder(completeRR.Body).Decode(&resp); err != nil {
+		t.Fatalf("complete: invalid response: %v", err)
+	}
+	if resp.SHA256 != hex.EncodeToString(sum[:]) || resp.Size != 4 {
+		t.Errorf("complete response = %+v", resp)
+	}
+}
+
+func TestResumableUpload_SizeLimits(t *testing.T) {
+	srv := newUploadTestServer(t, &mockStorage{})
+	srv.maxArtifactBytes = 8
+	handler := srv.routes()
+
+	// A declared length over the maximum is refused before any data is sent
+	req := uploadRequest("POST", "/api/v1/uploads", nil)
+	req.Header.Set("X-PowerTool-Job-ID", "test-job")
+	req.Header.Set("X-PowerTool-Namespace", "default")
+	req.Header.Set("X-PowerTool-Pod-Name", "web-1")
+	req.Header.Set("Upload-Length", "9")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("create over maximum: expected 413, got %d", rr.Code)
+	}
+
+	// Without a declared length the chunks are limited as they arrive
+	id := createUpload(t, handler, 0)
+	if rr := putChunk(handler, id, 0, "01234"); rr.Code != http.StatusOK {
+		t.Fatalf("first chunk: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := putChunk(handler, id, 5, "5678"); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunk past maximum: expected 413, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Streamed without Content-Length, the overrun is caught while reading
+	chunk := uploadRequest("PUT", "/api/v1/uploads/"+id, strings.NewReader("5678"))
+	chunk.ContentLength = -1
+	chunk.Header.Set("Upload-Offset", "5")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, chunk)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("streamed chunk past maximum: expected 413, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The session is left where it was, so the upload can still finish
+	if rr := putChunk(handler, id, 5, "567"); rr.Code != http.StatusOK || rr.Header().Get("Upload-Offset") != "8" {
+		t.Errorf("chunk up to maximum: got %d with Upload-Offset %q, want 200 and 8", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+}
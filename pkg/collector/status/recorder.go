@@ -0,0 +1,63 @@
+// Package status records the artifacts the collector stores in the status of
+// the PowerTools they belong to.
+package status
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/pkg/collector/storage"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// conflictBackoff retries status updates that lost a race with the controller
+// or with other uploads. Every pod of a run may finish at the same moment, so
+// it allows more attempts than retry.DefaultRetry, with jitter to spread
+// them out.
+var conflictBackoff = wait.Backoff{
+	Steps:    10,
+	Duration: 10 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   0.5,
+}
+
+// Recorder appends stored artifacts to PowerTool status
+type Recorder struct {
+	client client.Client
+}
+
+// NewRecorder creates a recorder that updates PowerTools through c, which
+// must be allowed to get and update powertools/status
+func NewRecorder(c client.Client) *Recorder {
+	return &Recorder{client: c}
+}
+
+// RecordArtifact adds artifact to the status of its PowerTool, reading the
+// latest version again whenever the update conflicts
+func (r *Recorder) RecordArtifact(ctx context.Context, artifact *storage.Artifact) error {
+	ref := toev1alpha1.ArtifactReference{
+		Path:    artifact.Path,
+		PodName: artifact.PodName,
+		Size:    artifact.Size,
+		SHA256:  artifact.SHA256,
+	}
+	key := types.NamespacedName{Namespace: artifact.Namespace, Name: artifact.PowerToolName}
+	err := retry.RetryOnConflict(conflictBackoff, func() error {
+		var powerTool toev1alpha1.PowerTool
+		if err := r.client.Get(ctx, key, &powerTool); err != nil {
+			return err
+		}
+		powerTool.Status.RecordArtifact(ref)
+		return r.client.Status().Update(ctx, &powerTool)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record artifact %s in PowerTool %s: %w", artifact.Path, key, err)
+	}
+	return nil
+}
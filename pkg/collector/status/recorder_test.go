@@ -0,0 +1,80 @@
+package status
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	toev1alpha1 "toe/api/v1alpha1"
+	"toe/pkg/collector/storage"
+)
+
+func newFakeClient(funcs interceptor.Funcs) client.Client {
+	scheme := runtime.NewScheme()
+	_ = toev1alpha1.AddToScheme(scheme)
+	powerTool := &toev1alpha1.PowerTool{ObjectMeta: metav1.ObjectMeta{Name: "job", Namespace: "web"}}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(powerTool).
+		WithStatusSubresource(powerTool).
+		WithInterceptorFuncs(funcs).
+		Build()
+}
+
+func testArtifact(path string, size int64) *storage.Artifact {
+	return &storage.Artifact{
+		ProfileMetadata: storage.ProfileMetadata{Namespace: "web", PowerToolName: "job", PodName: "web-1"},
+		Path:            path,
+		Size:            size,
+		SHA256:          "aa",
+	}
+}
+
+func TestRecorder_RecordArtifact(t *testing.T) {
+	// The first update of each artifact loses a race, as when the controller
+	// or another upload wrote the status first
+	conflicts := 0
+	c := newFakeClient(interceptor.Funcs{
+		SubResourceUpdate: func(ctx context.Context, c client.Client, sub string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			if conflicts++; conflicts%2 == 1 {
+				return apierrors.NewConflict(schema.GroupResource{Resource: "powertools"}, obj.GetName(), nil)
+			}
+			return c.SubResource(sub).Update(ctx, obj, opts...)
+		},
+	})
+	r := NewRecorder(c)
+
+	for _, a := range []*storage.Artifact{testArtifact("a", 512), testArtifact("b", 1024), testArtifact("a", 512)} {
+		if err := r.RecordArtifact(context.Background(), a); err != nil {
+			t.Fatalf("RecordArtifact(%s) error = %v", a.Path, err)
+		}
+	}
+
+	var powerTool toev1alpha1.PowerTool
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "web", Name: "job"}, &powerTool); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	status := powerTool.Status
+	if len(status.Artifacts) != 2 || status.Artifacts[0].Path != "a" || status.Artifacts[0].PodName != "web-1" {
+		t.Errorf("Artifacts = %+v, want a and b", status.Artifacts)
+	}
+	if *status.ArtifactCount != 2 || *status.ArtifactBytes != 1536 || *status.BytesWritten != "1.5 KiB" {
+		t.Errorf("totals = %d artifacts, %d bytes (%s), want 2 and 1536 (1.5 KiB)", *status.ArtifactCount, *status.ArtifactBytes, *status.BytesWritten)
+	}
+}
+
+func TestRecorder_RecordArtifact_NotFound(t *testing.T) {
+	r := NewRecorder(newFakeClient(interceptor.Funcs{}))
+	a := testArtifact("a", 1)
+	a.PowerToolName = "gone"
+	if err := r.RecordArtifact(context.Background(), a); !apierrors.IsNotFound(err) {
+		t.Errorf("RecordArtifact() error = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MetadataSuffix is appended to an artifact's name to form its sidecar
+// metadata file
+const MetadataSuffix = ".meta.json"
+
+// ErrDigestMismatch is returned when the stored content does not hash to the
+// digest the client sent
+var ErrDigestMismatch = errors.New("sha256 digest mismatch")
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// DigestSource is implemented by readers that know the SHA-256 their content
+// should have. It is consulted only after the content has been read to EOF,
+// so the digest may come from an HTTP trailer.
+type DigestSource interface {
+	ExpectedSHA256() string
+}
+
+// NormalizeSHA256 lowercases a hex digest and checks its format; an empty
+// digest stays empty
+func NormalizeSHA256(digest string) (string, error) {
+	digest = strings.ToLower(strings.TrimSpace(digest))
+	if digest != "" && !sha256Pattern.MatchString(digest) {
+		return "", fmt.Errorf("%w: sha256 digest must be 64 hex characters", ErrInvalidMetadata)
+	}
+	return digest, nil
+}
+
+// Artifact describes a stored profile. It is also what the sidecar metadata
+// file next to each artifact contains.
+type Artifact struct {
+	ProfileMetadata
+	// Path is the artifact's key, its location relative to the storage root
+	Path string `json:"path"`
+	// Size and SHA256 describe the content as served, compressed or not.
+	// For encrypted artifacts that is the content before encryption.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// UncompressedSize and UncompressedSHA256 are set when the collector
+	// compressed the upload, and describe the content as uploaded
+	UncompressedSize   int64     `json:"uncompressedSize,omitempty"`
+	UncompressedSHA256 string    `json:"uncompressedSha256,omitempty"`
+	CreatedAt          time.Time `json:"createdAt"`
+	// Encryption is set when the artifact is encrypted at rest
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// Deduplicated is set when an identical artifact already existed and
+	// nothing new was written
+	Deduplicated bool `json:"-"`
+}
+
+// readSidecar loads the metadata stored next to the artifact at key
+func (m *Manager) readSidecar(ctx context.Context, key string) (*Artifact, error) {
+	rc, err := m.backend.Get(ctx, key+MetadataSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	artifact := &Artifact{}
+	if err := json.NewDecoder(rc).Decode(artifact); err != nil {
+		return nil, fmt.Errorf("failed to decode artifact metadata: %w", err)
+	}
+	return artifact, nil
+}
+
+// UploadSHA256 is the digest of the content as uploaded, which is what the
+// client sent and a retry carries again
+func (a *Artifact) UploadSHA256() string {
+	if a.UncompressedSHA256 != "" {
+		return a.UncompressedSHA256
+	}
+	return a.SHA256
+}
+
+// sameContent reports whether two artifacts hold the same content. Digests
+// of uploads are compared, so the result does not depend on the compressor
+// producing the same bytes; artifacts compressed before the upload digest
+// was recorded still match on what was stored.
+func sameContent(a, b *Artifact) bool {
+	return a.UploadSHA256() == b.UploadSHA256() || a.SHA256 == b.SHA256
+}
+
+// storedSize is the size of the object as stored, which is what quotas and
+// retention count
+func (a *Artifact) storedSize() int64 {
+	if a.Encryption != nil {
+		return a.Encryption.Size
+	}
+	return a.Size
+}
+
+// writeSidecar stores the metadata file next to an artifact
+func (m *Manager) writeSidecar(ctx context.Context, artifact *Artifact) error {
+	data, err := encodeSidecar(artifact)
+	if err != nil {
+		return err
+	}
+	if err := m.backend.Put(ctx, artifact.Path+MetadataSuffix, bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to write artifact metadata: %w", err)
+	}
+	m.index.Put(artifact)
+	return nil
+}
+
+func encodeSidecar(artifact *Artifact) ([]byte, error) {
+	data, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode artifact metadata: %w", err)
+	}
+	return data, nil
+}
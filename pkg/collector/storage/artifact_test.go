@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type digestBuffer struct {
+	io.Reader
+	digest string
+}
+
+func (d *digestBuffer) ExpectedSHA256() string {
+	return d.digest
+}
+
+func digestOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+var artifactMetadata = ProfileMetadata{
+	Namespace:     "default",
+	AppLabel:      "app-web",
+	PowerToolName: "job",
+	PodName:       "web-1",
+	Filename:      "perf.data",
+}
+
+func TestNormalizeSHA256(t *testing.T) {
+	digest := digestOf("data")
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: ""},
+		{name: "lowercase", in: digest, want: digest},
+		{name: "uppercase", in: strings.ToUpper(digest), want: digest},
+		{name: "surrounding space", in: " " + digest + " ", want: digest},
+		{name: "too short", in: digest[:10], wantErr: true},
+		{name: "not hex", in: strings.Repeat("z", 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeSHA256(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeSHA256() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidMetadata) {
+				t.Errorf("NormalizeSHA256() error = %v, want ErrInvalidMetadata", err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeSHA256() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveProfile_VerifiesDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		digest  string
+		wantErr error
+	}{
+		{name: "no digest", digest: ""},
+		{name: "matching digest", digest: digestOf("profile")},
+		{name: "matching uppercase digest", digest: strings.ToUpper(digestOf("profile"))},
+		{name: "mismatched digest", digest: digestOf("something else"), wantErr: ErrDigestMismatch},
+		{name: "malformed digest", digest: "abc", wantErr: ErrInvalidMetadata},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			basePath := t.TempDir()
+			mgr, err := NewManager(basePath, "2006-01-02")
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+
+			artifact, err := mgr.SaveProfile(context.Background(), &digestBuffer{Reader: bytes.NewBufferString("profile"), digest: tt.digest}, artifactMetadata)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("SaveProfile() error = %v, want %v", err, tt.wantErr)
+				}
+				matches, _ := filepath.Glob(filepath.Join(basePath, "default", "app-web", "job", "*", "*"))
+				if len(matches) != 0 {
+					t.Errorf("rejected upload left files behind: %v", matches)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SaveProfile() error = %v", err)
+			}
+			if artifact.SHA256 != digestOf("profile") || artifact.Size != int64(len("profile")) {
+				t.Errorf("SaveProfile() artifact = %+v", artifact)
+			}
+		})
+	}
+}
+
+func TestSaveProfile_WritesSidecar(t *testing.T) {
+	basePath := t.TempDir()
+	mgr, err := NewManager(basePath, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	artifact, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("profile"), artifactMetadata)
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	if !strings.HasPrefix(artifact.Path, "default/app-web/job/") || !strings.HasSuffix(artifact.Path, "/web-1_perf.data") {
+		t.Errorf("artifact path = %q, want default/app-web/job/<date>/web-1_perf.data", artifact.Path)
+	}
+
+	sidecar, err := mgr.readSidecar(context.Background(), artifact.Path)
+	if err != nil {
+		t.Fatalf("readSidecar() error = %v", err)
+	}
+	if !reflect.DeepEqual(sidecar.ProfileMetadata, artifactMetadata) {
+		t.Errorf("sidecar metadata = %+v, want %+v", sidecar.ProfileMetadata, artifactMetadata)
+	}
+	if sidecar.Path != artifact.Path || sidecar.Size != 7 || sidecar.SHA256 != digestOf("profile") {
+		t.Errorf("sidecar = %+v, want path %s, size 7 and sha256 of content", sidecar, artifact.Path)
+	}
+	if sidecar.CreatedAt.IsZero() {
+		t.Error("sidecar has no creation time")
+	}
+}
+
+func TestSaveProfile_Deduplicates(t *testing.T) {
+	basePath := t.TempDir()
+	mgr, err := NewManager(basePath, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	first, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("profile"), artifactMetadata)
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+
+	// A retry of the same content resolves to the existing artifact
+	retry, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("profile"), artifactMetadata)
+	if err != nil {
+		t.Fatalf("SaveProfile() retry error = %v", err)
+	}
+	if !retry.Deduplicated || retry.Path != first.Path {
+		t.Errorf("retry = %+v, want deduplicated against %s", retry, first.Path)
+	}
+
+	// Different content under the same name still gets its own file
+	other, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("changed"), artifactMetadata)
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	if other.Deduplicated || !strings.HasSuffix(other.Path, "/web-1_perf-1.data") {
+		t.Errorf("different content = %+v, want new artifact web-1_perf-1.data", other)
+	}
+
+	dir := filepath.Dir(filepath.Join(basePath, filepath.FromSlash(first.Path)))
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 4 {
+		t.Errorf("expected two artifacts with sidecars, got %d entries", len(entries))
+	}
+}
+
+func TestValidate_RejectsSidecarSuffix(t *testing.T) {
+	metadata := artifactMetadata
+	metadata.Filename = "perf.data" + MetadataSuffix
+	if err := metadata.Validate(); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("Validate() error = %v, want ErrInvalidMetadata", err)
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// Supported values for Config.Backend
+const (
+	BackendFilesystem = "filesystem"
+	BackendS3         = "s3"
+)
+
+// Backend stores artifacts as objects addressed by slash-separated keys of
+// the form namespace/label/powertool/date/name. Missing objects are reported
+// with fs.ErrNotExist and taken keys with fs.ErrExist, whatever the backend.
+type Backend interface {
+	// Put stores size bytes from r under key. It never replaces an existing
+	// object and fails with fs.ErrExist instead.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the object stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange opens length bytes of the object starting at offset; a
+	// negative length reads to the end
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat describes the object stored under key
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns every object whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes the object stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+// checker is implemented by backends that can verify they accept writes
+type checker interface {
+	Check(ctx context.Context) error
+}
+
+// probeKey is looked up to check backends without a Check of their own. It is
+// never a valid artifact key.
+const probeKey = ".readyz"
+
+// checkBackend verifies backend is usable: with its own check if it has one,
+// otherwise by looking up an object that does not exist
+func checkBackend(ctx context.Context, backend Backend) error {
+	if c, ok := backend.(checker); ok {
+		return c.Check(ctx)
+	}
+	if _, err := backend.Stat(ctx, probeKey); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Config selects and configures the storage backend
+type Config struct {
+	// Backend is BackendFilesystem (the default) or BackendS3
+	Backend string
+	// Path is the filesystem backend's root directory
+	Path string
+	// S3 configures the S3 backend
+	S3 S3Config
+}
+
+// NewBackend creates the backend selected by cfg
+func NewBackend(cfg Config) (Backend, error) {
+	switch cfg.Backend {
+	case "", BackendFilesystem:
+		return NewFilesystemBackend(cfg.Path)
+	case BackendS3:
+		return NewS3Backend(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, must be %q or %q", cfg.Backend, BackendFilesystem, BackendS3)
+	}
+}
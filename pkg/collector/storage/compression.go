@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings artifacts may be stored with. The empty encoding means
+// uncompressed.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// ErrUnsupportedEncoding is returned for content encodings other than gzip
+// and zstd
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// encodingExtensions are appended to the names of compressed artifacts so
+// they are recognizable on disk
+var encodingExtensions = map[string]string{
+	EncodingGzip: ".gz",
+	EncodingZstd: ".zst",
+}
+
+// encodingMagic is how each encoding's streams begin
+var encodingMagic = map[string][]byte{
+	EncodingGzip: {0x1f, 0x8b},
+	EncodingZstd: {0x28, 0xb5, 0x2f, 0xfd},
+}
+
+// ParseEncoding normalizes a Content-Encoding header or compression setting.
+// Empty, "identity" and "none" all mean uncompressed and return "".
+func ParseEncoding(v string) (string, error) {
+	switch enc := strings.ToLower(strings.TrimSpace(v)); enc {
+	case "", "identity", "none":
+		return "", nil
+	case EncodingGzip, "x-gzip":
+		return EncodingGzip, nil
+	case EncodingZstd:
+		return EncodingZstd, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedEncoding, v)
+	}
+}
+
+// NewDecoder decompresses r according to encoding; an empty encoding returns
+// r unchanged
+func NewDecoder(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "":
+		return io.NopCloser(r), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	case EncodingZstd:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// newEncoder compresses into w. Output is deterministic for the same input,
+// so a retried upload still deduplicates.
+func newEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		// A zero header carries no modification time
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// withEncodingExtension appends the encoding's extension to name unless the
+// client already named the file that way
+func withEncodingExtension(name, encoding string) string {
+	ext := encodingExtensions[encoding]
+	if ext == "" || strings.HasSuffix(name, ext) {
+		return name
+	}
+	return name + ext
+}
+
+// checkEncoding rejects staged content that does not start like the encoding
+// it claims, so a wrong Content-Encoding is caught before it is stored
+func checkEncoding(path, encoding string) error {
+	magic := encodingMagic[encoding]
+	if magic == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open staged profile: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, head); err != nil || !bytes.Equal(head, magic) {
+		return fmt.Errorf("%w: content is not %s encoded", ErrInvalidMetadata, encoding)
+	}
+	return nil
+}
+
+// compressStaged compresses the staged file at src into a new staged file,
+// returning its path, size and SHA-256
+func (m *Manager) compressStaged(src, encoding string) (string, int64, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to open staged profile: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.CreateTemp(m.stagingDir, "compressed-*")
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to create compressed profile file: %w", err)
+	}
+	outPath := out.Name()
+	fail := func(err error) (string, int64, string, error) {
+		_ = out.Close()
+		_ = os.Remove(outPath)
+		return "", 0, "", err
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(out, hash)}
+	enc, err := newEncoder(counter, encoding)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := io.Copy(enc, in); err != nil {
+		return fail(fmt.Errorf("failed to compress profile data: %w", err))
+	}
+	if err := enc.Close(); err != nil {
+		return fail(fmt.Errorf("failed to compress profile data: %w", err))
+	}
+	if err := out.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to sync compressed profile data: %w", err))
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(outPath)
+		return "", 0, "", fmt.Errorf("failed to close compressed profile file: %w", err)
+	}
+	return outPath, counter.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
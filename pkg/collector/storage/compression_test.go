@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseEncoding(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "identity", want: ""},
+		{in: "none", want: ""},
+		{in: "gzip", want: EncodingGzip},
+		{in: "x-gzip", want: EncodingGzip},
+		{in: " ZSTD ", want: EncodingZstd},
+		{in: "br", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseEncoding(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnsupportedEncoding) {
+				t.Errorf("ParseEncoding(%q) error = %v, want ErrUnsupportedEncoding", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseEncoding(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSaveProfile_Compress(t *testing.T) {
+	content := strings.Repeat("perf sample ", 1000)
+
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			mgr, err := NewManager(t.TempDir(), "2006-01-02")
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			ctx := context.Background()
+			metadata := ProfileMetadata{
+				Namespace: "default", AppLabel: "app-web", PowerToolName: "job",
+				PodName: "web-1", Filename: "perf.data", Compress: encoding,
+			}
+
+			artifact, err := mgr.SaveProfile(ctx, bytes.NewBufferString(content), metadata)
+			if err != nil {
+				t.Fatalf("SaveProfile() error = %v", err)
+			}
+			if artifact.Encoding != encoding || !strings.HasSuffix(artifact.Path, "web-1_perf.data"+encodingExtensions[encoding]) {
+				t.Errorf("SaveProfile() = %s with encoding %q, want a %s artifact", artifact.Path, artifact.Encoding, encoding)
+			}
+			if artifact.UncompressedSize != int64(len(content)) || artifact.Size >= artifact.UncompressedSize {
+				t.Errorf("sizes = %d compressed, %d uncompressed", artifact.Size, artifact.UncompressedSize)
+			}
+			uploaded := sha256.Sum256([]byte(content))
+			if artifact.UncompressedSHA256 != hex.EncodeToString(uploaded[:]) || artifact.UploadSHA256() != artifact.UncompressedSHA256 ||
+				artifact.SHA256 == artifact.UncompressedSHA256 {
+				t.Errorf("digests = %s stored, %s uploaded, want the upload's kept apart", artifact.SHA256, artifact.UncompressedSHA256)
+			}
+
+			stored, rc, err := mgr.OpenArtifact(ctx, artifact.Path)
+			if err != nil {
+				t.Fatalf("OpenArtifact() error = %v", err)
+			}
+			defer func() {
+				_ = rc.Close()
+			}()
+			if stored.Encoding != encoding || stored.SHA256 != artifact.SHA256 || stored.UncompressedSHA256 != artifact.UncompressedSHA256 {
+				t.Errorf("sidecar = %+v, want encoding and digest recorded", stored)
+			}
+			decoded, err := NewDecoder(rc, stored.Encoding)
+			if err != nil {
+				t.Fatalf("NewDecoder() error = %v", err)
+			}
+			got, err := io.ReadAll(decoded)
+			if err != nil || string(got) != content {
+				t.Errorf("decoded content differs from the upload, err = %v", err)
+			}
+
+			// A retry is recognized by the upload's digest, even if another
+			// compressor version stored different bytes
+			stored.SHA256 = strings.Repeat("0", 64)
+			if err := mgr.backend.Delete(ctx, stored.Path+MetadataSuffix); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := mgr.writeSidecar(ctx, stored); err != nil {
+				t.Fatalf("writeSidecar() error = %v", err)
+			}
+			retry, err := mgr.SaveProfile(ctx, bytes.NewBufferString(content), metadata)
+			if err != nil {
+				t.Fatalf("SaveProfile() retry error = %v", err)
+			}
+			if !retry.Deduplicated || retry.Path != artifact.Path {
+				t.Errorf("retry = %s, deduplicated %v, want %s deduplicated", retry.Path, retry.Deduplicated, artifact.Path)
+			}
+
+			found, err := mgr.ListArtifacts(ctx, ArtifactFilter{Namespace: "default", SHA256: artifact.UncompressedSHA256})
+			if err != nil || len(found) != 1 {
+				t.Errorf("ListArtifacts() by upload digest = %d artifacts, %v, want 1", len(found), err)
+			}
+		})
+	}
+}
+
+func TestSaveProfile_PreCompressed(t *testing.T) {
+	mgr, err := NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte("already compressed"))
+	_ = gz.Close()
+	compressed := buf.Bytes()
+
+	// Stored as sent even though the PowerTool asks for zstd
+	artifact, err := mgr.SaveProfile(ctx, bytes.NewReader(compressed), ProfileMetadata{
+		Namespace: "default", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1",
+		Filename: "capture.pcap.gz", Encoding: EncodingGzip, Compress: EncodingZstd,
+	})
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	if artifact.Encoding != EncodingGzip || artifact.Size != int64(len(compressed)) || artifact.UncompressedSize != 0 {
+		t.Errorf("SaveProfile() = %+v, want the gzip upload stored unchanged", artifact)
+	}
+	if !strings.HasSuffix(artifact.Path, "web-1_capture.pcap.gz") {
+		t.Errorf("path = %s, want the extension not repeated", artifact.Path)
+	}
+
+	// Content that does not match its declared encoding is refused
+	_, err = mgr.SaveProfile(ctx, bytes.NewBufferString("plain text"), ProfileMetadata{
+		Namespace: "default", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1",
+		Filename: "other.data", Encoding: EncodingZstd,
+	})
+	if !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("SaveProfile() with wrong encoding error = %v, want ErrInvalidMetadata", err)
+	}
+}
@@ -0,0 +1,456 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// EncryptionAlgorithm names the format of encrypted artifacts: the content
+// is split into segments, each sealed with AES-256-GCM under the artifact's
+// own data key. A segment's nonce is its index and a flag marking the last
+// segment, so segments cannot be reordered, dropped or truncated unnoticed.
+const EncryptionAlgorithm = "AES-256-GCM-STREAM"
+
+// encryptionSegmentSize is the plaintext bytes sealed in each segment. Range
+// requests decrypt whole segments, so it bounds the waste of a small read.
+const encryptionSegmentSize = 64 << 10
+
+// keySize is the size of master and data keys, for AES-256
+const keySize = 32
+
+// gcmTagSize is the authentication tag added to each sealed segment
+const gcmTagSize = 16
+
+// ErrDecryptionFailed is returned when an artifact or its data key cannot be
+// decrypted: the master key is missing or wrong, or the content was altered
+var ErrDecryptionFailed = errors.New("artifact decryption failed")
+
+// keyIDPattern restricts master key IDs to names that are safe in logs and
+// metadata
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Encryption describes how an artifact is encrypted. It is recorded in the
+// artifact's metadata, which is all rotating the master key rewrites.
+type Encryption struct {
+	Algorithm string `json:"algorithm"`
+	// KeyID names the master key DataKey is sealed with
+	KeyID string `json:"keyId"`
+	// DataKey is the artifact's own key, sealed with the master key
+	DataKey     []byte `json:"dataKey"`
+	SegmentSize int    `json:"segmentSize"`
+	// Size is the size of the encrypted object
+	Size int64 `json:"size"`
+}
+
+// Keyring holds the master keys data keys are sealed with. New artifacts use
+// the primary key; the others only open data keys sealed before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// keyringFile is the YAML layout LoadKeyring reads
+type keyringFile struct {
+	Primary string `json:"primary"`
+	// Keys maps key IDs to base64-encoded 32-byte keys
+	Keys map[string]string `json:"keys"`
+}
+
+// NewKeyring creates a keyring of 32-byte master keys by ID. primary must be
+// one of them.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+	k := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key ID %q: must be letters, digits, '.', '_' or '-'", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %q is %d bytes, want %d", id, len(key), keySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a keyring file, typically a mounted Secret, of the form
+//
+//	primary: 2025-11
+//	keys:
+//	  2025-11: <base64 of 32 random bytes>
+//	  2025-01: <base64 of 32 random bytes>
+func LoadKeyring(path string) (*Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption keyring: %w", err)
+	}
+	var file keyringFile
+	if err := yaml.UnmarshalStrict(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse encryption keyring %s: %w", path, err)
+	}
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in %s: %w", id, path, err)
+		}
+		keys[id] = key
+	}
+	keyring, err := NewKeyring(file.Primary, keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption keyring %s: %w", path, err)
+	}
+	return keyring, nil
+}
+
+// Primary returns the ID of the key new data keys are sealed with
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// KeyIDs returns the IDs of every key in the keyring, sorted
+func (k *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// seal encrypts a data key with the primary key. The key ID is bound as
+// additional data, so a sealed key cannot be passed off as another's.
+func (k *Keyring) seal(dataKey []byte) (string, []byte, error) {
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return k.primary, aead.Seal(nonce, nonce, dataKey, []byte(k.primary)), nil
+}
+
+// open decrypts a data key sealed with the key named keyID
+func (k *Keyring) open(keyID string, sealed []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: master key %q is not in the keyring", ErrDecryptionFailed, keyID)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: sealed data key is too short", ErrDecryptionFailed)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key does not open with master key %q", ErrDecryptionFailed, keyID)
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce is the nonce of segment index. The last segment is marked so
+// a stream cut at a segment boundary does not decrypt.
+func segmentNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// segmentCount is the number of segments size bytes are sealed in. Empty
+// content is one empty segment, so even it is authenticated.
+func segmentCount(size, segmentSize int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + segmentSize - 1) / segmentSize
+}
+
+// encryptedSize is the size of size bytes once encrypted
+func encryptedSize(size, segmentSize int64) int64 {
+	return size + segmentCount(size, segmentSize)*gcmTagSize
+}
+
+// encryptStaged encrypts the size bytes staged at src under a new data key
+// into a new staged file, returning its path and how it was encrypted
+func (m *Manager) encryptStaged(src string, size int64) (string, *Encryption, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	keyID, sealed, err := m.keyring.seal(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open staged profile: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.CreateTemp(m.stagingDir, "encrypted-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create encrypted profile file: %w", err)
+	}
+	outPath := out.Name()
+	fail := func(err error) (string, *Encryption, error) {
+		_ = out.Close()
+		_ = os.Remove(outPath)
+		return "", nil, err
+	}
+
+	segments := segmentCount(size, encryptionSegmentSize)
+	plain := make([]byte, encryptionSegmentSize)
+	sealedSegment := make([]byte, 0, encryptionSegmentSize+aead.Overhead())
+	for i := int64(0); i < segments; i++ {
+		n := min(encryptionSegmentSize, size-i*encryptionSegmentSize)
+		if _, err := io.ReadFull(in, plain[:n]); err != nil {
+			return fail(fmt.Errorf("failed to read staged profile: %w", err))
+		}
+		sealedSegment = aead.Seal(sealedSegment[:0], segmentNonce(i, i == segments-1), plain[:n], nil)
+		if _, err := out.Write(sealedSegment); err != nil {
+			return fail(fmt.Errorf("failed to write encrypted profile data: %w", err))
+		}
+	}
+	if err := out.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to sync encrypted profile data: %w", err))
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(outPath)
+		return "", nil, fmt.Errorf("failed to close encrypted profile file: %w", err)
+	}
+	return outPath, &Encryption{
+		Algorithm:   EncryptionAlgorithm,
+		KeyID:       keyID,
+		DataKey:     sealed,
+		SegmentSize: encryptionSegmentSize,
+		Size:        encryptedSize(size, encryptionSegmentSize),
+	}, nil
+}
+
+// openEncrypted returns the decrypted content of an encrypted artifact
+func (m *Manager) openEncrypted(ctx context.Context, artifact *Artifact) (io.ReadSeekCloser, error) {
+	enc := artifact.Encryption
+	if enc.Algorithm != EncryptionAlgorithm || enc.SegmentSize <= 0 {
+		return nil, fmt.Errorf("%w: unsupported encryption %q", ErrDecryptionFailed, enc.Algorithm)
+	}
+	if m.keyring == nil {
+		return nil, fmt.Errorf("%w: artifact is encrypted and no keyring is configured", ErrDecryptionFailed)
+	}
+	dataKey, err := m.keyring.open(enc.KeyID, enc.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		aead:        aead,
+		src:         &objectReader{ctx: ctx, backend: m.backend, key: artifact.Path, size: enc.Size},
+		size:        artifact.Size,
+		segmentSize: int64(enc.SegmentSize),
+		segment:     -1,
+	}, nil
+}
+
+// decryptingReader decrypts an encrypted object segment by segment. Seeking
+// is by plaintext offset and only reads the segment it lands in, so range
+// requests work on encrypted artifacts too.
+type decryptingReader struct {
+	aead        cipher.AEAD
+	src         io.ReadSeekCloser
+	size        int64
+	segmentSize int64
+
+	offset  int64
+	segment int64
+	plain   []byte
+	sealed  []byte
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	index := r.offset / r.segmentSize
+	if index != r.segment {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.offset-index*r.segmentSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// load reads and opens segment index
+func (r *decryptingReader) load(index int64) error {
+	r.segment = -1
+	overhead := int64(r.aead.Overhead())
+	if _, err := r.src.Seek(index*(r.segmentSize+overhead), io.SeekStart); err != nil {
+		return err
+	}
+	n := min(r.segmentSize, r.size-index*r.segmentSize) + overhead
+	if int64(cap(r.sealed)) < n {
+		r.sealed = make([]byte, n)
+	}
+	if _, err := io.ReadFull(r.src, r.sealed[:n]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read encrypted segment %d: %w", index, err)
+	}
+	last := index == segmentCount(r.size, r.segmentSize)-1
+	plain, err := r.aead.Open(r.plain[:0], segmentNonce(index, last), r.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: segment %d does not authenticate", ErrDecryptionFailed, index)
+	}
+	r.plain = plain
+	r.segment = index
+	return nil
+}
+
+func (r *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = r.offset + offset
+	case io.SeekEnd:
+		next = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if next < 0 {
+		return 0, fmt.Errorf("negative position %d", next)
+	}
+	r.offset = next
+	return next, nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.src.Close()
+}
+
+// replacer is implemented by backends that can atomically replace an object,
+// which re-sealing data keys needs to rewrite metadata in place
+type replacer interface {
+	Replace(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// RewrapResult summarizes one pass of RewrapDataKeys
+type RewrapResult struct {
+	// Rewrapped is how many data keys were sealed again with the primary key
+	Rewrapped int
+	// Remaining is how many artifacts are still sealed with other keys,
+	// because re-sealing them failed
+	Remaining int
+}
+
+// RewrapDataKeys seals the data key of every artifact encrypted under a key
+// other than the primary one with the primary key instead. Only metadata is
+// rewritten; the encrypted content is untouched. Once Remaining is zero the
+// old keys can be removed from the keyring.
+func (m *Manager) RewrapDataKeys(ctx context.Context) (RewrapResult, error) {
+	var result RewrapResult
+	if m.keyring == nil {
+		return result, fmt.Errorf("encryption is not enabled")
+	}
+	rep, ok := m.backend.(replacer)
+	if !ok {
+		return result, fmt.Errorf("storage backend cannot replace artifact metadata")
+	}
+	objects, err := m.backend.List(ctx, "")
+	if err != nil {
+		return result, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+
+	var errs []error
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, MetadataSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(obj.Key, MetadataSuffix)
+		artifact, err := m.readSidecar(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if artifact.Encryption == nil || artifact.Encryption.KeyID == m.keyring.Primary() {
+			continue
+		}
+
+		if err := m.rewrap(ctx, rep, artifact); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			result.Remaining++
+			continue
+		}
+		result.Rewrapped++
+	}
+	return result, errors.Join(errs...)
+}
+
+// rewrap re-seals one artifact's data key with the primary key and replaces
+// its metadata
+func (m *Manager) rewrap(ctx context.Context, rep replacer, artifact *Artifact) error {
+	dataKey, err := m.keyring.open(artifact.Encryption.KeyID, artifact.Encryption.DataKey)
+	if err != nil {
+		return err
+	}
+	keyID, sealed, err := m.keyring.seal(dataKey)
+	if err != nil {
+		return err
+	}
+	artifact.Encryption.KeyID = keyID
+	artifact.Encryption.DataKey = sealed
+
+	data, err := encodeSidecar(artifact)
+	if err != nil {
+		return err
+	}
+	if err := rep.Replace(ctx, artifact.Path+MetadataSuffix, bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to replace artifact metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, primary string, keys map[string][]byte) *Keyring {
+	t.Helper()
+	keyring, err := NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	return keyring
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to generate random bytes: %v", err)
+	}
+	return b
+}
+
+func encryptionMetadata(filename string) ProfileMetadata {
+	return ProfileMetadata{
+		Namespace: "default", AppLabel: "app-web", PowerToolName: "job",
+		PodName: "web-1", Filename: filename,
+	}
+}
+
+func TestNewKeyring_Errors(t *testing.T) {
+	key := make([]byte, keySize)
+	tests := []struct {
+		name    string
+		primary string
+		keys    map[string][]byte
+	}{
+		{"primary missing", "k2", map[string][]byte{"k1": key}},
+		{"short key", "k1", map[string][]byte{"k1": key[:16]}},
+		{"invalid key ID", "../k1", map[string][]byte{"../k1": key}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.primary, tt.keys); err == nil {
+				t.Error("NewKeyring() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "keyring.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write keyring: %v", err)
+		}
+		return path
+	}
+	key := base64.StdEncoding.EncodeToString(randomBytes(t, keySize))
+
+	keyring, err := LoadKeyring(write("primary: 2025-11\nkeys:\n  2025-11: " + key + "\n  2025-01: " + key + "\n"))
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	if keyring.Primary() != "2025-11" {
+		t.Errorf("Primary() = %q, want 2025-11", keyring.Primary())
+	}
+	if got := strings.Join(keyring.KeyIDs(), ","); got != "2025-01,2025-11" {
+		t.Errorf("KeyIDs() = %q, want 2025-01,2025-11", got)
+	}
+
+	for _, content := range []string{
+		"primary: k1\nkeys:\n  k1: not-base64!\n",
+		"primary: k1\nkeys:\n  k1: " + key + "\nunknown: true\n",
+		"keys:\n  k1: " + key + "\n",
+	} {
+		if _, err := LoadKeyring(write(content)); err == nil {
+			t.Errorf("LoadKeyring(%q) error = nil, want error", content)
+		}
+	}
+}
+
+func TestSaveProfile_Encrypted(t *testing.T) {
+	sizes := map[string]int{
+		"empty":          0,
+		"one segment":    1000,
+		"exact segments": 2 * encryptionSegmentSize,
+		"partial last":   2*encryptionSegmentSize + 123,
+	}
+	for name, size := range sizes {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			mgr, err := NewManager(root, "2006-01-02")
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			mgr.EnableEncryption(testKeyring(t, "k1", map[string][]byte{"k1": randomBytes(t, keySize)}))
+			ctx := context.Background()
+			content := randomBytes(t, size)
+
+			artifact, err := mgr.SaveProfile(ctx, bytes.NewReader(content), encryptionMetadata("perf.data"))
+			if err != nil {
+				t.Fatalf("SaveProfile() error = %v", err)
+			}
+			if artifact.Encryption == nil || artifact.Encryption.KeyID != "k1" {
+				t.Fatalf("Encryption = %+v, want sealed with k1", artifact.Encryption)
+			}
+			if artifact.Size != int64(size) || artifact.SHA256 != digestOf(string(content)) {
+				t.Errorf("Size, SHA256 = %d, %s, want those of the plaintext", artifact.Size, artifact.SHA256)
+			}
+
+			stored, err := os.ReadFile(filepath.Join(root, artifact.Path))
+			if err != nil {
+				t.Fatalf("failed to read stored object: %v", err)
+			}
+			if int64(len(stored)) != artifact.Encryption.Size {
+				t.Errorf("stored %d bytes, Encryption.Size = %d", len(stored), artifact.Encryption.Size)
+			}
+			if size > 0 && bytes.Contains(stored, content[:min(size, 64)]) {
+				t.Error("stored object contains plaintext")
+			}
+
+			_, rc, err := mgr.OpenArtifact(ctx, artifact.Path)
+			if err != nil {
+				t.Fatalf("OpenArtifact() error = %v", err)
+			}
+			defer func() {
+				_ = rc.Close()
+			}()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("reading decrypted artifact error = %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("decrypted %d bytes, want the %d stored", len(got), len(content))
+			}
+		})
+	}
+}
+
+func TestOpenArtifact_EncryptedSeek(t *testing.T) {
+	mgr, err := NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	mgr.EnableEncryption(testKeyring(t, "k1", map[string][]byte{"k1": randomBytes(t, keySize)}))
+	ctx := context.Background()
+	content := randomBytes(t, 3*encryptionSegmentSize+17)
+
+	artifact, err := mgr.SaveProfile(ctx, bytes.NewReader(content), encryptionMetadata("perf.data"))
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	_, rc, err := mgr.OpenArtifact(ctx, artifact.Path)
+	if err != nil {
+		t.Fatalf("OpenArtifact() error = %v", err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	// A range spanning a segment boundary, then one back at the start
+	for _, r := range []struct{ offset, length int64 }{
+		{encryptionSegmentSize - 10, 30},
+		{5, 10},
+		{int64(len(content)) - 7, 7},
+	} {
+		if _, err := rc.Seek(r.offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek(%d) error = %v", r.offset, err)
+		}
+		got := make([]byte, r.length)
+		if _, err := io.ReadFull(rc, got); err != nil {
+			t.Fatalf("ReadFull at %d error = %v", r.offset, err)
+		}
+		if !bytes.Equal(got, content[r.offset:r.offset+r.length]) {
+			t.Errorf("bytes at %d differ from the plaintext", r.offset)
+		}
+	}
+}
+
+func TestOpenArtifact_EncryptedTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([]byte) []byte
+	}{
+		{"flipped byte", func(b []byte) []byte {
+			b[10] ^= 1
+			return b
+		}},
+		{"truncated at segment boundary", func(b []byte) []byte {
+			return b[:encryptionSegmentSize+gcmTagSize]
+		}},
+		{"segments swapped", func(b []byte) []byte {
+			n := encryptionSegmentSize + gcmTagSize
+			swapped := append([]byte(nil), b[n:2*n]...)
+			swapped = append(swapped, b[:n]...)
+			return append(swapped, b[2*n:]...)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			mgr, err := NewManager(root, "2006-01-02")
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+			mgr.EnableEncryption(testKeyring(t, "k1", map[string][]byte{"k1": randomBytes(t, keySize)}))
+			ctx := context.Background()
+
+			artifact, err := mgr.SaveProfile(ctx, bytes.NewReader(randomBytes(t, 3*encryptionSegmentSize)), encryptionMetadata("perf.data"))
+			if err != nil {
+				t.Fatalf("SaveProfile() error = %v", err)
+			}
+			path := filepath.Join(root, artifact.Path)
+			stored, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read stored object: %v", err)
+			}
+			if err := os.Remove(path); err != nil {
+				t.Fatalf("failed to remove stored object: %v", err)
+			}
+			if err := os.WriteFile(path, tt.tamper(stored), 0o644); err != nil {
+				t.Fatalf("failed to write tampered object: %v", err)
+			}
+
+			_, rc, err := mgr.OpenArtifact(ctx, artifact.Path)
+			if err != nil {
+				t.Fatalf("OpenArtifact() error = %v", err)
+			}
+			defer func() {
+				_ = rc.Close()
+			}()
+			if _, err := io.ReadAll(rc); err == nil {
+				t.Error("reading tampered artifact succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestManager_RewrapDataKeys(t *testing.T) {
+	root := t.TempDir()
+	ctx := context.Background()
+	oldKey, newKey := randomBytes(t, keySize), randomBytes(t, keySize)
+	content := randomBytes(t, 100000)
+
+	mgr, err := NewManager(root, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	// Stored before encryption was enabled, so never rewrapped
+	plain, err := mgr.SaveProfile(ctx, strings.NewReader("plaintext"), encryptionMetadata("plain.txt"))
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	mgr.EnableEncryption(testKeyring(t, "old", map[string][]byte{"old": oldKey}))
+	artifact, err := mgr.SaveProfile(ctx, bytes.NewReader(content), encryptionMetadata("perf.data"))
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	before, err := os.ReadFile(filepath.Join(root, artifact.Path))
+	if err != nil {
+		t.Fatalf("failed to read stored object: %v", err)
+	}
+
+	// Rotate: the new key becomes primary and the old one is kept to open
+	// existing data keys
+	mgr.EnableEncryption(testKeyring(t, "new", map[string][]byte{"old": oldKey, "new": newKey}))
+	result, err := mgr.RewrapDataKeys(ctx)
+	if err != nil {
+		t.Fatalf("RewrapDataKeys() error = %v", err)
+	}
+	if result.Rewrapped != 1 || result.Remaining != 0 {
+		t.Errorf("RewrapDataKeys() = %+v, want 1 rewrapped and none remaining", result)
+	}
+
+	after, err := os.ReadFile(filepath.Join(root, artifact.Path))
+	if err != nil {
+		t.Fatalf("failed to read stored object: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("rewrapping rewrote the encrypted content")
+	}
+
+	// The old key can now be dropped
+	mgr.EnableEncryption(testKeyring(t, "new", map[string][]byte{"new": newKey}))
+	for _, a := range []struct {
+		path string
+		want []byte
+	}{{artifact.Path, content}, {plain.Path, []byte("plaintext")}} {
+		stored, rc, err := mgr.OpenArtifact(ctx, a.path)
+		if err != nil {
+			t.Fatalf("OpenArtifact(%s) error = %v", a.path, err)
+		}
+		got, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil || !bytes.Equal(got, a.want) {
+			t.Errorf("OpenArtifact(%s) content differs, error = %v", a.path, err)
+		}
+		if stored.Encryption != nil && stored.Encryption.KeyID != "new" {
+			t.Errorf("KeyID = %q after rewrap, want new", stored.Encryption.KeyID)
+		}
+	}
+
+	if result, err := mgr.RewrapDataKeys(ctx); err != nil || result.Rewrapped != 0 {
+		t.Errorf("second RewrapDataKeys() = %+v, %v, want nothing to do", result, err)
+	}
+}
+
+func TestOpenArtifact_MissingMasterKey(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	mgr.EnableEncryption(testKeyring(t, "old", map[string][]byte{"old": randomBytes(t, keySize)}))
+	artifact, err := mgr.SaveProfile(ctx, strings.NewReader("secret"), encryptionMetadata("perf.data"))
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+
+	mgr.EnableEncryption(testKeyring(t, "new", map[string][]byte{"new": randomBytes(t, keySize)}))
+	if _, _, err := mgr.OpenArtifact(ctx, artifact.Path); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("OpenArtifact() error = %v, want ErrDecryptionFailed", err)
+	}
+	result, err := mgr.RewrapDataKeys(ctx)
+	if err == nil || result.Remaining != 1 {
+		t.Errorf("RewrapDataKeys() = %+v, %v, want the artifact reported as remaining", result, err)
+	}
+}
+
+func TestSaveProfile_CompressedAndEncrypted(t *testing.T) {
+	ctx := context.Background()
+	mgr, err := NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	mgr.EnableEncryption(testKeyring(t, "k1", map[string][]byte{"k1": randomBytes(t, keySize)}))
+	content := strings.Repeat("perf sample ", 10000)
+	metadata := encryptionMetadata("perf.data")
+	metadata.Compress = EncodingZstd
+
+	artifact, err := mgr.SaveProfile(ctx, strings.NewReader(content), metadata)
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	if artifact.Encryption == nil || artifact.Encoding != EncodingZstd {
+		t.Fatalf("artifact = %+v, want compressed and encrypted", artifact)
+	}
+	if artifact.Encryption.Size >= int64(len(content)) {
+		t.Errorf("stored %d bytes for %d compressible bytes, want compression before encryption", artifact.Encryption.Size, len(content))
+	}
+
+	_, rc, err := mgr.OpenArtifact(ctx, artifact.Path)
+	if err != nil {
+		t.Fatalf("OpenArtifact() error = %v", err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	decoded, err := NewDecoder(rc, artifact.Encoding)
+	if err != nil {
+		t.Fatalf("NewDecoder() error = %v", err)
+	}
+	got, err := io.ReadAll(decoded)
+	if err != nil || string(got) != content {
+		t.Errorf("decrypted and decompressed content differs, error = %v", err)
+	}
+}
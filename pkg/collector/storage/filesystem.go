@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FilesystemBackend stores objects as files below a root directory, typically
+// a PersistentVolume mounted into the collector
+type FilesystemBackend struct {
+	root string
+	// afterMkdir runs between creating an upload's directory and its first
+	// file, for tests to race PruneEmptyDirs there
+	afterMkdir func(dir string)
+}
+
+// NewFilesystemBackend creates root if needed and stores objects below it
+func NewFilesystemBackend(root string) (*FilesystemBackend, error) {
+	if root == "" {
+		return nil, fmt.Errorf("storage path is required")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &FilesystemBackend{root: root}, nil
+}
+
+// Put writes the object to a hidden temp file in its directory, fsyncs it and
+// hard-links it into place, so readers never see a partial object and an
+// existing one is never replaced. If r is a file on the same filesystem it is
+// linked directly instead of copied.
+func (b *FilesystemBackend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+
+	// PruneEmptyDirs may remove the directory before the upload puts its
+	// first file in it, so that step is retried once. After that the
+	// directory is not empty and the sweeper leaves it alone.
+	tmp, linked, err := b.create(key, dir, path, r)
+	if errors.Is(err, fs.ErrNotExist) {
+		tmp, linked, err = b.create(key, dir, path, r)
+	}
+	if err != nil {
+		return err
+	}
+	if linked {
+		return syncDir(dir)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write profile data: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync profile data: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close profile file: %w", err)
+	}
+
+	if err := os.Link(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to publish %s: %w", key, err)
+	}
+	return syncDir(dir)
+}
+
+// create makes dir and puts the first file of an upload in it: the object
+// itself when r is a file that can be linked into place, otherwise a temp
+// file to copy r to
+func (b *FilesystemBackend) create(key, dir, path string, r io.Reader) (tmp *os.File, linked bool, err error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if b.afterMkdir != nil {
+		b.afterMkdir(dir)
+	}
+	// MkdirAll follows symlinks, so check again against what is on disk
+	if err := b.checkContainedOnDisk(dir); err != nil {
+		return nil, false, err
+	}
+
+	if f, ok := r.(*os.File); ok {
+		if err := os.Link(f.Name(), path); err == nil {
+			return nil, true, nil
+		} else if errors.Is(err, fs.ErrExist) || errors.Is(err, fs.ErrNotExist) {
+			return nil, false, fmt.Errorf("failed to publish %s: %w", key, err)
+		}
+		// Most likely a different filesystem; fall back to copying
+	}
+
+	tmp, err = os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create profile file: %w", err)
+	}
+	return tmp, false, nil
+}
+
+// Replace writes the object to a hidden temp file in its directory and renames
+// it over the existing one, so readers see either the old or the new object
+func (b *FilesystemBackend) Replace(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := b.checkContainedOnDisk(dir); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".replace-*")
+	if err != nil {
+		return fmt.Errorf("failed to create replacement file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write replacement data: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync replacement data: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close replacement file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", key, err)
+	}
+	return syncDir(dir)
+}
+
+func (b *FilesystemBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (b *FilesystemBackend) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	f, err := b.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	file := f.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to seek in %s: %w", key, err)
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (b *FilesystemBackend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+// List walks the directories below prefix. Hidden files and directories, such
+// as in-progress uploads, are skipped.
+func (b *FilesystemBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// Start from the deepest directory the prefix names, then filter by the
+	// full prefix
+	start := b.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := b.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		start = dir
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == start {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != start {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+	return objects, nil
+}
+
+func (b *FilesystemBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Check verifies that files can be created below root
+func (b *FilesystemBackend) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(b.root, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("storage directory is not writable: %w", err)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// PruneEmptyDirs removes directories left empty by deleted artifacts, deepest
+// first. The root and hidden directories are kept.
+func (b *FilesystemBackend) PruneEmptyDirs(ctx context.Context) (int, error) {
+	var dirs []string
+	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() || path == b.root {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to walk storage directory: %w", err)
+	}
+
+	// WalkDir visits parents before children, so going backwards empties
+	// children before their parents are tried
+	removed := 0
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		// Remove fails if an upload created a file in the meantime
+		if err := os.Remove(dirs[i]); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// path maps a key to a file below root, rejecting keys that lexically leave it
+func (b *FilesystemBackend) path(key string) (string, error) {
+	path := filepath.Join(b.root, filepath.FromSlash(key))
+	rel, err := filepath.Rel(b.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("%w: key %s escapes storage directory", ErrInvalidMetadata, key)
+	}
+	return path, nil
+}
+
+// checkContainedOnDisk rejects paths that leave the root directory once
+// symlinks are resolved
+func (b *FilesystemBackend) checkContainedOnDisk(path string) error {
+	base, err := filepath.EvalSymlinks(b.root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve storage directory: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve profile directory: %w", err)
+	}
+	rel, err := filepath.Rel(base, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: path %s resolves outside storage directory", ErrInvalidMetadata, path)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open profile directory: %w", err)
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync profile directory: %w", err)
+	}
+	return nil
+}
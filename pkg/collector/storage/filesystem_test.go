@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilesystemBackend_Objects(t *testing.T) {
+	root := t.TempDir()
+	backend, err := NewFilesystemBackend(root)
+	if err != nil {
+		t.Fatalf("NewFilesystemBackend() error = %v", err)
+	}
+	ctx := context.Background()
+
+	key := "default/app-web/job/2025-01-02/web-1_perf.data"
+	if err := backend.Put(ctx, key, bytes.NewBufferString("profile"), 7); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := backend.Put(ctx, key, bytes.NewBufferString("other"), 5); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Put() over existing object error = %v, want fs.ErrExist", err)
+	}
+
+	rc, err := backend.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "profile" {
+		t.Errorf("Get() = %q, want %q", data, "profile")
+	}
+
+	if err := backend.Replace(ctx, key, bytes.NewBufferString("PROFILE"), 7); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	rc, err = backend.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() after Replace() error = %v", err)
+	}
+	data, _ = io.ReadAll(rc)
+	_ = rc.Close()
+	if string(data) != "PROFILE" {
+		t.Errorf("Get() after Replace() = %q, want %q", data, "PROFILE")
+	}
+
+	// Hidden staging and session directories are not artifacts
+	if err := os.MkdirAll(filepath.Join(root, ".uploads"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".uploads", "session.data"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Put(ctx, "prod/app-web/job/2025-01-02/web-1_perf.data", bytes.NewBufferString("x"), 1); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	all, err := backend.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("List(\"\") = %+v, want the two artifacts", all)
+	}
+	scoped, err := backend.List(ctx, "default/app-web/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(scoped) != 1 || scoped[0].Key != key || scoped[0].Size != 7 {
+		t.Errorf("List(\"default/app-web/\") = %+v, want only %s", scoped, key)
+	}
+	if missing, err := backend.List(ctx, "missing/"); err != nil || len(missing) != 0 {
+		t.Errorf("List() of missing prefix = %+v, %v, want nothing", missing, err)
+	}
+
+	if err := backend.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := backend.Stat(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after Delete() error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestFilesystemBackend_PutRacesPrune(t *testing.T) {
+	ctx := context.Background()
+	staged := filepath.Join(t.TempDir(), "staged")
+	if err := os.WriteFile(staged, []byte("profile"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range map[string]func() io.Reader{
+		"copied": func() io.Reader { return bytes.NewBufferString("profile") },
+		"linked": func() io.Reader {
+			f, err := os.Open(staged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = f.Close() })
+			return f
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			backend, err := NewFilesystemBackend(t.TempDir())
+			if err != nil {
+				t.Fatalf("NewFilesystemBackend() error = %v", err)
+			}
+			// The sweeper runs once, right after Put created the directories
+			swept := 0
+			backend.afterMkdir = func(string) {
+				if swept == 0 {
+					swept, _ = backend.PruneEmptyDirs(ctx)
+				}
+			}
+
+			key := "default/app-web/job/2025-01-02/web-1_perf.data"
+			if err := backend.Put(ctx, key, body(), 7); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if swept == 0 {
+				t.Fatal("PruneEmptyDirs() removed nothing, the race was not exercised")
+			}
+			if _, err := backend.Stat(ctx, key); err != nil {
+				t.Errorf("Stat() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestFilesystemBackend_RejectsEscapingKeys(t *testing.T) {
+	backend, err := NewFilesystemBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemBackend() error = %v", err)
+	}
+	if err := backend.Put(context.Background(), "../outside", bytes.NewBufferString("x"), 1); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("Put() error = %v, want ErrInvalidMetadata", err)
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	if b, err := NewBackend(Config{Path: t.TempDir()}); err != nil {
+		t.Errorf("NewBackend() default error = %v", err)
+	} else if _, ok := b.(*FilesystemBackend); !ok {
+		t.Errorf("NewBackend() default = %T, want *FilesystemBackend", b)
+	}
+
+	b, err := NewBackend(Config{Backend: BackendS3, S3: S3Config{
+		Endpoint: "http://minio:9000", Bucket: "profiles", AccessKeyID: "a", SecretAccessKey: "b",
+	}})
+	if err != nil {
+		t.Errorf("NewBackend() s3 error = %v", err)
+	} else if _, ok := b.(*S3Backend); !ok {
+		t.Errorf("NewBackend() s3 = %T, want *S3Backend", b)
+	}
+
+	if _, err := NewBackend(Config{Backend: "gcs"}); err == nil {
+		t.Error("NewBackend() with unknown backend expected error, got nil")
+	}
+}
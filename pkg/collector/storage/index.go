@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// indexVersion changes whenever the layout of the index does, so an index
+// written by another version is rebuilt rather than misread
+const indexVersion = "1"
+
+// indexBatchSize bounds the sidecars read and indexed per transaction while
+// rebuilding. Uploads wait for the batch in progress to be indexed.
+const indexBatchSize = 100
+
+var (
+	// bucketArtifacts maps artifact keys to their metadata, as in the sidecar
+	bucketArtifacts = []byte("artifacts")
+	// bucketByTime holds a scope, the creation time and the artifact key
+	// for each artifact and each scope it belongs to: its namespace and its
+	// PowerTool. Listings scan the narrowest scope, already in time order.
+	bucketByTime = []byte("by-time")
+	bucketMeta   = []byte("meta")
+
+	metaVersion = []byte("version")
+	metaState   = []byte("state")
+)
+
+// Index states recorded in the meta bucket. An index still open when the
+// collector stopped may have missed updates.
+const (
+	indexStateOpen  = "open"
+	indexStateClean = "clean"
+)
+
+// Scope tags in by-time keys
+const (
+	scopeNamespace = 'n'
+	scopePowerTool = 'p'
+)
+
+// Index is a metadata index of stored artifacts, kept in an embedded bbolt
+// database so listings neither walk the storage tree nor read every sidecar.
+// The sidecars stay the source of truth: the index is rebuilt from them
+// whenever it cannot be trusted.
+type Index struct {
+	db *bolt.DB
+	// mu orders updates against the batches of a rebuild
+	mu sync.Mutex
+	// stale is set while the index does not match storage: before it is
+	// rebuilt, and after an update failed. Listings then read sidecars, and
+	// the index is not marked clean on close so the next start rebuilds it.
+	stale atomic.Bool
+	// failed records an update failing while a rebuild runs, so that the
+	// rebuild does not clear stale over the missing entry
+	failed atomic.Bool
+}
+
+// OpenIndex opens the index at path, creating it if needed. An index that was
+// not closed cleanly, or was written by another version, is stale until
+// rebuilt; see Manager.RebuildIndex.
+func OpenIndex(path string) (*Index, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create artifact index directory: %w", err)
+	}
+	// Only one collector may hold the index; another one still running
+	// fails the open rather than blocking start-up
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open artifact index: %w", err)
+	}
+
+	clean := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketArtifacts, bucketByTime} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		clean = string(meta.Get(metaVersion)) == indexVersion && string(meta.Get(metaState)) == indexStateClean
+		return meta.Put(metaState, []byte(indexStateOpen))
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open artifact index: %w", err)
+	}
+
+	idx := &Index{db: db}
+	idx.stale.Store(!clean)
+	return idx, nil
+}
+
+// Ready reports whether listings can be answered from the index
+func (i *Index) Ready() bool {
+	return i != nil && !i.stale.Load()
+}
+
+// Close marks the index clean, unless it went stale, and closes it
+func (i *Index) Close() error {
+	if i == nil {
+		return nil
+	}
+	if !i.stale.Load() {
+		if err := i.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketMeta).Put(metaState, []byte(indexStateClean))
+		}); err != nil {
+			log.Printf("Failed to mark artifact index clean, it will be rebuilt: %v", err)
+		}
+	}
+	return i.db.Close()
+}
+
+// Put adds or replaces the entry of an artifact. A failure is logged and
+// leaves the index stale, since the artifact itself is stored.
+func (i *Index) Put(artifact *Artifact) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.db.Update(func(tx *bolt.Tx) error {
+		return putIndexEntry(tx, artifact)
+	}); err != nil {
+		i.fail("index", artifact.Path, err)
+	}
+}
+
+// Delete removes the entry of the artifact at key, if there is one
+func (i *Index) Delete(key string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.db.Update(func(tx *bolt.Tx) error {
+		return deleteIndexEntry(tx, key)
+	}); err != nil {
+		i.fail("unindex", key, err)
+	}
+}
+
+func (i *Index) fail(op, key string, err error) {
+	i.failed.Store(true)
+	if !i.stale.Swap(true) {
+		log.Printf("Failed to %s artifact %s, listing from storage until the index is rebuilt: %v", op, key, err)
+	}
+}
+
+// List returns the indexed artifacts matching filter, oldest first
+func (i *Index) List(filter ArtifactFilter) ([]Artifact, error) {
+	scope := indexScope(scopeNamespace, filter.Namespace)
+	if filter.PowerToolName != "" {
+		scope = indexScope(scopePowerTool, filter.Namespace, filter.PowerToolName)
+	}
+	start := scope
+	if !filter.Since.IsZero() {
+		start = timeKey(scope, filter.Since, "")
+	}
+
+	var artifacts []Artifact
+	err := i.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bucketArtifacts)
+		c := tx.Bucket(bucketByTime).Cursor()
+		for k, _ := c.Seek(start); k != nil && bytes.HasPrefix(k, scope); k, _ = c.Next() {
+			rest := k[len(scope):]
+			if len(rest) < 8 {
+				continue
+			}
+			if !filter.Until.IsZero() && int64(binary.BigEndian.Uint64(rest[:8])) > filter.Until.UnixNano() {
+				break
+			}
+			data := records.Get(rest[8:])
+			if data == nil {
+				continue
+			}
+			var artifact Artifact
+			if err := json.Unmarshal(data, &artifact); err != nil {
+				return fmt.Errorf("failed to decode indexed artifact %s: %w", rest[8:], err)
+			}
+			if filter.matches(&artifact) {
+				artifacts = append(artifacts, artifact)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// indexScope builds the by-time key prefix of a scope. Namespaces and
+// PowerTool names never contain a zero byte.
+func indexScope(tag byte, names ...string) []byte {
+	scope := []byte{tag}
+	for _, name := range names {
+		scope = append(scope, name...)
+		scope = append(scope, 0)
+	}
+	return scope
+}
+
+// timeKey orders artifacts in a scope by creation time and then key, as
+// ListArtifacts does
+func timeKey(scope []byte, created time.Time, key string) []byte {
+	k := make([]byte, 0, len(scope)+8+len(key))
+	k = append(k, scope...)
+	k = binary.BigEndian.AppendUint64(k, uint64(max(created.UnixNano(), 0)))
+	return append(k, key...)
+}
+
+// timeKeys are the by-time keys of an artifact, one per scope
+func timeKeys(a *Artifact) [][]byte {
+	return [][]byte{
+		timeKey(indexScope(scopeNamespace, a.Namespace), a.CreatedAt, a.Path),
+		timeKey(indexScope(scopePowerTool, a.Namespace, a.PowerToolName), a.CreatedAt, a.Path),
+	}
+}
+
+func putIndexEntry(tx *bolt.Tx, artifact *Artifact) error {
+	if err := deleteIndexEntry(tx, artifact.Path); err != nil {
+		return err
+	}
+	data, err := json.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketArtifacts).Put([]byte(artifact.Path), data); err != nil {
+		return err
+	}
+	byTime := tx.Bucket(bucketByTime)
+	for _, k := range timeKeys(artifact) {
+		if err := byTime.Put(k, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteIndexEntry(tx *bolt.Tx, key string) error {
+	records := tx.Bucket(bucketArtifacts)
+	data := records.Get([]byte(key))
+	if data == nil {
+		return nil
+	}
+	var old Artifact
+	if err := json.Unmarshal(data, &old); err == nil {
+		byTime := tx.Bucket(bucketByTime)
+		for _, k := range timeKeys(&old) {
+			if err := byTime.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return records.Delete([]byte(key))
+}
+
+// EnableIndex answers listings from the index at path while it is up to
+// date. An index that is stale when opened stays unused until RebuildIndex
+// has run. Deleting the file forces a rebuild.
+func (m *Manager) EnableIndex(path string) (*Index, error) {
+	idx, err := OpenIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	m.index = idx
+	return idx, nil
+}
+
+// RebuildIndex replaces the contents of the index with the sidecars in
+// storage and returns how many artifacts it indexed. Uploads and deletions
+// may go on meanwhile; listings read sidecars until it succeeds.
+func (m *Manager) RebuildIndex(ctx context.Context) (int, error) {
+	idx := m.index
+	if idx == nil {
+		return 0, fmt.Errorf("the artifact index is not enabled")
+	}
+	idx.stale.Store(true)
+	idx.failed.Store(false)
+
+	// Emptied before listing, so an artifact stored in between is either
+	// listed or indexed by its own upload afterwards
+	idx.mu.Lock()
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketArtifacts, bucketByTime} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	idx.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to empty artifact index: %w", err)
+	}
+
+	objects, err := m.backend.List(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, MetadataSuffix) {
+			keys = append(keys, strings.TrimSuffix(obj.Key, MetadataSuffix))
+		}
+	}
+
+	count := 0
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), indexBatchSize)]
+		keys = keys[len(batch):]
+		n, err := m.indexBatch(ctx, idx, batch)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Put(metaVersion, []byte(indexVersion))
+	})
+	if err != nil {
+		return count, fmt.Errorf("failed to record artifact index version: %w", err)
+	}
+	if idx.failed.Load() {
+		return count, fmt.Errorf("failed to index artifacts stored while rebuilding")
+	}
+	idx.stale.Store(false)
+	return count, nil
+}
+
+// indexBatch indexes the artifacts at keys. Sidecars are read under the
+// index lock, so a deletion racing the rebuild cannot leave an entry behind
+// and an update cannot be overwritten with older metadata.
+func (m *Manager) indexBatch(ctx context.Context, idx *Index, keys []string) (int, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var artifacts []*Artifact
+	for _, key := range keys {
+		artifact, err := m.readSidecar(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted while rebuilding
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", key, err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		for _, artifact := range artifacts {
+			if err := putIndexEntry(tx, artifact); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to index artifacts: %w", err)
+	}
+	return len(artifacts), nil
+}
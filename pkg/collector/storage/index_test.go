@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestIndex enables an empty, up to date index on mgr
+func newTestIndex(t *testing.T, mgr *Manager, root string) *Index {
+	t.Helper()
+	idx, err := mgr.EnableIndex(filepath.Join(root, ".index", "artifacts.db"))
+	if err != nil {
+		t.Fatalf("EnableIndex() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = idx.Close()
+	})
+	if _, err := mgr.RebuildIndex(context.Background()); err != nil {
+		t.Fatalf("RebuildIndex() error = %v", err)
+	}
+	return idx
+}
+
+func saveTestArtifacts(t *testing.T, mgr *Manager, pods ...string) {
+	t.Helper()
+	for _, pod := range pods {
+		metadata := ProfileMetadata{
+			Namespace: "default", AppLabel: "app-web", PowerToolName: "job", PodName: pod, Filename: "perf.data",
+			NodeName: "node-1", Labels: map[string]string{"app": "web"},
+		}
+		if _, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString(pod), metadata); err != nil {
+			t.Fatalf("SaveProfile() error = %v", err)
+		}
+	}
+}
+
+func TestIndex_Rebuild(t *testing.T) {
+	root := t.TempDir()
+	mgr, err := NewManager(root, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	ctx := context.Background()
+	saveTestArtifacts(t, mgr, "web-1", "web-2", "web-3")
+	filter := ArtifactFilter{Namespace: "default", PowerToolName: "job"}
+	want, err := mgr.ListArtifacts(ctx, filter)
+	if err != nil {
+		t.Fatalf("ListArtifacts() error = %v", err)
+	}
+
+	path := filepath.Join(root, ".index", "artifacts.db")
+	idx, err := mgr.EnableIndex(path)
+	if err != nil {
+		t.Fatalf("EnableIndex() error = %v", err)
+	}
+	if idx.Ready() {
+		t.Fatal("new index is ready before it was built")
+	}
+	n, err := mgr.RebuildIndex(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("RebuildIndex() = %d, %v; want 3 artifacts", n, err)
+	}
+	got, err := idx.List(filter)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("indexed listing = %+v, want %+v", got, want)
+	}
+
+	// Closed cleanly, the index is trusted on the next start
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	idx, err = OpenIndex(path)
+	if err != nil {
+		t.Fatalf("OpenIndex() error = %v", err)
+	}
+	if !idx.Ready() {
+		t.Error("cleanly closed index is not ready")
+	}
+	if got, _ := idx.List(filter); len(got) != 3 {
+		t.Errorf("reopened index lists %d artifacts, want 3", len(got))
+	}
+
+	// Not closed, as when the collector is killed, it must be rebuilt
+	if err := idx.db.Close(); err != nil {
+		t.Fatalf("closing database error = %v", err)
+	}
+	idx, err = OpenIndex(path)
+	if err != nil {
+		t.Fatalf("OpenIndex() error = %v", err)
+	}
+	defer func() {
+		_ = idx.Close()
+	}()
+	if idx.Ready() {
+		t.Error("index that was not closed is ready")
+	}
+}
+
+func TestIndex_Updates(t *testing.T) {
+	root := t.TempDir()
+	mgr, err := NewManager(root, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	ctx := context.Background()
+	idx := newTestIndex(t, mgr, root)
+	saveTestArtifacts(t, mgr, "web-1", "web-2")
+
+	filter := ArtifactFilter{Namespace: "default"}
+	got, err := idx.List(filter)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("List() = %d artifacts, %v; want 2", len(got), err)
+	}
+	if got[0].NodeName != "node-1" || got[0].Labels["app"] != "web" {
+		t.Errorf("indexed artifact = %+v, want its node and labels", got[0])
+	}
+
+	// Expired artifacts leave the index with their files
+	if _, err := mgr.Sweep(ctx, RetentionPolicy{DefaultDays: 1}, time.Now().AddDate(0, 0, 2)); err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if got, err := idx.List(filter); err != nil || len(got) != 0 {
+		t.Errorf("List() after sweep = %+v, %v; want nothing", got, err)
+	}
+
+	// A failed update makes listings read sidecars again
+	if err := idx.db.Close(); err != nil {
+		t.Fatalf("closing database error = %v", err)
+	}
+	saveTestArtifacts(t, mgr, "web-3")
+	if idx.Ready() {
+		t.Fatal("index is ready after an update failed")
+	}
+	if got, err := mgr.ListArtifacts(ctx, filter); err != nil || len(got) != 1 {
+		t.Errorf("ListArtifacts() = %d artifacts, %v; want the one in storage", len(got), err)
+	}
+}
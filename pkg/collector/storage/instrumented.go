@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"toe/pkg/collector/metrics"
+)
+
+// instrumentedBackend records the latency of every operation of the backend
+// it wraps
+type instrumentedBackend struct {
+	backend Backend
+}
+
+// NewInstrumentedBackend wraps backend so its operations are timed in
+// metrics.StorageLatency
+func NewInstrumentedBackend(backend Backend) Backend {
+	return &instrumentedBackend{backend: backend}
+}
+
+func observe(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.StorageLatency.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (b *instrumentedBackend) Put(ctx context.Context, key string, r io.Reader, size int64) (err error) {
+	defer func(start time.Time) {
+		observe("put", start, err)
+	}(time.Now())
+	return b.backend.Put(ctx, key, r, size)
+}
+
+// Replace passes through to backends that can replace objects
+func (b *instrumentedBackend) Replace(ctx context.Context, key string, r io.Reader, size int64) (err error) {
+	rep, ok := b.backend.(replacer)
+	if !ok {
+		return fmt.Errorf("storage backend cannot replace objects")
+	}
+	defer func(start time.Time) {
+		observe("replace", start, err)
+	}(time.Now())
+	return rep.Replace(ctx, key, r, size)
+}
+
+func (b *instrumentedBackend) Get(ctx context.Context, key string) (rc io.ReadCloser, err error) {
+	defer func(start time.Time) {
+		observe("get", start, err)
+	}(time.Now())
+	return b.backend.Get(ctx, key)
+}
+
+func (b *instrumentedBackend) GetRange(ctx context.Context, key string, offset, length int64) (rc io.ReadCloser, err error) {
+	defer func(start time.Time) {
+		observe("get_range", start, err)
+	}(time.Now())
+	return b.backend.GetRange(ctx, key, offset, length)
+}
+
+func (b *instrumentedBackend) Stat(ctx context.Context, key string) (info ObjectInfo, err error) {
+	defer func(start time.Time) {
+		observe("stat", start, err)
+	}(time.Now())
+	return b.backend.Stat(ctx, key)
+}
+
+func (b *instrumentedBackend) List(ctx context.Context, prefix string) (objects []ObjectInfo, err error) {
+	defer func(start time.Time) {
+		observe("list", start, err)
+	}(time.Now())
+	return b.backend.List(ctx, prefix)
+}
+
+func (b *instrumentedBackend) Delete(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) {
+		observe("delete", start, err)
+	}(time.Now())
+	return b.backend.Delete(ctx, key)
+}
+
+// PruneEmptyDirs passes through to backends with directories
+func (b *instrumentedBackend) PruneEmptyDirs(ctx context.Context) (int, error) {
+	if pruner, ok := b.backend.(emptyDirPruner); ok {
+		return pruner.PruneEmptyDirs(ctx)
+	}
+	return 0, nil
+}
+
+// Check passes through to backends with their own writability check
+func (b *instrumentedBackend) Check(ctx context.Context) error {
+	return checkBackend(ctx, b.backend)
+}
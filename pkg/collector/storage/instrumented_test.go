@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"toe/pkg/collector/metrics"
+)
+
+func TestInstrumentedBackend(t *testing.T) {
+	fsBackend, err := NewFilesystemBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFilesystemBackend() error = %v", err)
+	}
+	backend := NewInstrumentedBackend(fsBackend)
+	ctx := context.Background()
+
+	if err := backend.Put(ctx, "ns/label/pt/date/a", bytes.NewBufferString("x"), 1); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := backend.Stat(ctx, "ns/label/pt/date/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() error = %v, want fs.ErrNotExist through the wrapper", err)
+	}
+
+	if testutil.CollectAndCount(metrics.StorageLatency, "toe_collector_storage_operation_duration_seconds") < 2 {
+		t.Error("expected latency series for put and stat")
+	}
+	if _, ok := backend.(emptyDirPruner); !ok {
+		t.Error("instrumented backend hides PruneEmptyDirs")
+	}
+}
+
+func TestManager_Check(t *testing.T) {
+	root := t.TempDir()
+	mgr, err := NewManager(root, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if err := mgr.Check(context.Background()); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+
+	if err := os.RemoveAll(mgr.stagingDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Check(context.Background()); err == nil {
+		t.Error("Check() without a staging directory expected error, got nil")
+	}
+}
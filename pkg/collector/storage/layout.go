@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultPathTemplate is the layout artifacts have always been stored in:
+// namespace/label/powertool/date
+const DefaultPathTemplate = "{{.Namespace}}/{{.AppLabel}}/{{.PowerToolName}}/{{.Date}}"
+
+// PathFields are the values a path template can use. Date is the upload
+// date, or the day a rolling capture started, in the configured date format.
+// It spans several path elements when the format contains slashes.
+type PathFields struct {
+	Namespace     string
+	AppLabel      string
+	PowerToolName string
+	PodName       string
+	NodeName      string
+	ContainerName string
+	Tool          string
+	Labels        map[string]string
+	Date          string
+}
+
+// samplePathFields render a template once when it is parsed, to check it and
+// to find which path elements hold the namespace, label and PowerTool. The
+// values are chosen not to be mistaken for literal text.
+var samplePathFields = PathFields{
+	Namespace:     "namespace-4e1f",
+	AppLabel:      "label-4e1f",
+	PowerToolName: "powertool-4e1f",
+	PodName:       "pod-4e1f",
+	NodeName:      "node-4e1f",
+	ContainerName: "container-4e1f",
+	Tool:          "tool-4e1f",
+	Labels:        map[string]string{},
+}
+
+// sampleDate stands in for the upload date; every field of the layout has
+// two digits so the date format renders at its full width
+var sampleDate = time.Date(2025, 12, 31, 23, 59, 58, 0, time.UTC)
+
+// PathTemplate lays out the directory each artifact is stored in. Artifacts
+// are named <pod>_<filename> within it. Every directory it renders has the
+// same number of elements, with the namespace and PowerTool each in an
+// element of its own, so an artifact key alone says who owns the artifact.
+type PathTemplate struct {
+	text       string
+	tmpl       *template.Template
+	dateFormat string
+	// depth is the number of elements in a rendered directory
+	depth int
+	// namespace, appLabel and powerTool are the indices of the elements
+	// holding them. appLabel is -1 when the layout leaves the label out.
+	namespace, appLabel, powerTool int
+}
+
+// ParsePathTemplate parses a Go template for artifact directories, such as
+// DefaultPathTemplate, rendering dates with dateFormat. The template must
+// give the namespace and the PowerTool name an element each, since artifacts
+// of different owners would otherwise share names. It must not render empty,
+// hidden, "." or ".." elements or an absolute path. Labels may be missing
+// from a pod, so label lookups need a default, as in
+// {{or (index .Labels "team") "none"}}.
+func ParsePathTemplate(text, dateFormat string) (*PathTemplate, error) {
+	if dateFormat == "" {
+		return nil, fmt.Errorf("dateFormat is required")
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template: %w", err)
+	}
+	t := &PathTemplate{text: text, tmpl: tmpl, dateFormat: dateFormat}
+
+	fields := samplePathFields
+	fields.Date = sampleDate.Format(dateFormat)
+	elements, err := t.render(fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %q: %w", text, err)
+	}
+	t.depth = len(elements)
+	t.namespace = indexOf(elements, fields.Namespace)
+	t.appLabel = indexOf(elements, fields.AppLabel)
+	t.powerTool = indexOf(elements, fields.PowerToolName)
+	if t.namespace < 0 || t.powerTool < 0 {
+		return nil, fmt.Errorf("invalid path template %q: {{.Namespace}} and {{.PowerToolName}} must each be a whole path element", text)
+	}
+	return t, nil
+}
+
+// String returns the template text
+func (t *PathTemplate) String() string {
+	return t.text
+}
+
+// render executes the template and checks every element of the result
+func (t *PathTemplate) render(fields PathFields) ([]string, error) {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, fields); err != nil {
+		return nil, err
+	}
+	dir := b.String()
+	elements := strings.Split(dir, "/")
+	for _, e := range elements {
+		switch {
+		case e == "":
+			return nil, fmt.Errorf("%q has an empty element or is absolute", dir)
+		case strings.HasPrefix(e, "."):
+			return nil, fmt.Errorf("%q has a hidden, \".\" or \"..\" element", dir)
+		case len(e) > maxNameLength:
+			return nil, fmt.Errorf("%q has an element longer than %d characters", dir, maxNameLength)
+		case strings.ContainsAny(e, "\\\x00"):
+			return nil, fmt.Errorf("%q has an element with a backslash or NUL", dir)
+		}
+	}
+	return elements, nil
+}
+
+// directory renders the directory an upload is stored in, dated by date
+func (t *PathTemplate) directory(metadata ProfileMetadata, date time.Time) (string, error) {
+	elements, err := t.render(PathFields{
+		Namespace:     metadata.Namespace,
+		AppLabel:      metadata.AppLabel,
+		PowerToolName: metadata.PowerToolName,
+		PodName:       metadata.PodName,
+		NodeName:      metadata.NodeName,
+		ContainerName: metadata.ContainerName,
+		Tool:          metadata.Tool,
+		Labels:        metadata.Labels,
+		Date:          date.Format(t.dateFormat),
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: path template: %v", ErrInvalidMetadata, err)
+	}
+	// Keys are split by position, so the owner must be found where the
+	// sample put it whatever the values: a slash in a value or a
+	// conditional in the template must not move it
+	dir := path.Join(elements...)
+	if len(elements) != t.depth ||
+		elements[t.namespace] != metadata.Namespace || elements[t.powerTool] != metadata.PowerToolName ||
+		(t.appLabel >= 0 && elements[t.appLabel] != metadata.AppLabel) {
+		return "", fmt.Errorf("%w: path template renders %q, which does not keep the layout of %q", ErrInvalidMetadata, dir, t.text)
+	}
+	return dir, nil
+}
+
+// ValidateKey checks that key names an artifact: a clean relative path below
+// a directory of this layout, with no hidden components
+func (t *PathTemplate) ValidateKey(key string) error {
+	if key == "" || path.Clean(key) != key || path.IsAbs(key) || strings.HasSuffix(key, MetadataSuffix) {
+		return fmt.Errorf("%w: invalid artifact key %q", ErrInvalidMetadata, key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, ".") {
+			return fmt.Errorf("%w: invalid artifact key %q", ErrInvalidMetadata, key)
+		}
+	}
+	if _, _, _, ok := t.SplitKey(key); !ok {
+		return fmt.Errorf("%w: invalid artifact key %q", ErrInvalidMetadata, key)
+	}
+	return nil
+}
+
+// SplitKey returns the namespace, label and PowerTool components of an
+// artifact key: a directory of this layout and a file name, or the segments
+// directory of a rolling capture and a segment name. label is empty
+// when the layout leaves it out. A key of another layout with the same depth
+// still splits, so the result is only a claim until checked against the
+// artifact's metadata.
+func (t *PathTemplate) SplitKey(key string) (namespace, label, powerTool string, ok bool) {
+	parts := strings.Split(key, "/")
+	switch {
+	case len(parts) == t.depth+1:
+	case len(parts) == t.depth+2 && strings.HasSuffix(parts[t.depth], SegmentsSuffix):
+	default:
+		return "", "", "", false
+	}
+	if t.appLabel >= 0 {
+		label = parts[t.appLabel]
+	}
+	return parts[t.namespace], label, parts[t.powerTool], true
+}
+
+// prefix is the longest key prefix all artifacts matching filter share: the
+// leading elements the filter pins down
+func (t *PathTemplate) prefix(filter ArtifactFilter) string {
+	var prefix string
+	for i := 0; i < t.depth; i++ {
+		var value string
+		switch i {
+		case t.namespace:
+			value = filter.Namespace
+		case t.appLabel:
+			value = filter.AppLabel
+		case t.powerTool:
+			value = filter.PowerToolName
+		}
+		if value == "" {
+			break
+		}
+		prefix += value + "/"
+	}
+	return prefix
+}
+
+func indexOf(elements []string, value string) int {
+	for i, e := range elements {
+		if e == value {
+			return i
+		}
+	}
+	return -1
+}
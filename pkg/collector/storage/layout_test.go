@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		wantErr   string
+		wantDepth int
+	}{
+		{name: "default", template: DefaultPathTemplate, wantDepth: 6},
+		{name: "date first", template: "{{.Date}}/{{.Namespace}}/{{.PowerToolName}}", wantDepth: 5},
+		{name: "by tool and node", template: "{{.Namespace}}/{{.Tool}}/{{.NodeName}}/{{.PowerToolName}}", wantDepth: 4},
+		{name: "literal and label with default", template: `archive/{{.Namespace}}/team-{{or (index .Labels "team") "none"}}/{{.PowerToolName}}`, wantDepth: 4},
+		{name: "no namespace", template: "{{.AppLabel}}/{{.PowerToolName}}/{{.Date}}", wantErr: "{{.Namespace}} and {{.PowerToolName}}"},
+		{name: "no PowerTool", template: "{{.Namespace}}/{{.AppLabel}}/{{.Date}}", wantErr: "{{.Namespace}} and {{.PowerToolName}}"},
+		{name: "namespace not a whole element", template: "ns-{{.Namespace}}/{{.PowerToolName}}", wantErr: "{{.Namespace}} and {{.PowerToolName}}"},
+		{name: "absolute", template: "/{{.Namespace}}/{{.PowerToolName}}", wantErr: "absolute"},
+		{name: "parent", template: "../{{.Namespace}}/{{.PowerToolName}}", wantErr: `".."`},
+		{name: "hidden", template: "{{.Namespace}}/.staging/{{.PowerToolName}}", wantErr: "hidden"},
+		{name: "empty element", template: "{{.Namespace}}//{{.PowerToolName}}", wantErr: "empty"},
+		{name: "label without default", template: `{{.Namespace}}/{{index .Labels "team"}}/{{.PowerToolName}}`, wantErr: "empty"},
+		{name: "unknown field", template: "{{.Namespace}}/{{.Cluster}}/{{.PowerToolName}}", wantErr: "Cluster"},
+		{name: "syntax", template: "{{.Namespace}/{{.PowerToolName}}", wantErr: "invalid path template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := ParsePathTemplate(tt.template, "2006/01/02")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParsePathTemplate() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePathTemplate() error = %v", err)
+			}
+			if layout.depth != tt.wantDepth {
+				t.Errorf("depth = %d, want %d", layout.depth, tt.wantDepth)
+			}
+		})
+	}
+
+	if _, err := ParsePathTemplate(DefaultPathTemplate, ""); err == nil {
+		t.Error("ParsePathTemplate() without a date format succeeded")
+	}
+}
+
+func TestPathTemplate_Directory(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	metadata := ProfileMetadata{
+		Namespace: "default", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1", Filename: "perf.data",
+		NodeName: "node-1", Tool: "aperf", Labels: map[string]string{"team": "payments"},
+	}
+	tests := []struct {
+		name     string
+		template string
+		metadata func(ProfileMetadata) ProfileMetadata
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", template: DefaultPathTemplate, want: "default/app-web/job/2025/01/02"},
+		{name: "date first", template: "{{.Date}}/{{.Namespace}}/{{.PowerToolName}}", want: "2025/01/02/default/job"},
+		{name: "by node", template: "{{.Namespace}}/{{.NodeName}}/{{.PowerToolName}}", want: "default/node-1/job"},
+		{name: "label", template: `{{.Namespace}}/{{or (index .Labels "team") "none"}}/{{.PowerToolName}}`, want: "default/payments/job"},
+		{
+			name:     "label missing",
+			template: `{{.Namespace}}/{{or (index .Labels "team") "none"}}/{{.PowerToolName}}`,
+			metadata: func(m ProfileMetadata) ProfileMetadata { m.Labels = nil; return m },
+			want:     "default/none/job",
+		},
+		{
+			name:     "empty value",
+			template: "{{.Namespace}}/{{.NodeName}}/{{.PowerToolName}}",
+			metadata: func(m ProfileMetadata) ProfileMetadata { m.NodeName = ""; return m },
+			wantErr:  true,
+		},
+		{
+			name:     "value with a slash",
+			template: "{{.Namespace}}/{{.Tool}}/{{.PowerToolName}}",
+			metadata: func(m ProfileMetadata) ProfileMetadata { m.Tool = "a/b"; return m },
+			wantErr:  true,
+		},
+		{
+			name:     "value escaping",
+			template: "{{.Namespace}}/{{.Tool}}/{{.PowerToolName}}",
+			metadata: func(m ProfileMetadata) ProfileMetadata { m.Tool = ".."; return m },
+			wantErr:  true,
+		},
+		{
+			name:     "conditional moving the namespace",
+			template: `{{if eq .Namespace "default"}}shared/{{.Namespace}}{{else}}{{.Namespace}}/own{{end}}/{{.PowerToolName}}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := ParsePathTemplate(tt.template, "2006/01/02")
+			if err != nil {
+				t.Fatalf("ParsePathTemplate() error = %v", err)
+			}
+			m := metadata
+			if tt.metadata != nil {
+				m = tt.metadata(m)
+			}
+			got, err := layout.directory(m, date)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMetadata) {
+					t.Errorf("directory() = %q, %v; want ErrInvalidMetadata", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("directory() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	flat, err := ParsePathTemplate(DefaultPathTemplate, "2006-01-02")
+	if err != nil {
+		t.Fatalf("ParsePathTemplate() error = %v", err)
+	}
+	dateFirst, err := ParsePathTemplate("{{.Date}}/{{.Namespace}}/{{.PowerToolName}}", "2006/01/02")
+	if err != nil {
+		t.Fatalf("ParsePathTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		layout        *PathTemplate
+		key           string
+		wantErr       bool
+		wantNamespace string
+		wantPowerTool string
+	}{
+		{layout: flat, key: "default/app-web/job/2025-01-02/web-1_perf.data", wantNamespace: "default", wantPowerTool: "job"},
+		{layout: flat, key: "default/app-web/job/2025-01-02/web-1_capture.pcap.segments/000001.pcap", wantNamespace: "default", wantPowerTool: "job"},
+		{layout: flat, key: "", wantErr: true},
+		{layout: flat, key: "default/app-web/job/web-1_perf.data", wantErr: true},
+		{layout: flat, key: "2025/01/02/default/app-web/job/web-1_perf.data", wantErr: true},
+		{layout: flat, key: "default/app-web/job/2025-01-02/web-1_capture/000001.pcap", wantErr: true},
+		{layout: flat, key: "/default/app-web/job/2025-01-02/web-1_perf.data", wantErr: true},
+		{layout: flat, key: "default/app-web/job/2025-01-02/../../../../etc/passwd", wantErr: true},
+		{layout: flat, key: "default/app-web/job/2025-01-02//web-1_perf.data", wantErr: true},
+		{layout: flat, key: ".uploads/a/b/c/d", wantErr: true},
+		{layout: flat, key: "default/app-web/job/2025-01-02/web-1_perf.data" + MetadataSuffix, wantErr: true},
+		{layout: dateFirst, key: "2025/01/02/default/job/web-1_perf.data", wantNamespace: "default", wantPowerTool: "job"},
+		{layout: dateFirst, key: "2025/01/02/default/web-1_perf.data", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := tt.layout.ValidateKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidMetadata) {
+					t.Errorf("ValidateKey() error = %v, want ErrInvalidMetadata", err)
+				}
+				return
+			}
+			namespace, _, powerTool, _ := tt.layout.SplitKey(tt.key)
+			if namespace != tt.wantNamespace || powerTool != tt.wantPowerTool {
+				t.Errorf("SplitKey() = %q, %q; want %q, %q", namespace, powerTool, tt.wantNamespace, tt.wantPowerTool)
+			}
+		})
+	}
+}
+
+func TestSetPathTemplate(t *testing.T) {
+	mgr, err := NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if err := mgr.SetPathTemplate("{{.AppLabel}}/{{.Date}}"); err == nil {
+		t.Fatal("SetPathTemplate() accepted a template without the namespace and PowerTool")
+	}
+	if err := mgr.SetPathTemplate("{{.Date}}/{{.Namespace}}/{{.Tool}}/{{.PowerToolName}}"); err != nil {
+		t.Fatalf("SetPathTemplate() error = %v", err)
+	}
+
+	ctx := context.Background()
+	metadata := ProfileMetadata{Namespace: "default", AppLabel: "app-web", PowerToolName: "job", PodName: "web-1", Filename: "perf.data", Tool: "aperf"}
+	saved, err := mgr.SaveProfile(ctx, bytes.NewBufferString("data"), metadata)
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	want := time.Now().Format("2006-01-02") + "/default/aperf/job/web-1_perf.data"
+	if saved.Path != want {
+		t.Errorf("Path = %q, want %q", saved.Path, want)
+	}
+	if _, err := mgr.Artifact(ctx, saved.Path); err != nil {
+		t.Errorf("Artifact() error = %v", err)
+	}
+
+	for _, filter := range []ArtifactFilter{
+		{Namespace: "default"},
+		{Namespace: "default", PowerToolName: "job"},
+		{Namespace: "default", AppLabel: "app-web"},
+	} {
+		got, err := mgr.ListArtifacts(ctx, filter)
+		if err != nil || len(got) != 1 || got[0].Path != want {
+			t.Errorf("ListArtifacts(%+v) = %+v, %v; want the artifact", filter, got, err)
+		}
+	}
+
+	// The tool is part of the path, so an upload without one is refused
+	metadata.Tool = ""
+	if _, err := mgr.SaveProfile(ctx, bytes.NewBufferString("data"), metadata); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("SaveProfile() without a tool error = %v, want ErrInvalidMetadata", err)
+	}
+}
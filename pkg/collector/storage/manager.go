@@ -1,68 +1,381 @@
 package storage
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// ErrInvalidMetadata is returned when upload metadata cannot be safely used to
+// build a storage path
+var ErrInvalidMetadata = errors.New("invalid profile metadata")
+
+// maxNameLength bounds label and filename components to what common
+// filesystems accept for a single path element
+const maxNameLength = 255
+
+// maxCollisionSuffix bounds how many numbered variants are tried before giving
+// up on finding a free filename
+const maxCollisionSuffix = 1000
+
+// safeName matches label and filename components: no separators, no leading
+// dot, nothing a shell or another tool would need to escape
+var safeName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
 type ProfileMetadata struct {
-	Namespace     string
-	AppLabel      string
-	PowerToolName string
-	Filename      string
+	Namespace     string `json:"namespace"`
+	AppLabel      string `json:"appLabel"`
+	PowerToolName string `json:"powerToolName"`
+	PodName       string `json:"podName,omitempty"`
+	Filename      string `json:"filename"`
+	// NodeName, ContainerName, Tool and Labels record where the artifact
+	// came from: the target pod's node and labels, the container profiled
+	// and the tool that ran. They are indexed, and a path template may use
+	// them.
+	NodeName      string            `json:"nodeName,omitempty"`
+	ContainerName string            `json:"containerName,omitempty"`
+	Tool          string            `json:"tool,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	// RetentionDays is the retention the originating PowerTool declared;
+	// zero means none
+	RetentionDays int32 `json:"retentionDays,omitempty"`
+	// Encoding is the content encoding of the upload and, once stored, of
+	// the stored object. Empty means uncompressed.
+	Encoding string `json:"encoding,omitempty"`
+	// Compress is the encoding to compress uncompressed uploads with before
+	// storing them. It is a storage instruction and is not recorded.
+	Compress string `json:"-"`
+	// Segment is set when the upload is one piece of a rolling capture
+	Segment *Segment `json:"segment,omitempty"`
+}
+
+// Validate checks every component that ends up in the storage path
+func (m ProfileMetadata) Validate() error {
+	var errs []string
+	if msgs := validation.IsDNS1123Label(m.Namespace); len(msgs) > 0 {
+		errs = append(errs, fmt.Sprintf("namespace %q: %s", m.Namespace, strings.Join(msgs, "; ")))
+	}
+	if msgs := validation.IsDNS1123Subdomain(m.PowerToolName); len(msgs) > 0 {
+		errs = append(errs, fmt.Sprintf("PowerTool name %q: %s", m.PowerToolName, strings.Join(msgs, "; ")))
+	}
+	if m.PodName != "" {
+		if msgs := validation.IsDNS1123Subdomain(m.PodName); len(msgs) > 0 {
+			errs = append(errs, fmt.Sprintf("pod name %q: %s", m.PodName, strings.Join(msgs, "; ")))
+		}
+	}
+	if err := validateSafeName(m.AppLabel); err != nil {
+		errs = append(errs, fmt.Sprintf("label %q: %v", m.AppLabel, err))
+	}
+	if err := validateSafeName(m.Filename); err != nil {
+		errs = append(errs, fmt.Sprintf("filename %q: %v", m.Filename, err))
+	} else if strings.HasSuffix(m.Filename, MetadataSuffix) {
+		errs = append(errs, fmt.Sprintf("filename %q: %s is reserved for artifact metadata", m.Filename, MetadataSuffix))
+	}
+	for _, enc := range []string{m.Encoding, m.Compress} {
+		if enc != "" && encodingExtensions[enc] == "" {
+			errs = append(errs, fmt.Sprintf("encoding %q: must be %q or %q", enc, EncodingGzip, EncodingZstd))
+		}
+	}
+	if m.Segment != nil && (m.Segment.Index < 0 || m.Segment.Index > MaxSegmentIndex) {
+		errs = append(errs, fmt.Sprintf("segment %d: must be between 0 and %d", m.Segment.Index, MaxSegmentIndex))
+	}
+	if m.RetentionDays < 0 {
+		errs = append(errs, fmt.Sprintf("retention %d days: must not be negative", m.RetentionDays))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidMetadata, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func validateSafeName(name string) error {
+	if len(name) > maxNameLength {
+		return fmt.Errorf("must be no more than %d characters", maxNameLength)
+	}
+	if !safeName.MatchString(name) {
+		return fmt.Errorf("must start with a letter or digit and contain only letters, digits, '.', '_' or '-'")
+	}
+	return nil
 }
 
+// Manager validates uploads, verifies their digests and publishes them to a
+// Backend under <directory>/<pod>_<filename>, the directory being laid out
+// by a PathTemplate
 type Manager struct {
-	basePath   string
-	dateFormat string
+	backend    Backend
+	stagingDir string
+	layout     *PathTemplate
+	// quotas, when enabled, limit the bytes each namespace and PowerTool
+	// may store
+	quotas *Quotas
+	// keyring, when set, encrypts new artifacts and decrypts stored ones
+	keyring *Keyring
+	// index, when enabled, answers listings without reading every sidecar
+	index *Index
 }
 
+// NewManager stores profiles on the local filesystem below basePath
 func NewManager(basePath, dateFormat string) (*Manager, error) {
-	if err := os.MkdirAll(basePath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	backend, err := NewFilesystemBackend(basePath)
+	if err != nil {
+		return nil, err
 	}
+	// Staging on the same filesystem lets finished uploads be hard-linked
+	// into place instead of copied
+	return NewManagerWithBackend(backend, filepath.Join(basePath, ".staging"), dateFormat)
+}
 
-	if dateFormat == "" {
-		return nil, fmt.Errorf("dateFormat is required")
+// NewManagerWithBackend stores profiles in backend. Uploads are staged in
+// stagingDir until they are complete and verified.
+// Artifacts are laid out by DefaultPathTemplate until SetPathTemplate.
+func NewManagerWithBackend(backend Backend, stagingDir, dateFormat string) (*Manager, error) {
+	layout, err := ParsePathTemplate(DefaultPathTemplate, dateFormat)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
 	}
 
 	return &Manager{
-		basePath:   basePath,
-		dateFormat: dateFormat,
+		backend:    backend,
+		stagingDir: stagingDir,
+		layout:     layout,
 	}, nil
 }
 
-func (m *Manager) SaveProfile(r io.Reader, metadata ProfileMetadata) error {
-	datePath := time.Now().Format(m.dateFormat)
-	path := filepath.Join(
-		m.basePath,
-		metadata.Namespace,
-		metadata.AppLabel,
-		metadata.PowerToolName,
-		datePath,
-		metadata.Filename,
-	)
+// SetPathTemplate lays out artifacts stored from now on with the template
+// text, rendering dates in the manager's date format. See ParsePathTemplate.
+// Keys are checked and split with the new layout too, so artifacts stored
+// under a different one are no longer served or counted.
+func (m *Manager) SetPathTemplate(text string) error {
+	layout, err := ParsePathTemplate(text, m.layout.dateFormat)
+	if err != nil {
+		return err
+	}
+	m.layout = layout
+	return nil
+}
+
+// PathTemplate returns the layout of artifact keys
+func (m *Manager) PathTemplate() *PathTemplate {
+	return m.layout
+}
+
+// Backend returns the backend profiles are stored in
+func (m *Manager) Backend() Backend {
+	return m.backend
+}
+
+// EnableEncryption encrypts artifacts stored from now on with data keys
+// sealed by keyring, which also decrypts them on download. Artifacts stored
+// before stay as they are and are still served.
+func (m *Manager) EnableEncryption(keyring *Keyring) {
+	m.keyring = keyring
+}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+// Check verifies that uploads can be staged and that the backend is usable
+func (m *Manager) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(m.stagingDir, "readyz-*")
+	if err != nil {
+		return fmt.Errorf("staging directory is not writable: %w", err)
+	}
+	_ = f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("failed to remove staging probe: %w", err)
 	}
+	return checkBackend(ctx, m.backend)
+}
 
-	f, err := os.Create(path)
+// SaveProfile stages the profile locally, hashing it on the way, and then
+// publishes it under the directory the path template renders for it, named
+// <pod>_<filename>. If r is
+// a DigestSource the content must match its digest, as received. Uncompressed
+// uploads are compressed if metadata.Compress asks for it, and content that
+// arrived compressed is stored as it is. With quotas enabled, artifacts that
+// would exceed them fail with ErrQuotaExceeded. An existing artifact is never
+// overwritten: an identical retry is deduplicated and anything else gets a
+// numbered variant. With encryption enabled the content is stored encrypted,
+// compressed first if it is compressed at all.
+func (m *Manager) SaveProfile(ctx context.Context, r io.Reader, metadata ProfileMetadata) (*Artifact, error) {
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+	dir, err := m.directory(metadata)
 	if err != nil {
-		return fmt.Errorf("failed to create profile file: %w", err)
+		return nil, err
 	}
+
+	tmp, err := os.CreateTemp(m.stagingDir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create profile file: %w", err)
+	}
+	tmpPath := tmp.Name()
 	defer func() {
-		if err := f.Close(); err != nil {
-			_ = err
-		}
+		_ = os.Remove(tmpPath)
 	}()
 
-	if _, err := io.Copy(f, r); err != nil {
-		return fmt.Errorf("failed to write profile data: %w", err)
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("failed to write profile data: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("failed to sync profile data: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close profile file: %w", err)
 	}
 
-	return nil
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if src, ok := r.(DigestSource); ok {
+		expected, err := NormalizeSHA256(src.ExpectedSHA256())
+		if err != nil {
+			return nil, err
+		}
+		if expected != "" && expected != sum {
+			return nil, fmt.Errorf("%w: client sent %s, received content hashes to %s", ErrDigestMismatch, expected, sum)
+		}
+	}
+
+	if metadata.Encoding != "" {
+		if err := checkEncoding(tmpPath, metadata.Encoding); err != nil {
+			return nil, err
+		}
+	}
+
+	artifact := &Artifact{
+		ProfileMetadata: metadata,
+		Size:            size,
+		SHA256:          sum,
+	}
+	if metadata.Encoding == "" && metadata.Compress != "" {
+		compressedPath, compressedSize, compressedSum, err := m.compressStaged(tmpPath, metadata.Compress)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = os.Remove(compressedPath)
+		}()
+		tmpPath = compressedPath
+		artifact.Encoding = metadata.Compress
+		artifact.UncompressedSize = size
+		artifact.UncompressedSHA256 = sum
+		artifact.Size = compressedSize
+		artifact.SHA256 = compressedSum
+	}
+	artifact.Compress = ""
+
+	if m.keyring != nil {
+		encryptedPath, encryption, err := m.encryptStaged(tmpPath, artifact.Size)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = os.Remove(encryptedPath)
+		}()
+		tmpPath = encryptedPath
+		artifact.Encryption = encryption
+	}
+
+	// Quotas count stored bytes, so they are reserved only now that the
+	// final size is known, and returned if nothing new was stored
+	if err := m.quotas.Reserve(metadata.Namespace, metadata.PowerToolName, artifact.storedSize()); err != nil {
+		return nil, err
+	}
+	var stored *Artifact
+	if metadata.Segment != nil {
+		stored, err = m.publishSegment(ctx, tmpPath, dir, artifact)
+	} else {
+		stored, err = m.publish(ctx, tmpPath, dir, artifact)
+	}
+	if err != nil || stored.Deduplicated {
+		m.quotas.Release(metadata.Namespace, metadata.PowerToolName, artifact.storedSize())
+	}
+	return stored, err
+}
+
+// directory is the key prefix an upload is stored under. Segments use the
+// date their capture started.
+func (m *Manager) directory(metadata ProfileMetadata) (string, error) {
+	date := time.Now()
+	if metadata.Segment != nil && !metadata.Segment.Started.IsZero() {
+		date = metadata.Segment.Started
+	}
+	return m.layout.directory(metadata, date)
+}
+
+// artifactName prefixes the filename with the pod name so tools running in
+// several pods of one PowerTool never write the same name
+func artifactName(metadata ProfileMetadata) string {
+	if metadata.PodName == "" {
+		return metadata.Filename
+	}
+	return metadata.PodName + "_" + metadata.Filename
+}
+
+// publish stores the staged file under the first free name in dir, followed
+// by its metadata. Existing artifacts are never replaced; if one of the same
+// name already holds content with this digest, it is returned instead and
+// nothing is written.
+func (m *Manager) publish(ctx context.Context, tmpPath, dir string, artifact *Artifact) (*Artifact, error) {
+	name := withEncodingExtension(artifactName(artifact.ProfileMetadata), artifact.Encoding)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for i := 0; i < maxCollisionSuffix; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+		key := path.Join(dir, candidate)
+
+		existing, err := m.readSidecar(ctx, key)
+		switch {
+		case err == nil && sameContent(existing, artifact):
+			existing.Deduplicated = true
+			return existing, nil
+		case err == nil:
+			continue
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+
+		f, err := os.Open(tmpPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open staged profile: %w", err)
+		}
+		err = m.backend.Put(ctx, key, f, artifact.storedSize())
+		_ = f.Close()
+		if errors.Is(err, fs.ErrExist) {
+			// Taken by a concurrent upload or an artifact without metadata
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to publish profile file: %w", err)
+		}
+
+		artifact.Path = key
+		artifact.CreatedAt = time.Now().UTC()
+		if err := m.writeSidecar(ctx, artifact); err != nil {
+			return nil, err
+		}
+		return artifact, nil
+	}
+	return nil, fmt.Errorf("failed to publish profile file: too many artifacts named %s", name)
 }
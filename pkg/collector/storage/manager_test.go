@@ -2,9 +2,12 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -60,12 +63,13 @@ func TestNewManager(t *testing.T) {
 				return
 			}
 
-			if mgr.basePath != tt.basePath {
-				t.Errorf("NewManager() basePath = %v, want %v", mgr.basePath, tt.basePath)
+			backend, ok := mgr.backend.(*FilesystemBackend)
+			if !ok || backend.root != tt.basePath {
+				t.Errorf("NewManager() backend = %#v, want filesystem backend at %v", mgr.backend, tt.basePath)
 			}
 
-			if mgr.dateFormat != tt.dateFormat {
-				t.Errorf("NewManager() dateFormat = %v, want %v", mgr.dateFormat, tt.dateFormat)
+			if mgr.layout.dateFormat != tt.dateFormat {
+				t.Errorf("NewManager() dateFormat = %v, want %v", mgr.layout.dateFormat, tt.dateFormat)
 			}
 
 			// Verify directory was created
@@ -163,7 +167,7 @@ func TestSaveProfile(t *testing.T) {
 			}
 
 			reader := bytes.NewBufferString(tt.content)
-			err = mgr.SaveProfile(reader, tt.metadata)
+			_, err = mgr.SaveProfile(context.Background(), reader, tt.metadata)
 			if err != nil {
 				t.Errorf("SaveProfile() error = %v", err)
 				return
@@ -208,7 +212,7 @@ func TestSaveProfile_DirectoryCreation(t *testing.T) {
 	}
 
 	reader := bytes.NewBufferString("test data")
-	err = mgr.SaveProfile(reader, metadata)
+	_, err = mgr.SaveProfile(context.Background(), reader, metadata)
 	if err != nil {
 		t.Errorf("SaveProfile() error = %v", err)
 	}
@@ -243,7 +247,7 @@ func TestSaveProfile_ReadError(t *testing.T) {
 		Filename:      "test.txt",
 	}
 
-	err = mgr.SaveProfile(errorReader, metadata)
+	_, err = mgr.SaveProfile(context.Background(), errorReader, metadata)
 	if err == nil {
 		t.Error("SaveProfile() expected error, got nil")
 	}
@@ -264,7 +268,7 @@ func TestSaveProfile_EmptyFile(t *testing.T) {
 	}
 
 	reader := bytes.NewReader([]byte{})
-	err = mgr.SaveProfile(reader, metadata)
+	_, err = mgr.SaveProfile(context.Background(), reader, metadata)
 	if err != nil {
 		t.Errorf("SaveProfile() unexpected error = %v", err)
 	}
@@ -320,7 +324,7 @@ func TestSaveProfile_SpecialCharacters(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			reader := bytes.NewBufferString("test data")
-			err := mgr.SaveProfile(reader, tt.metadata)
+			_, err := mgr.SaveProfile(context.Background(), reader, tt.metadata)
 			if err != nil {
 				t.Errorf("SaveProfile() unexpected error = %v", err)
 			}
@@ -358,7 +362,7 @@ func TestSaveProfile_FileCreationError(t *testing.T) {
 	}
 
 	reader := bytes.NewBufferString("test data")
-	err = mgr.SaveProfile(reader, metadata)
+	_, err = mgr.SaveProfile(context.Background(), reader, metadata)
 	if err == nil {
 		t.Error("SaveProfile() expected error for file creation conflict, got nil")
 	}
@@ -385,7 +389,7 @@ func TestSaveProfile_WriteLargeFile(t *testing.T) {
 	}
 
 	reader := bytes.NewReader(largeData)
-	err = mgr.SaveProfile(reader, metadata)
+	_, err = mgr.SaveProfile(context.Background(), reader, metadata)
 	if err != nil {
 		t.Errorf("SaveProfile() unexpected error for large file = %v", err)
 	}
@@ -415,3 +419,195 @@ type errorReader struct {
 func (e *errorReader) Read(p []byte) (n int, err error) {
 	return 0, e.err
 }
+
+func TestProfileMetadata_Validate(t *testing.T) {
+	valid := ProfileMetadata{
+		Namespace:     "default",
+		AppLabel:      "app.kubernetes.io_name-web",
+		PowerToolName: "profile.job-1",
+		PodName:       "web-7d9f8-abcde",
+		Filename:      "perf.data",
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(*ProfileMetadata)
+		wantErr bool
+	}{
+		{name: "valid", mutate: func(m *ProfileMetadata) {}},
+		{name: "empty pod name", mutate: func(m *ProfileMetadata) { m.PodName = "" }},
+		{name: "namespace traversal", mutate: func(m *ProfileMetadata) { m.Namespace = "../../etc" }, wantErr: true},
+		{name: "namespace uppercase", mutate: func(m *ProfileMetadata) { m.Namespace = "Default" }, wantErr: true},
+		{name: "namespace with dot", mutate: func(m *ProfileMetadata) { m.Namespace = "a.b" }, wantErr: true},
+		{name: "name traversal", mutate: func(m *ProfileMetadata) { m.PowerToolName = ".." }, wantErr: true},
+		{name: "name with slash", mutate: func(m *ProfileMetadata) { m.PowerToolName = "a/b" }, wantErr: true},
+		{name: "pod with slash", mutate: func(m *ProfileMetadata) { m.PodName = "../x" }, wantErr: true},
+		{name: "label with slash", mutate: func(m *ProfileMetadata) { m.AppLabel = "app/nginx" }, wantErr: true},
+		{name: "label dot-dot", mutate: func(m *ProfileMetadata) { m.AppLabel = ".." }, wantErr: true},
+		{name: "absolute filename", mutate: func(m *ProfileMetadata) { m.Filename = "/etc/passwd" }, wantErr: true},
+		{name: "hidden filename", mutate: func(m *ProfileMetadata) { m.Filename = ".bashrc" }, wantErr: true},
+		{name: "filename with space", mutate: func(m *ProfileMetadata) { m.Filename = "my file" }, wantErr: true},
+		{name: "empty filename", mutate: func(m *ProfileMetadata) { m.Filename = "" }, wantErr: true},
+		{name: "overlong filename", mutate: func(m *ProfileMetadata) { m.Filename = strings.Repeat("a", 256) }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid
+			tt.mutate(&m)
+			err := m.Validate()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMetadata) {
+					t.Errorf("Validate() error = %v, want ErrInvalidMetadata", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Validate() unexpected error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSaveProfile_RejectsEscapes(t *testing.T) {
+	root := t.TempDir()
+	basePath := filepath.Join(root, "data")
+	mgr, err := NewManager(basePath, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	metadata := ProfileMetadata{
+		Namespace:     "default",
+		AppLabel:      "..",
+		PowerToolName: "job",
+		Filename:      "out.txt",
+	}
+	if _, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("x"), metadata); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("SaveProfile() error = %v, want ErrInvalidMetadata", err)
+	}
+
+	entries, _ := os.ReadDir(root)
+	if len(entries) != 1 {
+		t.Errorf("SaveProfile() wrote outside the storage directory: %v", entries)
+	}
+}
+
+func TestSaveProfile_RejectsSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	basePath := filepath.Join(root, "data")
+	outside := filepath.Join(root, "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, err := NewManager(basePath, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(basePath, "default")); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := ProfileMetadata{
+		Namespace:     "default",
+		AppLabel:      "app-web",
+		PowerToolName: "job",
+		Filename:      "out.txt",
+	}
+	if _, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString("x"), metadata); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("SaveProfile() error = %v, want ErrInvalidMetadata", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(outside, "*", "*", "*", "*"))
+	if len(matches) != 0 {
+		t.Errorf("SaveProfile() wrote through symlink: %v", matches)
+	}
+}
+
+func TestSaveProfile_CollisionFree(t *testing.T) {
+	basePath := t.TempDir()
+	mgr, err := NewManager(basePath, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	uploads := []struct {
+		pod     string
+		content string
+	}{
+		{"web-1", "first"},
+		{"web-2", "second"},
+		{"web-1", "retry"},
+	}
+	for _, u := range uploads {
+		metadata := ProfileMetadata{
+			Namespace:     "default",
+			AppLabel:      "app-web",
+			PowerToolName: "job",
+			PodName:       u.pod,
+			Filename:      "perf.data",
+		}
+		if _, err := mgr.SaveProfile(context.Background(), bytes.NewBufferString(u.content), metadata); err != nil {
+			t.Fatalf("SaveProfile() error = %v", err)
+		}
+	}
+
+	dirs, _ := filepath.Glob(filepath.Join(basePath, "default", "app-web", "job", "*"))
+	if len(dirs) != 1 {
+		t.Fatalf("expected one date directory, got %v", dirs)
+	}
+
+	want := map[string]string{
+		"web-1_perf.data":   "first",
+		"web-2_perf.data":   "second",
+		"web-1_perf-1.data": "retry",
+	}
+	entries, err := os.ReadDir(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each artifact has a metadata sidecar next to it
+	if len(entries) != 2*len(want) {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Fatalf("expected %d files without temp leftovers, got %v", 2*len(want), names)
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dirs[0], name))
+		if err != nil {
+			t.Errorf("missing artifact %s: %v", name, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("%s content = %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestSaveProfile_FailedWriteLeavesNothing(t *testing.T) {
+	basePath := t.TempDir()
+	mgr, err := NewManager(basePath, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	metadata := ProfileMetadata{
+		Namespace:     "default",
+		AppLabel:      "app-web",
+		PowerToolName: "job",
+		PodName:       "web-1",
+		Filename:      "perf.data",
+	}
+	reader := io.MultiReader(bytes.NewBufferString("partial"), &errorReader{err: errors.New("connection reset")})
+	if _, err := mgr.SaveProfile(context.Background(), reader, metadata); err == nil {
+		t.Fatal("SaveProfile() expected error, got nil")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(basePath, "default", "app-web", "job", "*", "*"))
+	if len(matches) != 0 {
+		t.Errorf("failed upload left files behind: %v", matches)
+	}
+}
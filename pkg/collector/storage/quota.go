@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"toe/pkg/collector/metrics"
+)
+
+// ErrQuotaExceeded is returned when storing an artifact would take its
+// namespace or PowerTool past its byte quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaLimits caps the bytes stored per namespace and per PowerTool. Zero
+// means no limit.
+type QuotaLimits struct {
+	Namespace int64
+	PowerTool int64
+}
+
+// Quotas tracks the bytes stored by each namespace and PowerTool and refuses
+// to let either exceed its limit. Reservations are made before an artifact
+// is published, so concurrent uploads cannot overshoot together. Usage is
+// kept in memory, so each collector replica enforces the limits on its own.
+type Quotas struct {
+	limits QuotaLimits
+
+	mu         sync.Mutex
+	namespaces map[string]int64
+	powerTools map[powerToolKey]int64
+}
+
+type powerToolKey struct {
+	namespace string
+	name      string
+}
+
+// NewQuotas creates quotas with nothing stored yet
+func NewQuotas(limits QuotaLimits) *Quotas {
+	return &Quotas{
+		limits:     limits,
+		namespaces: map[string]int64{},
+		powerTools: map[powerToolKey]int64{},
+	}
+}
+
+// Check reports whether size more bytes would fit, without reserving them.
+// It is a cheap early answer; Reserve makes the binding decision.
+func (q *Quotas) Check(namespace, powerTool string, size int64) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.check(namespace, powerTool, size)
+}
+
+// Reserve counts size bytes against the namespace and PowerTool, or fails
+// with ErrQuotaExceeded if they do not fit
+func (q *Quotas) Reserve(namespace, powerTool string, size int64) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.check(namespace, powerTool, size); err != nil {
+		return err
+	}
+	q.add(namespace, powerTool, size)
+	return nil
+}
+
+// Release returns size bytes, after an artifact is deleted or a reservation
+// was not used
+func (q *Quotas) Release(namespace, powerTool string, size int64) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.add(namespace, powerTool, -size)
+}
+
+// Usage returns the bytes stored by the namespace and by the PowerTool
+func (q *Quotas) Usage(namespace, powerTool string) (int64, int64) {
+	if q == nil {
+		return 0, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.namespaces[namespace], q.powerTools[powerToolKey{namespace, powerTool}]
+}
+
+func (q *Quotas) check(namespace, powerTool string, size int64) error {
+	if limit := q.limits.Namespace; limit > 0 && q.namespaces[namespace]+size > limit {
+		return fmt.Errorf("%w: namespace %s has %d of %d bytes stored", ErrQuotaExceeded, namespace, q.namespaces[namespace], limit)
+	}
+	key := powerToolKey{namespace, powerTool}
+	if limit := q.limits.PowerTool; limit > 0 && q.powerTools[key]+size > limit {
+		return fmt.Errorf("%w: PowerTool %s/%s has %d of %d bytes stored", ErrQuotaExceeded, namespace, powerTool, q.powerTools[key], limit)
+	}
+	return nil
+}
+
+func (q *Quotas) add(namespace, powerTool string, size int64) {
+	key := powerToolKey{namespace, powerTool}
+	q.namespaces[namespace] += size
+	q.powerTools[key] += size
+	if q.namespaces[namespace] <= 0 {
+		delete(q.namespaces, namespace)
+	}
+	if q.powerTools[key] <= 0 {
+		delete(q.powerTools, key)
+	}
+	metrics.StoredBytes.WithLabelValues(namespace).Set(float64(q.namespaces[namespace]))
+}
+
+// EnableQuotas makes the manager enforce limits, counting what is already
+// stored. Usage is taken from object keys and sizes, so no metadata has to be
+// read; sidecars themselves are not counted.
+func (m *Manager) EnableQuotas(ctx context.Context, limits QuotaLimits) (*Quotas, error) {
+	objects, err := m.backend.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+
+	quotas := NewQuotas(limits)
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, MetadataSuffix) {
+			continue
+		}
+		namespace, _, powerTool, ok := m.layout.SplitKey(obj.Key)
+		if !ok {
+			continue
+		}
+		quotas.add(namespace, powerTool, obj.Size)
+	}
+	m.quotas = quotas
+	return quotas, nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotas_Reserve(t *testing.T) {
+	q := NewQuotas(QuotaLimits{Namespace: 100, PowerTool: 60})
+
+	steps := []struct {
+		name      string
+		namespace string
+		powerTool string
+		size      int64
+		wantErr   bool
+	}{
+		{"fits", "team-a", "job-1", 50, false},
+		{"PowerTool full", "team-a", "job-1", 20, true},
+		{"other PowerTool", "team-a", "job-2", 50, false},
+		{"namespace full", "team-a", "job-3", 1, true},
+		{"other namespace", "team-b", "job-1", 60, false},
+	}
+	for _, step := range steps {
+		err := q.Reserve(step.namespace, step.powerTool, step.size)
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: Reserve() error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("%s: Reserve() error = %v, want ErrQuotaExceeded", step.name, err)
+		}
+	}
+
+	if ns, pt := q.Usage("team-a", "job-1"); ns != 100 || pt != 50 {
+		t.Errorf("Usage(team-a, job-1) = %d, %d, want 100, 50", ns, pt)
+	}
+
+	q.Release("team-a", "job-1", 50)
+	if err := q.Check("team-a", "job-3", 50); err != nil {
+		t.Errorf("Check() after Release error = %v", err)
+	}
+	if ns, pt := q.Usage("team-a", "job-1"); ns != 50 || pt != 0 {
+		t.Errorf("Usage(team-a, job-1) after Release = %d, %d, want 50, 0", ns, pt)
+	}
+}
+
+func TestQuotas_Nil(t *testing.T) {
+	var q *Quotas
+	if err := q.Reserve("team-a", "job-1", 1<<40); err != nil {
+		t.Errorf("nil Reserve() error = %v", err)
+	}
+	q.Release("team-a", "job-1", 1)
+	if err := q.Check("team-a", "job-1", 1<<40); err != nil {
+		t.Errorf("nil Check() error = %v", err)
+	}
+}
+
+func TestManager_Quotas(t *testing.T) {
+	root := t.TempDir()
+	ctx := context.Background()
+	metadata := func(powerTool, pod string) ProfileMetadata {
+		return ProfileMetadata{
+			Namespace:     "quota-test",
+			AppLabel:      "app-web",
+			PowerToolName: powerTool,
+			PodName:       pod,
+			Filename:      "perf.data",
+			RetentionDays: 1,
+		}
+	}
+
+	mgr, err := NewManager(root, "2006/01/02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, err := mgr.SaveProfile(ctx, strings.NewReader(strings.Repeat("a", 40)), metadata("job-1", "web-1")); err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+
+	// Usage already stored counts once quotas are enabled, as after a restart
+	quotas, err := mgr.EnableQuotas(ctx, QuotaLimits{Namespace: 100})
+	if err != nil {
+		t.Fatalf("EnableQuotas() error = %v", err)
+	}
+	if ns, pt := quotas.Usage("quota-test", "job-1"); ns != 40 || pt != 40 {
+		t.Fatalf("Usage() after EnableQuotas = %d, %d, want 40, 40", ns, pt)
+	}
+
+	// A retry of stored content takes no more space
+	if _, err := mgr.SaveProfile(ctx, strings.NewReader(strings.Repeat("a", 40)), metadata("job-1", "web-1")); err != nil {
+		t.Fatalf("SaveProfile() retry error = %v", err)
+	}
+	if ns, _ := quotas.Usage("quota-test", "job-1"); ns != 40 {
+		t.Errorf("Usage() after deduplicated retry = %d, want 40", ns)
+	}
+
+	if _, err := mgr.SaveProfile(ctx, strings.NewReader(strings.Repeat("b", 50)), metadata("job-2", "web-1")); err != nil {
+		t.Fatalf("SaveProfile() within quota error = %v", err)
+	}
+	_, err = mgr.SaveProfile(ctx, strings.NewReader(strings.Repeat("c", 20)), metadata("job-2", "web-2"))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("SaveProfile() over quota error = %v, want ErrQuotaExceeded", err)
+	}
+	artifacts, err := mgr.ListArtifacts(ctx, ArtifactFilter{Namespace: "quota-test", PowerToolName: "job-2"})
+	if err != nil {
+		t.Fatalf("ListArtifacts() error = %v", err)
+	}
+	if len(artifacts) != 1 {
+		t.Errorf("ListArtifacts() = %d artifacts, want only the one within quota", len(artifacts))
+	}
+
+	// Expired artifacts give their space back
+	if _, err := mgr.Sweep(ctx, RetentionPolicy{}, time.Now().AddDate(0, 0, 2)); err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if ns, _ := quotas.Usage("quota-test", "job-1"); ns != 0 {
+		t.Errorf("Usage() after Sweep = %d, want 0", ns)
+	}
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ArtifactFilter selects artifacts for ListArtifacts. Namespace is required;
+// empty fields and zero times match everything.
+type ArtifactFilter struct {
+	Namespace     string
+	PowerToolName string
+	AppLabel      string
+	PodName       string
+	NodeName      string
+	ContainerName string
+	Tool          string
+	SHA256        string
+	// Selector, when set, must match the labels of the target pod
+	Selector labels.Selector
+	// Since and Until bound the creation time, inclusive
+	Since time.Time
+	Until time.Time
+}
+
+func (f ArtifactFilter) matches(a *Artifact) bool {
+	if a.Namespace != f.Namespace {
+		return false
+	}
+	for _, c := range []struct{ want, got string }{
+		{f.PowerToolName, a.PowerToolName},
+		{f.AppLabel, a.AppLabel},
+		{f.PodName, a.PodName},
+		{f.NodeName, a.NodeName},
+		{f.ContainerName, a.ContainerName},
+		{f.Tool, a.Tool},
+	} {
+		if c.want != "" && c.got != c.want {
+			return false
+		}
+	}
+	// Either the digest of what was stored or of what was uploaded
+	if f.SHA256 != "" && f.SHA256 != a.SHA256 && f.SHA256 != a.UncompressedSHA256 {
+		return false
+	}
+	if f.Selector != nil && !f.Selector.Matches(labels.Set(a.Labels)) {
+		return false
+	}
+	if !f.Since.IsZero() && a.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && a.CreatedAt.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// ListArtifacts returns the artifacts matching filter, oldest first. They
+// come from the index when it is enabled and up to date, and from reading the
+// sidecars otherwise.
+func (m *Manager) ListArtifacts(ctx context.Context, filter ArtifactFilter) ([]Artifact, error) {
+	if filter.Namespace == "" {
+		return nil, fmt.Errorf("%w: namespace is required", ErrInvalidMetadata)
+	}
+	if m.index.Ready() {
+		return m.index.List(filter)
+	}
+
+	// Narrow the listing as far as the key layout allows
+	objects, err := m.backend.List(ctx, m.layout.prefix(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts []Artifact
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, MetadataSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(obj.Key, MetadataSuffix)
+		ns, label, powerTool, ok := m.layout.SplitKey(key)
+		if !ok || ns != filter.Namespace ||
+			(filter.AppLabel != "" && label != "" && label != filter.AppLabel) ||
+			(filter.PowerToolName != "" && powerTool != filter.PowerToolName) {
+			continue
+		}
+
+		artifact, err := m.readSidecar(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted while listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filter.matches(artifact) {
+			artifacts = append(artifacts, *artifact)
+		}
+	}
+
+	sort.Slice(artifacts, func(i, j int) bool {
+		if artifacts[i].CreatedAt.Equal(artifacts[j].CreatedAt) {
+			return artifacts[i].Path < artifacts[j].Path
+		}
+		return artifacts[i].CreatedAt.Before(artifacts[j].CreatedAt)
+	})
+	return artifacts, nil
+}
+
+// Artifact returns the metadata of the artifact stored under key
+func (m *Manager) Artifact(ctx context.Context, key string) (*Artifact, error) {
+	if err := m.layout.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	return m.readSidecar(ctx, key)
+}
+
+// OpenArtifact returns the metadata and content of the artifact stored under
+// key. The content is seekable, so it can serve HTTP range requests. Encrypted
+// artifacts are decrypted.
+func (m *Manager) OpenArtifact(ctx context.Context, key string) (*Artifact, io.ReadSeekCloser, error) {
+	artifact, err := m.Artifact(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if artifact.Encryption != nil {
+		content, err := m.openEncrypted(ctx, artifact)
+		if err != nil {
+			return nil, nil, err
+		}
+		return artifact, content, nil
+	}
+	return artifact, &objectReader{ctx: ctx, backend: m.backend, key: key, size: artifact.Size}, nil
+}
+
+// objectReader reads an object through ranged gets, reopening it at the new
+// offset after a seek
+type objectReader struct {
+	ctx     context.Context
+	backend Backend
+	key     string
+	size    int64
+
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.backend.GetRange(r.ctx, r.key, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = r.offset + offset
+	case io.SeekEnd:
+		next = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if next < 0 {
+		return 0, fmt.Errorf("negative position %d", next)
+	}
+	if next != r.offset {
+		r.closeBody()
+		r.offset = next
+	}
+	return next, nil
+}
+
+func (r *objectReader) Close() error {
+	r.closeBody()
+	return nil
+}
+
+func (r *objectReader) closeBody() {
+	if r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestListArtifacts(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		name := "sidecars"
+		if indexed {
+			name = "index"
+		}
+		t.Run(name, func(t *testing.T) {
+			testListArtifacts(t, indexed)
+		})
+	}
+}
+
+func testListArtifacts(t *testing.T, indexed bool) {
+	root := t.TempDir()
+	mgr, err := NewManager(root, "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	ctx := context.Background()
+	if indexed {
+		newTestIndex(t, mgr, root)
+	}
+
+	web := map[string]string{"app": "web", "tier": "frontend"}
+	uploads := []ProfileMetadata{
+		{Namespace: "default", AppLabel: "app-web", PowerToolName: "job-a", PodName: "web-1", Filename: "perf.data",
+			NodeName: "node-1", ContainerName: "nginx", Tool: "aperf", Labels: web},
+		{Namespace: "default", AppLabel: "app-web", PowerToolName: "job-b", PodName: "web-1", Filename: "perf.data",
+			NodeName: "node-1", ContainerName: "nginx", Tool: "tcpdump", Labels: web},
+		{Namespace: "default", AppLabel: "app-db", PowerToolName: "job-a", PodName: "db-0", Filename: "perf.data",
+			NodeName: "node-2", ContainerName: "postgres", Tool: "aperf", Labels: map[string]string{"app": "db"}},
+		{Namespace: "other", AppLabel: "app-web", PowerToolName: "job-a", PodName: "web-1", Filename: "perf.data"},
+	}
+	var first *Artifact
+	for i, u := range uploads {
+		saved, err := mgr.SaveProfile(ctx, bytes.NewBufferString(u.PowerToolName+u.PodName+string(rune('0'+i))), u)
+		if err != nil {
+			t.Fatalf("SaveProfile() error = %v", err)
+		}
+		if first == nil {
+			first = saved
+		}
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		filter ArtifactFilter
+		want   int
+	}{
+		{name: "namespace", filter: ArtifactFilter{Namespace: "default"}, want: 3},
+		{name: "powertool", filter: ArtifactFilter{Namespace: "default", PowerToolName: "job-a"}, want: 2},
+		{name: "label", filter: ArtifactFilter{Namespace: "default", AppLabel: "app-web"}, want: 2},
+		{name: "label and powertool", filter: ArtifactFilter{Namespace: "default", AppLabel: "app-web", PowerToolName: "job-b"}, want: 1},
+		{name: "other namespace", filter: ArtifactFilter{Namespace: "other"}, want: 1},
+		{name: "empty namespace", filter: ArtifactFilter{Namespace: "missing"}, want: 0},
+		{name: "pod", filter: ArtifactFilter{Namespace: "default", PodName: "web-1"}, want: 2},
+		{name: "node", filter: ArtifactFilter{Namespace: "default", NodeName: "node-2"}, want: 1},
+		{name: "container", filter: ArtifactFilter{Namespace: "default", ContainerName: "nginx"}, want: 2},
+		{name: "tool", filter: ArtifactFilter{Namespace: "default", Tool: "aperf"}, want: 2},
+		{name: "tool and powertool", filter: ArtifactFilter{Namespace: "default", PowerToolName: "job-a", Tool: "tcpdump"}, want: 0},
+		{name: "digest", filter: ArtifactFilter{Namespace: "default", SHA256: first.SHA256}, want: 1},
+		{name: "selector", filter: ArtifactFilter{Namespace: "default", Selector: mustSelector(t, "app=web,tier in (frontend)")}, want: 2},
+		{name: "negated selector", filter: ArtifactFilter{Namespace: "default", Selector: mustSelector(t, "app!=web")}, want: 1},
+		{name: "within range", filter: ArtifactFilter{Namespace: "default", Since: now.Add(-time.Hour), Until: now.Add(time.Hour)}, want: 3},
+		{name: "before range", filter: ArtifactFilter{Namespace: "default", Since: now.Add(time.Hour)}, want: 0},
+		{name: "after range", filter: ArtifactFilter{Namespace: "default", Until: now.Add(-time.Hour)}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mgr.ListArtifacts(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListArtifacts() error = %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ListArtifacts() returned %d artifacts, want %d: %+v", len(got), tt.want, got)
+			}
+			for i, a := range got {
+				if a.Namespace != tt.filter.Namespace || a.SHA256 == "" || a.Path == "" {
+					t.Errorf("ListArtifacts() returned %+v", a)
+				}
+				if i > 0 && a.CreatedAt.Before(got[i-1].CreatedAt) {
+					t.Errorf("ListArtifacts() not oldest first: %v after %v", a.CreatedAt, got[i-1].CreatedAt)
+				}
+			}
+		})
+	}
+
+	if _, err := mgr.ListArtifacts(ctx, ArtifactFilter{}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("ListArtifacts() without namespace error = %v, want ErrInvalidMetadata", err)
+	}
+}
+
+func mustSelector(t *testing.T, s string) labels.Selector {
+	t.Helper()
+	selector, err := labels.Parse(s)
+	if err != nil {
+		t.Fatalf("labels.Parse(%q) error = %v", s, err)
+	}
+	return selector
+}
+
+func TestOpenArtifact_Seek(t *testing.T) {
+	_, srv := newFakeS3(t, "profiles")
+	s3Mgr, err := NewManagerWithBackend(newTestS3Backend(t, srv.URL), t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManagerWithBackend() error = %v", err)
+	}
+	fsMgr, err := NewManager(t.TempDir(), "2006-01-02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	for name, mgr := range map[string]*Manager{"filesystem": fsMgr, "s3": s3Mgr} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			saved, err := mgr.SaveProfile(ctx, bytes.NewBufferString("0123456789"), artifactMetadata)
+			if err != nil {
+				t.Fatalf("SaveProfile() error = %v", err)
+			}
+
+			artifact, content, err := mgr.OpenArtifact(ctx, saved.Path)
+			if err != nil {
+				t.Fatalf("OpenArtifact() error = %v", err)
+			}
+			defer func() {
+				_ = content.Close()
+			}()
+			if artifact.SHA256 != saved.SHA256 {
+				t.Errorf("OpenArtifact() metadata = %+v", artifact)
+			}
+
+			buf := make([]byte, 3)
+			if _, err := io.ReadFull(content, buf); err != nil || string(buf) != "012" {
+				t.Errorf("first read = %q, %v", buf, err)
+			}
+			if _, err := content.Seek(6, io.SeekStart); err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			rest, err := io.ReadAll(content)
+			if err != nil || string(rest) != "6789" {
+				t.Errorf("read after seek = %q, %v", rest, err)
+			}
+			if end, _ := content.Seek(0, io.SeekEnd); end != 10 {
+				t.Errorf("Seek(0, SeekEnd) = %d, want 10", end)
+			}
+
+			if _, _, err := mgr.OpenArtifact(ctx, "default/app-web/job/2025-01-02/missing"); err == nil {
+				t.Error("OpenArtifact() of missing artifact expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+
+	"toe/pkg/collector/metrics"
+)
+
+// DefaultSweepInterval is how often expired artifacts are looked for
+const DefaultSweepInterval = time.Hour
+
+// reasonRetention labels deletions made because an artifact outlived its
+// retention period
+const reasonRetention = "retention"
+
+// RetentionPolicy decides how long artifacts are kept. It is applied when
+// sweeping, so changing it affects artifacts already stored.
+type RetentionPolicy struct {
+	// DefaultDays applies to artifacts whose PowerTool declared no retention.
+	// Zero keeps them forever.
+	DefaultDays int32
+	// MaxDays caps any declared retention. Zero means no cap.
+	MaxDays int32
+}
+
+// Days returns the retention for an artifact that declared declared days,
+// zero meaning none was declared. A result of zero keeps the artifact forever.
+func (p RetentionPolicy) Days(declared int32) int32 {
+	days := declared
+	if days <= 0 {
+		days = p.DefaultDays
+	}
+	if p.MaxDays > 0 && (days <= 0 || days > p.MaxDays) {
+		days = p.MaxDays
+	}
+	return days
+}
+
+// ExpiresAt returns when the artifact expires under the policy, and false if
+// it is kept forever
+func (p RetentionPolicy) ExpiresAt(a *Artifact) (time.Time, bool) {
+	days := p.Days(a.RetentionDays)
+	if days <= 0 {
+		return time.Time{}, false
+	}
+	return a.CreatedAt.AddDate(0, 0, int(days)), true
+}
+
+// emptyDirPruner is implemented by backends with real directories, which
+// deleting artifacts can leave empty
+type emptyDirPruner interface {
+	PruneEmptyDirs(ctx context.Context) (int, error)
+}
+
+// SweepResult summarizes one retention sweep
+type SweepResult struct {
+	Deleted      int
+	BytesDeleted int64
+	DirsRemoved  int
+}
+
+// Sweep deletes every artifact that expired under policy by now, then removes
+// directories left empty. Each deletion is logged and counted. Artifacts that
+// cannot be deleted are skipped and reported in the returned error.
+func (m *Manager) Sweep(ctx context.Context, policy RetentionPolicy, now time.Time) (SweepResult, error) {
+	var result SweepResult
+	objects, err := m.backend.List(ctx, "")
+	if err != nil {
+		return result, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+
+	var errs []error
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, MetadataSuffix) {
+			continue
+		}
+		key := strings.TrimSuffix(obj.Key, MetadataSuffix)
+		artifact, err := m.readSidecar(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+
+		expiresAt, ok := policy.ExpiresAt(artifact)
+		if !ok || now.Before(expiresAt) {
+			continue
+		}
+		if err := m.deleteArtifact(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+
+		size := artifact.storedSize()
+		m.quotas.Release(artifact.Namespace, artifact.PowerToolName, size)
+		log.Printf("Deleted artifact %s (%d bytes, created %s, retention %d days)",
+			key, size, artifact.CreatedAt.Format(time.RFC3339), policy.Days(artifact.RetentionDays))
+		metrics.ArtifactsDeleted.WithLabelValues(artifact.Namespace, reasonRetention).Inc()
+		metrics.ArtifactBytesDeleted.WithLabelValues(artifact.Namespace, reasonRetention).Add(float64(size))
+		result.Deleted++
+		result.BytesDeleted += size
+	}
+
+	if pruner, ok := m.backend.(emptyDirPruner); ok {
+		removed, err := pruner.PruneEmptyDirs(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		result.DirsRemoved = removed
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// deleteArtifact removes an artifact and then its metadata, so a failure
+// part way leaves the sidecar behind for the next sweep to retry
+func (m *Manager) deleteArtifact(ctx context.Context, key string) error {
+	if err := m.backend.Delete(ctx, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete artifact: %w", err)
+	}
+	if err := m.backend.Delete(ctx, key+MetadataSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete artifact metadata: %w", err)
+	}
+	m.index.Delete(key)
+	return nil
+}
+
+// Sweeper periodically deletes expired artifacts
+type Sweeper struct {
+	manager  *Manager
+	policy   RetentionPolicy
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewSweeper sweeps manager's storage every interval, or DefaultSweepInterval
+// if interval is zero
+func NewSweeper(manager *Manager, policy RetentionPolicy, interval time.Duration) *Sweeper {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	return &Sweeper{
+		manager:  manager,
+		policy:   policy,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Start sweeps once immediately and then every interval until ctx is done
+func (s *Sweeper) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sweeper) sweep(ctx context.Context) {
+	result, err := s.manager.Sweep(ctx, s.policy, s.now())
+	if err != nil {
+		log.Printf("Retention sweep failed: %v", err)
+	}
+	if result.Deleted > 0 || result.DirsRemoved > 0 {
+		log.Printf("Retention sweep deleted %d artifacts (%d bytes) and %d empty directories",
+			result.Deleted, result.BytesDeleted, result.DirsRemoved)
+	}
+}
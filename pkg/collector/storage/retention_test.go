@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"toe/pkg/collector/metrics"
+)
+
+func TestRetentionPolicy_Days(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		declared int32
+		want     int32
+	}{
+		{name: "declared", declared: 7, want: 7},
+		{name: "keep forever", want: 0},
+		{name: "default", policy: RetentionPolicy{DefaultDays: 30}, want: 30},
+		{name: "declared over default", policy: RetentionPolicy{DefaultDays: 30}, declared: 7, want: 7},
+		{name: "capped", policy: RetentionPolicy{MaxDays: 10}, declared: 90, want: 10},
+		{name: "cap applies to forever", policy: RetentionPolicy{MaxDays: 10}, want: 10},
+		{name: "under cap", policy: RetentionPolicy{DefaultDays: 30, MaxDays: 60}, declared: 45, want: 45},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Days(tt.declared); got != tt.want {
+				t.Errorf("Days(%d) = %d, want %d", tt.declared, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_Sweep(t *testing.T) {
+	root := t.TempDir()
+	mgr, err := NewManager(root, "2006/01/02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	ctx := context.Background()
+
+	save := func(powerTool string, days int32) *Artifact {
+		t.Helper()
+		artifact, err := mgr.SaveProfile(ctx, bytes.NewBufferString(powerTool), ProfileMetadata{
+			Namespace:     "retention-test",
+			AppLabel:      "app-web",
+			PowerToolName: powerTool,
+			PodName:       "web-1",
+			Filename:      "perf.data",
+			RetentionDays: days,
+		})
+		if err != nil {
+			t.Fatalf("SaveProfile() error = %v", err)
+		}
+		return artifact
+	}
+	short := save("short", 1)
+	long := save("long", 90)
+	undeclared := save("undeclared", 0)
+
+	policy := RetentionPolicy{DefaultDays: 10, MaxDays: 20}
+	deleted := testutil.ToFloat64(metrics.ArtifactsDeleted.WithLabelValues("retention-test", reasonRetention))
+
+	// Nothing has expired yet
+	result, err := mgr.Sweep(ctx, policy, time.Now())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if result.Deleted != 0 {
+		t.Errorf("Sweep() deleted %d artifacts before any expired", result.Deleted)
+	}
+
+	// The declared day and the default have passed, the capped 90 days not
+	result, err = mgr.Sweep(ctx, policy, time.Now().AddDate(0, 0, 15))
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if result.Deleted != 2 || result.BytesDeleted != short.Size+undeclared.Size {
+		t.Errorf("Sweep() = %+v, want the short and undeclared artifacts deleted", result)
+	}
+	for _, a := range []*Artifact{short, undeclared} {
+		for _, key := range []string{a.Path, a.Path + MetadataSuffix} {
+			if _, err := os.Stat(filepath.Join(root, key)); !os.IsNotExist(err) {
+				t.Errorf("%s still exists after its retention, stat error = %v", key, err)
+			}
+		}
+		// Its PowerTool directory held nothing else
+		if _, err := os.Stat(filepath.Join(root, "retention-test", "app-web", a.PowerToolName)); !os.IsNotExist(err) {
+			t.Errorf("empty directory for %s was not removed, stat error = %v", a.PowerToolName, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, long.Path)); err != nil {
+		t.Errorf("%s deleted before the retention cap, stat error = %v", long.Path, err)
+	}
+	if got := testutil.ToFloat64(metrics.ArtifactsDeleted.WithLabelValues("retention-test", reasonRetention)); got != deleted+2 {
+		t.Errorf("deleted artifacts counter = %v, want %v", got, deleted+2)
+	}
+
+	// The cap expires the last one, and the emptied tree goes with it
+	result, err = mgr.Sweep(ctx, policy, time.Now().AddDate(0, 0, 25))
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if result.Deleted != 1 {
+		t.Errorf("Sweep() deleted %d artifacts, want 1", result.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(root, "retention-test")); !os.IsNotExist(err) {
+		t.Errorf("empty namespace directory was not removed, stat error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".staging")); err != nil {
+		t.Errorf("hidden staging directory was removed, stat error = %v", err)
+	}
+}
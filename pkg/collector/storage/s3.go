@@ -0,0 +1,505 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultS3PartSize is the size of each part when an object is uploaded in
+// several parts. Objects up to this size go in a single request.
+const DefaultS3PartSize = 64 << 20
+
+// emptySHA256 is the SHA-256 of an empty payload
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload lets object bodies be streamed without hashing them first;
+// the collector verifies content digests itself
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config configures an S3-compatible object store such as AWS S3 or MinIO
+type S3Config struct {
+	// Endpoint is the service URL, e.g. https://s3.us-east-1.amazonaws.com
+	// or http://minio.minio.svc:9000. Buckets are addressed path-style.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every object key, e.g. "toe/"
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PartSize overrides DefaultS3PartSize
+	PartSize int64
+}
+
+// S3Backend stores objects in an S3-compatible bucket using the REST API
+// directly, signed with AWS Signature Version 4
+type S3Backend struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Backend validates cfg and returns a backend for its bucket
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket are required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 access key ID and secret access key are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: must be an http or https URL", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultS3PartSize
+	}
+
+	return &S3Backend{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+// Put uploads the object with If-None-Match so an existing object is never
+// replaced. Objects larger than the part size use a multipart upload.
+func (b *S3Backend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size > b.cfg.PartSize {
+		return b.putMultipart(ctx, key, r, size)
+	}
+
+	header := http.Header{"If-None-Match": {"*"}}
+	resp, err := b.do(ctx, http.MethodPut, key, nil, header, r, size)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload %s: %w", key, responseError(resp))
+	}
+	return nil
+}
+
+// Replace uploads the object unconditionally. S3 writes are atomic, so
+// readers see either the old or the new object. It is only used for small
+// objects such as metadata, so it never uses a multipart upload.
+func (b *S3Backend) Replace(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := b.do(ctx, http.MethodPut, key, nil, nil, r, size)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to replace %s: %w", key, responseError(resp))
+	}
+	return nil
+}
+
+func (b *S3Backend) putMultipart(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := b.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = decodeResponse(resp, &initiated)
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload of %s: %w", key, err)
+	}
+	uploadID := initiated.UploadID
+
+	completed, err := b.uploadParts(ctx, key, uploadID, r, size)
+	if err == nil {
+		err = b.completeMultipart(ctx, key, uploadID, completed)
+	}
+	if err != nil {
+		// Parts of an abandoned upload are billed until aborted
+		if abortErr := b.abortMultipart(context.WithoutCancel(ctx), key, uploadID); abortErr != nil {
+			return fmt.Errorf("%w (abort also failed: %v)", err, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (b *S3Backend) uploadParts(ctx context.Context, key, uploadID string, r io.Reader, size int64) ([]completedPart, error) {
+	var parts []completedPart
+	for remaining := size; remaining > 0; {
+		partSize := min(remaining, b.cfg.PartSize)
+		number := len(parts) + 1
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := b.do(ctx, http.MethodPut, key, query, nil, io.LimitReader(r, partSize), partSize)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := responseError(resp)
+			closeBody(resp)
+			return nil, fmt.Errorf("failed to upload part %d of %s: %w", number, key, err)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		closeBody(resp)
+		remaining -= partSize
+	}
+	return parts, nil
+}
+
+func (b *S3Backend) completeMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode multipart completion: %w", err)
+	}
+
+	header := http.Header{"If-None-Match": {"*"}, "Content-Type": {"application/xml"}}
+	resp, err := b.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, header, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	// S3 can report a failed completion in the body of a 200 response
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := decodeResponse(resp, &result); err != nil {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", key, err)
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("failed to complete multipart upload of %s: %s: %s", key, result.Code, result.Message)
+	}
+	return nil
+}
+
+func (b *S3Backend) abortMultipart(ctx context.Context, key, uploadID string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (b *S3Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer closeBody(resp)
+		return nil, fmt.Errorf("failed to get %s: %w", key, responseError(resp))
+	}
+	return resp.Body, nil
+}
+
+func (b *S3Backend) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset == 0 && length < 0 {
+		return b.Get(ctx, key)
+	}
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		if length == 0 {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+
+	resp, err := b.do(ctx, http.MethodGet, key, nil, http.Header{"Range": {rng}}, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		defer closeBody(resp)
+		return nil, fmt.Errorf("failed to get %s of %s: %w", rng, key, responseError(resp))
+	}
+	return resp.Body, nil
+}
+
+func (b *S3Backend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := b.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return ObjectInfo{}, fmt.Errorf("failed to stat %s: %w", key, responseError(resp))
+	}
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{Key: key, Size: resp.ContentLength, LastModified: modified}, nil
+}
+
+// List pages through ListObjectsV2
+func (b *S3Backend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {b.cfg.Prefix + prefix}}
+	for {
+		resp, err := b.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := decodeResponse(resp, &result); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+
+		for _, c := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          strings.TrimPrefix(c.Key, b.cfg.Prefix),
+				Size:         c.Size,
+				LastModified: c.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete %s: %w", key, responseError(resp))
+	}
+	return nil
+}
+
+// do sends a signed request for key in the configured bucket; an empty key
+// addresses the bucket itself
+func (b *S3Backend) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *b.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + b.cfg.Prefix + key
+	}
+	u.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build S3 request: %w", err)
+	}
+	req.URL.RawQuery = canonicalQuery(query)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptySHA256
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	signV4(req, payloadHash, b.cfg, b.now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// signV4 adds AWS Signature Version 4 headers to req
+func signV4(req *http.Request, payloadHash string, cfg S3Config, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", cfg.SessionToken)
+	}
+
+	// Sign the host, every x-amz-* header and the headers that change what
+	// the request does
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		switch {
+		case strings.HasPrefix(lower, "x-amz-"), lower == "range", lower == "if-none-match", lower == "content-type":
+			signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+cfg.SecretAccessKey), day)
+	key = hmacSHA256(key, cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query sorted by key, as both the signature and the
+// request itself use it
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but RFC 3986 unreserved characters and,
+// unless encodeSlash is set, '/'
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Error is the XML error document S3 returns
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// responseError turns a failed response into an error, mapping missing and
+// already existing objects to fs.ErrNotExist and fs.ErrExist
+func responseError(resp *http.Response) error {
+	var body s3Error
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = xml.Unmarshal(data, &body)
+
+	msg := fmt.Sprintf("S3 returned %s", resp.Status)
+	if body.Code != "" {
+		msg += ": " + body.Code
+		if body.Message != "" {
+			msg += ": " + body.Message
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%s: %w", msg, fs.ErrNotExist)
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("%s: %w", msg, fs.ErrExist)
+	case http.StatusConflict:
+		// A concurrent conditional write to the same key
+		if body.Code == "ConditionalRequestConflict" {
+			return fmt.Errorf("%s: %w", msg, fs.ErrExist)
+		}
+	}
+	return errors.New(msg)
+}
+
+// decodeResponse checks for success and decodes the XML body into v
+func decodeResponse(resp *http.Response, v any) error {
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode S3 response: %w", err)
+	}
+	return nil
+}
+
+// closeBody drains the body so the connection can be reused
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SegmentsSuffix is appended to a rolling capture's name to form the
+// directory its segments are stored in
+const SegmentsSuffix = ".segments"
+
+// MaxSegmentIndex bounds segment indices to the six digits their names have
+const MaxSegmentIndex = 999999
+
+// ErrSegmentConflict is returned when a segment index is already stored with
+// different content
+var ErrSegmentConflict = errors.New("segment already stored with different content")
+
+// Segment identifies one piece of a rolling capture. Segments uploaded by the
+// same pod under the same filename belong to one logical artifact.
+type Segment struct {
+	Index int `json:"index"`
+	// Final marks the last segment. Once it and every earlier index are
+	// stored the capture is complete.
+	Final bool `json:"final,omitempty"`
+	// Started is when the capture began. It picks the date directory, so the
+	// segments of a capture running past midnight stay together.
+	Started time.Time `json:"-"`
+}
+
+// SegmentGroup is a rolling capture assembled from its stored segments
+type SegmentGroup struct {
+	// Path is the logical artifact's key; its segments are stored below
+	// Path+SegmentsSuffix
+	Path string `json:"path"`
+	// Segments are ordered by index
+	Segments []Artifact `json:"segments"`
+	// Complete is set once the final segment and all before it are stored
+	Complete bool `json:"complete"`
+	// Missing lists the indices below the highest stored one that have not
+	// arrived
+	Missing []int `json:"missing,omitempty"`
+	Size    int64 `json:"size"`
+}
+
+// segmentKey is where a segment is stored: in its capture's segments
+// directory, named by its zero-padded index and the capture's extension
+func segmentKey(dir string, artifact *Artifact) string {
+	name := artifactName(artifact.ProfileMetadata)
+	segment := withEncodingExtension(fmt.Sprintf("%06d%s", artifact.Segment.Index, path.Ext(name)), artifact.Encoding)
+	return path.Join(dir, name+SegmentsSuffix, segment)
+}
+
+// SegmentGroupKey returns the logical artifact a segment's key belongs to
+func SegmentGroupKey(key string) string {
+	return strings.TrimSuffix(path.Dir(key), SegmentsSuffix)
+}
+
+// publishSegment stores a segment under its index. Unlike whole artifacts,
+// a segment has exactly one place: an identical retry is deduplicated and
+// different content under the same index is refused.
+func (m *Manager) publishSegment(ctx context.Context, tmpPath, dir string, artifact *Artifact) (*Artifact, error) {
+	key := segmentKey(dir, artifact)
+
+	existing, err := m.readSidecar(ctx, key)
+	switch {
+	case err == nil && sameContent(existing, artifact):
+		existing.Deduplicated = true
+		return existing, nil
+	case err == nil:
+		return nil, fmt.Errorf("%w: %s", ErrSegmentConflict, key)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staged profile: %w", err)
+	}
+	err = m.backend.Put(ctx, key, f, artifact.storedSize())
+	_ = f.Close()
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSegmentConflict, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish profile segment: %w", err)
+	}
+
+	artifact.Path = key
+	artifact.CreatedAt = time.Now().UTC()
+	if err := m.writeSidecar(ctx, artifact); err != nil {
+		return nil, err
+	}
+	return artifact, nil
+}
+
+// GroupSegments splits artifacts into whole artifacts and rolling captures,
+// keeping the order of the former. Groups are ordered by path.
+func GroupSegments(artifacts []Artifact) ([]Artifact, []SegmentGroup) {
+	var whole []Artifact
+	byPath := map[string]*SegmentGroup{}
+	for _, a := range artifacts {
+		if a.Segment == nil {
+			whole = append(whole, a)
+			continue
+		}
+		key := SegmentGroupKey(a.Path)
+		group, ok := byPath[key]
+		if !ok {
+			group = &SegmentGroup{Path: key}
+			byPath[key] = group
+		}
+		group.Segments = append(group.Segments, a)
+		group.Size += a.Size
+	}
+
+	groups := make([]SegmentGroup, 0, len(byPath))
+	for _, group := range byPath {
+		sort.Slice(group.Segments, func(i, j int) bool {
+			return group.Segments[i].Segment.Index < group.Segments[j].Segment.Index
+		})
+
+		next := 0
+		for _, s := range group.Segments {
+			for ; next < s.Segment.Index; next++ {
+				group.Missing = append(group.Missing, next)
+			}
+			next = s.Segment.Index + 1
+		}
+		// Nothing may follow the final segment
+		last := group.Segments[len(group.Segments)-1]
+		group.Complete = last.Segment.Final && len(group.Missing) == 0
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Path < groups[j].Path
+	})
+	return whole, groups
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveProfile_Segments(t *testing.T) {
+	mgr, err := NewManager(t.TempDir(), "2006/01/02")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	ctx := context.Background()
+	started := time.Date(2025, 10, 30, 23, 55, 0, 0, time.UTC)
+
+	save := func(index int, final bool, content string) (*Artifact, error) {
+		return mgr.SaveProfile(ctx, bytes.NewBufferString(content), ProfileMetadata{
+			Namespace: "default", AppLabel: "app-web", PowerToolName: "capture",
+			PodName: "web-1", Filename: "capture.pcap",
+			Segment: &Segment{Index: index, Final: final, Started: started},
+		})
+	}
+
+	first, err := save(0, false, "segment-0")
+	if err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	// Filed under the capture's start date, whenever it arrives
+	want := "default/app-web/capture/2025/10/30/web-1_capture.pcap.segments/000000.pcap"
+	if first.Path != want {
+		t.Errorf("segment path = %s, want %s", first.Path, want)
+	}
+	if SegmentGroupKey(first.Path) != "default/app-web/capture/2025/10/30/web-1_capture.pcap" {
+		t.Errorf("SegmentGroupKey(%s) = %s", first.Path, SegmentGroupKey(first.Path))
+	}
+
+	if _, err := save(2, true, "segment-2"); err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+
+	// A retry of a stored segment is deduplicated, other content refused
+	retry, err := save(0, false, "segment-0")
+	if err != nil || !retry.Deduplicated {
+		t.Errorf("retry = %+v, %v, want deduplicated", retry, err)
+	}
+	if _, err := save(0, false, "different"); !errors.Is(err, ErrSegmentConflict) {
+		t.Errorf("conflicting segment error = %v, want ErrSegmentConflict", err)
+	}
+
+	list := func() SegmentGroup {
+		t.Helper()
+		artifacts, err := mgr.ListArtifacts(ctx, ArtifactFilter{Namespace: "default"})
+		if err != nil {
+			t.Fatalf("ListArtifacts() error = %v", err)
+		}
+		whole, groups := GroupSegments(artifacts)
+		if len(whole) != 0 || len(groups) != 1 {
+			t.Fatalf("GroupSegments() = %d artifacts, %d groups, want one group", len(whole), len(groups))
+		}
+		return groups[0]
+	}
+
+	group := list()
+	if group.Complete || !reflect.DeepEqual(group.Missing, []int{1}) {
+		t.Errorf("group with a gap = complete %v, missing %v, want incomplete missing [1]", group.Complete, group.Missing)
+	}
+
+	if _, err := save(1, false, "segment-1"); err != nil {
+		t.Fatalf("SaveProfile() error = %v", err)
+	}
+	group = list()
+	if !group.Complete || len(group.Missing) != 0 || group.Size != 27 {
+		t.Errorf("group = complete %v, missing %v, size %d, want complete with 27 bytes", group.Complete, group.Missing, group.Size)
+	}
+	for i, segment := range group.Segments {
+		if segment.Segment.Index != i || !strings.HasSuffix(segment.Path, fmt.Sprintf("/%06d.pcap", i)) {
+			t.Errorf("segment %d = %s, index %d", i, segment.Path, segment.Segment.Index)
+		}
+	}
+}
+
+func TestGroupSegments(t *testing.T) {
+	segment := func(path string, index int, final bool) Artifact {
+		return Artifact{Path: path, Size: 1, ProfileMetadata: ProfileMetadata{Segment: &Segment{Index: index, Final: final}}}
+	}
+	artifacts := []Artifact{
+		{Path: "ns/l/pt/d/web-1_perf.data"},
+		segment("ns/l/pt/d/web-1_a.pcap.segments/000001.pcap", 1, false),
+		segment("ns/l/pt/d/web-1_a.pcap.segments/000000.pcap", 0, false),
+		segment("ns/l/pt/d/web-2_a.pcap.segments/000000.pcap", 0, true),
+		segment("ns/l/pt/d/web-2_a.pcap.segments/000001.pcap", 1, false),
+	}
+
+	whole, groups := GroupSegments(artifacts)
+	if len(whole) != 1 || whole[0].Path != "ns/l/pt/d/web-1_perf.data" {
+		t.Errorf("whole artifacts = %+v", whole)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("GroupSegments() returned %d groups, want 2", len(groups))
+	}
+	if groups[0].Path != "ns/l/pt/d/web-1_a.pcap" || groups[0].Complete || groups[0].Segments[0].Segment.Index != 0 {
+		t.Errorf("group without final segment = %+v", groups[0])
+	}
+	// A segment after the final one means the marker is stale
+	if groups[1].Complete {
+		t.Errorf("group with segments past the final one reported complete")
+	}
+}
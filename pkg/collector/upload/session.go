@@ -0,0 +1,406 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"toe/pkg/collector/storage"
+)
+
+// DefaultSessionTTL is how long an upload session may sit idle before it is
+// considered abandoned and removed
+const DefaultSessionTTL = time.Hour
+
+// sweepInterval is how often abandoned sessions are looked for
+const sweepInterval = time.Minute
+
+var (
+	// ErrSessionNotFound is returned for unknown, expired or finished sessions
+	ErrSessionNotFound = errors.New("upload session not found")
+	// ErrIncomplete is returned when finalizing a session that has not
+	// received its declared length
+	ErrIncomplete = errors.New("upload incomplete")
+	// ErrLengthExceeded is returned for chunks that run past the declared
+	// upload length
+	ErrLengthExceeded = errors.New("chunk exceeds declared upload length")
+)
+
+// OffsetMismatchError is returned when a chunk does not start at the
+// session's current offset. Clients should resume from Current.
+type OffsetMismatchError struct {
+	Current int64
+	Got     int64
+}
+
+func (e *OffsetMismatchError) Error() string {
+	return fmt.Sprintf("offset mismatch: upload is at %d, chunk starts at %d", e.Current, e.Got)
+}
+
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Session is the persisted state of one resumable upload
+type Session struct {
+	ID           string                  `json:"id"`
+	Owner        string                  `json:"owner"`
+	Metadata     storage.ProfileMetadata `json:"metadata"`
+	Offset       int64                   `json:"offset"`
+	Length       int64                   `json:"length,omitempty"`
+	SHA256       string                  `json:"sha256,omitempty"`
+	LastActivity time.Time               `json:"lastActivity"`
+}
+
+// entry guards one session; the lock serializes chunks of the same upload
+type entry struct {
+	mu      sync.Mutex
+	session Session
+}
+
+// SessionStore keeps in-progress uploads on local disk: <id>.data holds the
+// bytes received so far and <id>.json the session state, so uploads survive a
+// collector restart.
+type SessionStore struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*entry
+}
+
+// NewSessionStore opens the session directory, picking up sessions left by a
+// previous run. A zero ttl uses DefaultSessionTTL.
+func NewSessionStore(dir string, ttl time.Duration) (*SessionStore, error) {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create upload session directory: %w", err)
+	}
+
+	s := &SessionStore{
+		dir:      dir,
+		ttl:      ttl,
+		now:      time.Now,
+		sessions: make(map[string]*entry),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// TTL returns how long a session may stay idle
+func (s *SessionStore) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *SessionStore) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read upload session directory: %w", err)
+	}
+
+	for _, de := range entries {
+		id, ok := strings.CutSuffix(de.Name(), ".json")
+		if !ok || !sessionIDPattern.MatchString(id) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, de.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read upload session %s: %w", id, err)
+		}
+		var sess Session
+		if err := json.Unmarshal(data, &sess); err != nil {
+			log.Printf("Discarding unreadable upload session %s: %v", id, err)
+			s.removeFiles(id)
+			continue
+		}
+		// The data file is the source of truth for how much arrived
+		info, err := os.Stat(s.dataPath(id))
+		if err != nil {
+			log.Printf("Discarding upload session %s without data: %v", id, err)
+			s.removeFiles(id)
+			continue
+		}
+		sess.Offset = info.Size()
+		s.sessions[id] = &entry{session: sess}
+	}
+	return nil
+}
+
+// Create starts a session for an upload of length bytes (0 if unknown) whose
+// content should hash to sha256 (empty if not known yet)
+func (s *SessionStore) Create(owner string, metadata storage.ProfileMetadata, length int64, sha256 string) (Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return Session{}, err
+	}
+
+	sess := Session{
+		ID:           id,
+		Owner:        owner,
+		Metadata:     metadata,
+		Length:       length,
+		SHA256:       sha256,
+		LastActivity: s.now(),
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		s.removeFiles(id)
+		return Session{}, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	if err := s.persist(&sess); err != nil {
+		s.removeFiles(id)
+		return Session{}, err
+	}
+
+	s.mu.Lock()
+	s.sessions[id] = &entry{session: sess}
+	s.mu.Unlock()
+
+	return sess, nil
+}
+
+// Get returns the current state of a session
+func (s *SessionStore) Get(id string) (Session, error) {
+	e, err := s.lookup(id)
+	if err != nil {
+		return Session{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !s.has(id, e) {
+		return Session{}, ErrSessionNotFound
+	}
+	return e.session, nil
+}
+
+// Append writes a chunk starting at offset. A chunk is applied entirely or not
+// at all, so a dropped connection leaves the session at the previous offset.
+func (s *SessionStore) Append(id string, offset int64, r io.Reader) (Session, error) {
+	e, err := s.lookup(id)
+	if err != nil {
+		return Session{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !s.has(id, e) {
+		return Session{}, ErrSessionNotFound
+	}
+	sess := &e.session
+
+	if offset != sess.Offset {
+		return Session{}, &OffsetMismatchError{Current: sess.Offset, Got: offset}
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to open upload data: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return Session{}, fmt.Errorf("failed to seek upload data: %w", err)
+	}
+	src := r
+	if sess.Length > 0 {
+		// Reading one byte past the declared length detects overruns
+		src = io.LimitReader(r, sess.Length-offset+1)
+	}
+	n, err := io.Copy(f, src)
+	if err == nil && sess.Length > 0 && offset+n > sess.Length {
+		err = fmt.Errorf("%w %d", ErrLengthExceeded, sess.Length)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		if truncErr := f.Truncate(offset); truncErr != nil {
+			log.Printf("Failed to roll back upload session %s to offset %d: %v", id, offset, truncErr)
+		}
+		return Session{}, fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	sess.Offset = offset + n
+	sess.LastActivity = s.now()
+	if err := s.persist(sess); err != nil {
+		return Session{}, err
+	}
+	return *sess, nil
+}
+
+// Finalize hands the completed upload to save and removes the session once
+// save succeeds. On failure the session is kept so the client can retry.
+func (s *SessionStore) Finalize(id string, save func(io.Reader, storage.ProfileMetadata) error) (Session, error) {
+	e, err := s.lookup(id)
+	if err != nil {
+		return Session{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !s.has(id, e) {
+		return Session{}, ErrSessionNotFound
+	}
+	sess := &e.session
+
+	if sess.Length > 0 && sess.Offset != sess.Length {
+		return Session{}, fmt.Errorf("%w: received %d of %d bytes", ErrIncomplete, sess.Offset, sess.Length)
+	}
+
+	f, err := os.Open(s.dataPath(id))
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to open upload data: %w", err)
+	}
+	err = save(f, sess.Metadata)
+	_ = f.Close()
+	if err != nil {
+		sess.LastActivity = s.now()
+		return Session{}, err
+	}
+
+	s.forget(id)
+	return *sess, nil
+}
+
+// Abort drops a session and everything it received
+func (s *SessionStore) Abort(id string) error {
+	e, err := s.lookup(id)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !s.has(id, e) {
+		return ErrSessionNotFound
+	}
+	s.forget(id)
+	return nil
+}
+
+// Start removes abandoned sessions until ctx is cancelled
+func (s *SessionStore) Start(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Expire()
+		}
+	}
+}
+
+// Expire removes sessions idle for longer than the TTL and returns how many
+// were removed
+func (s *SessionStore) Expire() int {
+	cutoff := s.now().Add(-s.ttl)
+
+	s.mu.Lock()
+	entries := make([]*entry, 0, len(s.sessions))
+	for _, e := range s.sessions {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	removed := 0
+	for _, e := range entries {
+		e.mu.Lock()
+		sess := e.session
+		// A session finalized or aborted concurrently is already gone
+		if sess.LastActivity.Before(cutoff) && s.has(sess.ID, e) {
+			log.Printf("Expiring abandoned upload session %s for %s/%s after %d bytes",
+				sess.ID, sess.Metadata.Namespace, sess.Metadata.PowerToolName, sess.Offset)
+			s.forget(sess.ID)
+			removed++
+		}
+		e.mu.Unlock()
+	}
+	return removed
+}
+
+// lookup finds a session. Callers must re-check it is still registered after
+// taking its lock, since it may have been finalized in between.
+func (s *SessionStore) lookup(id string) (*entry, error) {
+	if !sessionIDPattern.MatchString(id) {
+		return nil, ErrSessionNotFound
+	}
+	s.mu.Lock()
+	e, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return e, nil
+}
+
+func (s *SessionStore) has(id string, e *entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id] == e
+}
+
+// forget removes a session; the caller holds the session lock
+func (s *SessionStore) forget(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	s.removeFiles(id)
+}
+
+func (s *SessionStore) persist(sess *Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload session: %w", err)
+	}
+	tmp := s.statePath(sess.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save upload session: %w", err)
+	}
+	if err := os.Rename(tmp, s.statePath(sess.ID)); err != nil {
+		return fmt.Errorf("failed to save upload session: %w", err)
+	}
+	return nil
+}
+
+func (s *SessionStore) removeFiles(id string) {
+	for _, p := range []string{s.dataPath(id), s.statePath(id), s.statePath(id) + ".tmp"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove upload session file %s: %v", p, err)
+		}
+	}
+}
+
+func (s *SessionStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".data")
+}
+
+func (s *SessionStore) statePath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate upload session ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
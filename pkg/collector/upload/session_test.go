@@ -0,0 +1,209 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"toe/pkg/collector/storage"
+)
+
+var testMetadata = storage.ProfileMetadata{
+	Namespace:     "default",
+	AppLabel:      "app-web",
+	PowerToolName: "profile-job",
+	PodName:       "web-1",
+	Filename:      "perf.data",
+}
+
+type failingReader struct {
+	data []byte
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestSessionStore_ChunkedUpload(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+
+	sess, err := store.Create("user", testMetadata, 11, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := store.Append(sess.ID, 0, bytes.NewBufferString("hello ")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	// A chunk at the wrong offset is refused with the current offset
+	_, err = store.Append(sess.ID, 0, bytes.NewBufferString("hello "))
+	var mismatch *OffsetMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Current != 6 {
+		t.Fatalf("Append() at stale offset error = %v, want OffsetMismatchError at 6", err)
+	}
+
+	// A chunk that fails mid-way leaves the offset untouched
+	if _, err := store.Append(sess.ID, 6, &failingReader{data: []byte("wo")}); err == nil {
+		t.Fatal("Append() with failing reader expected error, got nil")
+	}
+	got, err := store.Get(sess.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Offset != 6 {
+		t.Errorf("offset after failed chunk = %d, want 6", got.Offset)
+	}
+
+	if _, err := store.Finalize(sess.ID, func(io.Reader, storage.ProfileMetadata) error { return nil }); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("Finalize() before all bytes arrived error = %v, want ErrIncomplete", err)
+	}
+
+	if _, err := store.Append(sess.ID, 6, bytes.NewBufferString("world")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	var saved bytes.Buffer
+	var savedMeta storage.ProfileMetadata
+	done, err := store.Finalize(sess.ID, func(r io.Reader, m storage.ProfileMetadata) error {
+		savedMeta = m
+		_, err := io.Copy(&saved, r)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Finalize() error = %v", err)
+	}
+	if saved.String() != "hello world" {
+		t.Errorf("finalized content = %q, want %q", saved.String(), "hello world")
+	}
+	if !reflect.DeepEqual(savedMeta, testMetadata) {
+		t.Errorf("finalized metadata = %+v, want %+v", savedMeta, testMetadata)
+	}
+	if done.Offset != 11 {
+		t.Errorf("finalized offset = %d, want 11", done.Offset)
+	}
+
+	if _, err := store.Get(sess.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Get() after Finalize() error = %v, want ErrSessionNotFound", err)
+	}
+	entries, _ := os.ReadDir(store.dir)
+	if len(entries) != 0 {
+		t.Errorf("finalized session left files behind: %v", entries)
+	}
+}
+
+func TestSessionStore_LengthExceeded(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+
+	sess, err := store.Create("user", testMetadata, 4, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := store.Append(sess.ID, 0, bytes.NewBufferString("toolong")); !errors.Is(err, ErrLengthExceeded) {
+		t.Errorf("Append() error = %v, want ErrLengthExceeded", err)
+	}
+	got, _ := store.Get(sess.ID)
+	if got.Offset != 0 {
+		t.Errorf("offset after rejected chunk = %d, want 0", got.Offset)
+	}
+}
+
+func TestSessionStore_FailedSaveKeepsSession(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+
+	sess, _ := store.Create("user", testMetadata, 0, "")
+	if _, err := store.Append(sess.ID, 0, bytes.NewBufferString("data")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if _, err := store.Finalize(sess.ID, func(io.Reader, storage.ProfileMetadata) error {
+		return errors.New("disk full")
+	}); err == nil {
+		t.Fatal("Finalize() expected error, got nil")
+	}
+	if _, err := store.Get(sess.ID); err != nil {
+		t.Errorf("session should survive a failed save, Get() error = %v", err)
+	}
+}
+
+func TestSessionStore_Expire(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	stale, _ := store.Create("user", testMetadata, 0, "")
+	now = now.Add(30 * time.Minute)
+	active, _ := store.Create("user", testMetadata, 0, "")
+	now = now.Add(45 * time.Minute)
+
+	if removed := store.Expire(); removed != 1 {
+		t.Errorf("Expire() removed %d sessions, want 1", removed)
+	}
+	if _, err := store.Get(stale.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("stale session still present: %v", err)
+	}
+	if _, err := store.Get(active.ID); err != nil {
+		t.Errorf("active session removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.dir, stale.ID+".data")); !os.IsNotExist(err) {
+		t.Errorf("stale session data not removed: %v", err)
+	}
+}
+
+func TestSessionStore_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewSessionStore(dir, 0)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+	sess, _ := store.Create("user", testMetadata, 0, "")
+	if _, err := store.Append(sess.ID, 0, bytes.NewBufferString("partial")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	reopened, err := NewSessionStore(dir, 0)
+	if err != nil {
+		t.Fatalf("NewSessionStore() reopen error = %v", err)
+	}
+	got, err := reopened.Get(sess.ID)
+	if err != nil {
+		t.Fatalf("Get() after restart error = %v", err)
+	}
+	if got.Offset != 7 || got.Owner != "user" || !reflect.DeepEqual(got.Metadata, testMetadata) {
+		t.Errorf("restored session = %+v", got)
+	}
+}
+
+func TestSessionStore_RejectsMalformedIDs(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+	for _, id := range []string{"", "../etc/passwd", "ABCDEF0123456789ABCDEF0123456789"} {
+		if _, err := store.Get(id); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Get(%q) error = %v, want ErrSessionNotFound", id, err)
+		}
+	}
+}
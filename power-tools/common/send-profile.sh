@@ -2,6 +2,9 @@
 
 # Helper script to send profile data to collector
 # Usage: send-profile.sh <profile-file>
+#
+# Segments of a rolling capture set SEGMENT_INDEX, SEGMENT_FINAL=true on the
+# last one, and ARTIFACT_NAME to the capture's name shared by all segments.
 
 if [ $# -ne 1 ]; then
     echo "Usage: $0 <profile-file>"
@@ -9,7 +12,7 @@ if [ $# -ne 1 ]; then
 fi
 
 PROFILE_FILE="$1"
-FILENAME=$(basename "$PROFILE_FILE")
+FILENAME=${ARTIFACT_NAME:-$(basename "$PROFILE_FILE")}
 
 # Validate required environment variables
 if [ -z "$COLLECTOR_TOKEN" ]; then
@@ -27,6 +30,11 @@ if [ -z "$TARGET_NAMESPACE" ]; then
     exit 1
 fi
 
+if [ -z "$TARGET_POD_NAME" ]; then
+    echo "Error: TARGET_POD_NAME not set"
+    exit 1
+fi
+
 # Handle TLS certificate
 CURL_OPTS=""
 if [ -n "$COLLECTOR_CA_CERT" ]; then
@@ -34,23 +42,133 @@ if [ -n "$COLLECTOR_CA_CERT" ]; then
     CA_CERT_FILE=$(mktemp)
     echo "$COLLECTOR_CA_CERT" > "$CA_CERT_FILE"
     CURL_OPTS="--cacert $CA_CERT_FILE"
-elif [[ "$COLLECTOR_ENDPOINT" == https://* ]]; then
-    # For HTTPS without CA cert, skip verification (not recommended for production)
-    echo "Warning: No CA certificate provided, skipping TLS verification"
-    CURL_OPTS="--insecure"
-fi
-
-# Send profile data with metadata headers
-curl -X POST \
-    $CURL_OPTS \
-    -H "Authorization: Bearer $COLLECTOR_TOKEN" \
-    -H "X-PowerTool-Job-ID: $POWERTOOL_JOB_ID" \
-    -H "X-PowerTool-Namespace: $TARGET_NAMESPACE" \
-    -H "X-PowerTool-Matching-Labels: ${POD_MATCHING_LABELS:-unknown}" \
-    -H "X-PowerTool-Filename: $FILENAME" \
-    -H "Content-Type: application/octet-stream" \
-    --data-binary "@$PROFILE_FILE" \
-    "$COLLECTOR_ENDPOINT/api/v1/profile"
+else
+    # Never upload without verifying the collector
+    echo "Error: COLLECTOR_CA_CERT not set, refusing to upload without TLS verification"
+    exit 1
+fi
+
+# Files above this size use the resumable upload protocol so a dropped
+# connection only costs the chunk in flight
+RESUMABLE_THRESHOLD=${RESUMABLE_THRESHOLD:-67108864}
+CHUNK_SIZE=${CHUNK_SIZE:-8388608}
+MAX_RETRIES=${MAX_RETRIES:-5}
+
+FILE_SIZE=$(wc -c < "$PROFILE_FILE" | tr -d ' ')
+
+# The collector verifies the digest and rejects corrupted uploads. Without
+# sha256sum the header is left empty, which curl omits.
+FILE_SHA256=""
+if command -v sha256sum >/dev/null 2>&1; then
+    FILE_SHA256=$(sha256sum "$PROFILE_FILE" | cut -d ' ' -f 1)
+fi
+
+# Send profile data with metadata headers in a single request
+upload_single() {
+    curl -X POST \
+        $CURL_OPTS \
+        -H "Authorization: Bearer $COLLECTOR_TOKEN" \
+        -H "X-PowerTool-Job-ID: $POWERTOOL_JOB_ID" \
+        -H "X-PowerTool-Namespace: $TARGET_NAMESPACE" \
+        -H "X-PowerTool-Pod-Name: $TARGET_POD_NAME" \
+        -H "X-PowerTool-Matching-Labels: ${POD_MATCHING_LABELS:-unknown}" \
+        -H "X-PowerTool-Filename: $FILENAME" \
+        -H "X-PowerTool-SHA256: $FILE_SHA256" \
+        -H "X-PowerTool-Retention-Days: $RETENTION_DAYS" \
+        -H "X-PowerTool-Segment: ${SEGMENT_INDEX:-}" \
+        -H "X-PowerTool-Segment-Final: ${SEGMENT_FINAL:-}" \
+        -H "Content-Type: application/octet-stream" \
+        --data-binary "@$PROFILE_FILE" \
+        "$COLLECTOR_ENDPOINT/api/v1/profile"
+}
+
+# Print the collector's current offset for the upload session
+query_offset() {
+    curl -sS --fail -I \
+        $CURL_OPTS \
+        -H "Authorization: Bearer $COLLECTOR_TOKEN" \
+        -H "X-PowerTool-Job-ID: $POWERTOOL_JOB_ID" \
+        -H "X-PowerTool-Namespace: $TARGET_NAMESPACE" \
+        -H "X-PowerTool-Pod-Name: $TARGET_POD_NAME" \
+        "$UPLOAD_URL" | tr -d '\r' | sed -n 's/^[Uu]pload-[Oo]ffset: *//p'
+}
+
+# Create an upload session, send the file in chunks resuming from the
+# collector's offset after failures, then finalize
+upload_resumable() {
+    LOCATION=$(curl -sS --fail -X POST \
+        $CURL_OPTS \
+        -H "Authorization: Bearer $COLLECTOR_TOKEN" \
+        -H "X-PowerTool-Job-ID: $POWERTOOL_JOB_ID" \
+        -H "X-PowerTool-Namespace: $TARGET_NAMESPACE" \
+        -H "X-PowerTool-Pod-Name: $TARGET_POD_NAME" \
+        -H "X-PowerTool-Matching-Labels: ${POD_MATCHING_LABELS:-unknown}" \
+        -H "X-PowerTool-Filename: $FILENAME" \
+        -H "X-PowerTool-SHA256: $FILE_SHA256" \
+        -H "X-PowerTool-Retention-Days: $RETENTION_DAYS" \
+        -H "X-PowerTool-Segment: ${SEGMENT_INDEX:-}" \
+        -H "X-PowerTool-Segment-Final: ${SEGMENT_FINAL:-}" \
+        -H "Upload-Length: $FILE_SIZE" \
+        -D - -o /dev/null \
+        "$COLLECTOR_ENDPOINT/api/v1/uploads" | tr -d '\r' | sed -n 's/^[Ll]ocation: *//p')
+    if [ -z "$LOCATION" ]; then
+        echo "Error: failed to create upload session"
+        return 1
+    fi
+    UPLOAD_URL="$COLLECTOR_ENDPOINT$LOCATION"
+
+    OFFSET=0
+    FAILURES=0
+    while [ "$OFFSET" -lt "$FILE_SIZE" ]; do
+        if dd if="$PROFILE_FILE" bs="$CHUNK_SIZE" skip=$((OFFSET / CHUNK_SIZE)) count=1 2>/dev/null | \
+            curl -sS --fail -X PUT \
+                $CURL_OPTS \
+                -H "Authorization: Bearer $COLLECTOR_TOKEN" \
+                -H "X-PowerTool-Job-ID: $POWERTOOL_JOB_ID" \
+                -H "X-PowerTool-Namespace: $TARGET_NAMESPACE" \
+                -H "X-PowerTool-Pod-Name: $TARGET_POD_NAME" \
+                -H "Upload-Offset: $OFFSET" \
+                -H "Content-Type: application/offset+octet-stream" \
+                --data-binary @- \
+                -o /dev/null \
+                "$UPLOAD_URL"; then
+            FAILURES=0
+            OFFSET=$((OFFSET + CHUNK_SIZE))
+            if [ "$OFFSET" -gt "$FILE_SIZE" ]; then
+                OFFSET=$FILE_SIZE
+            fi
+            continue
+        fi
+
+        FAILURES=$((FAILURES + 1))
+        if [ "$FAILURES" -gt "$MAX_RETRIES" ]; then
+            echo "Error: giving up on upload after $MAX_RETRIES retries at offset $OFFSET"
+            return 1
+        fi
+        sleep "$FAILURES"
+
+        # Chunks are applied whole or not at all; resume where the collector is
+        CURRENT=$(query_offset)
+        if [ -n "$CURRENT" ]; then
+            OFFSET=$CURRENT
+        fi
+    done
+
+    curl -sS --fail -X POST \
+        $CURL_OPTS \
+        -H "Authorization: Bearer $COLLECTOR_TOKEN" \
+        -H "X-PowerTool-Job-ID: $POWERTOOL_JOB_ID" \
+        -H "X-PowerTool-Namespace: $TARGET_NAMESPACE" \
+        -H "X-PowerTool-Pod-Name: $TARGET_POD_NAME" \
+        -o /dev/null \
+        "$UPLOAD_URL/complete"
+}
+
+if [ "$FILE_SIZE" -gt "$RESUMABLE_THRESHOLD" ]; then
+    upload_resumable
+else
+    upload_single
+fi
 
 CURL_EXIT_CODE=$?
 
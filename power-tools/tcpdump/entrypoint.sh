@@ -11,21 +11,99 @@ echo "Duration: $DURATION"
 echo "Output: $OUTPUT_FILE"
 echo "Arguments: $*"
 
+# Parse a duration like 30s, 5m or 1h into seconds
+parse_seconds() {
+    if [[ "$1" =~ ^([0-9]+)([smh])$ ]]; then
+        NUM="${BASH_REMATCH[1]}"
+        case "${BASH_REMATCH[2]}" in
+            s) echo "$NUM" ;;
+            m) echo $((NUM * 60)) ;;
+            h) echo $((NUM * 3600)) ;;
+        esac
+    else
+        return 1
+    fi
+}
+
 # Parse duration to timeout value
-if [[ "$DURATION" =~ ^([0-9]+)([smh])$ ]]; then
-    NUM="${BASH_REMATCH[1]}"
-    UNIT="${BASH_REMATCH[2]}"
-    
-    case "$UNIT" in
-        s) TIMEOUT="$NUM" ;;
-        m) TIMEOUT=$((NUM * 60)) ;;
-        h) TIMEOUT=$((NUM * 3600)) ;;
-    esac
-else
+if ! TIMEOUT=$(parse_seconds "$DURATION"); then
     echo "Invalid duration format: $DURATION (use format like 30s, 5m, 1h)"
     exit 1
 fi
 
+# With a rolling interval the capture is uploaded in numbered segments as
+# tcpdump rotates, so a pod that dies mid-capture loses one segment at most
+if [ -n "${ROLLING_INTERVAL:-}" ] && [ -n "${COLLECTOR_ENDPOINT:-}" ]; then
+    if ! INTERVAL=$(parse_seconds "$ROLLING_INTERVAL"); then
+        echo "Invalid rolling interval format: $ROLLING_INTERVAL (use format like 30s, 5m, 1h)"
+        exit 1
+    fi
+
+    SEGMENT_DIR=$(mktemp -d)
+    ARTIFACT_NAME=$(basename "$OUTPUT_FILE")
+    SEGMENT_INDEX=0
+    echo "Rolling capture every ${INTERVAL}s as $ARTIFACT_NAME"
+
+    # Upload finished segments in order, holding back the newest $1 that
+    # tcpdump may still be writing. A failed upload is retried on the next
+    # call under the same index.
+    upload_segments() {
+        local hold=$1
+        local segments=()
+        mapfile -t segments < <(find "$SEGMENT_DIR" -name '*.pcap' | sort)
+        local ready=$((${#segments[@]} - hold))
+        local i final
+        for ((i = 0; i < ready; i++)); do
+            final=""
+            if [ "$hold" -eq 0 ] && [ "$i" -eq $((ready - 1)) ]; then
+                final=true
+            fi
+            if SEGMENT_INDEX=$SEGMENT_INDEX SEGMENT_FINAL=$final ARTIFACT_NAME=$ARTIFACT_NAME \
+                send-profile.sh "${segments[$i]}"; then
+                rm -f "${segments[$i]}"
+                SEGMENT_INDEX=$((SEGMENT_INDEX + 1))
+            else
+                echo "Failed to upload segment $SEGMENT_INDEX, will retry"
+                return 1
+            fi
+        done
+    }
+
+    # tcpdump names segments by their start time, so they sort in order
+    timeout "$TIMEOUT" tcpdump -G "$INTERVAL" -w "$SEGMENT_DIR/%Y%m%d-%H%M%S.pcap" "$@" &
+    TCPDUMP_PID=$!
+    while kill -0 "$TCPDUMP_PID" 2>/dev/null; do
+        sleep 5
+        upload_segments 1 || true
+    done
+
+    EXIT_CODE=0
+    if wait "$TCPDUMP_PID"; then
+        echo "Tcpdump capture completed successfully"
+    else
+        EXIT_CODE=$?
+        if [ $EXIT_CODE -eq 124 ]; then
+            echo "Tcpdump capture completed (timeout reached)"
+            EXIT_CODE=0
+        else
+            # Upload what was captured before failing
+            echo "Tcpdump capture failed with exit code $EXIT_CODE"
+        fi
+    fi
+
+    # The last upload carries the completion marker
+    for ATTEMPT in 1 2 3 4 5; do
+        if upload_segments 0; then
+            echo "Uploaded $SEGMENT_INDEX segments of $ARTIFACT_NAME"
+            rm -rf "$SEGMENT_DIR"
+            exit "$EXIT_CODE"
+        fi
+        sleep "$ATTEMPT"
+    done
+    echo "Error: failed to upload the remaining segments of $ARTIFACT_NAME"
+    exit 1
+fi
+
 # Build tcpdump command with provided arguments
 TCPDUMP_CMD="tcpdump -w $OUTPUT_FILE $*"
 